@@ -23,6 +23,7 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -31,14 +32,24 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/dasomel/nfs-quota-agent/internal/agent"
+	"github.com/dasomel/nfs-quota-agent/internal/archive"
 	"github.com/dasomel/nfs-quota-agent/internal/audit"
+	"github.com/dasomel/nfs-quota-agent/internal/chargeback"
 	"github.com/dasomel/nfs-quota-agent/internal/cleanup"
 	"github.com/dasomel/nfs-quota-agent/internal/completion"
+	"github.com/dasomel/nfs-quota-agent/internal/cron"
+	"github.com/dasomel/nfs-quota-agent/internal/deleter"
+	"github.com/dasomel/nfs-quota-agent/internal/doctor"
 	"github.com/dasomel/nfs-quota-agent/internal/history"
+	"github.com/dasomel/nfs-quota-agent/internal/manual"
 	"github.com/dasomel/nfs-quota-agent/internal/metrics"
+	"github.com/dasomel/nfs-quota-agent/internal/plan"
 	"github.com/dasomel/nfs-quota-agent/internal/policy"
+	"github.com/dasomel/nfs-quota-agent/internal/projects"
 	"github.com/dasomel/nfs-quota-agent/internal/status"
+	"github.com/dasomel/nfs-quota-agent/internal/trash"
 	"github.com/dasomel/nfs-quota-agent/internal/ui"
+	"github.com/dasomel/nfs-quota-agent/internal/verify"
 )
 
 // version is set via ldflags at build time
@@ -55,9 +66,18 @@ Commands:
   status       Show quota status and disk usage
   top          Show top directories by usage
   report       Generate quota report (JSON/YAML)
-  cleanup      Remove orphaned quotas (no matching PV)
+  cleanup      Find and clean up orphaned directories and quotas
   ui           Start web UI dashboard
   audit        Query audit logs
+  quota        Manually get/set/remove project quotas (no Kubernetes needed)
+  doctor       Diagnose the node's quota setup
+  verify       Verify every PV has an enforced quota matching its capacity
+  projects     Maintain /etc/projects and /etc/projid (rebuild, adopt)
+  plan         Show the quota changes 'run' would make (dry-run)
+  trash        List, restore or purge quarantined orphan directories
+  forecast     Predict when directories and the export disk will fill
+  history      Export, import or summarize usage history (CSV, JSON, Parquet)
+  chargeback   Storage cost per namespace or team for a billing period
   completion   Generate shell completion script
   version      Print version information
 
@@ -88,6 +108,33 @@ Examples:
   # Query audit logs
   nfs-quota-agent audit --file=/var/log/nfs-quota-agent/audit.log
 
+  # Set a 10Gi quota on a directory outside Kubernetes
+  nfs-quota-agent quota set /data/shared 10Gi --path=/data
+
+  # Diagnose quota setup
+  nfs-quota-agent doctor --nfs-base-path=/export --kubeconfig=~/.kube/config
+
+  # Verify quotas of all PVs (exits non-zero on problems)
+  nfs-quota-agent verify --nfs-base-path=/export --kubeconfig=~/.kube/config
+
+  # Show what the agent would change before running it
+  nfs-quota-agent plan --nfs-base-path=/export --kubeconfig=~/.kube/config
+
+  # Restore an orphan directory moved to the trash
+  nfs-quota-agent trash restore <id> --nfs-base-path=/export
+
+  # Predict when volumes will hit their quota from usage history
+  nfs-quota-agent forecast --path=/export --window=336h
+
+  # Export the last 30 days of usage history as Parquet
+  nfs-quota-agent history export --format=parquet --since=30d --out=usage.parquet
+
+  # Storage cost per team for last month
+  nfs-quota-agent chargeback --prices="nfs-fast=0.10,*=0.05" --label=team
+
+  # Rebuild /etc/projects and /etc/projid from cluster state
+  nfs-quota-agent projects rebuild --nfs-base-path=/export --kubeconfig=~/.kube/config
+
   # Generate shell completion
   source <(nfs-quota-agent completion bash)
 `, version)
@@ -114,6 +161,24 @@ func main() {
 		runUI(os.Args[2:])
 	case "audit":
 		runAudit(os.Args[2:])
+	case "quota":
+		runQuota(os.Args[2:])
+	case "doctor":
+		runDoctor(os.Args[2:])
+	case "verify":
+		runVerify(os.Args[2:])
+	case "projects":
+		runProjects(os.Args[2:])
+	case "plan":
+		runPlan(os.Args[2:])
+	case "trash":
+		runTrash(os.Args[2:])
+	case "forecast":
+		runForecast(os.Args[2:])
+	case "history":
+		runHistory(os.Args[2:])
+	case "chargeback":
+		runChargeback(os.Args[2:])
 	case "completion":
 		completion.RunCompletion(os.Args[2:])
 	case "version", "--version", "-v":
@@ -147,18 +212,50 @@ func runAgent(args []string) {
 		uiAddr          string
 		enableAudit     bool
 		auditLogPath    string
+		rebuildProjects bool
+		adoptExisting   bool
+		dryRun          bool
+		freezeConfigMap string
 
 		// Auto-cleanup options
 		enableAutoCleanup bool
 		cleanupInterval   time.Duration
+		cleanupSchedule   string
+		cleanupWindow     time.Duration
 		orphanGracePeriod time.Duration
 		cleanupDryRun     bool
+		trashDir          string
+		orphanStateFile   string
+		trashRetention    time.Duration
+		archiveDir        string
+		archiveFormat     string
+		purgeFilesPerSec  int
+		purgeBytesPerSec  string
+		purgeIdleIO       bool
+		orphanExcludes    stringListFlag
+		cleanupMaxCount   int
+		cleanupMaxBytes   string
+		cleanupAbortPct   int
 
 		// History options
 		enableHistory    bool
 		historyPath      string
 		historyInterval  time.Duration
 		historyRetention time.Duration
+		historyRaw       time.Duration
+		historyHourly    time.Duration
+		historyMaxSize   string
+		historyMaxPaths  int
+		historyEvict     time.Duration
+		forecastWindow   time.Duration
+		forecastMethod   string
+		enableAnomalies  bool
+		anomalyBaseline  time.Duration
+		anomalySens      float64
+		anomalyMinGrowth string
+		anomalyMinDrop   string
+		chargebackPrices string
+		priceCurrency    string
 
 		// Policy options
 		enablePolicy    bool
@@ -177,18 +274,50 @@ func runAgent(args []string) {
 	fs.StringVar(&uiAddr, "ui-addr", ":8080", "Web UI listen address")
 	fs.BoolVar(&enableAudit, "enable-audit", false, "Enable audit logging")
 	fs.StringVar(&auditLogPath, "audit-log-path", "/var/log/nfs-quota-agent/audit.log", "Audit log file path")
+	fs.BoolVar(&rebuildProjects, "rebuild-projects", false, "Regenerate /etc/projects and /etc/projid from cluster state on startup")
+	fs.BoolVar(&adoptExisting, "adopt-existing", false, "Keep existing project IDs of PV directories and reconcile only the limits")
+	fs.BoolVar(&dryRun, "dry-run", false, "Log and audit planned quota changes without touching the filesystem or PV annotations")
+	fs.StringVar(&freezeConfigMap, "freeze-configmap", "", "ConfigMap (namespace/name) whose \"frozen\" key pauses quota changes and cleanup")
 
 	// Auto-cleanup flags
 	fs.BoolVar(&enableAutoCleanup, "enable-auto-cleanup", false, "Enable automatic orphan directory cleanup")
 	fs.DurationVar(&cleanupInterval, "cleanup-interval", 1*time.Hour, "Interval between cleanup runs")
+	fs.StringVar(&cleanupSchedule, "cleanup-schedule", "", "Cron schedule for cleanup runs in local time, e.g. \"0 1 * * 1-5\" (replaces --cleanup-interval; CRON_TZ=<zone> prefix supported)")
+	fs.DurationVar(&cleanupWindow, "cleanup-window", 0, "How long each scheduled cleanup may move and purge directories before deferring the rest (0 = until done)")
 	fs.DurationVar(&orphanGracePeriod, "orphan-grace-period", 24*time.Hour, "Grace period before deleting orphans")
+	fs.StringVar(&orphanStateFile, "orphan-state-file", "", "File that keeps orphan first-seen times across restarts (default: <nfs-base-path>/"+agent.DefaultOrphanStateFile+")")
+	fs.StringVar(&trashDir, "trash-dir", "", "Directory removed orphans are moved to, on the same filesystem (default: <nfs-base-path>/.nfs-quota-trash)")
+	fs.DurationVar(&trashRetention, "trash-retention", trash.DefaultRetention, "How long removed orphans are kept in the trash before being purged")
+	fs.StringVar(&archiveDir, "archive-dir", "", "Archive trashed orphans to this local directory before purging them (empty disables archiving)")
+	fs.StringVar(&archiveFormat, "archive-format", string(archive.FormatGzip), "Archive format: tar.gz, tar.zst (requires the zstd binary)")
+	fs.IntVar(&purgeFilesPerSec, "purge-files-per-sec", 0, "Maximum files deleted per second when purging the trash (0 = unlimited)")
+	fs.StringVar(&purgeBytesPerSec, "purge-bytes-per-sec", "", "Maximum data deleted per second when purging the trash, e.g. 200Mi (empty = unlimited)")
+	fs.BoolVar(&purgeIdleIO, "purge-idle-io", true, "Purge the trash at idle I/O priority (Linux, BFQ/CFQ schedulers)")
+	fs.Var(&orphanExcludes, "orphan-exclude", "Protect directories from cleanup: glob on name or relative path, or re:<regex> (repeatable)")
+	fs.IntVar(&cleanupMaxCount, "cleanup-max-count", 0, "Maximum orphans removed per cleanup run (0 = unlimited)")
+	fs.StringVar(&cleanupMaxBytes, "cleanup-max-bytes", "", "Maximum orphan data removed per cleanup run, e.g. 100Gi (empty = unlimited)")
+	fs.IntVar(&cleanupAbortPct, "cleanup-abort-percent", agent.DefaultAbortPercent, "Abort a cleanup run when more than this percent of directories look orphaned (0 = never)")
 	fs.BoolVar(&cleanupDryRun, "cleanup-dry-run", true, "Dry-run mode for cleanup (no actual deletion)")
 
 	// History flags
 	fs.BoolVar(&enableHistory, "enable-history", false, "Enable usage history collection")
-	fs.StringVar(&historyPath, "history-path", "/var/lib/nfs-quota-agent/history.json", "Path to store usage history")
+	fs.StringVar(&historyPath, "history-path", "/var/lib/nfs-quota-agent/history", "Directory to store usage history segments")
 	fs.DurationVar(&historyInterval, "history-interval", 5*time.Minute, "Interval between history snapshots")
-	fs.DurationVar(&historyRetention, "history-retention", 30*24*time.Hour, "How long to keep history data")
+	fs.DurationVar(&historyRetention, "history-retention", 365*24*time.Hour, "How long to keep history data (daily rollups)")
+	fs.DurationVar(&historyRaw, "history-raw-retention", 48*time.Hour, "How long to keep raw history snapshots")
+	fs.DurationVar(&historyHourly, "history-hourly-retention", 30*24*time.Hour, "How long to keep hourly history rollups (0 = disabled)")
+	fs.StringVar(&historyMaxSize, "history-max-size", "", "Cap on history size on disk, oldest days dropped first (e.g. 5Gi, empty = unlimited)")
+	fs.IntVar(&historyMaxPaths, "history-max-paths", 0, "Cap on directories with history; new ones are not recorded beyond it (0 = unlimited)")
+	fs.DurationVar(&historyEvict, "history-evict-after", 0, "Drop a directory's history once its PV has been gone this long (0 = never)")
+	fs.DurationVar(&forecastWindow, "forecast-window", history.DefaultForecastWindow, "How much usage history time-to-full forecasts fit")
+	fs.StringVar(&forecastMethod, "forecast-method", history.MethodLinear, "Forecast fit: linear or robust")
+	fs.BoolVar(&enableAnomalies, "enable-anomaly-detection", false, "Detect abnormal usage growth and sudden drops from history")
+	fs.DurationVar(&anomalyBaseline, "anomaly-baseline", 24*time.Hour, "How much history a directory's usual growth rate is taken from")
+	fs.Float64Var(&anomalySens, "anomaly-sensitivity", 6, "Deviations from the usual growth rate that count as abnormal (lower = more alerts)")
+	fs.StringVar(&anomalyMinGrowth, "anomaly-min-growth", "1Gi", "Growth per hour below which no anomaly is raised")
+	fs.StringVar(&anomalyMinDrop, "anomaly-min-drop", "1Gi", "Smallest drop between two snapshots raised as an anomaly")
+	fs.StringVar(&chargebackPrices, "chargeback-prices", "", "Price per GiB-month by StorageClass for /api/chargeback, e.g. \"nfs-fast=0.10,*=0.05\"")
+	fs.StringVar(&priceCurrency, "chargeback-currency", "USD", "Currency shown with chargeback costs")
 
 	// Policy flags
 	fs.BoolVar(&enablePolicy, "enable-policy", false, "Enable namespace quota policy")
@@ -205,20 +334,7 @@ func runAgent(args []string) {
 	_ = fs.Parse(args)
 
 	// Create Kubernetes client
-	var config *rest.Config
-	var err error
-
-	if kubeconfig != "" {
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
-	} else {
-		config, err = rest.InClusterConfig()
-	}
-	if err != nil {
-		slog.Error("Failed to create Kubernetes config", "error", err)
-		os.Exit(1)
-	}
-
-	client, err := kubernetes.NewForConfig(config)
+	client, err := newKubeClient(kubeconfig)
 	if err != nil {
 		slog.Error("Failed to create Kubernetes client", "error", err)
 		os.Exit(1)
@@ -228,20 +344,105 @@ func runAgent(args []string) {
 	ag := agent.NewQuotaAgent(client, nfsBasePath, nfsServerPath, provisionerName)
 	ag.SetProcessAllNFS(processAllNFS)
 	ag.SetSyncInterval(syncInterval)
+	ag.SetRebuildProjects(rebuildProjects)
+	ag.SetAdoptExisting(adoptExisting)
+	ag.SetDryRun(dryRun)
+	ag.SetFreezeConfigMap(freezeConfigMap)
 
 	// Configure auto-cleanup
 	ag.SetEnableAutoCleanup(enableAutoCleanup)
 	ag.SetCleanupIntervalDuration(cleanupInterval)
+	if cleanupSchedule != "" {
+		schedule, err := cron.Parse(cleanupSchedule)
+		if err != nil {
+			slog.Error("Invalid cleanup schedule", "error", err)
+			os.Exit(1)
+		}
+		ag.SetCleanupSchedule(schedule)
+		ag.SetCleanupWindow(cleanupWindow)
+	}
 	ag.SetOrphanGracePeriodDuration(orphanGracePeriod)
+	ag.SetOrphanStateFile(orphanStateFile)
 	ag.SetCleanupDryRunFlag(cleanupDryRun)
+	ag.SetTrashDir(trashDir)
+	ag.SetTrashRetention(trashRetention)
+	excludeRules, err := agent.ParseExcludeRules(orphanExcludes)
+	if err != nil {
+		slog.Error("Invalid orphan exclude pattern", "error", err)
+		os.Exit(1)
+	}
+	ag.SetOrphanExcludes(excludeRules)
+	maxBytes, err := parseByteSize(cleanupMaxBytes)
+	if err != nil {
+		slog.Error("Invalid cleanup-max-bytes value", "value", cleanupMaxBytes, "error", err)
+		os.Exit(1)
+	}
+	ag.SetCleanupMaxCount(cleanupMaxCount)
+	ag.SetCleanupMaxBytes(uint64(maxBytes))
+	ag.SetCleanupAbortPercent(cleanupAbortPct)
+	if archiveDir != "" {
+		format, err := parseArchiveFormat(archiveFormat)
+		if err != nil {
+			slog.Error("Invalid archive configuration", "error", err)
+			os.Exit(1)
+		}
+		ag.SetArchiveDir(archiveDir)
+		ag.SetArchiveFormat(format)
+		slog.Info("Orphan archiving enabled", "dir", archiveDir, "format", format)
+	}
+	purgeLimits, err := parsePurgeLimits(purgeFilesPerSec, purgeBytesPerSec, purgeIdleIO)
+	if err != nil {
+		slog.Error("Invalid purge limits", "error", err)
+		os.Exit(1)
+	}
+	ag.SetPurgeLimits(purgeLimits)
 
 	// Configure history
 	var historyStore *history.Store
 	if enableHistory {
-		historyStore, err = history.NewStore(historyPath, historyInterval, historyRetention)
+		historyStore, err = history.NewStore(historyPath, historyInterval, history.Retention{
+			Raw:    historyRaw,
+			Hourly: historyHourly,
+			Daily:  historyRetention,
+		})
 		if err != nil {
 			slog.Error("Failed to create history store", "error", err)
 		} else {
+			if err := history.ValidateMethod(forecastMethod); err != nil {
+				slog.Error("Invalid forecast method", "error", err)
+				os.Exit(1)
+			}
+			historyStore.SetForecast(forecastWindow, forecastMethod)
+			maxBytes, err := parseByteSize(historyMaxSize)
+			if err != nil {
+				slog.Error("Invalid history-max-size value", "value", historyMaxSize, "error", err)
+				os.Exit(1)
+			}
+			historyStore.SetLimits(history.Limits{
+				MaxBytes:   maxBytes,
+				MaxPaths:   historyMaxPaths,
+				EvictAfter: historyEvict,
+			})
+			if enableAnomalies {
+				minGrowth, err := parseByteSize(anomalyMinGrowth)
+				if err != nil {
+					slog.Error("Invalid anomaly-min-growth value", "value", anomalyMinGrowth, "error", err)
+					os.Exit(1)
+				}
+				minDrop, err := parseByteSize(anomalyMinDrop)
+				if err != nil {
+					slog.Error("Invalid anomaly-min-drop value", "value", anomalyMinDrop, "error", err)
+					os.Exit(1)
+				}
+				historyStore.SetAnomalyDetection(history.AnomalyConfig{
+					Enabled:     true,
+					Baseline:    anomalyBaseline,
+					Sensitivity: anomalySens,
+					MinGrowth:   uint64(minGrowth),
+					MinDrop:     uint64(minDrop),
+				})
+				slog.Info("Usage anomaly detection enabled", "baseline", anomalyBaseline, "sensitivity", anomalySens)
+			}
 			ag.SetHistoryStore(historyStore)
 			slog.Info("History collection enabled", "path", historyPath, "interval", historyInterval)
 		}
@@ -281,6 +482,11 @@ func runAgent(args []string) {
 
 	// Start UI server if enabled
 	if enableUI {
+		prices, err := chargeback.ParsePrices(chargebackPrices, priceCurrency)
+		if err != nil {
+			slog.Error("Invalid chargeback prices", "error", err)
+			os.Exit(1)
+		}
 		actualAuditPath := ""
 		if enableAudit {
 			actualAuditPath = auditLogPath
@@ -295,6 +501,8 @@ func runAgent(args []string) {
 				Client:        client,
 				Agent:         ag,
 				HistoryStore:  historyStore,
+				Prices:        prices,
+				Usages:        ag.UsageCache(),
 			}); err != nil {
 				slog.Error("Web UI server failed", "error", err)
 			}
@@ -305,12 +513,52 @@ func runAgent(args []string) {
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
+	go handleFreezeSignals(ctx, ag)
+
 	if err := ag.Run(ctx); err != nil {
 		slog.Error("Agent failed", "error", err)
 		os.Exit(1)
 	}
 }
 
+// handleFreezeSignals freezes the agent on SIGUSR1 and unfreezes it on SIGUSR2
+func handleFreezeSignals(ctx context.Context, ag *agent.QuotaAgent) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(sigCh)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-sigCh:
+			if sig == syscall.SIGUSR1 {
+				ag.Freeze(agent.FreezeSourceSignal, "SIGUSR1")
+			} else {
+				ag.Unfreeze(agent.FreezeSourceSignal)
+			}
+		}
+	}
+}
+
+// newKubeClient creates a Kubernetes client from a kubeconfig file, or from
+// the in-cluster config if kubeconfig is empty
+func newKubeClient(kubeconfig string) (kubernetes.Interface, error) {
+	var config *rest.Config
+	var err error
+
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes config: %w", err)
+	}
+
+	return kubernetes.NewForConfig(config)
+}
+
 func runStatus(args []string) {
 	fs := flag.NewFlagSet("status", flag.ExitOnError)
 
@@ -436,36 +684,88 @@ func runCleanup(args []string) {
 	fs := flag.NewFlagSet("cleanup", flag.ExitOnError)
 
 	var (
-		path       string
 		kubeconfig string
-		dryRun     bool
-		force      bool
+		excludes   stringListFlag
+		maxBytes   string
+		legacyPath string
+		legacyYes  bool
+		opts       cleanup.Options
 	)
 
-	fs.StringVar(&path, "path", "/data", "NFS export path")
-	fs.StringVar(&kubeconfig, "kubeconfig", "", "Path to kubeconfig file")
-	fs.BoolVar(&dryRun, "dry-run", true, "Dry-run mode (no changes)")
-	fs.BoolVar(&force, "force", false, "Force cleanup without confirmation")
+	fs.StringVar(&kubeconfig, "kubeconfig", "", "Path to kubeconfig file (optional, uses in-cluster config if not set)")
+	fs.StringVar(&opts.NfsBasePath, "nfs-base-path", "/export", "Local path where NFS is mounted")
+	fs.StringVar(&legacyPath, "path", "", "Removed: use --nfs-base-path (and --quota-only for the old behavior)")
+	fs.StringVar(&opts.NfsServerPath, "nfs-server-path", "/data", "NFS server's export path")
+	fs.StringVar(&opts.ProvisionerName, "provisioner-name", "cluster.local/nfs-subdir-external-provisioner", "Provisioner name to filter PVs")
+	fs.BoolVar(&opts.ProcessAllNFS, "process-all-nfs", false, "Process all NFS PVs regardless of provisioner")
+	fs.StringVar(&opts.ProjectsFile, "projects-file", "/etc/projects", "Path to the projects file")
+	fs.StringVar(&opts.ProjidFile, "projid-file", "/etc/projid", "Path to the projid file")
+	fs.StringVar(&opts.TrashDir, "trash-dir", "", "Trash directory (default: <nfs-base-path>/.nfs-quota-trash)")
+	fs.StringVar(&opts.AuditLogPath, "audit-log", "", "Audit log file path, also read for orphan ownership hints (empty disables audit logging)")
+	fs.Var(&excludes, "orphan-exclude", "Never clean up directories matching this glob, or regex with re: prefix (repeatable)")
+	fs.BoolVar(&opts.QuotaOnly, "quota-only", false, "Only remove quotas whose directory is gone; report directories without PV")
+	fs.DurationVar(&opts.GracePeriod, "orphan-grace-period", 24*time.Hour, "Grace period before moving orphans to the trash")
+	fs.StringVar(&opts.StateFile, "orphan-state-file", "", "File that keeps orphan first-seen times, shared with the agent (default: <nfs-base-path>/"+agent.DefaultOrphanStateFile+")")
+	fs.IntVar(&opts.MaxCount, "cleanup-max-count", 0, "Maximum orphans moved to the trash per run (0 = unlimited)")
+	fs.StringVar(&maxBytes, "cleanup-max-bytes", "", "Maximum orphan data moved to the trash per run, e.g. 100Gi (empty = unlimited)")
+	fs.IntVar(&opts.AbortPercent, "cleanup-abort-percent", agent.DefaultAbortPercent, "Abort when more than this percent of directories look orphaned (0 = never)")
+	fs.StringVar(&opts.Output, "output", "table", "Output format: table, json")
+	fs.BoolVar(&opts.DryRun, "dry-run", true, "Only report orphans (no changes)")
+	fs.BoolVar(&opts.Yes, "yes", false, "Clean up without confirmation")
+	fs.BoolVar(&legacyYes, "force", false, "Removed: use --yes (and --quota-only for the old behavior)")
 
 	fs.Usage = func() {
 		fmt.Println("Usage: nfs-quota-agent cleanup [flags]")
-		fmt.Println("\nRemove orphaned quotas that have no matching PV in Kubernetes")
+		fmt.Println("\nFind orphans with the same detection the agent uses for native and CSI PVs:")
+		fmt.Println("  dir-without-pv     directory no PV points to (moved to the trash after --orphan-grace-period)")
+		fmt.Println("  quota-without-dir  projects file entry whose directory is gone (quota removed)")
+		fmt.Println("  pv-without-quota   managed PV without a project (reported only)")
 		fmt.Println("\nFlags:")
 		fs.PrintDefaults()
 		fmt.Println("\nExamples:")
-		fmt.Println("  # Dry-run (default, shows what would be removed)")
-		fmt.Println("  nfs-quota-agent cleanup --path=/data --kubeconfig=~/.kube/config")
+		fmt.Println("  # Dry-run (default, shows what would be cleaned up)")
+		fmt.Println("  nfs-quota-agent cleanup --nfs-base-path=/export --kubeconfig=~/.kube/config")
 		fmt.Println("")
-		fmt.Println("  # Actually remove orphaned quotas")
-		fmt.Println("  nfs-quota-agent cleanup --path=/data --kubeconfig=~/.kube/config --dry-run=false")
+		fmt.Println("  # Clean up after confirmation")
+		fmt.Println("  nfs-quota-agent cleanup --nfs-base-path=/export --kubeconfig=~/.kube/config --dry-run=false")
 		fmt.Println("")
-		fmt.Println("  # Force remove without confirmation")
-		fmt.Println("  nfs-quota-agent cleanup --path=/data --kubeconfig=~/.kube/config --dry-run=false --force")
+		fmt.Println("  # Clean up without confirmation, at most 10 directories, JSON output")
+		fmt.Println("  nfs-quota-agent cleanup --nfs-base-path=/export --dry-run=false --yes --cleanup-max-count=10 --output=json")
+		fmt.Println("")
+		fmt.Println("  # Only remove quotas whose directory is gone, as before")
+		fmt.Println("  nfs-quota-agent cleanup --nfs-base-path=/export --dry-run=false --quota-only")
 	}
 
 	_ = fs.Parse(args)
+	opts.Excludes = excludes
+
+	// cleanup used to only remove quotas; refuse the old flags rather than
+	// start moving directories in scripts written for that
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "path" || f.Name == "force" {
+			fmt.Fprintf(os.Stderr, "Error: --%s is no longer supported: cleanup now also moves directories without a PV to the trash. "+
+				"Use --nfs-base-path and --yes, and --quota-only to only remove quotas as before\n", f.Name)
+			os.Exit(1)
+		}
+	})
+	size, err := parseByteSize(maxBytes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid --cleanup-max-bytes: %v\n", err)
+		os.Exit(1)
+	}
+	opts.MaxBytes = uint64(size)
 
-	if err := cleanup.RunCleanup(path, kubeconfig, dryRun, force); err != nil {
+	client, err := newKubeClient(kubeconfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts.Client = client
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	if err := cleanup.Run(ctx, opts); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -487,7 +787,7 @@ func runAudit(args []string) {
 	)
 
 	fs.StringVar(&filePath, "file", "/var/log/nfs-quota-agent/audit.log", "Audit log file path")
-	fs.StringVar(&action, "action", "", "Filter by action (CREATE, UPDATE, DELETE, CLEANUP)")
+	fs.StringVar(&action, "action", "", "Filter by action (CREATE, UPDATE, DELETE, CLEANUP, QUARANTINE, RESTORE, PURGE, ARCHIVE, ANOMALY)")
 	fs.StringVar(&pvName, "pv", "", "Filter by PV name")
 	fs.StringVar(&namespace, "namespace", "", "Filter by namespace")
 	fs.StringVar(&startTime, "start", "", "Start time (RFC3339 format)")
@@ -563,3 +863,735 @@ func runAudit(args []string) {
 	fmt.Printf("Found %d audit entries:\n\n", len(entries))
 	audit.PrintEntries(entries, format)
 }
+
+func runQuota(args []string) {
+	usage := func() {
+		fmt.Println("Usage: nfs-quota-agent quota <set|get|remove|list> [args] [flags]")
+		fmt.Println("\nManage project quotas on directories outside Kubernetes")
+		fmt.Println("\nCommands:")
+		fmt.Println("  set <path> <size>   Set or update the quota of a directory (e.g. 10Gi)")
+		fmt.Println("  get <path>          Show the quota of a directory")
+		fmt.Println("  remove <path>       Remove the quota of a directory")
+		fmt.Println("  list                List all configured project quotas")
+		fmt.Println("\nExamples:")
+		fmt.Println("  nfs-quota-agent quota set /data/shared 10Gi --path=/data")
+		fmt.Println("  nfs-quota-agent quota set /data/shared 50Gi --inodes=100000 --soft --path=/data")
+		fmt.Println("  nfs-quota-agent quota get /data/shared --path=/data --output=json")
+		fmt.Println("  nfs-quota-agent quota remove /data/shared --path=/data")
+		fmt.Println("  nfs-quota-agent quota list --path=/data")
+	}
+
+	if len(args) == 0 || args[0] == "help" || args[0] == "--help" || args[0] == "-h" {
+		usage()
+		return
+	}
+
+	sub := args[0]
+	fs := flag.NewFlagSet("quota "+sub, flag.ExitOnError)
+
+	var (
+		opts        manual.Options
+		inodes      int64
+		soft        bool
+		projectName string
+	)
+
+	fs.StringVar(&opts.QuotaPath, "path", "/data", "Mount point of the quota-enabled filesystem")
+	fs.StringVar(&opts.ProjectsFile, "projects-file", "/etc/projects", "Path to the projects file")
+	fs.StringVar(&opts.ProjidFile, "projid-file", "/etc/projid", "Path to the projid file")
+	fs.StringVar(&opts.AuditLogPath, "audit-log", "", "Audit log file path (empty disables audit logging)")
+	fs.StringVar(&opts.Output, "output", "table", "Output format: table, json")
+	if sub == "set" {
+		fs.Int64Var(&inodes, "inodes", 0, "Inode (file count) limit, 0 for no limit (kept when not given)")
+		fs.BoolVar(&soft, "soft", false, "Set soft limits instead of hard limits")
+		fs.StringVar(&projectName, "name", "", "Project name for new quotas (default: dir_<directory name>)")
+	}
+
+	fs.Usage = func() {
+		usage()
+		fmt.Println("\nFlags:")
+		fs.PrintDefaults()
+	}
+
+	positional := parseInterspersed(fs, args[1:])
+
+	var err error
+	switch sub {
+	case "set":
+		if len(positional) != 2 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		var size int64
+		size, err = policy.ParseQuotaSize(positional[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid size %q: %v\n", positional[1], err)
+			os.Exit(1)
+		}
+		req := manual.SetRequest{
+			Path:        positional[0],
+			SizeBytes:   size,
+			Inodes:      inodes,
+			Soft:        soft,
+			ProjectName: projectName,
+		}
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "inodes" {
+				req.InodesSet = true
+			}
+		})
+		err = manual.Set(opts, req)
+	case "get":
+		if len(positional) != 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		err = manual.Get(opts, positional[0])
+	case "remove":
+		if len(positional) != 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		err = manual.Remove(opts, positional[0])
+	case "list":
+		err = manual.List(opts)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown quota command: %s\n\n", sub)
+		usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// stringListFlag is a flag that may be repeated to collect several values
+type stringListFlag []string
+
+func (s *stringListFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringListFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// parseInterspersed parses flags that may appear before, between or after
+// positional arguments and returns the positional arguments
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		_ = fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func runDoctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+
+	var (
+		kubeconfig string
+		output     string
+		opts       doctor.Options
+	)
+
+	fs.StringVar(&kubeconfig, "kubeconfig", "", "Path to kubeconfig file (optional, uses in-cluster config if not set)")
+	fs.StringVar(&opts.NfsBasePath, "nfs-base-path", "/export", "Local path where NFS is mounted")
+	fs.StringVar(&opts.NfsServerPath, "nfs-server-path", "/data", "NFS server's export path")
+	fs.StringVar(&opts.ProvisionerName, "provisioner-name", "cluster.local/nfs-subdir-external-provisioner", "Provisioner name to filter PVs")
+	fs.BoolVar(&opts.ProcessAllNFS, "process-all-nfs", false, "Process all NFS PVs regardless of provisioner")
+	fs.StringVar(&opts.ProjectsFile, "projects-file", "/etc/projects", "Path to the projects file")
+	fs.StringVar(&opts.ProjidFile, "projid-file", "/etc/projid", "Path to the projid file")
+	fs.StringVar(&output, "output", "table", "Output format: table, json")
+
+	fs.Usage = func() {
+		fmt.Println("Usage: nfs-quota-agent doctor [flags]")
+		fmt.Println("\nDiagnose quota tools, mount options, quota state, projects files,")
+		fmt.Println("PV path mapping and RBAC permissions on this node")
+		fmt.Println("\nFlags:")
+		fs.PrintDefaults()
+		fmt.Println("\nExit status is 1 if any check fails.")
+	}
+
+	_ = fs.Parse(args)
+
+	opts.Client, opts.ClientErr = newKubeClient(kubeconfig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	report := doctor.Run(ctx, opts)
+	if err := report.Print(output); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if report.Failures > 0 {
+		os.Exit(1)
+	}
+}
+
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+
+	var (
+		kubeconfig string
+		output     string
+		opts       verify.Options
+	)
+
+	fs.StringVar(&kubeconfig, "kubeconfig", "", "Path to kubeconfig file (optional, uses in-cluster config if not set)")
+	fs.StringVar(&opts.NfsBasePath, "nfs-base-path", "/export", "Local path where NFS is mounted")
+	fs.StringVar(&opts.NfsServerPath, "nfs-server-path", "/data", "NFS server's export path")
+	fs.StringVar(&opts.ProvisionerName, "provisioner-name", "cluster.local/nfs-subdir-external-provisioner", "Provisioner name to filter PVs")
+	fs.BoolVar(&opts.ProcessAllNFS, "process-all-nfs", false, "Process all NFS PVs regardless of provisioner")
+	fs.BoolVar(&opts.AdoptExisting, "adopt-existing", false, "Expect the project IDs 'run --adopt-existing' keeps")
+	fs.StringVar(&opts.ProjectsFile, "projects-file", "/etc/projects", "Path to the projects file (with --adopt-existing)")
+	fs.StringVar(&opts.ProjidFile, "projid-file", "/etc/projid", "Path to the projid file (with --adopt-existing)")
+	fs.StringVar(&output, "output", "table", "Output format: table, json")
+
+	fs.Usage = func() {
+		fmt.Println("Usage: nfs-quota-agent verify [flags]")
+		fmt.Println("\nCheck that every PV the agent would process has an enforced project quota")
+		fmt.Println("matching its capacity. Each PV is reported as OK, MISSING, WRONG_LIMIT,")
+		fmt.Println("WRONG_PROJECT, CONFLICT or DIR_MISSING.")
+		fmt.Println("\nFlags:")
+		fs.PrintDefaults()
+		fmt.Println("\nExit status is 1 if any PV is not OK.")
+	}
+
+	_ = fs.Parse(args)
+
+	client, err := newKubeClient(kubeconfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts.Client = client
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	report, err := verify.Run(ctx, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := report.Print(output); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if report.Problems > 0 {
+		os.Exit(1)
+	}
+}
+
+func runProjects(args []string) {
+	usage := func() {
+		fmt.Println("Usage: nfs-quota-agent projects <rebuild|adopt> [flags]")
+		fmt.Println("\nMaintain the projects and projid files")
+		fmt.Println("\nCommands:")
+		fmt.Println("  rebuild   Regenerate the files from the PV list and the project IDs")
+		fmt.Println("            stored on the directories, show a diff and write after confirmation")
+		fmt.Println("  adopt     Show which existing project IDs 'run --adopt-existing' keeps")
+		fmt.Println("            for each PV directory and report conflicts (read-only)")
+		fmt.Println("\nExamples:")
+		fmt.Println("  nfs-quota-agent projects rebuild --nfs-base-path=/export --dry-run")
+		fmt.Println("  nfs-quota-agent projects rebuild --nfs-base-path=/export --yes")
+		fmt.Println("  nfs-quota-agent projects adopt --nfs-base-path=/export")
+	}
+
+	if len(args) == 0 || args[0] == "help" || args[0] == "--help" || args[0] == "-h" {
+		usage()
+		return
+	}
+	sub := args[0]
+	if sub != "rebuild" && sub != "adopt" {
+		fmt.Fprintf(os.Stderr, "Unknown projects command: %s\n\n", sub)
+		usage()
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("projects "+sub, flag.ExitOnError)
+
+	var (
+		kubeconfig string
+		opts       projects.Options
+	)
+
+	fs.StringVar(&kubeconfig, "kubeconfig", "", "Path to kubeconfig file (optional, uses in-cluster config if not set)")
+	fs.StringVar(&opts.NfsBasePath, "nfs-base-path", "/export", "Local path where NFS is mounted")
+	fs.StringVar(&opts.NfsServerPath, "nfs-server-path", "/data", "NFS server's export path")
+	fs.StringVar(&opts.ProvisionerName, "provisioner-name", "cluster.local/nfs-subdir-external-provisioner", "Provisioner name to filter PVs")
+	fs.BoolVar(&opts.ProcessAllNFS, "process-all-nfs", false, "Process all NFS PVs regardless of provisioner")
+	fs.StringVar(&opts.ProjectsFile, "projects-file", "/etc/projects", "Path to the projects file")
+	fs.StringVar(&opts.ProjidFile, "projid-file", "/etc/projid", "Path to the projid file")
+	fs.StringVar(&opts.Output, "output", "table", "Output format: table, json")
+	if sub == "rebuild" {
+		fs.BoolVar(&opts.DryRun, "dry-run", false, "Show the diff without writing")
+		fs.BoolVar(&opts.Yes, "yes", false, "Write without confirmation (required to write with --output=json)")
+	}
+
+	fs.Usage = func() {
+		usage()
+		fmt.Println("\nFlags:")
+		fs.PrintDefaults()
+	}
+
+	_ = fs.Parse(args[1:])
+
+	client, err := newKubeClient(kubeconfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts.Client = client
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if sub == "adopt" {
+		blocked, err := projects.Adopt(ctx, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if blocked > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := projects.Rebuild(ctx, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runPlan(args []string) {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+
+	var (
+		kubeconfig string
+		output     string
+		opts       plan.Options
+	)
+
+	fs.StringVar(&kubeconfig, "kubeconfig", "", "Path to kubeconfig file (optional, uses in-cluster config if not set)")
+	fs.StringVar(&opts.NfsBasePath, "nfs-base-path", "/export", "Local path where NFS is mounted")
+	fs.StringVar(&opts.NfsServerPath, "nfs-server-path", "/data", "NFS server's export path")
+	fs.StringVar(&opts.ProvisionerName, "provisioner-name", "cluster.local/nfs-subdir-external-provisioner", "Provisioner name to filter PVs")
+	fs.BoolVar(&opts.ProcessAllNFS, "process-all-nfs", false, "Process all NFS PVs regardless of provisioner")
+	fs.StringVar(&opts.ProjectsFile, "projects-file", "/etc/projects", "Path to the projects file")
+	fs.StringVar(&opts.ProjidFile, "projid-file", "/etc/projid", "Path to the projid file")
+	fs.BoolVar(&opts.AdoptExisting, "adopt-existing", false, "Plan as 'run --adopt-existing' would")
+	fs.StringVar(&output, "output", "table", "Output format: table, json")
+
+	fs.Usage = func() {
+		fmt.Println("Usage: nfs-quota-agent plan [flags]")
+		fmt.Println("\nPerform a full PV sync without applying anything and list every quota")
+		fmt.Println("create, update, shrink, project assignment and projects file edit")
+		fmt.Println("'run' would make.")
+		fmt.Println("\nFlags:")
+		fs.PrintDefaults()
+	}
+
+	_ = fs.Parse(args)
+
+	client, err := newKubeClient(kubeconfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts.Client = client
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	p, err := plan.Run(ctx, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := p.Print(output); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runTrash(args []string) {
+	usage := func() {
+		fmt.Println("Usage: nfs-quota-agent trash <list|restore|purge> [id] [flags]")
+		fmt.Println("\nManage orphan directories quarantined by the agent")
+		fmt.Println("\nCommands:")
+		fmt.Println("  list           List quarantined directories")
+		fmt.Println("  restore <id>   Move a directory back to its original path (or --to)")
+		fmt.Println("  purge [id]     Permanently delete expired directories, one directory,")
+		fmt.Println("                 or all with --all")
+		fmt.Println("\nExamples:")
+		fmt.Println("  nfs-quota-agent trash list --nfs-base-path=/export")
+		fmt.Println("  nfs-quota-agent trash restore 20240101T120000Z-pvc-abc --nfs-base-path=/export")
+		fmt.Println("  nfs-quota-agent trash purge --nfs-base-path=/export --retention=72h")
+	}
+
+	if len(args) == 0 || args[0] == "help" || args[0] == "--help" || args[0] == "-h" {
+		usage()
+		return
+	}
+
+	sub := args[0]
+	fs := flag.NewFlagSet("trash "+sub, flag.ExitOnError)
+
+	var (
+		opts          trash.Options
+		target        string
+		all           bool
+		archiveFormat string
+		filesPerSec   int
+		bytesPerSec   string
+		idleIO        bool
+	)
+
+	fs.StringVar(&opts.BasePath, "nfs-base-path", "/export", "Local path where NFS is mounted")
+	fs.StringVar(&opts.TrashDir, "trash-dir", "", "Trash directory (default: <nfs-base-path>/.nfs-quota-trash)")
+	fs.DurationVar(&opts.Retention, "retention", trash.DefaultRetention, "Trash retention used to decide what has expired")
+	fs.StringVar(&opts.AuditLogPath, "audit-log", "", "Audit log file path (empty disables audit logging)")
+	fs.StringVar(&opts.Output, "output", "table", "Output format: table, json")
+	switch sub {
+	case "restore":
+		fs.StringVar(&target, "to", "", "Restore to this path instead of the original path")
+	case "purge":
+		fs.BoolVar(&all, "all", false, "Purge every entry, not only expired ones")
+		fs.BoolVar(&opts.Yes, "yes", false, "Purge without confirmation")
+		fs.StringVar(&opts.Archive.Dir, "archive-dir", "", "Archive directories to this local directory before purging them")
+		fs.StringVar(&archiveFormat, "archive-format", string(archive.FormatGzip), "Archive format: tar.gz, tar.zst")
+		fs.IntVar(&filesPerSec, "files-per-sec", 0, "Maximum files deleted per second (0 = unlimited)")
+		fs.StringVar(&bytesPerSec, "bytes-per-sec", "", "Maximum data deleted per second, e.g. 200Mi (empty = unlimited)")
+		fs.BoolVar(&idleIO, "idle-io", true, "Delete at idle I/O priority (Linux, BFQ/CFQ schedulers)")
+	}
+
+	fs.Usage = func() {
+		usage()
+		fmt.Println("\nFlags:")
+		fs.PrintDefaults()
+	}
+
+	positional := parseInterspersed(fs, args[1:])
+
+	if opts.Archive.Dir != "" {
+		format, err := parseArchiveFormat(archiveFormat)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts.Archive.Format = format
+	}
+
+	var err error
+	if sub == "purge" {
+		if opts.Limits, err = parsePurgeLimits(filesPerSec, bytesPerSec, idleIO); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	switch sub {
+	case "list":
+		err = trash.List(opts)
+	case "restore":
+		if len(positional) != 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		err = trash.Restore(opts, positional[0], target)
+	case "purge":
+		if len(positional) > 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		id := ""
+		if len(positional) == 1 {
+			id = positional[0]
+		}
+		// Interrupting leaves the entry checkpointed for the next purge
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		err = trash.Purge(ctx, opts, id, all)
+		cancel()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown trash command: %s\n\n", sub)
+		usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runForecast(args []string) {
+	fs := flag.NewFlagSet("forecast", flag.ExitOnError)
+
+	var opts history.ForecastOptions
+
+	fs.StringVar(&opts.HistoryPath, "history-path", "/var/lib/nfs-quota-agent/history", "Directory with the agent's usage history")
+	fs.StringVar(&opts.BasePath, "path", "/export", "NFS export path (for the disk forecast)")
+	fs.StringVar(&opts.Dir, "dir", "", "Only forecast this directory (path or name)")
+	fs.DurationVar(&opts.Window, "window", history.DefaultForecastWindow, "How much history to fit")
+	fs.StringVar(&opts.Method, "method", history.MethodLinear, "Fit: linear (least squares) or robust (Theil-Sen)")
+	fs.StringVar(&opts.Output, "output", "table", "Output format: table, json")
+
+	fs.Usage = func() {
+		fmt.Println("Usage: nfs-quota-agent forecast [flags]")
+		fmt.Println("\nFit the growth of each directory over the last --window of usage history")
+		fmt.Println("and estimate when it reaches its quota and when the export disk fills.")
+		fmt.Println("Reads the history directory of 'run --enable-history' without changing it.")
+		fmt.Println("\nFlags:")
+		fs.PrintDefaults()
+	}
+
+	_ = fs.Parse(args)
+
+	if err := history.RunForecast(opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runChargeback(args []string) {
+	fs := flag.NewFlagSet("chargeback", flag.ExitOnError)
+
+	var (
+		opts       chargeback.RunOptions
+		kubeconfig string
+		month      string
+		since      string
+		until      string
+		prices     string
+		currency   string
+	)
+
+	fs.StringVar(&opts.HistoryPath, "history-path", "/var/lib/nfs-quota-agent/history", "Directory with the agent's usage history")
+	fs.StringVar(&kubeconfig, "kubeconfig", "", "Path to kubeconfig file, for --label (optional, uses in-cluster config if not set)")
+	fs.StringVar(&month, "month", "", "Billing month as YYYY-MM (default: last month)")
+	fs.StringVar(&since, "since", "", "Start of the period instead of --month: RFC 3339, YYYY-MM-DD or a duration ago such as 30d")
+	fs.StringVar(&until, "until", "", "End of the period with --since (default: now)")
+	fs.StringVar(&prices, "prices", "", "Price per GiB-month by StorageClass, e.g. \"nfs-fast=0.10,nfs=0.05,*=0.03\" (* = any other)")
+	fs.StringVar(&currency, "currency", "USD", "Currency shown with costs")
+	fs.StringVar(&opts.Label, "label", "", "Group by this namespace label (e.g. team) instead of by namespace")
+	fs.StringVar(&opts.Format, "format", chargeback.FormatMarkdown, "Output format: markdown, csv, json")
+	fs.StringVar(&opts.Output, "out", "-", "Output file (- for stdout)")
+
+	fs.Usage = func() {
+		fmt.Println("Usage: nfs-quota-agent chargeback [flags]")
+		fmt.Println("\nIntegrate the usage history of 'run --enable-history' over a billing period")
+		fmt.Println("into GiB-hours of quota and of actual usage, and price them per GiB-month")
+		fmt.Println("(730 hours) of each StorageClass, per namespace or namespace label.")
+		fmt.Println("\nExamples:")
+		fmt.Println("  nfs-quota-agent chargeback --prices=\"*=0.05\"")
+		fmt.Println("  nfs-quota-agent chargeback --month=2024-03 --label=team --prices=\"nfs-fast=0.10,*=0.05\" --format=csv --out=march.csv")
+		fmt.Println("\nFlags:")
+		fs.PrintDefaults()
+	}
+
+	_ = fs.Parse(args)
+
+	var err error
+	if opts.Prices, err = chargeback.ParsePrices(prices, currency); err == nil {
+		opts.Start, opts.End, err = chargeback.ParsePeriod(month, since, until, time.Now())
+	}
+	if err == nil && opts.Label != "" {
+		opts.Client, err = newKubeClient(kubeconfig)
+	}
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		err = chargeback.Run(ctx, opts)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runHistory(args []string) {
+	usage := func() {
+		fmt.Println("Usage: nfs-quota-agent history <export|import|summary> [file] [flags]")
+		fmt.Println("\nExport, import or summarize the usage history of 'run --enable-history'")
+		fmt.Println("\nCommands:")
+		fmt.Println("  export          Write history as CSV, JSON or Parquet (each day at the")
+		fmt.Println("                  finest resolution still kept)")
+		fmt.Println("  import <file>   Merge a CSV or JSON export into a history directory,")
+		fmt.Println("                  e.g. on a new node; stop the agent using it first")
+		fmt.Println("  summary         Print usage growth per namespace, StorageClass or for")
+		fmt.Println("                  the whole export over a period")
+		fmt.Println("\nExamples:")
+		fmt.Println("  nfs-quota-agent history export --format=csv --since=7d > usage.csv")
+		fmt.Println("  nfs-quota-agent history export --format=parquet --path=/export/pvc-abc --out=pvc-abc.parquet")
+		fmt.Println("  nfs-quota-agent history export --namespace=team-a --since=30d --out=team-a.csv")
+		fmt.Println("  nfs-quota-agent history export --since=2024-03-01 --until=2024-04-01 --out=march.json")
+		fmt.Println("  nfs-quota-agent history summary --since=90d")
+		fmt.Println("  nfs-quota-agent history summary --by=storageclass --since=2024-01-01 --until=2024-04-01")
+		fmt.Println("  nfs-quota-agent history import usage.json --history-path=/var/lib/nfs-quota-agent/history")
+	}
+
+	if len(args) == 0 || args[0] == "help" || args[0] == "--help" || args[0] == "-h" {
+		usage()
+		return
+	}
+
+	sub := args[0]
+	fs := flag.NewFlagSet("history "+sub, flag.ExitOnError)
+
+	var (
+		historyPath string
+		format      string
+		out         string
+		path        string
+		namespace   string
+		pvc         string
+		since       string
+		until       string
+		groupBy     string
+		step        time.Duration
+		output      string
+		interval    time.Duration
+		retention   history.Retention
+	)
+
+	fs.StringVar(&historyPath, "history-path", "/var/lib/nfs-quota-agent/history", "Directory with the agent's usage history")
+	switch sub {
+	case "export":
+		fs.StringVar(&format, "format", history.FormatCSV, "Output format: csv, json, parquet")
+		fs.StringVar(&out, "out", "-", "Output file (- for stdout)")
+		fs.StringVar(&path, "path", "", "Only export this directory (path or name)")
+		fs.StringVar(&namespace, "namespace", "", "Only export snapshots of PVCs in this namespace")
+		fs.StringVar(&pvc, "pvc", "", "Only export snapshots of this PVC")
+		fs.StringVar(&since, "since", "", "Start time: RFC 3339, YYYY-MM-DD or a duration ago such as 7d")
+		fs.StringVar(&until, "until", "", "End time: RFC 3339, YYYY-MM-DD or a duration ago such as 24h")
+	case "summary":
+		fs.StringVar(&groupBy, "by", history.GroupByNamespace, "Group by: namespace, storageclass, export")
+		fs.StringVar(&since, "since", "30d", "Start of the period: RFC 3339, YYYY-MM-DD or a duration ago such as 90d")
+		fs.StringVar(&until, "until", "", "End of the period (default: now)")
+		fs.DurationVar(&step, "step", 0, "Resolution of the points in JSON output (default: finest kept for the period)")
+		fs.StringVar(&output, "output", "table", "Output format: table, json")
+	case "import":
+		fs.StringVar(&format, "format", "", "Input format: csv, json (default: from the file extension)")
+		fs.DurationVar(&interval, "history-interval", 5*time.Minute, "History collection interval of the agent")
+		fs.DurationVar(&retention.Daily, "history-retention", 365*24*time.Hour, "How long to keep history data (daily rollups)")
+		fs.DurationVar(&retention.Raw, "history-raw-retention", 48*time.Hour, "How long to keep raw history snapshots")
+		fs.DurationVar(&retention.Hourly, "history-hourly-retention", 30*24*time.Hour, "How long to keep hourly history rollups (0 = disabled)")
+	}
+
+	fs.Usage = func() {
+		usage()
+		fmt.Println("\nFlags:")
+		fs.PrintDefaults()
+	}
+
+	positional := parseInterspersed(fs, args[1:])
+
+	var err error
+	switch sub {
+	case "export":
+		opts := history.ExportOptions{
+			HistoryPath: historyPath,
+			Format:      format,
+			Output:      out,
+			Filter: history.ExportFilter{
+				Path:     path,
+				Selector: history.Selector{Namespace: namespace, PVCName: pvc},
+			},
+		}
+		now := time.Now()
+		if opts.Filter.Since, err = history.ParseTime(since, now); err != nil {
+			break
+		}
+		if opts.Filter.Until, err = history.ParseTime(until, now); err != nil {
+			break
+		}
+		err = history.RunExport(opts)
+	case "summary":
+		opts := history.SummaryOptions{
+			HistoryPath: historyPath,
+			GroupBy:     groupBy,
+			Step:        step,
+			Output:      output,
+		}
+		now := time.Now()
+		if opts.Since, err = history.ParseTime(since, now); err != nil {
+			break
+		}
+		if opts.Until, err = history.ParseTime(until, now); err != nil {
+			break
+		}
+		err = history.RunSummary(opts)
+	case "import":
+		if len(positional) != 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		err = history.RunImport(history.ImportOptions{
+			HistoryPath: historyPath,
+			Input:       positional[0],
+			Format:      format,
+			Interval:    interval,
+			Retention:   retention,
+		})
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown history command: %s\n\n", sub)
+		usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// parseByteSize parses a size such as 200Mi, with empty meaning zero
+func parseByteSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v, err := policy.ParseQuotaSize(s)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 {
+		return 0, fmt.Errorf("size %q is negative", s)
+	}
+	return v, nil
+}
+
+// parsePurgeLimits builds the deletion limits for trash purges
+func parsePurgeLimits(filesPerSec int, bytesPerSec string, idleIO bool) (deleter.Limits, error) {
+	if filesPerSec < 0 {
+		return deleter.Limits{}, fmt.Errorf("files per second must not be negative")
+	}
+	bytes, err := parseByteSize(bytesPerSec)
+	if err != nil {
+		return deleter.Limits{}, fmt.Errorf("invalid bytes per second %q: %w", bytesPerSec, err)
+	}
+	return deleter.Limits{FilesPerSec: filesPerSec, BytesPerSec: bytes, IdleIO: idleIO}, nil
+}
+
+// parseArchiveFormat parses an archive format and checks its tools are installed
+func parseArchiveFormat(s string) (archive.Format, error) {
+	format, err := archive.ParseFormat(s)
+	if err != nil {
+		return "", err
+	}
+	if err := archive.CheckFormat(format); err != nil {
+		return "", err
+	}
+	return format, nil
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/dasomel/nfs-quota-agent/internal/quota"
+)
+
+// Adoption sources
+const (
+	AdoptSourceDirectory    = "directory"
+	AdoptSourceProjectsFile = "projects-file"
+	AdoptSourceGenerated    = "generated"
+)
+
+// Adoption describes which project a PV directory is bound to when existing
+// project IDs are adopted instead of generating new ones
+type Adoption struct {
+	PVName      string   `json:"pvName"`
+	Path        string   `json:"path"`
+	ProjectID   uint32   `json:"projectId"`
+	ProjectName string   `json:"projectName"`
+	Source      string   `json:"source"`
+	DirTagged   bool     `json:"dirTagged"` // directory already carries ProjectID
+	Recorded    bool     `json:"recorded"`  // projects file already maps the path to ProjectID
+	Conflicts   []string `json:"conflicts,omitempty"`
+	Blocked     bool     `json:"blocked"` // a conflict prevents applying the limit
+}
+
+// PlanAdoption reports which project every PV directory would be bound to in
+// adoption mode. PVs whose directory does not exist are skipped.
+func (a *QuotaAgent) PlanAdoption(ctx context.Context) ([]Adoption, error) {
+	if a.fsType == "" {
+		if err := a.detectFilesystemType(); err != nil {
+			return nil, fmt.Errorf("failed to detect filesystem type: %w", err)
+		}
+	}
+
+	targets, err := a.Targets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := quota.ReadProjectEntries(a.projectsFile, a.projidFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read project files: %w", err)
+	}
+
+	var result []Adoption
+	for _, t := range targets {
+		dirID, exists, err := a.dirProjectID(t.LocalPath)
+		if !exists {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read project ID of %s: %w", t.LocalPath, err)
+		}
+		result = append(result, DecideAdoption(t, dirID, entries))
+	}
+
+	return result, nil
+}
+
+// adoptTarget decides the project of a single PV directory in adoption mode
+func (a *QuotaAgent) adoptTarget(t PVTarget) (Adoption, error) {
+	if _, err := os.Stat(t.LocalPath); err != nil {
+		return Adoption{}, err
+	}
+	dirID, err := quota.GetDirProjectID(a.fsType, t.LocalPath)
+	if err != nil {
+		return Adoption{}, err
+	}
+	entries, err := quota.ReadProjectEntries(a.projectsFile, a.projidFile)
+	if err != nil {
+		return Adoption{}, err
+	}
+	return DecideAdoption(t, dirID, entries), nil
+}
+
+// DecideAdoption keeps the project ID found on the directory, then the one
+// recorded in the projects file, and only falls back to the generated ID.
+// A project ID already used by another path is a blocking conflict, since
+// setting its limit would also change the limit of that path. So is a
+// directory whose ID differs from its projects file entry, until one of the
+// two is fixed by hand.
+func DecideAdoption(t PVTarget, dirID uint32, entries []quota.ProjectEntry) Adoption {
+	ad := Adoption{PVName: t.PVName, Path: t.LocalPath}
+
+	var fileEntry *quota.ProjectEntry
+	for i := range entries {
+		if entries[i].Path == t.LocalPath {
+			fileEntry = &entries[i]
+			break
+		}
+	}
+
+	switch {
+	case dirID != 0:
+		ad.ProjectID, ad.Source, ad.DirTagged = dirID, AdoptSourceDirectory, true
+		if fileEntry != nil && fileEntry.ID != dirID {
+			// Recording the directory's ID would add a second entry for
+			// the path next to the stale one
+			ad.Blocked = true
+			ad.Conflicts = append(ad.Conflicts, fmt.Sprintf(
+				"projects file maps %s to project %d but the directory carries project %d", t.LocalPath, fileEntry.ID, dirID))
+		}
+	case fileEntry != nil:
+		ad.ProjectID, ad.Source = fileEntry.ID, AdoptSourceProjectsFile
+	default:
+		ad.ProjectID, ad.Source = t.ProjectID, AdoptSourceGenerated
+	}
+	ad.Recorded = fileEntry != nil && fileEntry.ID == ad.ProjectID
+
+	nameOwner := make(map[string]uint32)
+	for _, e := range entries {
+		if e.ID == ad.ProjectID && e.Name != "" && ad.ProjectName == "" {
+			ad.ProjectName = e.Name
+		}
+		if e.Name != "" {
+			nameOwner[e.Name] = e.ID
+		}
+		if e.ID == ad.ProjectID && e.Path != t.LocalPath {
+			ad.Blocked = true
+			ad.Conflicts = append(ad.Conflicts, fmt.Sprintf(
+				"project %d is also used by %s; its limit would be shared", ad.ProjectID, e.Path))
+		}
+	}
+
+	if ad.ProjectName == "" {
+		ad.ProjectName = t.ProjectName
+		if id, ok := nameOwner[ad.ProjectName]; ok && id != ad.ProjectID {
+			ad.ProjectName = fmt.Sprintf("%s_%d", t.ProjectName, ad.ProjectID)
+		}
+	}
+
+	return ad
+}
+
+// applyLimits reconciles only the block hard limit of an adopted project
+// whose directory already carries the project ID. Soft and inode limits set
+// by hand are kept.
+func (a *QuotaAgent) applyLimits(path, projectName string, projectID uint32, sizeBytes int64, recorded bool) error {
+	if !recorded {
+		if err := quota.AddProject(path, projectName, projectID, a.projectsFile, a.projidFile); err != nil {
+			return fmt.Errorf("failed to add project: %w", err)
+		}
+	}
+	return quota.SetProjectBlockHardLimit(a.fsType, a.quotaPath, projectID, sizeBytes, a.projectLimits[projectID])
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/dasomel/nfs-quota-agent/internal/quota"
+)
+
+func TestDecideAdoption(t *testing.T) {
+	target := PVTarget{PVName: "pv-a", LocalPath: "/export/a", ProjectName: "pv_pv_a", ProjectID: 100}
+
+	tests := []struct {
+		name     string
+		dirID    uint32
+		entries  []quota.ProjectEntry
+		wantID   uint32
+		wantName string
+		source   string
+		tagged   bool
+		recorded bool
+		blocked  bool
+		conflict bool
+	}{
+		{
+			name:   "fresh directory",
+			wantID: 100, wantName: "pv_pv_a", source: AdoptSourceGenerated,
+		},
+		{
+			name:    "hand-made project on directory and in file",
+			dirID:   42,
+			entries: []quota.ProjectEntry{{ID: 42, Name: "team_a", Path: "/export/a"}},
+			wantID:  42, wantName: "team_a", source: AdoptSourceDirectory, tagged: true, recorded: true,
+		},
+		{
+			name:   "directory tagged but not recorded",
+			dirID:  42,
+			wantID: 42, wantName: "pv_pv_a", source: AdoptSourceDirectory, tagged: true,
+		},
+		{
+			name:    "recorded but directory not tagged",
+			entries: []quota.ProjectEntry{{ID: 42, Name: "team_a", Path: "/export/a"}},
+			wantID:  42, wantName: "team_a", source: AdoptSourceProjectsFile, recorded: true,
+		},
+		{
+			name:    "file and directory disagree",
+			dirID:   42,
+			entries: []quota.ProjectEntry{{ID: 43, Name: "team_a", Path: "/export/a"}},
+			wantID:  42, wantName: "pv_pv_a", source: AdoptSourceDirectory, tagged: true, blocked: true, conflict: true,
+		},
+		{
+			name:    "project shared with another path",
+			dirID:   42,
+			entries: []quota.ProjectEntry{{ID: 42, Name: "team", Path: "/export/a"}, {ID: 42, Name: "team", Path: "/export/b"}},
+			wantID:  42, wantName: "team", source: AdoptSourceDirectory, tagged: true, recorded: true, blocked: true, conflict: true,
+		},
+		{
+			name:    "generated name taken by another project",
+			dirID:   42,
+			entries: []quota.ProjectEntry{{ID: 7, Name: "pv_pv_a", Path: "/export/old"}},
+			wantID:  42, wantName: "pv_pv_a_42", source: AdoptSourceDirectory, tagged: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ad := DecideAdoption(target, tt.dirID, tt.entries)
+			if ad.ProjectID != tt.wantID || ad.ProjectName != tt.wantName || ad.Source != tt.source {
+				t.Errorf("DecideAdoption() = %d/%s/%s, want %d/%s/%s",
+					ad.ProjectID, ad.ProjectName, ad.Source, tt.wantID, tt.wantName, tt.source)
+			}
+			if ad.DirTagged != tt.tagged || ad.Recorded != tt.recorded || ad.Blocked != tt.blocked {
+				t.Errorf("DecideAdoption() tagged/recorded/blocked = %v/%v/%v, want %v/%v/%v",
+					ad.DirTagged, ad.Recorded, ad.Blocked, tt.tagged, tt.recorded, tt.blocked)
+			}
+			if (len(ad.Conflicts) > 0) != tt.conflict {
+				t.Errorf("DecideAdoption() conflicts = %v, want conflict %v", ad.Conflicts, tt.conflict)
+			}
+		})
+	}
+}
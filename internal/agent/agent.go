@@ -22,6 +22,7 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -30,10 +31,15 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 
+	"github.com/dasomel/nfs-quota-agent/internal/archive"
 	"github.com/dasomel/nfs-quota-agent/internal/audit"
+	"github.com/dasomel/nfs-quota-agent/internal/cron"
+	"github.com/dasomel/nfs-quota-agent/internal/deleter"
 	"github.com/dasomel/nfs-quota-agent/internal/history"
 	"github.com/dasomel/nfs-quota-agent/internal/quota"
 	"github.com/dasomel/nfs-quota-agent/internal/status"
+	"github.com/dasomel/nfs-quota-agent/internal/trash"
+	"github.com/dasomel/nfs-quota-agent/internal/ui"
 	"github.com/dasomel/nfs-quota-agent/internal/util"
 )
 
@@ -43,9 +49,10 @@ const (
 	AnnotationQuotaStatus = "nfs.io/quota-status"
 
 	// Quota status values
-	QuotaStatusPending = "pending"
-	QuotaStatusApplied = "applied"
-	QuotaStatusFailed  = "failed"
+	QuotaStatusPending  = "pending"
+	QuotaStatusApplied  = "applied"
+	QuotaStatusFailed   = "failed"
+	QuotaStatusConflict = "conflict" // adoption mode: project is shared with another path
 )
 
 // QuotaAgent manages filesystem quotas for NFS PVs
@@ -64,6 +71,11 @@ type QuotaAgent struct {
 	appliedQuotas   map[string]int64
 	auditLogger     *audit.Logger
 
+	// Project limits from the quota report read at the start of each sync.
+	// ext4 setquota always sets all four limits, so the ones the agent does
+	// not manage are taken from here.
+	projectLimits map[uint32]quota.Limits
+
 	// Auto-cleanup configuration
 	enableAutoCleanup bool
 	cleanupInterval   time.Duration
@@ -71,32 +83,96 @@ type QuotaAgent struct {
 	cleanupDryRun     bool
 	orphanLastSeen    map[string]time.Time
 	orphanMu          sync.Mutex
+	cleanupSchedule   *cron.Schedule
+	cleanupWindow     time.Duration
+	nextCleanup       time.Time
+
+	// Persisted orphan first-seen times
+	orphanStateFile   string
+	orphanStateLoaded bool
+	orphanStateSaved  time.Time
+	orphanStateErr    string
+
+	// Orphan ownership hints from the audit log and history
+	auditLogPath string
+	ownerMu      sync.Mutex
+	ownerHints   map[string]*ui.OrphanOwner
+	ownerHintsAt time.Time
+
+	// Cleanup safety rails
+	orphanExcludes      []ExcludeRule
+	cleanupMaxCount     int
+	cleanupMaxBytes     uint64
+	cleanupAbortPercent int
+	lastCleanup         *ui.CleanupRunInfo
+
+	// Quarantine of removed orphans
+	trashDir       string
+	trashRetention time.Duration
+	archiveDir     string
+	archiveFormat  archive.Format
+	deleter        *deleter.Deleter
 
 	// History configuration
 	historyStore *history.Store
 
+	// Directory usage scans shared by history, metrics and the web UI
+	usages *status.UsageCache
+
 	// Policy configuration
 	enablePolicy    bool
 	defaultQuota    int64
 	enforceMaxQuota bool
+
+	// Startup options
+	rebuildProjects bool
+
+	// Adoption of pre-existing project IDs
+	adoptExisting bool
+
+	// Dry-run mode: plan changes without applying them
+	dryRun         bool
+	plannedChanges map[string]bool
+	planMu         sync.Mutex
+
+	// Maintenance freeze: pause quota changes and cleanup, queue PV events
+	freezeConfigMap string
+	freezeMu        sync.Mutex
+	frozen          bool
+	frozenSince     time.Time
+	freezeCh        chan struct{}
+	freezeHolds     map[string]ui.FreezeHold
+	pendingEvents   map[string]pvEvent
+	replaying       bool
+	thawCh          chan struct{}
 }
 
 // NewQuotaAgent creates a new QuotaAgent
 func NewQuotaAgent(client kubernetes.Interface, nfsBasePath, nfsServerPath, provisionerName string) *QuotaAgent {
 	return &QuotaAgent{
-		client:            client,
-		nfsBasePath:       nfsBasePath,
-		nfsServerPath:     nfsServerPath,
-		provisionerName:   provisionerName,
-		quotaPath:         nfsBasePath,
-		projectsFile:      "/etc/projects",
-		projidFile:        "/etc/projid",
-		syncInterval:      30 * time.Second,
-		appliedQuotas:     make(map[string]int64),
-		cleanupInterval:   1 * time.Hour,
-		orphanGracePeriod: 24 * time.Hour,
-		cleanupDryRun:     true,
-		orphanLastSeen:    make(map[string]time.Time),
+		client:              client,
+		nfsBasePath:         nfsBasePath,
+		nfsServerPath:       nfsServerPath,
+		provisionerName:     provisionerName,
+		quotaPath:           nfsBasePath,
+		projectsFile:        "/etc/projects",
+		projidFile:          "/etc/projid",
+		syncInterval:        30 * time.Second,
+		appliedQuotas:       make(map[string]int64),
+		cleanupInterval:     1 * time.Hour,
+		orphanGracePeriod:   24 * time.Hour,
+		cleanupDryRun:       true,
+		orphanLastSeen:      make(map[string]time.Time),
+		cleanupAbortPercent: DefaultAbortPercent,
+		trashRetention:      trash.DefaultRetention,
+		archiveFormat:       archive.FormatGzip,
+		deleter:             deleter.New(deleter.Limits{}),
+		plannedChanges:      make(map[string]bool),
+		freezeHolds:         make(map[string]ui.FreezeHold),
+		pendingEvents:       make(map[string]pvEvent),
+		thawCh:              make(chan struct{}, 1),
+		freezeCh:            make(chan struct{}),
+		usages:              status.NewUsageCache(nfsBasePath),
 	}
 }
 
@@ -108,6 +184,7 @@ func (a *QuotaAgent) SetProjectsFile(v string)                     { a.projectsF
 func (a *QuotaAgent) SetProjidFile(v string)                       { a.projidFile = v }
 func (a *QuotaAgent) SetSyncInterval(v time.Duration)              { a.syncInterval = v }
 func (a *QuotaAgent) SetAuditLogger(v *audit.Logger)               { a.auditLogger = v }
+func (a *QuotaAgent) SetAuditLogPath(v string)                     { a.auditLogPath = v }
 func (a *QuotaAgent) SetEnableAutoCleanup(v bool)                  { a.enableAutoCleanup = v }
 func (a *QuotaAgent) SetCleanupIntervalDuration(v time.Duration)   { a.cleanupInterval = v }
 func (a *QuotaAgent) SetOrphanGracePeriodDuration(v time.Duration) { a.orphanGracePeriod = v }
@@ -116,6 +193,22 @@ func (a *QuotaAgent) SetHistoryStore(v *history.Store)             { a.historySt
 func (a *QuotaAgent) SetEnablePolicy(v bool)                       { a.enablePolicy = v }
 func (a *QuotaAgent) SetDefaultQuota(v int64)                      { a.defaultQuota = v }
 func (a *QuotaAgent) SetEnforceMaxQuota(v bool)                    { a.enforceMaxQuota = v }
+func (a *QuotaAgent) SetRebuildProjects(v bool)                    { a.rebuildProjects = v }
+func (a *QuotaAgent) SetAdoptExisting(v bool)                      { a.adoptExisting = v }
+func (a *QuotaAgent) SetDryRun(v bool)                             { a.dryRun = v }
+func (a *QuotaAgent) SetFreezeConfigMap(v string)                  { a.freezeConfigMap = v }
+func (a *QuotaAgent) SetTrashDir(v string)                         { a.trashDir = v }
+func (a *QuotaAgent) SetTrashRetention(v time.Duration)            { a.trashRetention = v }
+func (a *QuotaAgent) SetArchiveDir(v string)                       { a.archiveDir = v }
+func (a *QuotaAgent) SetArchiveFormat(v archive.Format)            { a.archiveFormat = v }
+func (a *QuotaAgent) SetOrphanExcludes(v []ExcludeRule)            { a.orphanExcludes = v }
+func (a *QuotaAgent) SetCleanupMaxCount(v int)                     { a.cleanupMaxCount = v }
+func (a *QuotaAgent) SetCleanupMaxBytes(v uint64)                  { a.cleanupMaxBytes = v }
+func (a *QuotaAgent) SetCleanupAbortPercent(v int)                 { a.cleanupAbortPercent = v }
+func (a *QuotaAgent) SetOrphanStateFile(v string)                  { a.orphanStateFile = v }
+func (a *QuotaAgent) SetCleanupSchedule(v *cron.Schedule)          { a.cleanupSchedule = v }
+func (a *QuotaAgent) SetCleanupWindow(v time.Duration)             { a.cleanupWindow = v }
+func (a *QuotaAgent) SetPurgeLimits(v deleter.Limits)              { a.deleter = deleter.New(v) }
 
 // Getters for UI/metrics interface
 
@@ -126,6 +219,9 @@ func (a *QuotaAgent) OrphanGracePeriod() time.Duration { return a.orphanGracePer
 func (a *QuotaAgent) CleanupInterval() time.Duration   { return a.cleanupInterval }
 func (a *QuotaAgent) EnablePolicy() bool               { return a.enablePolicy }
 func (a *QuotaAgent) AuditLogger() *audit.Logger       { return a.auditLogger }
+func (a *QuotaAgent) DryRun() bool                     { return a.dryRun }
+func (a *QuotaAgent) PurgeStatus() deleter.Status      { return a.deleter.Status() }
+func (a *QuotaAgent) UsageCache() *status.UsageCache   { return a.usages }
 
 func (a *QuotaAgent) AppliedQuotaCount() int {
 	a.mu.Lock()
@@ -146,18 +242,38 @@ func (a *QuotaAgent) Run(ctx context.Context) error {
 		"provisionerName", a.provisionerName,
 		"processAllNFS", a.processAllNFS,
 		"fsType", a.fsType,
+		"dryRun", a.dryRun,
 	)
 
+	if a.dryRun {
+		slog.Warn("Dry-run mode: quotas, projects files and PV annotations will not be changed")
+		a.cleanupDryRun = true
+	}
+
 	// Check if quota is available
 	if err := a.checkQuotaAvailable(); err != nil {
 		return fmt.Errorf("quota not available: %w", err)
 	}
 
+	// Regenerate projects files from cluster state if requested
+	if a.rebuildProjects {
+		if err := a.rebuildProjectFiles(ctx); err != nil {
+			slog.Error("Failed to rebuild projects files", "error", err)
+		}
+	}
+
 	// Load existing projects
 	if err := a.loadProjects(); err != nil {
 		slog.Warn("Failed to load existing projects", "error", err)
 	}
 
+	// Read the freeze ConfigMap before the initial sync so that a freeze
+	// set during a migration is honoured across restarts
+	if a.freezeConfigMap != "" {
+		initial := a.loadFreezeConfigMap(ctx)
+		go a.watchFreezeConfigMap(ctx, initial)
+	}
+
 	// Initial sync
 	if err := a.syncAllQuotas(ctx); err != nil {
 		slog.Error("Initial quota sync failed", "error", err)
@@ -171,6 +287,12 @@ func (a *QuotaAgent) Run(ctx context.Context) error {
 		go a.runAutoCleanup(ctx)
 	}
 
+	// Purge quarantined orphans after their retention; with a cleanup
+	// schedule, purging happens inside the cleanup windows instead
+	if !a.enableAutoCleanup || a.cleanupSchedule == nil {
+		go a.runTrashPurge(ctx)
+	}
+
 	// Start history collection if enabled
 	if a.historyStore != nil {
 		go a.collectHistory(ctx)
@@ -189,6 +311,11 @@ func (a *QuotaAgent) Run(ctx context.Context) error {
 			if err := a.syncAllQuotas(ctx); err != nil {
 				slog.Error("Periodic quota sync failed", "error", err)
 			}
+		case <-a.thawCh:
+			a.replayQueuedEvents(ctx)
+			if err := a.syncAllQuotas(ctx); err != nil {
+				slog.Error("Quota sync after unfreeze failed", "error", err)
+			}
 		}
 	}
 }
@@ -250,11 +377,27 @@ func (a *QuotaAgent) loadProjects() error {
 
 // syncAllQuotas syncs quotas for all matching PVs
 func (a *QuotaAgent) syncAllQuotas(ctx context.Context) error {
+	if a.Frozen() {
+		slog.Debug("Agent frozen, skipping quota sync")
+		return nil
+	}
+
+	if a.dryRun {
+		changes, err := a.Plan(ctx)
+		if err != nil {
+			return err
+		}
+		a.recordPlan(changes)
+		return nil
+	}
+
 	pvList, err := a.client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to list PVs: %w", err)
 	}
 
+	a.loadProjectLimits()
+
 	syncedCount := 0
 	for _, pv := range pvList.Items {
 		if a.shouldProcessPV(&pv) {
@@ -270,6 +413,27 @@ func (a *QuotaAgent) syncAllQuotas(ctx context.Context) error {
 	return nil
 }
 
+// loadProjectLimits reads the current project limits once per sync. Only
+// ext4 needs them; without a report, limits are set by block hard limit alone.
+func (a *QuotaAgent) loadProjectLimits() {
+	if a.fsType != quota.FSTypeExt4 {
+		return
+	}
+
+	quotas, err := quota.GetExt4ProjectQuotas(a.quotaPath)
+	limits := make(map[uint32]quota.Limits, len(quotas))
+	if err != nil {
+		slog.Warn("Failed to read quota report, soft and inode limits of changed projects are not kept", "error", err)
+	}
+	for id, pq := range quotas {
+		limits[id] = pq.Limits
+	}
+
+	a.mu.Lock()
+	a.projectLimits = limits
+	a.mu.Unlock()
+}
+
 // shouldProcessPV checks if this PV should be processed by the agent
 func (a *QuotaAgent) shouldProcessPV(pv *v1.PersistentVolume) bool {
 	if pv.Status.Phase != v1.VolumeBound {
@@ -324,6 +488,10 @@ func (a *QuotaAgent) getNFSPath(pv *v1.PersistentVolume) string {
 
 // ensureQuota ensures the quota is applied for a PV
 func (a *QuotaAgent) ensureQuota(ctx context.Context, pv *v1.PersistentVolume) error {
+	if a.dryRun {
+		return a.planPV(pv)
+	}
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
@@ -351,10 +519,37 @@ func (a *QuotaAgent) ensureQuota(ctx context.Context, pv *v1.PersistentVolume) e
 	projectName := a.getProjectName(pv)
 	projectID := a.generateProjectID(projectName)
 
+	// In adoption mode keep existing project IDs and only reconcile limits
+	var adopted, recorded bool
+	if a.adoptExisting {
+		ad, err := a.adoptTarget(PVTarget{PVName: pv.Name, LocalPath: localPath, ProjectName: projectName, ProjectID: projectID})
+		if err != nil {
+			return fmt.Errorf("failed to check existing project of %s: %w", localPath, err)
+		}
+		for _, c := range ad.Conflicts {
+			slog.Warn("Project adoption conflict", "pv", pv.Name, "path", localPath, "conflict", c)
+		}
+		if ad.Blocked {
+			a.updateQuotaStatus(ctx, pv, QuotaStatusConflict)
+			return fmt.Errorf("PV %s: project %d of %s conflicts with existing projects", pv.Name, ad.ProjectID, localPath)
+		}
+		if ad.Source != AdoptSourceGenerated {
+			slog.Info("Adopting existing project", "pv", pv.Name, "path", localPath,
+				"projectID", ad.ProjectID, "projectName", ad.ProjectName, "source", ad.Source)
+		}
+		projectName, projectID = ad.ProjectName, ad.ProjectID
+		adopted, recorded = ad.DirTagged, ad.Recorded
+	}
+
 	oldQuota := a.appliedQuotas[localPath]
 	isUpdate := oldQuota > 0 && oldQuota != capacityBytes
 
-	err := a.applyQuota(localPath, projectName, projectID, capacityBytes)
+	var err error
+	if adopted {
+		err = a.applyLimits(localPath, projectName, projectID, capacityBytes, recorded)
+	} else {
+		err = a.applyQuota(localPath, projectName, projectID, capacityBytes)
+	}
 
 	var namespace, pvcName string
 	if pv.Spec.ClaimRef != nil {
@@ -402,21 +597,12 @@ func (a *QuotaAgent) getProjectName(pv *v1.PersistentVolume) string {
 			return name
 		}
 	}
-	name := strings.ReplaceAll(pv.Name, "-", "_")
-	if len(name) > 32 {
-		name = name[:32]
-	}
-	return "pv_" + name
+	return quota.ProjectName("pv_", pv.Name)
 }
 
 // generateProjectID generates a numeric project ID from project name
 func (a *QuotaAgent) generateProjectID(projectName string) uint32 {
-	var hash uint32 = 2166136261
-	for _, c := range projectName {
-		hash ^= uint32(c)
-		hash *= 16777619
-	}
-	return (hash % 4294967293) + 1
+	return quota.GenerateProjectID(projectName)
 }
 
 // applyQuota applies project quota based on filesystem type
@@ -425,7 +611,7 @@ func (a *QuotaAgent) applyQuota(path, projectName string, projectID uint32, size
 	case quota.FSTypeXFS:
 		return quota.ApplyXFSQuota(a.quotaPath, path, projectName, projectID, sizeBytes, a.projectsFile, a.projidFile)
 	case quota.FSTypeExt4:
-		return quota.ApplyExt4Quota(a.quotaPath, path, projectName, projectID, sizeBytes, a.projectsFile, a.projidFile)
+		return quota.ApplyExt4Quota(a.quotaPath, path, projectName, projectID, sizeBytes, a.projectLimits[projectID], a.projectsFile, a.projidFile)
 	default:
 		return fmt.Errorf("unsupported filesystem type: %s", a.fsType)
 	}
@@ -453,36 +639,105 @@ func (a *QuotaAgent) updateQuotaStatus(ctx context.Context, pv *v1.PersistentVol
 // collectHistory collects usage history periodically
 func (a *QuotaAgent) collectHistory(ctx context.Context) {
 	slog.Info("Starting history collection", "interval", a.historyStore.Interval())
+	a.historyStore.SetAnomalyHandler(a.reportAnomaly)
 
 	ticker := time.NewTicker(a.historyStore.Interval())
 	defer ticker.Stop()
 
-	a.recordHistory()
+	a.recordHistory(ctx)
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			a.recordHistory()
+			a.recordHistory(ctx)
 		}
 	}
 }
 
-// recordHistory records current usage to history
-func (a *QuotaAgent) recordHistory() {
+// recordHistory records current usage to history. Usage comes from one
+// quota report read; directories without a quota keep the size of the last
+// walk, which the metrics endpoint or web UI may have done recently, and are
+// walked again every status.UsageWalkMaxAge. Scans are reused for at most
+// half an interval, so the previous snapshot's scan is never recorded again
+// under a new timestamp.
+func (a *QuotaAgent) recordHistory(ctx context.Context) {
 	if a.historyStore == nil {
 		return
 	}
 
-	fsType, _ := quota.DetectFSType(a.nfsBasePath)
-	usages, err := status.GetDirUsages(a.nfsBasePath, fsType)
+	start := time.Now()
+	usages, scan, err := a.usages.Get(min(status.UsageMaxAge, a.historyStore.Interval()/2), false)
 	if err != nil {
 		slog.Error("Failed to get usages for history", "error", err)
 		return
 	}
 
-	if err := a.historyStore.Record(usages); err != nil {
+	if err := a.historyStore.Record(usages, a.historyOwners(ctx)); err != nil {
 		slog.Error("Failed to record history", "error", err)
 	}
+
+	c := history.Collection{
+		Time:     start,
+		Duration: time.Since(start),
+		Scan:     scan,
+		Reused:   scan.Time.Before(start),
+	}
+	a.historyStore.SetCollection(c)
+	slog.Debug("Recorded history snapshot", "dirs", scan.Dirs, "fromReport", scan.FromReport,
+		"walked", scan.Walked, "carried", scan.Carried, "skipped", scan.Skipped, "reused", c.Reused, "duration", c.Duration)
+}
+
+// historyOwners maps each directory to the PV using it, from the same PV
+// map the web UI shows, and to its project ID from the projects file
+func (a *QuotaAgent) historyOwners(ctx context.Context) map[string]history.Owner {
+	owners := make(map[string]history.Owner)
+	for path, info := range ui.PVInfoMap(ctx, a.client, a.nfsBasePath, a.nfsServerPath) {
+		owners[path] = history.Owner{
+			PVName:       info.PVName,
+			PVCName:      info.PVCName,
+			Namespace:    info.Namespace,
+			StorageClass: info.StorageClass,
+		}
+	}
+
+	projects, err := quota.ReadProjectsFile(a.projectsFile)
+	if err != nil {
+		slog.Warn("Failed to read projects file for history", "file", a.projectsFile, "error", err)
+		return owners
+	}
+	for id, path := range projects {
+		projectID, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			continue
+		}
+		o := owners[path]
+		o.ProjectID = uint32(projectID)
+		owners[path] = o
+	}
+	return owners
+}
+
+// Forecasts returns the time-to-full forecast of every tracked directory
+// and of the export disk, using the history store's default window and
+// method. Both are empty when history is disabled.
+func (a *QuotaAgent) Forecasts() ([]history.Forecast, *history.ExportForecast) {
+	if a.historyStore == nil {
+		return nil, nil
+	}
+	forecasts := a.historyStore.ForecastAll(0, "")
+	disk, err := status.GetDiskUsage(a.nfsBasePath)
+	if err != nil {
+		return forecasts, nil
+	}
+	return forecasts, history.ForecastExport(forecasts, a.nfsBasePath, disk, time.Now())
+}
+
+// HistoryCollection returns how the last history snapshot was gathered
+func (a *QuotaAgent) HistoryCollection() (history.Collection, bool) {
+	if a.historyStore == nil {
+		return history.Collection{}, false
+	}
+	return a.historyStore.LastCollection()
 }
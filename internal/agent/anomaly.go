@@ -0,0 +1,141 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/dasomel/nfs-quota-agent/internal/history"
+)
+
+// Event reasons of usage anomalies
+const (
+	ReasonUsageGrowth = "UsageGrowthAnomaly"
+	ReasonUsageDrop   = "UsageDropAnomaly"
+)
+
+// anomalyReportTimeout bounds the Kubernetes calls made per anomaly
+const anomalyReportTimeout = 10 * time.Second
+
+// reportAnomaly raises a usage anomaly detected by the history store: it is
+// logged, written to the audit log and posted as a Warning event on the PVC
+// using the directory (or its PV when unbound)
+func (a *QuotaAgent) reportAnomaly(an history.Anomaly) {
+	slog.Warn("Usage anomaly detected", "path", an.Path, "kind", an.Kind, "message", an.Message)
+
+	ctx, cancel := context.WithTimeout(context.Background(), anomalyReportTimeout)
+	defer cancel()
+
+	pv, t := a.pvForPath(ctx, an.Path)
+	if a.auditLogger != nil {
+		a.auditLogger.LogAnomaly(t.PVName, t.Namespace, t.PVCName, an.Path, an.Message)
+	}
+	if pv == nil {
+		return
+	}
+	if err := a.postAnomalyEvent(ctx, pv, t, an); err != nil {
+		slog.Warn("Failed to post usage anomaly event", "pv", pv.Name, "error", err)
+	}
+}
+
+// pvForPath finds the managed PV whose directory is path
+func (a *QuotaAgent) pvForPath(ctx context.Context, path string) (*v1.PersistentVolume, PVTarget) {
+	pvList, err := a.client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		slog.Warn("Failed to list PVs for usage anomaly", "path", path, "error", err)
+		return nil, PVTarget{}
+	}
+	for i := range pvList.Items {
+		pv := &pvList.Items[i]
+		if !a.shouldProcessPV(pv) {
+			continue
+		}
+		if t, ok := a.target(pv); ok && t.LocalPath == path {
+			return pv, t
+		}
+	}
+	return nil, PVTarget{}
+}
+
+// postAnomalyEvent creates a Warning event for an anomaly on the bound PVC,
+// or on the PV itself
+func (a *QuotaAgent) postAnomalyEvent(ctx context.Context, pv *v1.PersistentVolume, t PVTarget, an history.Anomaly) error {
+	ref := v1.ObjectReference{
+		Kind:       "PersistentVolume",
+		APIVersion: "v1",
+		Name:       pv.Name,
+		UID:        pv.UID,
+	}
+	namespace := metav1.NamespaceDefault
+	if t.PVCName != "" {
+		pvc, err := a.client.CoreV1().PersistentVolumeClaims(t.Namespace).Get(ctx, t.PVCName, metav1.GetOptions{})
+		if err == nil {
+			ref = v1.ObjectReference{
+				Kind:       "PersistentVolumeClaim",
+				APIVersion: "v1",
+				Namespace:  pvc.Namespace,
+				Name:       pvc.Name,
+				UID:        pvc.UID,
+			}
+			namespace = pvc.Namespace
+		}
+	}
+
+	reason := ReasonUsageGrowth
+	if an.Kind == history.AnomalyDrop {
+		reason = ReasonUsageDrop
+	}
+	host, _ := os.Hostname()
+	at := metav1.NewTime(an.DetectedAt)
+	_, err := a.client.CoreV1().Events(namespace).Create(ctx, &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: ref.Name + ".",
+			Namespace:    namespace,
+		},
+		InvolvedObject: ref,
+		Reason:         reason,
+		Message:        an.Message,
+		Type:           v1.EventTypeWarning,
+		Source:         v1.EventSource{Component: "nfs-quota-agent", Host: host},
+		FirstTimestamp: at,
+		LastTimestamp:  at,
+		Count:          1,
+	}, metav1.CreateOptions{})
+	return err
+}
+
+// Anomalies returns the ongoing usage anomalies and how many of each kind
+// were detected since the agent started. Both are empty when anomaly
+// detection is disabled.
+func (a *QuotaAgent) Anomalies() ([]history.Anomaly, map[string]int) {
+	if a.historyStore == nil || !a.historyStore.AnomalyDetection().Enabled {
+		return nil, nil
+	}
+	var active []history.Anomaly
+	for _, an := range a.historyStore.Anomalies(time.Now()) {
+		if an.Active() {
+			active = append(active, an)
+		}
+	}
+	return active, a.historyStore.AnomalyCounts()
+}
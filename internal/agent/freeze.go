@@ -0,0 +1,369 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/dasomel/nfs-quota-agent/internal/ui"
+)
+
+// Freeze sources
+const (
+	FreezeSourceAPI       = ui.FreezeSourceAPI
+	FreezeSourceConfigMap = ui.FreezeSourceConfigMap
+	FreezeSourceSignal    = ui.FreezeSourceSignal
+)
+
+// ConfigMap data keys controlling the freeze
+const (
+	FreezeKeyFrozen = "frozen"
+	FreezeKeyReason = "reason"
+)
+
+// pvEvent is a PV watch event held back while the agent is frozen
+type pvEvent struct {
+	eventType watch.EventType
+	pv        *v1.PersistentVolume
+}
+
+// Freeze pauses quota changes and orphan cleanup until every source that
+// froze the agent has called Unfreeze. PV events received while frozen are
+// queued and replayed on unfreeze.
+func (a *QuotaAgent) Freeze(source, reason string) {
+	a.freezeMu.Lock()
+	defer a.freezeMu.Unlock()
+
+	now := time.Now()
+	if !a.frozen {
+		a.frozen = true
+		a.frozenSince = now
+		close(a.freezeCh)
+	}
+	hold := ui.FreezeHold{Source: source, Reason: reason, Since: now}
+	if prev, ok := a.freezeHolds[source]; ok {
+		hold.Since = prev.Since
+	}
+	a.freezeHolds[source] = hold
+
+	slog.Warn("Agent frozen: quota changes and orphan cleanup paused", "source", source, "reason", reason)
+}
+
+// Unfreeze releases the freeze held by source. The agent resumes normal
+// operation and replays queued PV events once no other source holds a freeze.
+func (a *QuotaAgent) Unfreeze(source string) {
+	a.freezeMu.Lock()
+	defer a.freezeMu.Unlock()
+
+	if !a.frozen {
+		return
+	}
+
+	delete(a.freezeHolds, source)
+	if len(a.freezeHolds) > 0 {
+		slog.Warn("Agent still frozen by other sources", "released", source, "heldBy", a.freezeSources())
+		return
+	}
+
+	slog.Info("Agent unfrozen: resuming quota changes", "source", source,
+		"frozenFor", time.Since(a.frozenSince).Round(time.Second), "queuedEvents", len(a.pendingEvents))
+
+	a.frozen = false
+	a.frozenSince = time.Time{}
+	a.freezeCh = make(chan struct{})
+	// Live events keep queueing behind the queued ones until the replay
+	// has drained them, so an older queued event never overwrites a newer one
+	a.replaying = true
+
+	select {
+	case a.thawCh <- struct{}{}:
+	default:
+	}
+}
+
+// Frozen reports whether the agent is frozen
+func (a *QuotaAgent) Frozen() bool {
+	a.freezeMu.Lock()
+	defer a.freezeMu.Unlock()
+	return a.frozen
+}
+
+// untilFrozen returns a context that is cancelled when the agent freezes,
+// so long-running deletions stop at the freeze instead of at their next check
+func (a *QuotaAgent) untilFrozen(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	a.freezeMu.Lock()
+	frozen, freezeCh := a.frozen, a.freezeCh
+	a.freezeMu.Unlock()
+	if frozen {
+		cancel()
+		return ctx, cancel
+	}
+
+	go func() {
+		select {
+		case <-freezeCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// FreezeStatus returns the freeze state (for API). Reason is that of the
+// most recent freeze; Source lists every source still holding one.
+func (a *QuotaAgent) FreezeStatus() ui.FreezeInfo {
+	a.freezeMu.Lock()
+	defer a.freezeMu.Unlock()
+
+	info := ui.FreezeInfo{
+		Frozen:       a.frozen,
+		QueuedEvents: len(a.pendingEvents),
+	}
+	if !a.frozen {
+		return info
+	}
+
+	since := a.frozenSince
+	info.Since = &since
+	for _, source := range a.freezeSources() {
+		info.Holds = append(info.Holds, a.freezeHolds[source])
+	}
+	sort.SliceStable(info.Holds, func(i, j int) bool {
+		return info.Holds[i].Since.Before(info.Holds[j].Since)
+	})
+	if n := len(info.Holds); n > 0 {
+		info.Reason = info.Holds[n-1].Reason
+	}
+	info.Source = strings.Join(a.freezeSources(), ", ")
+	return info
+}
+
+// freezeSources returns the sources holding a freeze, sorted. The caller
+// must hold freezeMu.
+func (a *QuotaAgent) freezeSources() []string {
+	sources := make([]string, 0, len(a.freezeHolds))
+	for source := range a.freezeHolds {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// QueuedEventCount returns the number of PV events waiting for unfreeze
+func (a *QuotaAgent) QueuedEventCount() int {
+	a.freezeMu.Lock()
+	defer a.freezeMu.Unlock()
+	return len(a.pendingEvents)
+}
+
+// queueEvent holds back a PV event if the agent is frozen or still replaying
+// the events queued while it was. Only the latest event per PV is kept.
+// Returns false if the event should be handled now.
+func (a *QuotaAgent) queueEvent(eventType watch.EventType, pv *v1.PersistentVolume) bool {
+	a.freezeMu.Lock()
+	defer a.freezeMu.Unlock()
+
+	if !a.frozen && !a.replaying {
+		return false
+	}
+
+	a.pendingEvents[pv.Name] = pvEvent{eventType: eventType, pv: pv}
+	return true
+}
+
+// replayQueuedEvents handles PV events queued while the agent was frozen,
+// including those that arrive during the replay, until the queue is empty.
+// If the agent is frozen again, the rest stays queued.
+func (a *QuotaAgent) replayQueuedEvents(ctx context.Context) {
+	replayed := 0
+	for {
+		a.freezeMu.Lock()
+		if a.frozen {
+			a.freezeMu.Unlock()
+			return
+		}
+		if len(a.pendingEvents) == 0 {
+			a.replaying = false
+			a.freezeMu.Unlock()
+			break
+		}
+		names := make([]string, 0, len(a.pendingEvents))
+		for name := range a.pendingEvents {
+			names = append(names, name)
+		}
+		a.freezeMu.Unlock()
+		sort.Strings(names)
+
+		for _, name := range names {
+			a.freezeMu.Lock()
+			if a.frozen {
+				a.freezeMu.Unlock()
+				return
+			}
+			ev, ok := a.pendingEvents[name]
+			delete(a.pendingEvents, name)
+			a.freezeMu.Unlock()
+
+			if ok {
+				a.applyPVEvent(ctx, ev.eventType, ev.pv)
+				replayed++
+			}
+		}
+	}
+
+	if replayed > 0 {
+		slog.Info("Replayed queued PV events", "count", replayed)
+	}
+}
+
+// loadFreezeConfigMap reads the freeze ConfigMap once and freezes the agent
+// if requested. Returns the observed value, or nil if it could not be read.
+func (a *QuotaAgent) loadFreezeConfigMap(ctx context.Context) *bool {
+	namespace, name, err := splitConfigMapRef(a.freezeConfigMap)
+	if err != nil {
+		slog.Error("Invalid freeze ConfigMap", "configMap", a.freezeConfigMap, "error", err)
+		return nil
+	}
+
+	cm, err := a.client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			slog.Warn("Failed to read freeze ConfigMap", "namespace", namespace, "name", name, "error", err)
+			return nil
+		}
+		frozen := false
+		return &frozen
+	}
+
+	frozen, reason, err := parseFreezeConfigMap(cm.Data)
+	if err != nil {
+		slog.Warn("Ignoring freeze ConfigMap", "namespace", namespace, "name", name, "error", err)
+		return nil
+	}
+	if frozen {
+		a.Freeze(FreezeSourceConfigMap, reason)
+	}
+	return &frozen
+}
+
+// watchFreezeConfigMap follows the freeze ConfigMap ("namespace/name") and
+// freezes or unfreezes the agent when its "frozen" key changes. Only changes
+// from the last observed value are acted on. Unfreezing through the
+// ConfigMap releases only its own hold, so a freeze set through the API or a
+// signal stays in place, and vice versa.
+func (a *QuotaAgent) watchFreezeConfigMap(ctx context.Context, last *bool) {
+	namespace, name, err := splitConfigMapRef(a.freezeConfigMap)
+	if err != nil {
+		return
+	}
+
+	slog.Info("Watching freeze ConfigMap", "namespace", namespace, "name", name)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		watcher, err := a.client.CoreV1().ConfigMaps(namespace).Watch(ctx, metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String(),
+		})
+		if err != nil {
+			slog.Error("Failed to start freeze ConfigMap watch", "error", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		for event := range watcher.ResultChan() {
+			cm, ok := event.Object.(*v1.ConfigMap)
+			if !ok {
+				continue
+			}
+
+			var frozen bool
+			var reason string
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				frozen, reason, err = parseFreezeConfigMap(cm.Data)
+				if err != nil {
+					slog.Warn("Ignoring freeze ConfigMap", "namespace", namespace, "name", name, "error", err)
+					continue
+				}
+			case watch.Deleted:
+				frozen = false
+			default:
+				continue
+			}
+
+			changed := last == nil || *last != frozen
+			first := last == nil
+			last = &frozen
+			if !changed {
+				continue
+			}
+
+			if frozen {
+				a.Freeze(FreezeSourceConfigMap, reason)
+			} else if !first {
+				a.Unfreeze(FreezeSourceConfigMap)
+			}
+		}
+
+		slog.Warn("Freeze ConfigMap watch ended, restarting...")
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// splitConfigMapRef splits a "namespace/name" reference
+func splitConfigMapRef(ref string) (namespace, name string, err error) {
+	namespace, name, ok := strings.Cut(ref, "/")
+	if !ok || namespace == "" || name == "" {
+		return "", "", fmt.Errorf("expected namespace/name, got %q", ref)
+	}
+	return namespace, name, nil
+}
+
+// parseFreezeConfigMap reads the freeze switch from ConfigMap data.
+// A missing "frozen" key means not frozen.
+func parseFreezeConfigMap(data map[string]string) (frozen bool, reason string, err error) {
+	value := strings.TrimSpace(data[FreezeKeyFrozen])
+	if value == "" {
+		return false, "", nil
+	}
+
+	frozen, err = strconv.ParseBool(value)
+	if err != nil {
+		return false, "", fmt.Errorf("invalid %q value %q: %w", FreezeKeyFrozen, value, err)
+	}
+
+	return frozen, strings.TrimSpace(data[FreezeKeyReason]), nil
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestParseFreezeConfigMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string]string
+		frozen  bool
+		reason  string
+		wantErr bool
+	}{
+		{name: "empty", data: nil},
+		{name: "frozen", data: map[string]string{"frozen": "true", "reason": " storage migration "}, frozen: true, reason: "storage migration"},
+		{name: "not frozen", data: map[string]string{"frozen": "false", "reason": "done"}, reason: "done"},
+		{name: "numeric", data: map[string]string{"frozen": "1"}, frozen: true},
+		{name: "invalid", data: map[string]string{"frozen": "yes"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frozen, reason, err := parseFreezeConfigMap(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFreezeConfigMap() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if frozen != tt.frozen || reason != tt.reason {
+				t.Errorf("parseFreezeConfigMap() = (%v, %q), want (%v, %q)", frozen, reason, tt.frozen, tt.reason)
+			}
+		})
+	}
+}
+
+func TestSplitConfigMapRef(t *testing.T) {
+	tests := []struct {
+		ref       string
+		namespace string
+		name      string
+		wantErr   bool
+	}{
+		{ref: "kube-system/nfs-quota-freeze", namespace: "kube-system", name: "nfs-quota-freeze"},
+		{ref: "nfs-quota-freeze", wantErr: true},
+		{ref: "/nfs-quota-freeze", wantErr: true},
+		{ref: "kube-system/", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			namespace, name, err := splitConfigMapRef(tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitConfigMapRef() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if namespace != tt.namespace || name != tt.name {
+				t.Errorf("splitConfigMapRef() = (%q, %q), want (%q, %q)", namespace, name, tt.namespace, tt.name)
+			}
+		})
+	}
+}
+
+func TestFreezeQueuesEvents(t *testing.T) {
+	a := NewQuotaAgent(nil, "/export", "/export", "nfs.csi.k8s.io")
+	pv := func(name string) *v1.PersistentVolume {
+		return &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	}
+
+	if a.queueEvent(watch.Added, pv("pv-a")) {
+		t.Fatal("queueEvent() queued an event while not frozen")
+	}
+
+	a.Freeze(FreezeSourceAPI, "migration")
+	a.queueEvent(watch.Added, pv("pv-a"))
+	a.queueEvent(watch.Modified, pv("pv-a"))
+	a.queueEvent(watch.Added, pv("pv-b"))
+
+	st := a.FreezeStatus()
+	if !st.Frozen || st.Source != FreezeSourceAPI || st.Reason != "migration" || st.Since == nil {
+		t.Errorf("FreezeStatus() = %+v, want frozen by api", st)
+	}
+	if st.QueuedEvents != 2 {
+		t.Errorf("QueuedEvents = %d, want 2 (latest event per PV)", st.QueuedEvents)
+	}
+	if ev := a.pendingEvents["pv-a"]; ev.eventType != watch.Modified {
+		t.Errorf("queued event for pv-a = %s, want %s", ev.eventType, watch.Modified)
+	}
+
+	a.Unfreeze(FreezeSourceAPI)
+	if a.Frozen() {
+		t.Error("Frozen() = true after Unfreeze()")
+	}
+	select {
+	case <-a.thawCh:
+	default:
+		t.Error("Unfreeze() did not signal the sync loop")
+	}
+
+	// A live event before the replay must not be overtaken by the older
+	// queued one
+	if !a.queueEvent(watch.Deleted, pv("pv-a")) {
+		t.Fatal("queueEvent() handled a live event before the queue was replayed")
+	}
+	if ev := a.pendingEvents["pv-a"]; ev.eventType != watch.Deleted {
+		t.Errorf("queued event for pv-a = %s, want %s", ev.eventType, watch.Deleted)
+	}
+
+	a.replayQueuedEvents(context.Background())
+	if n := a.QueuedEventCount(); n != 0 {
+		t.Errorf("QueuedEventCount() = %d after replay, want 0", n)
+	}
+	if a.queueEvent(watch.Added, pv("pv-c")) {
+		t.Error("queueEvent() queued an event after the replay finished")
+	}
+}
+
+func TestFreezeMultipleSources(t *testing.T) {
+	a := NewQuotaAgent(nil, "/export", "/export", "nfs.csi.k8s.io")
+
+	a.Freeze(FreezeSourceConfigMap, "storage migration")
+	a.Freeze(FreezeSourceAPI, "disk check")
+
+	st := a.FreezeStatus()
+	if st.Source != "api, configmap" || st.Reason != "disk check" || len(st.Holds) != 2 {
+		t.Errorf("FreezeStatus() = %+v, want holds from api and configmap", st)
+	}
+
+	a.Unfreeze(FreezeSourceAPI)
+	if !a.Frozen() {
+		t.Fatal("Unfreeze(api) thawed a freeze still held by the ConfigMap")
+	}
+	select {
+	case <-a.thawCh:
+		t.Error("sync loop signalled while still frozen")
+	default:
+	}
+	if st := a.FreezeStatus(); st.Source != FreezeSourceConfigMap || st.Reason != "storage migration" {
+		t.Errorf("FreezeStatus() = %+v, want held by configmap only", st)
+	}
+
+	a.Unfreeze(FreezeSourceSignal)
+	if !a.Frozen() {
+		t.Error("Unfreeze(signal) thawed a freeze it did not hold")
+	}
+
+	a.Unfreeze(FreezeSourceConfigMap)
+	if a.Frozen() {
+		t.Error("Frozen() = true after every source unfroze")
+	}
+}
+
+func TestFreezeStopsDeletions(t *testing.T) {
+	a := NewQuotaAgent(nil, t.TempDir(), "/export", "nfs.csi.k8s.io")
+
+	ctx, cancel := a.untilFrozen(context.Background())
+	defer cancel()
+	a.Freeze(FreezeSourceAPI, "migration")
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("untilFrozen() context not cancelled by Freeze()")
+	}
+
+	if _, err := a.RestoreTrash("pv-a-1"); err == nil {
+		t.Error("RestoreTrash() succeeded while frozen")
+	}
+
+	a.Unfreeze(FreezeSourceAPI)
+	ctx, cancel = a.untilFrozen(context.Background())
+	defer cancel()
+	if ctx.Err() != nil {
+		t.Error("untilFrozen() context cancelled while not frozen")
+	}
+}
@@ -20,21 +20,24 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-
+	"github.com/dasomel/nfs-quota-agent/internal/audit"
 	"github.com/dasomel/nfs-quota-agent/internal/quota"
 	"github.com/dasomel/nfs-quota-agent/internal/status"
+	"github.com/dasomel/nfs-quota-agent/internal/trash"
 	"github.com/dasomel/nfs-quota-agent/internal/ui"
 	"github.com/dasomel/nfs-quota-agent/internal/util"
 )
 
 // runAutoCleanup runs the automatic orphan cleanup loop
 func (a *QuotaAgent) runAutoCleanup(ctx context.Context) {
+	if a.cleanupSchedule != nil {
+		a.runScheduledCleanup(ctx)
+		return
+	}
+
 	slog.Info("Starting auto-cleanup loop",
 		"interval", a.cleanupInterval,
 		"gracePeriod", a.orphanGracePeriod,
@@ -43,40 +46,86 @@ func (a *QuotaAgent) runAutoCleanup(ctx context.Context) {
 
 	ticker := time.NewTicker(a.cleanupInterval)
 	defer ticker.Stop()
+	a.setNextCleanup(time.Now().Add(a.cleanupInterval))
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			a.cleanupOrphans(ctx)
+			a.setNextCleanup(time.Now().Add(a.cleanupInterval))
+			a.cleanupOrphans(ctx, time.Time{})
 		}
 	}
 }
 
-// cleanupOrphans finds and removes orphaned directories
-func (a *QuotaAgent) cleanupOrphans(ctx context.Context) {
-	orphans := a.findOrphans(ctx)
+// cleanupOrphans finds and removes orphaned directories. Orphans still
+// pending when deadline passes are left for the next run; a zero deadline
+// lets the run finish.
+func (a *QuotaAgent) cleanupOrphans(ctx context.Context, deadline time.Time) {
+	if a.Frozen() {
+		slog.Info("Agent frozen, skipping orphan cleanup")
+		return
+	}
+
+	orphans, scanned := a.scanOrphans(ctx)
 	if len(orphans) == 0 {
 		slog.Debug("No orphaned directories found")
+		a.recordCleanupRun(false, "", 0, 0, 0)
 		return
 	}
 
 	slog.Info("Found orphaned directories", "count", len(orphans))
 
-	cleaned := 0
+	unprotected := 0
 	for _, orphan := range orphans {
-		if !orphan.CanDelete {
+		switch {
+		case orphan.ProtectedBy != "":
+			slog.Debug("Orphan is protected", "path", orphan.Path, "protectedBy", orphan.ProtectedBy)
+		case !orphan.CanDelete:
 			slog.Debug("Orphan still in grace period",
 				"path", orphan.Path,
 				"age", orphan.Age,
 				"gracePeriod", a.orphanGracePeriod,
 			)
-			continue
 		}
+		if orphan.ProtectedBy == "" {
+			unprotected++
+		}
+	}
 
+	// Many orphans at once usually means a broken API connection or path
+	// mapping rather than many deleted PVs
+	if orphanRatioExceeded(unprotected, scanned, a.cleanupAbortPercent) {
+		reason := fmt.Sprintf("%d of %d directories look orphaned (more than %d%%)", unprotected, scanned, a.cleanupAbortPercent)
+		slog.Error("Aborting orphan cleanup", "reason", reason)
+		a.recordCleanupRun(true, reason, 0, 0, 0)
+		return
+	}
+
+	selected, skipped := selectWithinBudget(orphans, a.cleanupMaxCount, a.cleanupMaxBytes)
+	if len(skipped) > 0 {
+		slog.Warn("Cleanup budget reached, leaving orphans for the next run",
+			"skipped", len(skipped),
+			"maxCount", a.cleanupMaxCount,
+			"maxBytes", util.FormatBytes(int64(a.cleanupMaxBytes)),
+		)
+	}
+
+	cleaned := 0
+	deferred := 0
+	var cleanedBytes uint64
+	for i, orphan := range selected {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			deferred = len(selected) - i
+			slog.Info("Cleanup window closed, deferring remaining orphans to the next window",
+				"deferred", deferred,
+				"deadline", deadline,
+			)
+			break
+		}
 		if a.cleanupDryRun {
-			slog.Info("[DRY-RUN] Would delete orphan",
+			slog.Info("[DRY-RUN] Would move orphan to trash",
 				"path", orphan.Path,
 				"size", orphan.SizeStr,
 				"age", orphan.Age,
@@ -88,19 +137,18 @@ func (a *QuotaAgent) cleanupOrphans(ctx context.Context) {
 					"error", err,
 				)
 			} else {
-				slog.Info("Removed orphan directory",
+				slog.Info("Moved orphan directory to trash",
 					"path", orphan.Path,
 					"size", orphan.SizeStr,
 				)
 				cleaned++
-
-				if a.auditLogger != nil {
-					a.auditLogger.LogCleanup(orphan.Path, orphan.DirName, 0, nil)
-				}
+				cleanedBytes += orphan.Size
 			}
 		}
 	}
 
+	a.recordCleanupRun(false, "", cleaned, cleanedBytes, len(skipped)+deferred)
+
 	if cleaned > 0 {
 		slog.Info("Cleanup completed", "removed", cleaned, "total", len(orphans))
 	}
@@ -108,93 +156,101 @@ func (a *QuotaAgent) cleanupOrphans(ctx context.Context) {
 
 // findOrphans finds directories without matching PVs
 func (a *QuotaAgent) findOrphans(ctx context.Context) []ui.OrphanInfo {
-	var orphans []ui.OrphanInfo
+	orphans, _ := a.scanOrphans(ctx)
+	a.annotateOwners(orphans)
+	return orphans
+}
 
-	pvList, err := a.client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+// scanOrphans finds directories without matching PVs and also returns the
+// number of unprotected directories scanned
+func (a *QuotaAgent) scanOrphans(ctx context.Context) (orphans []ui.OrphanInfo, scanned int) {
+	scan, err := a.detectOrphans(ctx)
 	if err != nil {
-		slog.Error("Failed to list PVs for orphan detection", "error", err)
-		return orphans
+		slog.Error("Failed to detect orphans", "error", err)
+		return nil, 0
 	}
+	return a.trackFindings(scan.findings, func(f OrphanFinding) uint64 {
+		return status.GetDirSize(f.Path)
+	}), scan.scanned
+}
 
-	validPaths := make(map[string]bool)
-	for _, pv := range pvList.Items {
-		nfsPath := a.getNFSPath(&pv)
-		if nfsPath != "" {
-			localPath := a.nfsPathToLocal(nfsPath)
-			validPaths[localPath] = true
+// SelectOrphans applies the auto-cleanup's safety rails to the directories
+// without PV among findings, for the cleanup command: their first-seen
+// times are kept in the orphan tracker, so grace periods run across cleanup
+// runs and agent restarts; the run is aborted when more than the abort
+// percent of the scanned directories look orphaned; and of those past the
+// grace period, at most the per-run budget is selected, oldest first.
+// FirstSeen is set on the findings.
+func (a *QuotaAgent) SelectOrphans(findings []OrphanFinding, scanned int) OrphanSelection {
+	orphans := a.trackFindings(findings, func(f OrphanFinding) uint64 { return f.Size })
+
+	firstSeen := make(map[string]time.Time, len(orphans))
+	unprotected := 0
+	for _, o := range orphans {
+		firstSeen[o.Path] = o.FirstSeen
+		if o.ProtectedBy == "" {
+			unprotected++
+		}
+	}
+	for i := range findings {
+		if t, ok := firstSeen[findings[i].Path]; ok && findings[i].Kind == OrphanDirWithoutPV {
+			findings[i].FirstSeen = &t
 		}
 	}
 
-	entries, err := os.ReadDir(a.nfsBasePath)
-	if err != nil {
-		slog.Error("Failed to read base path", "error", err)
-		return orphans
+	var sel OrphanSelection
+	if orphanRatioExceeded(unprotected, scanned, a.cleanupAbortPercent) {
+		sel.Aborted = fmt.Sprintf("%d of %d directories look orphaned (more than %d%%)", unprotected, scanned, a.cleanupAbortPercent)
+		return sel
+	}
+	selected, skipped := selectWithinBudget(orphans, a.cleanupMaxCount, a.cleanupMaxBytes)
+	sel.Selected = make(map[string]bool, len(selected))
+	for _, o := range selected {
+		sel.Selected[o.Path] = true
+	}
+	sel.Deferred = make(map[string]bool, len(skipped))
+	for _, o := range skipped {
+		sel.Deferred[o.Path] = true
 	}
+	return sel
+}
 
+// trackFindings records the first-seen time of every directory without PV
+// among findings, forgets those no longer found, and returns them as orphans
+func (a *QuotaAgent) trackFindings(findings []OrphanFinding, size func(OrphanFinding) uint64) (orphans []ui.OrphanInfo) {
 	a.orphanMu.Lock()
 	defer a.orphanMu.Unlock()
 
-	now := time.Now()
+	a.loadOrphanState()
+	tracked := len(a.orphanLastSeen)
 
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
-		}
-
-		name := entry.Name()
-		if strings.HasPrefix(name, ".") || name == "projects" || name == "projid" {
-			continue
-		}
-
-		dirPath := filepath.Join(a.nfsBasePath, name)
-
-		subEntries, err := os.ReadDir(dirPath)
-		if err != nil {
+	now := time.Now()
+	seen := make(map[string]bool)
+	for _, f := range findings {
+		if f.Kind != OrphanDirWithoutPV {
 			continue
 		}
-
-		for _, subEntry := range subEntries {
-			if !subEntry.IsDir() || strings.HasPrefix(subEntry.Name(), ".") {
-				continue
-			}
-
-			subDirPath := filepath.Join(dirPath, subEntry.Name())
-			if !validPaths[subDirPath] {
-				orphan := a.trackOrphan(subDirPath, subEntry.Name(), now)
-				if orphan != nil {
-					orphans = append(orphans, *orphan)
-				}
-			}
-		}
-
-		if !validPaths[dirPath] {
-			hasSubDirs := false
-			for _, sub := range subEntries {
-				if sub.IsDir() && !strings.HasPrefix(sub.Name(), ".") {
-					hasSubDirs = true
-					break
-				}
-			}
-			if !hasSubDirs {
-				orphan := a.trackOrphan(dirPath, name, now)
-				if orphan != nil {
-					orphans = append(orphans, *orphan)
-				}
-			}
-		}
+		seen[f.Path] = true
+		orphan := a.trackOrphan(f.Path, filepath.Base(f.Path), f.ProtectedBy, size(f), now)
+		orphans = append(orphans, *orphan)
 	}
 
+	// Forget directories that got a PV again or were removed
+	changed := len(a.orphanLastSeen) != tracked
 	for path := range a.orphanLastSeen {
-		if validPaths[path] {
+		if !seen[path] {
 			delete(a.orphanLastSeen, path)
+			changed = true
 		}
 	}
-
+	if changed {
+		a.saveOrphanState()
+	}
 	return orphans
 }
 
 // trackOrphan tracks when an orphan was first seen
-func (a *QuotaAgent) trackOrphan(path, dirName string, now time.Time) *ui.OrphanInfo {
+func (a *QuotaAgent) trackOrphan(path, dirName, protectedBy string, size uint64, now time.Time) *ui.OrphanInfo {
 	firstSeen, exists := a.orphanLastSeen[path]
 	if !exists {
 		a.orphanLastSeen[path] = now
@@ -202,82 +258,79 @@ func (a *QuotaAgent) trackOrphan(path, dirName string, now time.Time) *ui.Orphan
 	}
 
 	age := now.Sub(firstSeen)
-	size := status.GetDirSize(path)
 
 	return &ui.OrphanInfo{
-		Path:      path,
-		DirName:   dirName,
-		Size:      size,
-		SizeStr:   util.FormatBytes(int64(size)),
-		FirstSeen: firstSeen,
-		Age:       util.FormatDuration(age),
-		CanDelete: age >= a.orphanGracePeriod,
+		Path:        path,
+		DirName:     dirName,
+		Size:        size,
+		SizeStr:     util.FormatBytes(int64(size)),
+		FirstSeen:   firstSeen,
+		Age:         util.FormatDuration(age),
+		CanDelete:   protectedBy == "" && age >= a.orphanGracePeriod,
+		ProtectedBy: protectedBy,
 	}
 }
 
-// RemoveOrphan removes an orphaned directory
+// RemoveOrphan moves an orphaned directory to the trash, where it is kept
+// for the trash retention before being purged
 func (a *QuotaAgent) RemoveOrphan(orphan ui.OrphanInfo) error {
-	if a.fsType != "" {
-		a.removeQuotaForPath(orphan.Path)
+	if a.dryRun {
+		return fmt.Errorf("agent is running in dry-run mode")
 	}
 
-	if err := os.RemoveAll(orphan.Path); err != nil {
-		return fmt.Errorf("failed to remove directory: %w", err)
+	if a.Frozen() {
+		return fmt.Errorf("agent is frozen for maintenance")
 	}
 
-	a.orphanMu.Lock()
-	delete(a.orphanLastSeen, orphan.Path)
-	a.orphanMu.Unlock()
-
-	return nil
-}
-
-// removeQuotaForPath removes quota for a specific path
-func (a *QuotaAgent) removeQuotaForPath(path string) {
-	projectsData, err := os.ReadFile(a.projectsFile)
-	if err != nil {
-		return
+	if protectedBy := a.protectedBy(orphan.Path); protectedBy != "" {
+		return fmt.Errorf("%s is protected from cleanup (%s)", orphan.Path, protectedBy)
 	}
 
-	var projectID string
-	var projectName string
+	projectID, projectName, found, _ := quota.FindProjectByPath(orphan.Path, a.projectsFile, a.projidFile)
+	if !found {
+		projectName = orphan.DirName
+	}
 
-	for _, line := range strings.Split(string(projectsData), "\n") {
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		parts := strings.SplitN(line, ":", 2)
-		if len(parts) == 2 && parts[1] == path {
-			projectID = parts[0]
-			break
+	store := a.trashStore()
+	entry, err := store.Move(orphan.Path, trash.Entry{
+		Size:        orphan.Size,
+		ProjectID:   projectID,
+		ProjectName: projectName,
+		Reason:      "orphan",
+	})
+	if a.auditLogger != nil {
+		detail := ""
+		if entry != nil {
+			detail = entry.TrashPath
 		}
+		a.auditLogger.LogTrash(audit.ActionQuarantine, orphan.Path, projectName, projectID, detail, err)
+	}
+	if err != nil {
+		return err
 	}
 
-	if projectID == "" {
-		return
+	if a.fsType != "" {
+		a.removeQuotaForPath(orphan.Path)
 	}
 
-	projidData, err := os.ReadFile(a.projidFile)
-	if err == nil {
-		for _, line := range strings.Split(string(projidData), "\n") {
-			line = strings.TrimSpace(line)
-			if line == "" || strings.HasPrefix(line, "#") {
-				continue
-			}
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 && parts[1] == projectID {
-				projectName = parts[0]
-				break
-			}
-		}
+	a.orphanMu.Lock()
+	if _, ok := a.orphanLastSeen[orphan.Path]; ok {
+		delete(a.orphanLastSeen, orphan.Path)
+		a.saveOrphanState()
 	}
+	a.orphanMu.Unlock()
 
-	_ = quota.RemoveLineFromFile(a.projectsFile, projectID+":")
+	return nil
+}
 
-	if projectName != "" {
-		_ = quota.RemoveLineFromFile(a.projidFile, projectName+":")
+// removeQuotaForPath removes quota for a specific path
+func (a *QuotaAgent) removeQuotaForPath(path string) {
+	projectID, projectName, ok, err := quota.FindProjectByPath(path, a.projectsFile, a.projidFile)
+	if err != nil || !ok {
+		return
 	}
+
+	_ = quota.RemoveProjectPath(projectID, path, projectName, a.projectsFile, a.projidFile)
 }
 
 // GetOrphans returns list of orphaned directories (for API)
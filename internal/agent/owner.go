@@ -0,0 +1,120 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/dasomel/nfs-quota-agent/internal/audit"
+	"github.com/dasomel/nfs-quota-agent/internal/ui"
+)
+
+// ownerHintTTL is how long ownership hints are reused before the audit log
+// is read again
+const ownerHintTTL = 5 * time.Minute
+
+// annotateOwners sets the last known owner of each orphan
+func (a *QuotaAgent) annotateOwners(orphans []ui.OrphanInfo) {
+	if len(orphans) == 0 {
+		return
+	}
+	paths := make([]string, len(orphans))
+	for i, o := range orphans {
+		paths[i] = o.Path
+	}
+	owners := a.orphanOwners(paths)
+	for i := range orphans {
+		orphans[i].Owner = owners[orphans[i].Path]
+	}
+}
+
+// orphanOwners returns the last known owners of paths, reusing the previous
+// lookup while it is fresh and covers every path
+func (a *QuotaAgent) orphanOwners(paths []string) map[string]*ui.OrphanOwner {
+	a.ownerMu.Lock()
+	defer a.ownerMu.Unlock()
+
+	fresh := time.Since(a.ownerHintsAt) < ownerHintTTL
+	for _, p := range paths {
+		if _, ok := a.ownerHints[p]; !ok {
+			fresh = false
+			break
+		}
+	}
+	if !fresh {
+		a.ownerHints = a.lookupOwners(paths)
+		a.ownerHintsAt = time.Now()
+	}
+	return a.ownerHints
+}
+
+// lookupOwners reads the PV that last used each path from the audit log and
+// the last usage sample from the history store. Paths without any hint map
+// to nil.
+func (a *QuotaAgent) lookupOwners(paths []string) map[string]*ui.OrphanOwner {
+	hints := make(map[string]*ui.OrphanOwner, len(paths))
+	for _, p := range paths {
+		hints[p] = nil
+	}
+
+	if logPath := a.auditLogFile(); logPath != "" {
+		owners, err := audit.FindOwners(logPath, paths)
+		if err != nil && !os.IsNotExist(err) {
+			slog.Warn("Failed to read audit log for orphan owners", "path", logPath, "error", err)
+		}
+		for p, o := range owners {
+			hint := &ui.OrphanOwner{PVName: o.PVName, Namespace: o.Namespace, PVCName: o.PVCName}
+			if !o.Since.IsZero() {
+				since := o.Since
+				hint.Since = &since
+			}
+			if !o.DeletedAt.IsZero() {
+				deleted := o.DeletedAt
+				hint.DeletedAt = &deleted
+			}
+			hints[p] = hint
+		}
+	}
+
+	if a.historyStore != nil {
+		for _, p := range paths {
+			last, ok := a.historyStore.Last(p)
+			if !ok {
+				continue
+			}
+			if hints[p] == nil {
+				hints[p] = &ui.OrphanOwner{}
+			}
+			hints[p].LastRecorded = &last.Timestamp
+			hints[p].LastUsed = last.Used
+		}
+	}
+	return hints
+}
+
+// auditLogFile returns the audit log to read ownership hints from
+func (a *QuotaAgent) auditLogFile() string {
+	if a.auditLogPath != "" {
+		return a.auditLogPath
+	}
+	if a.auditLogger != nil {
+		return a.auditLogger.FilePath()
+	}
+	return ""
+}
@@ -0,0 +1,255 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/dasomel/nfs-quota-agent/internal/audit"
+	"github.com/dasomel/nfs-quota-agent/internal/quota"
+	"github.com/dasomel/nfs-quota-agent/internal/util"
+)
+
+// Planned change actions
+const (
+	PlanCreate       = "create"
+	PlanUpdate       = "update"
+	PlanShrink       = "shrink"
+	PlanSetProject   = "set-project"
+	PlanProjectsFile = "projects-file"
+)
+
+// PlannedChange is a change the agent would make in dry-run mode
+type PlannedChange struct {
+	Action      string `json:"action"`
+	PVName      string `json:"pvName"`
+	Namespace   string `json:"namespace,omitempty"`
+	PVCName     string `json:"pvcName,omitempty"`
+	Path        string `json:"path"`
+	ProjectID   uint32 `json:"projectId"`
+	ProjectName string `json:"projectName"`
+	OldBytes    int64  `json:"oldBytes,omitempty"`
+	NewBytes    int64  `json:"newBytes,omitempty"`
+	UsedBytes   int64  `json:"usedBytes,omitempty"`
+	Detail      string `json:"detail,omitempty"`
+}
+
+// Plan performs a full PV sync without touching the filesystem, the projects
+// files or PV annotations, and returns every change the agent would make
+func (a *QuotaAgent) Plan(ctx context.Context) ([]PlannedChange, error) {
+	if a.fsType == "" {
+		if err := a.detectFilesystemType(); err != nil {
+			return nil, fmt.Errorf("failed to detect filesystem type: %w", err)
+		}
+	}
+
+	targets, err := a.Targets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	quotas, entries, err := a.quotaState()
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []PlannedChange
+	for _, t := range targets {
+		c, err := a.planTarget(t, quotas, entries)
+		if err != nil {
+			slog.Warn("Failed to plan quota", "pv", t.PVName, "path", t.LocalPath, "error", err)
+			continue
+		}
+		changes = append(changes, c...)
+	}
+
+	return changes, nil
+}
+
+// quotaState reads the current project quotas and projects file entries
+func (a *QuotaAgent) quotaState() (map[uint32]*quota.ProjectQuota, []quota.ProjectEntry, error) {
+	quotas, err := quota.GetProjectQuotas(a.fsType, a.quotaPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read quota report: %w", err)
+	}
+	entries, err := quota.ReadProjectEntries(a.projectsFile, a.projidFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read project files: %w", err)
+	}
+	return quotas, entries, nil
+}
+
+// planTarget plans the changes for a single PV, applying the same project
+// selection as ensureQuota
+func (a *QuotaAgent) planTarget(t PVTarget, quotas map[uint32]*quota.ProjectQuota, entries []quota.ProjectEntry) ([]PlannedChange, error) {
+	if _, err := os.Stat(t.LocalPath); os.IsNotExist(err) {
+		// ensureQuota skips missing directories as well
+		return nil, nil
+	}
+
+	dirID, err := quota.GetDirProjectID(a.fsType, t.LocalPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.adoptExisting {
+		ad := DecideAdoption(t, dirID, entries)
+		if ad.Blocked {
+			return nil, fmt.Errorf("project %d conflicts with existing projects: %v", ad.ProjectID, ad.Conflicts)
+		}
+		t.ProjectID, t.ProjectName = ad.ProjectID, ad.ProjectName
+	}
+
+	return planChanges(t, dirID, quotas[t.ProjectID], entries), nil
+}
+
+// planChanges compares the current state of a PV directory with the project
+// and limit the agent would apply
+func planChanges(t PVTarget, dirID uint32, current *quota.ProjectQuota, entries []quota.ProjectEntry) []PlannedChange {
+	base := PlannedChange{
+		PVName:      t.PVName,
+		Namespace:   t.Namespace,
+		PVCName:     t.PVCName,
+		Path:        t.LocalPath,
+		ProjectID:   t.ProjectID,
+		ProjectName: t.ProjectName,
+	}
+	if current != nil {
+		base.UsedBytes = int64(current.BlockUsed)
+	}
+
+	var changes []PlannedChange
+	add := func(action, detail string) *PlannedChange {
+		c := base
+		c.Action, c.Detail = action, detail
+		changes = append(changes, c)
+		return &changes[len(changes)-1]
+	}
+
+	recorded, named := false, false
+	for _, e := range entries {
+		if e.ID == t.ProjectID && e.Path == t.LocalPath {
+			recorded = true
+		}
+		if e.Name == t.ProjectName {
+			named = true
+		}
+	}
+	if !recorded {
+		add(PlanProjectsFile, fmt.Sprintf("add %d:%s to projects file", t.ProjectID, t.LocalPath))
+	}
+	if !named {
+		add(PlanProjectsFile, fmt.Sprintf("add %s:%d to projid file", t.ProjectName, t.ProjectID))
+	}
+
+	switch {
+	case dirID == 0:
+		add(PlanSetProject, fmt.Sprintf("assign project %d to directory", t.ProjectID))
+	case dirID != t.ProjectID:
+		add(PlanSetProject, fmt.Sprintf("change directory project from %d to %d", dirID, t.ProjectID))
+	}
+
+	want := quota.BlockLimitBytes(t.CapacityBytes)
+	switch {
+	case current == nil || current.BlockHard == 0:
+		add(PlanCreate, "").NewBytes = want
+	case current.BlockHard < want:
+		c := add(PlanUpdate, "")
+		c.OldBytes, c.NewBytes = current.BlockHard, want
+	case current.BlockHard > want:
+		detail := ""
+		if int64(current.BlockUsed) > want {
+			detail = fmt.Sprintf("usage %s exceeds the new limit", util.FormatBytes(int64(current.BlockUsed)))
+		}
+		c := add(PlanShrink, detail)
+		c.OldBytes, c.NewBytes = current.BlockHard, want
+	}
+
+	return changes
+}
+
+// planPV plans the changes for a single PV event in dry-run mode
+func (a *QuotaAgent) planPV(pv *v1.PersistentVolume) error {
+	t, ok := a.target(pv)
+	if !ok {
+		return fmt.Errorf("PV %s has no storage capacity or NFS path", pv.Name)
+	}
+
+	quotas, entries, err := a.quotaState()
+	if err != nil {
+		return err
+	}
+
+	changes, err := a.planTarget(t, quotas, entries)
+	if err != nil {
+		return err
+	}
+	a.recordPlan(changes)
+	return nil
+}
+
+// recordPlan logs and audits planned changes that were not reported before
+func (a *QuotaAgent) recordPlan(changes []PlannedChange) {
+	a.planMu.Lock()
+	defer a.planMu.Unlock()
+
+	for _, c := range changes {
+		key := planKey(c)
+		if a.plannedChanges[key] {
+			continue
+		}
+		a.plannedChanges[key] = true
+
+		slog.Info("[DRY-RUN] Would "+c.Action,
+			"pv", c.PVName,
+			"path", c.Path,
+			"projectID", c.ProjectID,
+			"old", util.FormatBytes(c.OldBytes),
+			"new", util.FormatBytes(c.NewBytes),
+			"detail", c.Detail,
+		)
+
+		if a.auditLogger != nil {
+			action := audit.ActionCreate
+			if c.Action == PlanUpdate || c.Action == PlanShrink {
+				action = audit.ActionUpdate
+			}
+			detail := c.Action
+			if c.Detail != "" {
+				detail += ": " + c.Detail
+			}
+			a.auditLogger.LogPlanned(action, c.PVName, c.Namespace, c.PVCName, c.Path, c.ProjectName,
+				c.ProjectID, c.OldBytes, c.NewBytes, a.fsType, detail)
+		}
+	}
+}
+
+// planKey identifies a planned change; usage is left out so that a pending
+// change is reported once rather than on every sync
+func planKey(c PlannedChange) string {
+	switch c.Action {
+	case PlanCreate, PlanUpdate, PlanShrink:
+		return fmt.Sprintf("%s|%s|%d|%d|%d", c.Path, c.Action, c.ProjectID, c.OldBytes, c.NewBytes)
+	default:
+		return c.Path + "|" + c.Action + "|" + c.Detail
+	}
+}
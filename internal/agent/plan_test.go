@@ -0,0 +1,70 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/dasomel/nfs-quota-agent/internal/quota"
+)
+
+func TestPlanChanges(t *testing.T) {
+	const gi = int64(1 << 30)
+	target := PVTarget{PVName: "pv-a", LocalPath: "/export/a", ProjectName: "pv_pv_a", ProjectID: 100, CapacityBytes: 2 * gi}
+	recorded := []quota.ProjectEntry{{ID: 100, Name: "pv_pv_a", Path: "/export/a"}}
+	limit := func(hard, used int64) *quota.ProjectQuota {
+		return &quota.ProjectQuota{ProjectID: 100, BlockUsed: uint64(used), Limits: quota.Limits{BlockHard: hard}}
+	}
+
+	tests := []struct {
+		name    string
+		dirID   uint32
+		current *quota.ProjectQuota
+		entries []quota.ProjectEntry
+		want    []string
+	}{
+		{"new PV", 0, nil, nil, []string{PlanProjectsFile, PlanProjectsFile, PlanSetProject, PlanCreate}},
+		{"in sync", 100, limit(2*gi, 0), recorded, nil},
+		{"grow", 100, limit(1*gi, 0), recorded, []string{PlanUpdate}},
+		{"shrink", 100, limit(4*gi, 3*gi), recorded, []string{PlanShrink}},
+		{"directory in other project", 55, limit(2*gi, 0), recorded, []string{PlanSetProject}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := planChanges(target, tt.dirID, tt.current, tt.entries)
+			var got []string
+			for _, c := range changes {
+				got = append(got, c.Action)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("planChanges() actions = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("planChanges() actions = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+
+	changes := planChanges(target, 100, limit(4*gi, 3*gi), recorded)
+	if c := changes[0]; c.OldBytes != 4*gi || c.NewBytes != 2*gi || c.Detail == "" {
+		t.Errorf("Expected shrink below usage to be flagged, got %+v", c)
+	}
+}
@@ -0,0 +1,259 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+
+	"github.com/dasomel/nfs-quota-agent/internal/quota"
+)
+
+// ProjectsRebuild holds regenerated projects/projid files and the current ones
+type ProjectsRebuild struct {
+	Entries  []quota.ProjectEntry `json:"entries"`
+	Warnings []string             `json:"warnings,omitempty"`
+
+	ProjectsFile string `json:"projectsFile"`
+	ProjidFile   string `json:"projidFile"`
+	Projects     []byte `json:"-"`
+	Projid       []byte `json:"-"`
+	oldProjects  []byte
+	oldProjid    []byte
+}
+
+// FileDiff lists the lines removed from and added to a file
+type FileDiff struct {
+	File    string   `json:"file"`
+	Removed []string `json:"removed,omitempty"`
+	Added   []string `json:"added,omitempty"`
+}
+
+// Diff compares the regenerated files with the current ones
+func (p *ProjectsRebuild) Diff() []FileDiff {
+	var diffs []FileDiff
+	for _, f := range []struct {
+		name     string
+		old, new []byte
+	}{
+		{p.ProjectsFile, p.oldProjects, p.Projects},
+		{p.ProjidFile, p.oldProjid, p.Projid},
+	} {
+		removed, added := quota.DiffLines(f.old, f.new)
+		if len(removed) > 0 || len(added) > 0 {
+			diffs = append(diffs, FileDiff{File: f.name, Removed: removed, Added: added})
+		}
+	}
+	return diffs
+}
+
+// Changed reports whether the regenerated files differ from the current ones
+func (p *ProjectsRebuild) Changed() bool {
+	return len(p.Diff()) > 0
+}
+
+// Write atomically replaces the projects and projid files
+func (p *ProjectsRebuild) Write() error {
+	if err := quota.WriteFileAtomic(p.ProjectsFile, p.Projects); err != nil {
+		return fmt.Errorf("failed to write projects file: %w", err)
+	}
+	if err := quota.WriteFileAtomic(p.ProjidFile, p.Projid); err != nil {
+		return fmt.Errorf("failed to write projid file: %w", err)
+	}
+	return nil
+}
+
+// PlanProjectsRebuild regenerates the projects and projid files from the PV
+// list and the project IDs stored on the PV directories. Existing entries for
+// other directories (e.g. manual quotas) are kept if the directory still
+// carries that project ID.
+func (a *QuotaAgent) PlanProjectsRebuild(ctx context.Context) (*ProjectsRebuild, error) {
+	if a.fsType == "" {
+		if err := a.detectFilesystemType(); err != nil {
+			return nil, fmt.Errorf("failed to detect filesystem type: %w", err)
+		}
+	}
+
+	targets, err := a.Targets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := quota.ReadProjectEntries(a.projectsFile, a.projidFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read project files: %w", err)
+	}
+
+	p := &ProjectsRebuild{
+		ProjectsFile: a.projectsFile,
+		ProjidFile:   a.projidFile,
+	}
+	if p.oldProjects, err = os.ReadFile(a.projectsFile); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if p.oldProjid, err = os.ReadFile(a.projidFile); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	p.Entries, p.Warnings = buildProjectEntries(targets, existing, a.dirProjectID)
+	p.Projects, p.Projid = quota.FormatProjectFiles(p.Entries)
+
+	return p, nil
+}
+
+// dirProjectID reads the project ID of a directory. exists is false if the
+// directory does not exist.
+func (a *QuotaAgent) dirProjectID(path string) (id uint32, exists bool, err error) {
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		return 0, false, nil
+	}
+	id, err = quota.GetDirProjectID(a.fsType, path)
+	return id, true, err
+}
+
+// buildProjectEntries computes the project entries for the PV targets and the
+// existing entries, preferring the project ID found on each directory
+func buildProjectEntries(targets []PVTarget, existing []quota.ProjectEntry,
+	dirProjectID func(path string) (uint32, bool, error)) ([]quota.ProjectEntry, []string) {
+	var entries []quota.ProjectEntry
+	var warnings []string
+
+	existingByPath := make(map[string]quota.ProjectEntry)
+	namesByID := make(map[uint32]string)
+	for _, e := range existing {
+		existingByPath[e.Path] = e
+		if e.Name != "" {
+			namesByID[e.ID] = e.Name
+		}
+	}
+
+	sort.Slice(targets, func(i, j int) bool { return targets[i].LocalPath < targets[j].LocalPath })
+
+	seenPaths := make(map[string]bool)
+	for _, t := range targets {
+		if seenPaths[t.LocalPath] {
+			continue
+		}
+		seenPaths[t.LocalPath] = true
+
+		id, exists, err := dirProjectID(t.LocalPath)
+		switch {
+		case !exists:
+			warnings = append(warnings, fmt.Sprintf("PV %s: directory %s does not exist, skipped", t.PVName, t.LocalPath))
+			continue
+		case err != nil:
+			if e, ok := existingByPath[t.LocalPath]; ok {
+				id = e.ID
+			} else {
+				id = t.ProjectID
+			}
+			warnings = append(warnings, fmt.Sprintf("PV %s: failed to read project ID of %s (%v), using %d", t.PVName, t.LocalPath, err, id))
+		case id == 0:
+			id = t.ProjectID
+			warnings = append(warnings, fmt.Sprintf("PV %s: %s has no project ID yet, using %d", t.PVName, t.LocalPath, id))
+		case id != t.ProjectID:
+			warnings = append(warnings, fmt.Sprintf("PV %s: %s carries project ID %d, expected %d (%s)", t.PVName, t.LocalPath, id, t.ProjectID, t.ProjectName))
+		}
+
+		name := t.ProjectName
+		if id != t.ProjectID && namesByID[id] != "" {
+			name = namesByID[id]
+		}
+		entries = append(entries, quota.ProjectEntry{ID: id, Name: name, Path: t.LocalPath})
+	}
+
+	for _, e := range existing {
+		if seenPaths[e.Path] {
+			continue
+		}
+		seenPaths[e.Path] = true
+
+		id, exists, err := dirProjectID(e.Path)
+		switch {
+		case !exists:
+			warnings = append(warnings, fmt.Sprintf("dropping entry %d:%s: directory does not exist", e.ID, e.Path))
+			continue
+		case err != nil:
+			warnings = append(warnings, fmt.Sprintf("keeping entry %d:%s: failed to read project ID (%v)", e.ID, e.Path, err))
+		case id != e.ID:
+			warnings = append(warnings, fmt.Sprintf("dropping entry %d:%s: directory carries project ID %d", e.ID, e.Path, id))
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	quota.SortProjectEntries(entries)
+	return entries, append(warnings, dedupeProjectNames(entries)...)
+}
+
+// dedupeProjectNames makes sure a project name maps to a single project ID,
+// since quota tools resolve names through the projid file
+func dedupeProjectNames(entries []quota.ProjectEntry) []string {
+	var warnings []string
+	owner := make(map[string]uint32)
+	nameByID := make(map[uint32]string)
+	for i := range entries {
+		e := &entries[i]
+		if name, ok := nameByID[e.ID]; ok {
+			e.Name = name
+			continue
+		}
+		if e.Name == "" {
+			continue
+		}
+		if id, ok := owner[e.Name]; ok && id != e.ID {
+			renamed := fmt.Sprintf("%s_%d", e.Name, e.ID)
+			warnings = append(warnings, fmt.Sprintf("project name %s is used by IDs %d and %d, renaming the latter to %s", e.Name, id, e.ID, renamed))
+			e.Name = renamed
+		}
+		owner[e.Name] = e.ID
+		nameByID[e.ID] = e.Name
+	}
+	return warnings
+}
+
+// rebuildProjectFiles regenerates the projects files on startup
+func (a *QuotaAgent) rebuildProjectFiles(ctx context.Context) error {
+	p, err := a.PlanProjectsRebuild(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range p.Warnings {
+		slog.Warn("Projects rebuild", "warning", w)
+	}
+
+	diffs := p.Diff()
+	if len(diffs) == 0 {
+		slog.Info("Projects files are up to date", "entries", len(p.Entries))
+		return nil
+	}
+	if a.dryRun {
+		for _, d := range diffs {
+			slog.Info("[DRY-RUN] Would rebuild projects file", "file", d.File, "removed", d.Removed, "added", d.Added)
+		}
+		return nil
+	}
+	for _, d := range diffs {
+		slog.Info("Rebuilding projects file", "file", d.File, "removed", d.Removed, "added", d.Added)
+	}
+
+	return p.Write()
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dasomel/nfs-quota-agent/internal/quota"
+)
+
+func TestBuildProjectEntries(t *testing.T) {
+	targets := []PVTarget{
+		{PVName: "pv-a", LocalPath: "/export/a", ProjectName: "pv_pv_a", ProjectID: 100},
+		{PVName: "pv-b", LocalPath: "/export/b", ProjectName: "pv_pv_b", ProjectID: 200},
+		{PVName: "pv-c", LocalPath: "/export/c", ProjectName: "pv_pv_c", ProjectID: 300},
+		{PVName: "pv-gone", LocalPath: "/export/gone", ProjectName: "pv_pv_gone", ProjectID: 400},
+	}
+	existing := []quota.ProjectEntry{
+		{ID: 555, Name: "legacy_b", Path: "/export/old-b"},
+		{ID: 700, Name: "dir_shared", Path: "/export/shared"},
+		{ID: 800, Name: "dir_stale", Path: "/export/stale"},
+		{ID: 900, Name: "dir_moved", Path: "/export/moved"},
+	}
+	dirIDs := map[string]uint32{
+		"/export/a":      100, // matches
+		"/export/b":      555, // adopted from an older install
+		"/export/c":      0,   // not assigned yet
+		"/export/shared": 700, // manual quota, kept
+		"/export/moved":  901, // reassigned, dropped
+		"/export/old-b":  555,
+	}
+	dirProjectID := func(path string) (uint32, bool, error) {
+		if path == "/export/broken" {
+			return 0, true, errors.New("lsattr failed")
+		}
+		id, ok := dirIDs[path]
+		return id, ok, nil
+	}
+
+	entries, warnings := buildProjectEntries(targets, existing, dirProjectID)
+
+	got := make(map[string]quota.ProjectEntry)
+	for _, e := range entries {
+		got[e.Path] = e
+	}
+
+	want := map[string]quota.ProjectEntry{
+		"/export/a":      {ID: 100, Name: "pv_pv_a", Path: "/export/a"},
+		"/export/b":      {ID: 555, Name: "legacy_b", Path: "/export/b"},
+		"/export/c":      {ID: 300, Name: "pv_pv_c", Path: "/export/c"},
+		"/export/old-b":  {ID: 555, Name: "legacy_b", Path: "/export/old-b"},
+		"/export/shared": {ID: 700, Name: "dir_shared", Path: "/export/shared"},
+	}
+	if len(got) != len(want) {
+		t.Errorf("Got %d entries, want %d: %+v", len(got), len(want), entries)
+	}
+	for path, w := range want {
+		if got[path] != w {
+			t.Errorf("Entry for %s = %+v, want %+v", path, got[path], w)
+		}
+	}
+
+	joined := strings.Join(warnings, "\n")
+	for _, w := range []string{"pv-gone", "no project ID yet", "carries project ID 555", "dropping entry 800", "dropping entry 900"} {
+		if !strings.Contains(joined, w) {
+			t.Errorf("Expected warning containing %q, got:\n%s", w, joined)
+		}
+	}
+}
+
+func TestDedupeProjectNames(t *testing.T) {
+	entries := []quota.ProjectEntry{
+		{ID: 1, Name: "shared", Path: "/export/a"},
+		{ID: 1, Name: "other", Path: "/export/b"},
+		{ID: 2, Name: "shared", Path: "/export/c"},
+	}
+
+	warnings := dedupeProjectNames(entries)
+
+	if entries[1].Name != "shared" {
+		t.Errorf("Expected paths of one project to share its name, got %q", entries[1].Name)
+	}
+	if entries[2].Name != "shared_2" {
+		t.Errorf("Expected duplicate name to be renamed, got %q", entries[2].Name)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("Expected 1 warning, got %v", warnings)
+	}
+}
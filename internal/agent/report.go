@@ -0,0 +1,323 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/dasomel/nfs-quota-agent/internal/quota"
+	"github.com/dasomel/nfs-quota-agent/internal/status"
+	"github.com/dasomel/nfs-quota-agent/internal/ui"
+)
+
+// Orphan finding kinds
+const (
+	// OrphanQuotaWithoutDir is a projects file entry whose directory is gone
+	OrphanQuotaWithoutDir = "quota-without-dir"
+	// OrphanDirWithoutPV is a directory no PV points to
+	OrphanDirWithoutPV = "dir-without-pv"
+	// OrphanPVWithoutQuota is a managed PV without a projects file entry
+	OrphanPVWithoutQuota = "pv-without-quota"
+)
+
+// OrphanFinding is a mismatch between PVs, directories and quota projects
+type OrphanFinding struct {
+	Kind        string `json:"kind"`
+	Path        string `json:"path"`
+	PVName      string `json:"pvName,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	PVCName     string `json:"pvcName,omitempty"`
+	ProjectID   uint32 `json:"projectId,omitempty"`
+	ProjectName string `json:"projectName,omitempty"`
+	Size        uint64 `json:"size,omitempty"`
+	ProtectedBy string `json:"protectedBy,omitempty"`
+	Detail      string `json:"detail,omitempty"`
+
+	// FirstSeen is when the orphan tracker first saw a directory without PV
+	FirstSeen *time.Time `json:"firstSeen,omitempty"`
+
+	// Owner is the last known user of a directory without PV
+	Owner *ui.OrphanOwner `json:"owner,omitempty"`
+}
+
+// OrphanSelection is what a cleanup run may do with directories without PV
+type OrphanSelection struct {
+	// Aborted is why the run must not act, set when too many directories
+	// look orphaned
+	Aborted string
+	// Selected are past the grace period and within the per-run budget
+	Selected map[string]bool
+	// Deferred are past the grace period but over the budget
+	Deferred map[string]bool
+}
+
+// orphanDir is a directory considered by orphan detection
+type orphanDir struct {
+	path        string
+	protectedBy string
+	hasPV       bool
+}
+
+// orphanScan is the result of one orphan detection pass
+type orphanScan struct {
+	findings   []OrphanFinding
+	validPaths map[string]bool
+	scanned    int // unprotected directories scanned
+}
+
+// FindOrphans compares PVs (native and CSI), directories under the NFS base
+// path and the projects file, and returns every mismatch along with the
+// number of unprotected directories scanned. Used by the cleanup command;
+// the agent's auto-cleanup acts on the same findings.
+func (a *QuotaAgent) FindOrphans(ctx context.Context) ([]OrphanFinding, int, error) {
+	scan, err := a.detectOrphans(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	var paths []string
+	for i := range scan.findings {
+		if scan.findings[i].Kind == OrphanDirWithoutPV {
+			scan.findings[i].Size = status.GetDirSize(scan.findings[i].Path)
+			paths = append(paths, scan.findings[i].Path)
+		}
+	}
+	if len(paths) > 0 {
+		owners := a.orphanOwners(paths)
+		for i := range scan.findings {
+			if scan.findings[i].Kind == OrphanDirWithoutPV {
+				scan.findings[i].Owner = owners[scan.findings[i].Path]
+			}
+		}
+	}
+	return scan.findings, scan.scanned, nil
+}
+
+// detectOrphans runs one orphan detection pass
+func (a *QuotaAgent) detectOrphans(ctx context.Context) (*orphanScan, error) {
+	pvList, err := a.client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list PVs: %w", err)
+	}
+
+	validPaths := a.pvLocalPaths(pvList.Items)
+	dirs, err := a.orphanDirs(validPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := quota.ReadProjectEntries(a.projectsFile, a.projidFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read project files: %w", err)
+	}
+
+	scan := &orphanScan{validPaths: validPaths}
+	for _, d := range dirs {
+		if d.protectedBy == "" {
+			scan.scanned++
+		}
+	}
+	scan.findings = classifyOrphans(a.nfsBasePath, dirs, entries, a.targets(pvList.Items), validPaths, dirExists)
+	return scan, nil
+}
+
+// pvLocalPaths returns the local directory of every NFS PV in the cluster,
+// whichever provisioner created it
+func (a *QuotaAgent) pvLocalPaths(pvs []v1.PersistentVolume) map[string]bool {
+	paths := make(map[string]bool)
+	for i := range pvs {
+		if nfsPath := a.getNFSPath(&pvs[i]); nfsPath != "" {
+			paths[a.nfsPathToLocal(nfsPath)] = true
+		}
+	}
+	return paths
+}
+
+// orphanDirs lists the directories orphan detection considers: top-level
+// directories that are PVs or have no subdirectories, and the subdirectories
+// of the others. Dot directories, the trash and the legacy projects/projid
+// entries are skipped.
+func (a *QuotaAgent) orphanDirs(validPaths map[string]bool) ([]orphanDir, error) {
+	entries, err := os.ReadDir(a.nfsBasePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read base path: %w", err)
+	}
+
+	trashDir := a.trashStore().Dir()
+	dir := func(path string) orphanDir {
+		return orphanDir{path: path, protectedBy: a.protectedBy(path), hasPV: validPaths[path]}
+	}
+
+	var dirs []orphanDir
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || strings.HasPrefix(name, ".") || name == "projects" || name == "projid" {
+			continue
+		}
+
+		dirPath := filepath.Join(a.nfsBasePath, name)
+		if dirPath == trashDir {
+			continue
+		}
+		// The contents of a PV belong to the workload
+		if validPaths[dirPath] {
+			dirs = append(dirs, dir(dirPath))
+			continue
+		}
+
+		subEntries, err := os.ReadDir(dirPath)
+		if err != nil {
+			continue
+		}
+
+		hasSubDirs := false
+		for _, sub := range subEntries {
+			if !sub.IsDir() || strings.HasPrefix(sub.Name(), ".") {
+				continue
+			}
+			hasSubDirs = true
+			dirs = append(dirs, dir(filepath.Join(dirPath, sub.Name())))
+		}
+		if !hasSubDirs {
+			dirs = append(dirs, dir(dirPath))
+		}
+	}
+	return dirs, nil
+}
+
+// classifyOrphans turns scanned directories, projects file entries and
+// managed PV targets into findings, sorted by kind and path
+func classifyOrphans(basePath string, dirs []orphanDir, entries []quota.ProjectEntry, targets []PVTarget,
+	validPaths map[string]bool, exists func(string) bool) []OrphanFinding {
+	byPath := make(map[string]quota.ProjectEntry, len(entries))
+	for _, e := range entries {
+		byPath[filepath.Clean(e.Path)] = e
+	}
+
+	var findings []OrphanFinding
+	for _, d := range dirs {
+		if d.hasPV {
+			continue
+		}
+		f := OrphanFinding{Kind: OrphanDirWithoutPV, Path: d.path, ProtectedBy: d.protectedBy}
+		if e, ok := byPath[d.path]; ok {
+			f.ProjectID, f.ProjectName = e.ID, e.Name
+		}
+		findings = append(findings, f)
+	}
+
+	for _, e := range entries {
+		path := filepath.Clean(e.Path)
+		// Entries outside the base path belong to other exports, and a
+		// missing PV directory is created by the provisioner, not orphaned
+		if !withinBase(basePath, path) || validPaths[path] || exists(path) {
+			continue
+		}
+		findings = append(findings, OrphanFinding{
+			Kind:        OrphanQuotaWithoutDir,
+			Path:        path,
+			ProjectID:   e.ID,
+			ProjectName: e.Name,
+		})
+	}
+
+	for _, t := range targets {
+		if _, ok := byPath[filepath.Clean(t.LocalPath)]; ok {
+			continue
+		}
+		f := OrphanFinding{
+			Kind:      OrphanPVWithoutQuota,
+			Path:      t.LocalPath,
+			PVName:    t.PVName,
+			Namespace: t.Namespace,
+			PVCName:   t.PVCName,
+		}
+		if !exists(t.LocalPath) {
+			f.Detail = "directory missing"
+		}
+		findings = append(findings, f)
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Kind != findings[j].Kind {
+			return findings[i].Kind < findings[j].Kind
+		}
+		return findings[i].Path < findings[j].Path
+	})
+	return findings
+}
+
+// withinBase reports whether path lies below basePath
+func withinBase(basePath, path string) bool {
+	rel, err := filepath.Rel(basePath, path)
+	return err == nil && rel != "." && !strings.HasPrefix(rel, "..")
+}
+
+// dirExists reports whether path is an existing directory
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// ResolveOrphan fixes a finding: a directory without a PV is moved to the
+// trash and a quota without a directory has its limits cleared and its
+// projects file entries removed. PVs without a quota are left for the agent
+// to apply on its next sync.
+func (a *QuotaAgent) ResolveOrphan(f OrphanFinding) error {
+	switch f.Kind {
+	case OrphanDirWithoutPV:
+		if a.fsType == "" {
+			// Best effort, so RemoveOrphan also drops the projects entry
+			_ = a.detectFilesystemType()
+		}
+		return a.RemoveOrphan(ui.OrphanInfo{Path: f.Path, DirName: filepath.Base(f.Path), Size: f.Size})
+	case OrphanQuotaWithoutDir:
+		return a.removeStaleQuota(f)
+	default:
+		return fmt.Errorf("%s findings are resolved by the agent's quota sync", f.Kind)
+	}
+}
+
+// removeStaleQuota clears the limits of a project whose directory is gone
+// and removes it from the projects files
+func (a *QuotaAgent) removeStaleQuota(f OrphanFinding) error {
+	if a.dryRun {
+		return fmt.Errorf("agent is running in dry-run mode")
+	}
+	if a.Frozen() {
+		return fmt.Errorf("agent is frozen for maintenance")
+	}
+	if a.fsType == "" {
+		if err := a.detectFilesystemType(); err != nil {
+			return fmt.Errorf("failed to detect filesystem type: %w", err)
+		}
+	}
+
+	err := quota.DetachProjectPath(a.fsType, a.quotaPath, f.Path, false, f.ProjectID, f.ProjectName, a.projectsFile, a.projidFile)
+	if a.auditLogger != nil {
+		a.auditLogger.LogCleanup(f.Path, f.ProjectName, f.ProjectID, err)
+	}
+	return err
+}
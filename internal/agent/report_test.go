@@ -0,0 +1,107 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/dasomel/nfs-quota-agent/internal/quota"
+)
+
+func TestOrphanDirs(t *testing.T) {
+	base := t.TempDir()
+	for _, dir := range []string{
+		"pvc-a/app/data", // PV with its own subdirectories
+		"stale",          // no PV, no subdirectories
+		"ns/pvc-b",       // nested PV
+		"ns/leftover",    // nested orphan
+		".nfs-quota-trash/x",
+		".hidden",
+	} {
+		if err := os.MkdirAll(filepath.Join(base, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := &QuotaAgent{nfsBasePath: base}
+	valid := map[string]bool{
+		filepath.Join(base, "pvc-a"):    true,
+		filepath.Join(base, "ns/pvc-b"): true,
+	}
+
+	dirs, err := a.orphanDirs(valid)
+	if err != nil {
+		t.Fatalf("orphanDirs() error = %v", err)
+	}
+
+	var got []string
+	for _, d := range dirs {
+		rel, _ := filepath.Rel(base, d.path)
+		if d.hasPV {
+			rel += " (pv)"
+		}
+		got = append(got, rel)
+	}
+	want := []string{"ns/leftover", "ns/pvc-b (pv)", "pvc-a (pv)", "stale"}
+	if !slices.Equal(got, want) {
+		t.Errorf("orphanDirs() = %v, want %v", got, want)
+	}
+}
+
+func TestClassifyOrphans(t *testing.T) {
+	base := "/export"
+	dirs := []orphanDir{
+		{path: "/export/pvc-a", hasPV: true},
+		{path: "/export/old", protectedBy: "exclude:old"},
+		{path: "/export/stale"},
+	}
+	entries := []quota.ProjectEntry{
+		{ID: 1, Name: "pv_pvc_a", Path: "/export/pvc-a"},
+		{ID: 2, Name: "pv_stale", Path: "/export/stale"},
+		{ID: 3, Name: "pv_gone", Path: "/export/gone"},
+		{ID: 4, Name: "pv_pending", Path: "/export/pvc-pending"},
+		{ID: 5, Name: "other", Path: "/srv/other"},
+	}
+	targets := []PVTarget{
+		{PVName: "pvc-a", LocalPath: "/export/pvc-a"},
+		{PVName: "pvc-pending", LocalPath: "/export/pvc-pending"},
+		{PVName: "pvc-csi", LocalPath: "/export/csi/pvc-csi", Namespace: "default", PVCName: "data"},
+	}
+	valid := map[string]bool{
+		"/export/pvc-a":       true,
+		"/export/pvc-pending": true,
+		"/export/csi/pvc-csi": true,
+	}
+	exists := func(path string) bool {
+		return path == "/export/pvc-a" || path == "/export/old" || path == "/export/stale"
+	}
+
+	got := classifyOrphans(base, dirs, entries, targets, valid, exists)
+	want := []OrphanFinding{
+		{Kind: OrphanDirWithoutPV, Path: "/export/old", ProtectedBy: "exclude:old"},
+		{Kind: OrphanDirWithoutPV, Path: "/export/stale", ProjectID: 2, ProjectName: "pv_stale"},
+		{Kind: OrphanPVWithoutQuota, Path: "/export/csi/pvc-csi", PVName: "pvc-csi",
+			Namespace: "default", PVCName: "data", Detail: "directory missing"},
+		{Kind: OrphanQuotaWithoutDir, Path: "/export/gone", ProjectID: 3, ProjectName: "pv_gone"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("classifyOrphans() =\n%+v\nwant\n%+v", got, want)
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dasomel/nfs-quota-agent/internal/ui"
+)
+
+// KeepMarker is a file that protects a directory, and everything below it,
+// from orphan cleanup
+const KeepMarker = ".nfs-quota-keep"
+
+// DefaultAbortPercent aborts a cleanup run when more than this share of
+// directories looks orphaned
+const DefaultAbortPercent = 50
+
+// abortMinDirs is the number of scanned directories below which the
+// orphan ratio check is skipped, since small exports swing too much
+const abortMinDirs = 5
+
+// builtinExcludes are never treated as orphans
+var builtinExcludes = []string{"lost+found"}
+
+// ExcludeRule protects directories matching a pattern from orphan cleanup.
+// Patterns are globs matched against the directory name and its path
+// relative to the NFS base path, or regular expressions with a "re:" prefix
+// matched against the relative path.
+type ExcludeRule struct {
+	Pattern string
+	re      *regexp.Regexp
+}
+
+// ParseExcludeRules parses orphan exclusion patterns
+func ParseExcludeRules(patterns []string) ([]ExcludeRule, error) {
+	var rules []ExcludeRule
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		rule := ExcludeRule{Pattern: p}
+		if expr, ok := strings.CutPrefix(p, "re:"); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid exclude regex %q: %w", expr, err)
+			}
+			rule.re = re
+		} else if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid exclude glob %q: %w", p, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Match reports whether the rule matches a directory path relative to the
+// NFS base path
+func (r ExcludeRule) Match(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	if r.re != nil {
+		return r.re.MatchString(rel)
+	}
+	if ok, _ := filepath.Match(r.Pattern, rel); ok {
+		return true
+	}
+	ok, _ := filepath.Match(r.Pattern, filepath.Base(rel))
+	return ok
+}
+
+// protectedBy returns why a directory is protected from orphan cleanup,
+// or "" if it is not
+func (a *QuotaAgent) protectedBy(path string) string {
+	rel, err := filepath.Rel(a.nfsBasePath, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+
+	// A rule or marker on a parent protects the whole subtree
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i := range parts {
+		prefix := strings.Join(parts[:i+1], "/")
+		for _, name := range builtinExcludes {
+			if prefix == name {
+				return "exclude:" + name
+			}
+		}
+		for _, rule := range a.orphanExcludes {
+			if rule.Match(prefix) {
+				return "exclude:" + rule.Pattern
+			}
+		}
+		if _, err := os.Stat(filepath.Join(a.nfsBasePath, filepath.FromSlash(prefix), KeepMarker)); err == nil {
+			return "marker:" + filepath.Join(prefix, KeepMarker)
+		}
+	}
+	return ""
+}
+
+// orphanRatioExceeded reports whether more than percent of the scanned
+// directories look orphaned
+func orphanRatioExceeded(orphans, scanned, percent int) bool {
+	if percent <= 0 || scanned < abortMinDirs {
+		return false
+	}
+	return orphans*100 > scanned*percent
+}
+
+// selectWithinBudget picks deletable orphans, oldest first, until the per-run
+// count or byte budget is used up. Orphans that do not fit are returned as
+// skipped. A zero budget is unlimited.
+func selectWithinBudget(orphans []ui.OrphanInfo, maxCount int, maxBytes uint64) (selected, skipped []ui.OrphanInfo) {
+	candidates := make([]ui.OrphanInfo, 0, len(orphans))
+	for _, o := range orphans {
+		if o.CanDelete {
+			candidates = append(candidates, o)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].FirstSeen.Before(candidates[j].FirstSeen)
+	})
+
+	var bytes uint64
+	for _, o := range candidates {
+		if maxCount > 0 && len(selected) >= maxCount {
+			skipped = append(skipped, o)
+			continue
+		}
+		if maxBytes > 0 && bytes+o.Size > maxBytes {
+			skipped = append(skipped, o)
+			continue
+		}
+		selected = append(selected, o)
+		bytes += o.Size
+	}
+	return selected, skipped
+}
+
+// recordCleanupRun stores the outcome of a cleanup run (for API)
+func (a *QuotaAgent) recordCleanupRun(aborted bool, reason string, moved int, bytes uint64, skipped int) {
+	a.orphanMu.Lock()
+	defer a.orphanMu.Unlock()
+
+	a.lastCleanup = &ui.CleanupRunInfo{
+		Time:    time.Now(),
+		Aborted: aborted,
+		Reason:  reason,
+		Moved:   moved,
+		Bytes:   bytes,
+		Skipped: skipped,
+	}
+}
+
+// CleanupSafety returns the cleanup safety rails and the last run (for API)
+func (a *QuotaAgent) CleanupSafety() ui.CleanupSafetyInfo {
+	a.orphanMu.Lock()
+	defer a.orphanMu.Unlock()
+
+	excludes := append([]string{}, builtinExcludes...)
+	for _, rule := range a.orphanExcludes {
+		excludes = append(excludes, rule.Pattern)
+	}
+
+	info := ui.CleanupSafetyInfo{
+		Excludes:     excludes,
+		KeepMarker:   KeepMarker,
+		MaxCount:     a.cleanupMaxCount,
+		MaxBytes:     a.cleanupMaxBytes,
+		AbortPercent: a.cleanupAbortPercent,
+	}
+	if a.lastCleanup != nil {
+		last := *a.lastCleanup
+		info.LastRun = &last
+	}
+	return info
+}
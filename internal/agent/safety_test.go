@@ -0,0 +1,154 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dasomel/nfs-quota-agent/internal/ui"
+)
+
+func TestExcludeRuleMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		rel     string
+		want    bool
+	}{
+		{"scratch-*", "scratch-alice", true},
+		{"scratch-*", "default/scratch-bob", true},
+		{"scratch-*", "pvc-abc", false},
+		{"default/*", "default/pvc-abc", true},
+		{"default/*", "other/pvc-abc", false},
+		{"re:^tmp-[0-9]+$", "tmp-42", true},
+		{"re:^tmp-[0-9]+$", "ns/tmp-42", false},
+		{"re:/backup$", "ns/backup", true},
+	}
+
+	for _, tt := range tests {
+		rules, err := ParseExcludeRules([]string{tt.pattern})
+		if err != nil {
+			t.Fatalf("ParseExcludeRules(%q) error = %v", tt.pattern, err)
+		}
+		if got := rules[0].Match(tt.rel); got != tt.want {
+			t.Errorf("%q.Match(%q) = %v, want %v", tt.pattern, tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestParseExcludeRulesInvalid(t *testing.T) {
+	for _, p := range []string{"re:(", "[a-"} {
+		if _, err := ParseExcludeRules([]string{p}); err == nil {
+			t.Errorf("ParseExcludeRules(%q) succeeded, want error", p)
+		}
+	}
+	if rules, err := ParseExcludeRules([]string{"", "  "}); err != nil || len(rules) != 0 {
+		t.Errorf("ParseExcludeRules(blank) = %v, %v; want no rules", rules, err)
+	}
+}
+
+func TestProtectedBy(t *testing.T) {
+	base := t.TempDir()
+	for _, dir := range []string{"lost+found", "kept/pvc-a", "scratch-x", "pvc-b"} {
+		if err := os.MkdirAll(filepath.Join(base, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(base, "kept", KeepMarker), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, _ := ParseExcludeRules([]string{"scratch-*"})
+	a := &QuotaAgent{nfsBasePath: base, orphanExcludes: rules}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"lost+found", "exclude:lost+found"},
+		{"kept/pvc-a", "marker:kept/" + KeepMarker},
+		{"scratch-x", "exclude:scratch-*"},
+		{"pvc-b", ""},
+	}
+	for _, tt := range tests {
+		if got := a.protectedBy(filepath.Join(base, tt.path)); got != tt.want {
+			t.Errorf("protectedBy(%s) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestOrphanRatioExceeded(t *testing.T) {
+	tests := []struct {
+		orphans, scanned, percent int
+		want                      bool
+	}{
+		{orphans: 6, scanned: 10, percent: 50, want: true},
+		{orphans: 5, scanned: 10, percent: 50, want: false},
+		{orphans: 10, scanned: 10, percent: 0, want: false},
+		{orphans: 3, scanned: 3, percent: 50, want: false}, // too few to judge
+	}
+	for _, tt := range tests {
+		if got := orphanRatioExceeded(tt.orphans, tt.scanned, tt.percent); got != tt.want {
+			t.Errorf("orphanRatioExceeded(%d, %d, %d) = %v, want %v",
+				tt.orphans, tt.scanned, tt.percent, got, tt.want)
+		}
+	}
+}
+
+func TestSelectWithinBudget(t *testing.T) {
+	now := time.Now()
+	orphans := []ui.OrphanInfo{
+		{Path: "new", Size: 100, FirstSeen: now, CanDelete: true},
+		{Path: "old", Size: 300, FirstSeen: now.Add(-3 * time.Hour), CanDelete: true},
+		{Path: "mid", Size: 200, FirstSeen: now.Add(-2 * time.Hour), CanDelete: true},
+		{Path: "grace", Size: 1, FirstSeen: now.Add(-4 * time.Hour)},
+	}
+
+	paths := func(list []ui.OrphanInfo) []string {
+		var out []string
+		for _, o := range list {
+			out = append(out, o.Path)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name        string
+		maxCount    int
+		maxBytes    uint64
+		wantSel     []string
+		wantSkipped []string
+	}{
+		{name: "unlimited", wantSel: []string{"old", "mid", "new"}},
+		{name: "count", maxCount: 2, wantSel: []string{"old", "mid"}, wantSkipped: []string{"new"}},
+		{name: "bytes", maxBytes: 400, wantSel: []string{"old", "new"}, wantSkipped: []string{"mid"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel, skipped := selectWithinBudget(orphans, tt.maxCount, tt.maxBytes)
+			if got := paths(sel); !slices.Equal(got, tt.wantSel) {
+				t.Errorf("selected = %v, want %v", got, tt.wantSel)
+			}
+			if got := paths(skipped); !slices.Equal(got, tt.wantSkipped) {
+				t.Errorf("skipped = %v, want %v", got, tt.wantSkipped)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/dasomel/nfs-quota-agent/internal/cron"
+	"github.com/dasomel/nfs-quota-agent/internal/ui"
+)
+
+// runScheduledCleanup runs orphan cleanup and the trash purge at the times
+// of the cleanup schedule. Each run may move and purge directories until its
+// window closes; whatever is left is deferred to the next window.
+func (a *QuotaAgent) runScheduledCleanup(ctx context.Context) {
+	slog.Info("Starting scheduled cleanup loop",
+		"schedule", a.cleanupSchedule.String(),
+		"window", a.cleanupWindow,
+		"gracePeriod", a.orphanGracePeriod,
+		"dryRun", a.cleanupDryRun,
+	)
+
+	var last time.Time
+	for {
+		fire, start, deadline := cleanupSlot(a.cleanupSchedule, a.cleanupWindow, time.Now(), last)
+		if fire.IsZero() {
+			slog.Error("Cleanup schedule never fires, auto-cleanup stopped", "schedule", a.cleanupSchedule.String())
+			a.setNextCleanup(time.Time{})
+			return
+		}
+		a.setNextCleanup(start)
+
+		timer := time.NewTimer(time.Until(start))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		a.cleanupOrphans(ctx, deadline)
+		a.purgeExpiredTrash(ctx, deadline)
+		last = fire
+	}
+}
+
+// cleanupSlot returns the next schedule activation after last, when its run
+// starts and when its window closes (zero if unbounded). If now falls inside
+// an open window, for example after a restart, the run starts immediately.
+func cleanupSlot(s *cron.Schedule, window time.Duration, now, last time.Time) (fire, start, deadline time.Time) {
+	from := now.Add(-window)
+	if last.After(from) {
+		from = last
+	}
+
+	fire = s.Next(from)
+	if fire.IsZero() {
+		return time.Time{}, time.Time{}, time.Time{}
+	}
+	if window > 0 {
+		deadline = fire.Add(window)
+	}
+	if fire.After(now) {
+		return fire, fire, deadline
+	}
+	return fire, now, deadline
+}
+
+// setNextCleanup records when the next cleanup run starts (for API)
+func (a *QuotaAgent) setNextCleanup(t time.Time) {
+	a.orphanMu.Lock()
+	defer a.orphanMu.Unlock()
+	a.nextCleanup = t
+}
+
+// CleanupSchedule returns the cleanup schedule and next run (for API)
+func (a *QuotaAgent) CleanupSchedule() ui.CleanupScheduleInfo {
+	a.orphanMu.Lock()
+	defer a.orphanMu.Unlock()
+
+	info := ui.CleanupScheduleInfo{Interval: a.cleanupInterval.String()}
+	if a.cleanupSchedule != nil {
+		info.Schedule = a.cleanupSchedule.String()
+		info.TimeZone = a.cleanupSchedule.Location().String()
+		info.Interval = ""
+		if a.cleanupWindow > 0 {
+			info.Window = a.cleanupWindow.String()
+		}
+	}
+	if !a.nextCleanup.IsZero() {
+		next := a.nextCleanup
+		info.NextRun = &next
+	}
+	return info
+}
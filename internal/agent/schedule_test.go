@@ -0,0 +1,66 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dasomel/nfs-quota-agent/internal/cron"
+)
+
+func TestCleanupSlot(t *testing.T) {
+	// Weeknights 01:00-05:00
+	s, err := cron.Parse("CRON_TZ=UTC 0 1 * * 1-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	window := 4 * time.Hour
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2024, 1, day, hour, min, 0, 0, time.UTC) // 2024-01-08 is a Monday
+	}
+
+	tests := []struct {
+		name         string
+		now, last    time.Time
+		window       time.Duration
+		wantStart    time.Time
+		wantDeadline time.Time
+	}{
+		{name: "before window", now: at(8, 0, 30), window: window,
+			wantStart: at(8, 1, 0), wantDeadline: at(8, 5, 0)},
+		{name: "restart inside window", now: at(8, 2, 15), window: window,
+			wantStart: at(8, 2, 15), wantDeadline: at(8, 5, 0)},
+		{name: "finished inside window", now: at(8, 2, 15), last: at(8, 1, 0), window: window,
+			wantStart: at(9, 1, 0), wantDeadline: at(9, 5, 0)},
+		{name: "after window", now: at(8, 6, 0), window: window,
+			wantStart: at(9, 1, 0), wantDeadline: at(9, 5, 0)},
+		{name: "weekend", now: at(12, 6, 0), window: window,
+			wantStart: at(15, 1, 0), wantDeadline: at(15, 5, 0)},
+		{name: "no window", now: at(8, 2, 15),
+			wantStart: at(9, 1, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, start, deadline := cleanupSlot(s, tt.window, tt.now, tt.last)
+			if !start.Equal(tt.wantStart) || !deadline.Equal(tt.wantDeadline) {
+				t.Errorf("cleanupSlot() = %v, %v; want %v, %v", start, deadline, tt.wantStart, tt.wantDeadline)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PVTarget describes where and how the agent applies the quota of a PV
+type PVTarget struct {
+	PVName        string `json:"pvName"`
+	Namespace     string `json:"namespace,omitempty"`
+	PVCName       string `json:"pvcName,omitempty"`
+	NFSPath       string `json:"nfsPath"`
+	LocalPath     string `json:"localPath"`
+	ProjectName   string `json:"projectName"`
+	ProjectID     uint32 `json:"projectId"`
+	CapacityBytes int64  `json:"capacityBytes"`
+}
+
+// Targets lists every PV the agent would process, resolved to its local path
+// and project. PVs without an NFS path or capacity are skipped.
+func (a *QuotaAgent) Targets(ctx context.Context) ([]PVTarget, error) {
+	pvList, err := a.client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list PVs: %w", err)
+	}
+
+	return a.targets(pvList.Items), nil
+}
+
+// targets resolves the PVs the agent manages to their quota targets
+func (a *QuotaAgent) targets(pvs []v1.PersistentVolume) []PVTarget {
+	var targets []PVTarget
+	for i := range pvs {
+		pv := &pvs[i]
+		if !a.shouldProcessPV(pv) {
+			continue
+		}
+		if t, ok := a.target(pv); ok {
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
+
+// target resolves a single PV to its quota target
+func (a *QuotaAgent) target(pv *v1.PersistentVolume) (PVTarget, bool) {
+	capacity, ok := pv.Spec.Capacity[v1.ResourceStorage]
+	if !ok {
+		return PVTarget{}, false
+	}
+
+	nfsPath := a.getNFSPath(pv)
+	if nfsPath == "" {
+		return PVTarget{}, false
+	}
+
+	projectName := a.getProjectName(pv)
+	t := PVTarget{
+		PVName:        pv.Name,
+		NFSPath:       nfsPath,
+		LocalPath:     a.nfsPathToLocal(nfsPath),
+		ProjectName:   projectName,
+		ProjectID:     a.generateProjectID(projectName),
+		CapacityBytes: capacity.Value(),
+	}
+	if pv.Spec.ClaimRef != nil {
+		t.Namespace = pv.Spec.ClaimRef.Namespace
+		t.PVCName = pv.Spec.ClaimRef.Name
+	}
+
+	return t, true
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dasomel/nfs-quota-agent/internal/ui"
+	"github.com/dasomel/nfs-quota-agent/internal/util"
+)
+
+// DefaultOrphanStateFile is the file, relative to the NFS base path, that
+// keeps orphan first-seen times across restarts
+const DefaultOrphanStateFile = ".nfs-quota-orphans.json"
+
+// orphanState is the on-disk form of the orphan tracker. Paths are relative
+// to the NFS base path, so the state stays valid when the agent moves to a
+// node that mounts the export elsewhere.
+type orphanState struct {
+	Updated   time.Time            `json:"updated"`
+	FirstSeen map[string]time.Time `json:"firstSeen"`
+}
+
+// orphanStatePath returns the orphan tracker state file
+func (a *QuotaAgent) orphanStatePath() string {
+	if a.orphanStateFile != "" {
+		return a.orphanStateFile
+	}
+	return filepath.Join(a.nfsBasePath, DefaultOrphanStateFile)
+}
+
+// loadOrphanState loads persisted first-seen times once, keeping any
+// tracked in memory since. Caller must hold orphanMu.
+func (a *QuotaAgent) loadOrphanState() {
+	if a.orphanStateLoaded {
+		return
+	}
+	a.orphanStateLoaded = true
+
+	path := a.orphanStatePath()
+	state, err := readOrphanState(path)
+	if err != nil {
+		a.orphanStateErr = err.Error()
+		slog.Warn("Failed to load orphan tracker, grace periods start now", "path", path, "error", err)
+		return
+	}
+
+	for rel, firstSeen := range state.FirstSeen {
+		abs := filepath.Join(a.nfsBasePath, filepath.FromSlash(rel))
+		if seen, ok := a.orphanLastSeen[abs]; !ok || firstSeen.Before(seen) {
+			a.orphanLastSeen[abs] = firstSeen
+		}
+	}
+	if len(state.FirstSeen) > 0 {
+		slog.Info("Loaded orphan tracker", "path", path, "orphans", len(state.FirstSeen))
+	}
+}
+
+// saveOrphanState persists the first-seen times. Caller must hold orphanMu.
+func (a *QuotaAgent) saveOrphanState() {
+	state := orphanState{
+		Updated:   time.Now().UTC(),
+		FirstSeen: make(map[string]time.Time, len(a.orphanLastSeen)),
+	}
+	for abs, firstSeen := range a.orphanLastSeen {
+		rel, err := filepath.Rel(a.nfsBasePath, abs)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		state.FirstSeen[filepath.ToSlash(rel)] = firstSeen.UTC()
+	}
+
+	path := a.orphanStatePath()
+	if err := writeOrphanState(path, state); err != nil {
+		a.orphanStateErr = err.Error()
+		slog.Warn("Failed to save orphan tracker", "path", path, "error", err)
+		return
+	}
+	a.orphanStateErr = ""
+	a.orphanStateSaved = state.Updated
+}
+
+// readOrphanState reads a state file; a missing file is an empty state
+func readOrphanState(path string) (*orphanState, error) {
+	state := &orphanState{FirstSeen: make(map[string]time.Time)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("invalid orphan state file: %w", err)
+	}
+	if state.FirstSeen == nil {
+		state.FirstSeen = make(map[string]time.Time)
+	}
+	return state, nil
+}
+
+// writeOrphanState writes a state file atomically, so a crash mid-write
+// never leaves a truncated file behind
+func writeOrphanState(path string, state orphanState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(path, data, 0644)
+}
+
+// OrphanTracker returns where orphan first-seen times are kept (for API)
+func (a *QuotaAgent) OrphanTracker() ui.OrphanTrackerInfo {
+	a.orphanMu.Lock()
+	defer a.orphanMu.Unlock()
+
+	info := ui.OrphanTrackerInfo{
+		File:    a.orphanStatePath(),
+		Tracked: len(a.orphanLastSeen),
+		Error:   a.orphanStateErr,
+	}
+	if !a.orphanStateSaved.IsZero() {
+		saved := a.orphanStateSaved
+		info.Saved = &saved
+	}
+	return info
+}
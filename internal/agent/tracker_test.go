@@ -0,0 +1,73 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOrphanStateSurvivesRestart(t *testing.T) {
+	base := t.TempDir()
+	firstSeen := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	before := &QuotaAgent{nfsBasePath: base, orphanLastSeen: map[string]time.Time{
+		filepath.Join(base, "ns/stale"): firstSeen,
+		"/elsewhere/outside":            firstSeen, // not under the base path, dropped
+	}}
+	before.saveOrphanState()
+	if before.orphanStateErr != "" {
+		t.Fatalf("saveOrphanState() error = %s", before.orphanStateErr)
+	}
+
+	state, err := readOrphanState(filepath.Join(base, DefaultOrphanStateFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.FirstSeen) != 1 || !state.FirstSeen["ns/stale"].Equal(firstSeen) {
+		t.Errorf("persisted state = %v, want only ns/stale", state.FirstSeen)
+	}
+
+	// A restarted agent, possibly mounting the export elsewhere, keeps the
+	// earlier first-seen time over one tracked since startup
+	after := &QuotaAgent{nfsBasePath: base, orphanLastSeen: map[string]time.Time{
+		filepath.Join(base, "ns/stale"): firstSeen.Add(time.Hour),
+	}}
+	after.loadOrphanState()
+	if got := after.orphanLastSeen[filepath.Join(base, "ns/stale")]; !got.Equal(firstSeen) {
+		t.Errorf("first seen after restart = %v, want %v", got, firstSeen)
+	}
+}
+
+func TestReadOrphanState(t *testing.T) {
+	dir := t.TempDir()
+
+	state, err := readOrphanState(filepath.Join(dir, "missing.json"))
+	if err != nil || len(state.FirstSeen) != 0 {
+		t.Errorf("readOrphanState(missing) = %v, %v; want empty state", state, err)
+	}
+
+	corrupt := filepath.Join(dir, "corrupt.json")
+	if err := os.WriteFile(corrupt, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readOrphanState(corrupt); err == nil {
+		t.Error("readOrphanState(corrupt) succeeded, want error")
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/dasomel/nfs-quota-agent/internal/audit"
+	"github.com/dasomel/nfs-quota-agent/internal/trash"
+	"github.com/dasomel/nfs-quota-agent/internal/util"
+)
+
+// trashStore returns the quarantine store for removed orphans
+func (a *QuotaAgent) trashStore() *trash.Store {
+	dir := a.trashDir
+	if dir == "" {
+		dir = trash.DefaultDir(a.nfsBasePath)
+	}
+	store := trash.NewStore(dir, a.trashRetention)
+	store.SetDeleter(a.deleter)
+	return store
+}
+
+// runTrashPurge periodically purges trashed directories past their retention
+func (a *QuotaAgent) runTrashPurge(ctx context.Context) {
+	store := a.trashStore()
+	limits := a.deleter.Limits()
+	slog.Info("Starting trash purge loop", "dir", store.Dir(), "retention", store.Retention(),
+		"filesPerSec", limits.FilesPerSec, "bytesPerSec", limits.BytesPerSec, "idleIO", limits.IdleIO)
+
+	a.purgeExpiredTrash(ctx, time.Time{})
+
+	ticker := time.NewTicker(a.cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.purgeExpiredTrash(ctx, time.Time{})
+		}
+	}
+}
+
+// purgeExpiredTrash permanently deletes trashed directories past retention.
+// When deadline passes, ctx is cancelled or the agent freezes, the directory
+// being deleted is checkpointed and it and the rest are left for the next
+// run; a zero deadline purges them all.
+func (a *QuotaAgent) purgeExpiredTrash(ctx context.Context, deadline time.Time) {
+	if a.dryRun {
+		return
+	}
+	ctx, cancel := a.untilFrozen(ctx)
+	defer cancel()
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	store := a.trashStore()
+	expired, err := store.Expired(time.Now())
+	if err != nil {
+		slog.Error("Failed to list trash", "error", err)
+		return
+	}
+
+	for i, e := range expired {
+		if ctx.Err() != nil || a.Frozen() {
+			slog.Info("Trash purge stopped, deferring the rest to the next run", "deferred", len(expired)-i)
+			return
+		}
+		if e.Purging != nil {
+			slog.Info("Resuming interrupted purge", "id", e.ID, "deleted", util.FormatBytes(e.Purging.Bytes), "files", e.Purging.Files)
+		}
+		res, err := store.PurgeEntry(ctx, e, a.TrashArchive(), a.auditLogger)
+		if trash.Interrupted(err) {
+			slog.Info("Trash purge interrupted, will resume in the next run", "id", e.ID, "deferred", len(expired)-i)
+			return
+		}
+		if err != nil {
+			slog.Error("Failed to purge trashed directory", "id", e.ID, "error", err)
+			continue
+		}
+		if res != nil {
+			slog.Info("Archived trashed directory", "id", e.ID, "archive", res.Path,
+				"size", util.FormatBytes(res.Size), "checksum", res.Checksum)
+		}
+		slog.Info("Purged trashed directory", "id", e.ID, "originalPath", e.OriginalPath, "size", e.SizeStr)
+	}
+}
+
+// TrashEntries returns the quarantined directories (for API)
+func (a *QuotaAgent) TrashEntries() ([]trash.Entry, error) {
+	return a.trashStore().List()
+}
+
+// TrashRetention returns how long quarantined directories are kept
+func (a *QuotaAgent) TrashRetention() time.Duration { return a.trashRetention }
+
+// TrashArchive returns where quarantined directories are archived before purge
+func (a *QuotaAgent) TrashArchive() trash.ArchiveOptions {
+	return trash.ArchiveOptions{Dir: a.archiveDir, Format: a.archiveFormat}
+}
+
+// RestoreTrash moves a quarantined directory back to its original path
+func (a *QuotaAgent) RestoreTrash(id string) (*trash.Entry, error) {
+	if a.dryRun {
+		return nil, fmt.Errorf("agent is running in dry-run mode")
+	}
+	if a.Frozen() {
+		return nil, fmt.Errorf("agent is frozen for maintenance")
+	}
+
+	store := a.trashStore()
+	trashed, err := store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := store.Restore(id, "")
+	if a.auditLogger != nil {
+		a.auditLogger.LogTrash(audit.ActionRestore, trashed.OriginalPath, trashed.ProjectName, trashed.ProjectID, trashed.TrashPath, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("Restored trashed directory", "id", id, "path", entry.OriginalPath)
+	return entry, nil
+}
@@ -48,26 +48,53 @@ func (a *QuotaAgent) watchPVs(ctx context.Context) {
 				continue
 			}
 
-			switch event.Type {
-			case watch.Added, watch.Modified:
-				if a.shouldProcessPV(pv) {
-					if err := a.ensureQuota(ctx, pv); err != nil {
-						slog.Error("Failed to ensure quota", "pv", pv.Name, "error", err)
-					}
-				}
-			case watch.Deleted:
-				a.mu.Lock()
-				nfsPath := a.getNFSPath(pv)
-				if nfsPath != "" {
-					localPath := a.nfsPathToLocal(nfsPath)
-					delete(a.appliedQuotas, localPath)
-				}
-				a.mu.Unlock()
-				slog.Debug("PV deleted, quota tracking removed", "pv", pv.Name)
-			}
+			a.handlePVEvent(ctx, event.Type, pv)
 		}
 
 		slog.Warn("PV watch ended, restarting...")
 		time.Sleep(1 * time.Second)
 	}
 }
+
+// handlePVEvent applies a PV watch event, or queues it if the agent is frozen
+// or still replaying the events queued while it was
+func (a *QuotaAgent) handlePVEvent(ctx context.Context, eventType watch.EventType, pv *v1.PersistentVolume) {
+	if a.queueEvent(eventType, pv) {
+		slog.Debug("Agent frozen or replaying, PV event queued", "pv", pv.Name, "event", eventType)
+		return
+	}
+	a.applyPVEvent(ctx, eventType, pv)
+}
+
+// applyPVEvent applies a PV event without checking the freeze
+func (a *QuotaAgent) applyPVEvent(ctx context.Context, eventType watch.EventType, pv *v1.PersistentVolume) {
+	switch eventType {
+	case watch.Added, watch.Modified:
+		if a.shouldProcessPV(pv) {
+			if err := a.ensureQuota(ctx, pv); err != nil {
+				slog.Error("Failed to ensure quota", "pv", pv.Name, "error", err)
+			}
+		}
+	case watch.Deleted:
+		a.mu.Lock()
+		nfsPath := a.getNFSPath(pv)
+		localPath, tracked := "", false
+		if nfsPath != "" {
+			localPath = a.nfsPathToLocal(nfsPath)
+			_, tracked = a.appliedQuotas[localPath]
+			delete(a.appliedQuotas, localPath)
+		}
+		a.mu.Unlock()
+
+		// Remember who used the directory, for orphan ownership hints
+		if tracked && a.auditLogger != nil {
+			var namespace, pvcName string
+			if pv.Spec.ClaimRef != nil {
+				namespace = pv.Spec.ClaimRef.Namespace
+				pvcName = pv.Spec.ClaimRef.Name
+			}
+			a.auditLogger.LogPVDelete(pv.Name, namespace, pvcName, localPath)
+		}
+		slog.Debug("PV deleted, quota tracking removed", "pv", pv.Name)
+	}
+}
@@ -0,0 +1,369 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Format is an archive compression format
+type Format string
+
+const (
+	// FormatGzip writes tar.gz archives using the Go standard library
+	FormatGzip Format = "tar.gz"
+	// FormatZstd writes tar.zst archives by piping through the zstd binary
+	FormatZstd Format = "tar.zst"
+
+	partialSuffix = ".partial"
+)
+
+// Result describes a written and verified archive
+type Result struct {
+	Path     string    `json:"path"`
+	Format   Format    `json:"format"`
+	Checksum string    `json:"checksum"` // "sha256:<hex>" of the archive file
+	Size     int64     `json:"size"`     // archive file size in bytes
+	Files    int       `json:"files"`    // entries written (files, dirs, symlinks)
+	Bytes    int64     `json:"bytes"`    // uncompressed regular file content
+	Created  time.Time `json:"created"`
+}
+
+// ParseFormat parses an archive format name ("tar.gz", "gz", "tar.zst", "zst")
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "tar.gz", "tgz", "gz", "gzip":
+		return FormatGzip, nil
+	case "tar.zst", "zst", "zstd":
+		return FormatZstd, nil
+	default:
+		return "", fmt.Errorf("unsupported archive format %q (use tar.gz or tar.zst)", s)
+	}
+}
+
+// CheckFormat reports whether the tools needed for format are available
+func CheckFormat(format Format) error {
+	if format == FormatZstd {
+		if _, err := exec.LookPath("zstd"); err != nil {
+			return fmt.Errorf("zstd binary not found: %w", err)
+		}
+	}
+	return nil
+}
+
+// Create streams the directory src into <destDir>/<name>.<format>, with
+// the tar entries under <name>/. The
+// archive is written under a temporary name, verified by reading it back,
+// and only then renamed into place, so an archive at the final path is
+// always complete.
+func Create(src, destDir, name string, format Format) (*Result, error) {
+	if err := CheckFormat(format); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(destDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	final := filepath.Join(destDir, name+"."+string(format))
+	if _, err := os.Stat(final); err == nil {
+		return nil, fmt.Errorf("archive %s already exists", final)
+	}
+
+	partial := final + partialSuffix
+	res, err := write(src, name, partial, format)
+	if err != nil {
+		_ = os.Remove(partial)
+		return nil, err
+	}
+
+	if err := Verify(partial, res); err != nil {
+		_ = os.Remove(partial)
+		return nil, err
+	}
+
+	if err := os.Rename(partial, final); err != nil {
+		_ = os.Remove(partial)
+		return nil, fmt.Errorf("failed to finalize archive: %w", err)
+	}
+
+	res.Path = final
+	return res, nil
+}
+
+// Verify re-reads the archive at path and checks it against res: the file
+// checksum and size, and the number of entries and bytes in the tar stream
+func Verify(path string, res *Result) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	counter := &countingWriter{}
+	stream := io.TeeReader(f, io.MultiWriter(h, counter))
+
+	files, bytes, err := readTar(stream, res.Format)
+	if err != nil {
+		return fmt.Errorf("archive verification failed: %w", err)
+	}
+	// Drain anything after the tar end marker so the checksum covers the file
+	if _, err := io.Copy(io.Discard, stream); err != nil {
+		return fmt.Errorf("archive verification failed: %w", err)
+	}
+
+	if files != res.Files || bytes != res.Bytes {
+		return fmt.Errorf("archive verification failed: read %d entries/%d bytes, wrote %d/%d",
+			files, bytes, res.Files, res.Bytes)
+	}
+	if counter.n != res.Size {
+		return fmt.Errorf("archive verification failed: size %d, expected %d", counter.n, res.Size)
+	}
+	if sum := checksum(h); sum != res.Checksum {
+		return fmt.Errorf("archive verification failed: checksum %s, expected %s", sum, res.Checksum)
+	}
+	return nil
+}
+
+// write creates the archive at path and returns what was written
+func write(src, root, path string, format Format) (*Result, error) {
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer out.Close()
+
+	h := sha256.New()
+	counter := &countingWriter{}
+	sink := io.MultiWriter(out, h, counter)
+
+	res := &Result{Format: format, Created: time.Now().UTC()}
+
+	switch format {
+	case FormatGzip:
+		zw := gzip.NewWriter(sink)
+		if err := writeTar(zw, src, root, res); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, fmt.Errorf("failed to finish gzip stream: %w", err)
+		}
+	case FormatZstd:
+		if err := writeZstd(sink, src, root, res); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported archive format %q", format)
+	}
+
+	if err := out.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to sync archive: %w", err)
+	}
+
+	res.Checksum = checksum(h)
+	res.Size = counter.n
+	return res, nil
+}
+
+// writeZstd pipes the tar stream through "zstd -c" into sink. The tar stream
+// goes to zstd's stdin pipe directly, so when zstd exits early (the sink
+// failed, or it was killed) writes fail instead of blocking, and zstd is
+// always waited for.
+func writeZstd(sink io.Writer, src, root string, res *Result) error {
+	var stderr strings.Builder
+
+	cmd := exec.Command("zstd", "-q", "-c", "-T0")
+	cmd.Stdout = sink
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to start zstd: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start zstd: %w", err)
+	}
+
+	tarErr := writeTar(stdin, src, root, res)
+	closeErr := stdin.Close()
+
+	// A failed zstd explains a broken pipe in the tar stream
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("zstd failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if tarErr != nil {
+		return tarErr
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to finish zstd stream: %w", closeErr)
+	}
+	return nil
+}
+
+// writeTar writes src as a tar stream, with entries under root
+func writeTar(w io.Writer, src, root string, res *Result) error {
+	tw := tar.NewWriter(w)
+
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(filepath.Join(root, rel))
+
+		var link string
+		switch mode := info.Mode(); {
+		case mode.IsRegular(), mode.IsDir():
+		case mode&os.ModeSymlink != 0:
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		default:
+			// Sockets, devices and pipes carry no data worth keeping
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		res.Files++
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		n, err := io.Copy(tw, f)
+		res.Bytes += n
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %w", src, err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish tar stream: %w", err)
+	}
+	return nil
+}
+
+// readTar decompresses r and counts the tar entries and regular file bytes
+func readTar(r io.Reader, format Format) (files int, bytes int64, err error) {
+	var stream io.Reader
+	var cmd *exec.Cmd
+
+	switch format {
+	case FormatGzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer zr.Close()
+		stream = zr
+	case FormatZstd:
+		cmd = exec.Command("zstd", "-d", "-q", "-c")
+		cmd.Stdin = r
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			return 0, 0, err
+		}
+		if err := cmd.Start(); err != nil {
+			return 0, 0, fmt.Errorf("failed to start zstd: %w", err)
+		}
+		// On a corrupt archive the tar reader stops early; do not leave
+		// zstd and the goroutine feeding it behind
+		defer func() {
+			if cmd.ProcessState == nil {
+				_ = cmd.Process.Kill()
+				_ = cmd.Wait()
+			}
+		}()
+		stream = out
+	default:
+		return 0, 0, fmt.Errorf("unsupported archive format %q", format)
+	}
+
+	tr := tar.NewReader(stream)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return files, bytes, err
+		}
+		files++
+		if hdr.Typeflag == tar.TypeReg {
+			n, err := io.Copy(io.Discard, tr)
+			if err != nil {
+				return files, bytes, err
+			}
+			bytes += n
+		}
+	}
+
+	if cmd != nil {
+		// zstd may still be writing tar padding; drain it before waiting
+		_, _ = io.Copy(io.Discard, stream)
+		if err := cmd.Wait(); err != nil {
+			return files, bytes, fmt.Errorf("zstd failed: %w", err)
+		}
+	}
+	return files, bytes, nil
+}
+
+// checksum formats a digest as "sha256:<hex>"
+func checksum(h hash.Hash) string {
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// countingWriter counts bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
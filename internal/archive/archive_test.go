@@ -0,0 +1,177 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archive
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Format
+		wantErr bool
+	}{
+		{"tar.gz", FormatGzip, false},
+		{"gzip", FormatGzip, false},
+		{"TGZ", FormatGzip, false},
+		{"tar.zst", FormatZstd, false},
+		{"zstd", FormatZstd, false},
+		{"zip", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseFormat(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFormat(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseFormat(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateVerify(t *testing.T) {
+	for _, format := range []Format{FormatGzip, FormatZstd} {
+		t.Run(string(format), func(t *testing.T) {
+			if err := CheckFormat(format); err != nil {
+				t.Skip(err)
+			}
+
+			src := filepath.Join(t.TempDir(), "pvc-abc")
+			if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte(strings.Repeat("x", 4096)), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink("a.txt", filepath.Join(src, "link")); err != nil {
+				t.Fatal(err)
+			}
+
+			dest := t.TempDir()
+			res, err := Create(src, dest, "pvc-abc", format)
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+
+			if want := filepath.Join(dest, "pvc-abc."+string(format)); res.Path != want {
+				t.Errorf("Path = %s, want %s", res.Path, want)
+			}
+			// root dir, sub dir, two files, one symlink
+			if res.Files != 5 || res.Bytes != 5+4096 {
+				t.Errorf("Files/Bytes = %d/%d, want 5/%d", res.Files, res.Bytes, 5+4096)
+			}
+			if !strings.HasPrefix(res.Checksum, "sha256:") {
+				t.Errorf("Checksum = %q, want sha256 prefix", res.Checksum)
+			}
+			if info, err := os.Stat(res.Path); err != nil || info.Size() != res.Size {
+				t.Errorf("archive size = %v (%v), want %d", info, err, res.Size)
+			}
+			if _, err := os.Stat(res.Path + partialSuffix); !os.IsNotExist(err) {
+				t.Error("partial archive left behind")
+			}
+
+			if err := Verify(res.Path, res); err != nil {
+				t.Errorf("Verify() error = %v", err)
+			}
+
+			// A tampered archive must fail verification
+			data, err := os.ReadFile(res.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data[len(data)/2] ^= 0xff
+			if err := os.WriteFile(res.Path, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := Verify(res.Path, res); err == nil {
+				t.Error("Verify() of tampered archive succeeded")
+			}
+
+			// Never overwrite an existing archive
+			if _, err := Create(src, dest, "pvc-abc", format); err == nil {
+				t.Error("Create() over existing archive succeeded")
+			}
+		})
+	}
+}
+
+// failingWriter fails every write, like a full disk
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("no space left on device") }
+
+func TestWriteZstdSinkFails(t *testing.T) {
+	if err := CheckFormat(FormatZstd); err != nil {
+		t.Skip(err)
+	}
+	// Incompressible files, far more than zstd buffers before its first
+	// write to the failing sink
+	src := t.TempDir()
+	data := make([]byte, 1<<20)
+	for i := 0; i < 64; i++ {
+		if _, err := rand.Read(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(src, fmt.Sprintf("data-%d", i)), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- writeZstd(failingWriter{}, src, "pvc-abc", &Result{}) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("writeZstd() into a failing sink succeeded")
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("writeZstd() hung after zstd exited")
+	}
+}
+
+func TestVerifyCorruptZstd(t *testing.T) {
+	if err := CheckFormat(FormatZstd); err != nil {
+		t.Skip(err)
+	}
+	path := filepath.Join(t.TempDir(), "bad.tar.zst")
+	if err := os.WriteFile(path, []byte(strings.Repeat("not zstd", 1024)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, _, err := readTar(f, FormatZstd); err == nil {
+		t.Error("readTar() of a corrupt archive succeeded")
+	}
+}
@@ -256,3 +256,62 @@ func splitLines(data []byte) [][]byte {
 	}
 	return lines
 }
+
+func TestFindOwners(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	write := func(path string, entries ...Entry) {
+		t.Helper()
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		for _, e := range entries {
+			data, _ := json.Marshal(e)
+			f.Write(append(data, '\n'))
+		}
+	}
+
+	// Older entries live in a rotated file
+	write(logPath+".20240101-000000",
+		Entry{Timestamp: t0, Action: ActionCreate, PVName: "pv-a", Namespace: "team-a", PVCName: "data", Path: "/export/a", Success: true},
+		Entry{Timestamp: t0, Action: ActionCreate, PVName: "pv-b", Namespace: "team-b", PVCName: "logs", Path: "/export/b", Success: true},
+	)
+	write(logPath,
+		Entry{Timestamp: t0.Add(time.Hour), Action: ActionUpdate, PVName: "pv-a", Path: "/export/a", Success: true},
+		Entry{Timestamp: t0.Add(2 * time.Hour), Action: ActionDelete, PVName: "pv-a", Namespace: "team-a", PVCName: "data", Path: "/export/a", Success: true},
+		// Failed and planned changes do not change ownership
+		Entry{Timestamp: t0.Add(3 * time.Hour), Action: ActionCreate, PVName: "pv-x", Path: "/export/b", Success: false},
+		Entry{Timestamp: t0.Add(3 * time.Hour), Action: ActionCreate, PVName: "pv-y", Path: "/export/b", Success: true, DryRun: true},
+		Entry{Timestamp: t0.Add(4 * time.Hour), Action: ActionCleanup, Path: "/export/c", Success: true},
+	)
+	if f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644); err == nil {
+		f.WriteString("{not json\n")
+		f.Close()
+	}
+
+	owners, err := FindOwners(logPath, []string{"/export/a", "/export/b", "/export/c"})
+	if err != nil {
+		t.Fatalf("FindOwners() error = %v", err)
+	}
+
+	a := owners["/export/a"]
+	if a.PVName != "pv-a" || a.Namespace != "team-a" || a.PVCName != "data" {
+		t.Errorf("owner of a = %+v, want pv-a team-a/data", a)
+	}
+	if !a.Since.Equal(t0) || !a.DeletedAt.Equal(t0.Add(2*time.Hour)) {
+		t.Errorf("owner of a since %v deleted %v, want %v and %v", a.Since, a.DeletedAt, t0, t0.Add(2*time.Hour))
+	}
+	if b := owners["/export/b"]; b.PVName != "pv-b" || !b.DeletedAt.IsZero() {
+		t.Errorf("owner of b = %+v, want pv-b not deleted", b)
+	}
+	if _, ok := owners["/export/c"]; ok {
+		t.Error("path without PV entries has an owner")
+	}
+
+	if _, err := FindOwners(filepath.Join(t.TempDir(), "missing.log"), nil); !os.IsNotExist(err) {
+		t.Errorf("FindOwners(missing) error = %v, want not exist", err)
+	}
+}
@@ -26,6 +26,15 @@ const (
 	ActionUpdate  Action = "UPDATE"
 	ActionDelete  Action = "DELETE"
 	ActionCleanup Action = "CLEANUP"
+
+	// Orphan quarantine (trash) actions
+	ActionQuarantine Action = "QUARANTINE"
+	ActionRestore    Action = "RESTORE"
+	ActionPurge      Action = "PURGE"
+	ActionArchive    Action = "ARCHIVE"
+
+	// Usage anomaly detected from history
+	ActionAnomaly Action = "ANOMALY"
 )
 
 // Entry represents a single audit log entry
@@ -43,6 +52,11 @@ type Entry struct {
 	FSType      string    `json:"fs_type,omitempty"`
 	Success     bool      `json:"success"`
 	Error       string    `json:"error,omitempty"`
+	DryRun      bool      `json:"dry_run,omitempty"`
+	Detail      string    `json:"detail,omitempty"`
+	ArchivePath string    `json:"archive_path,omitempty"`
+	ArchiveSize int64     `json:"archive_size_bytes,omitempty"`
+	Checksum    string    `json:"checksum,omitempty"`
 	NodeName    string    `json:"node_name,omitempty"`
 	AgentID     string    `json:"agent_id,omitempty"`
 }
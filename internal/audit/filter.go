@@ -101,14 +101,16 @@ func PrintEntries(entries []Entry, format string) {
 			fmt.Println(string(data))
 		}
 	case "table":
-		fmt.Printf("%-20s %-8s %-30s %-40s %-10s %s\n",
+		fmt.Printf("%-20s %-10s %-30s %-40s %-10s %s\n",
 			"TIMESTAMP", "ACTION", "PV_NAME", "PATH", "STATUS", "QUOTA")
-		fmt.Println(strings.Repeat("-", 120))
+		fmt.Println(strings.Repeat("-", 122))
 
 		for _, entry := range entries {
 			status := "OK"
 			if !entry.Success {
 				status = "FAIL"
+			} else if entry.DryRun {
+				status = "DRY-RUN"
 			}
 			quota := ""
 			if entry.NewQuota > 0 {
@@ -124,7 +126,7 @@ func PrintEntries(entries []Entry, format string) {
 				path = "..." + path[len(path)-37:]
 			}
 
-			fmt.Printf("%-20s %-8s %-30s %-40s %-10s %s\n",
+			fmt.Printf("%-20s %-10s %-30s %-40s %-10s %s\n",
 				entry.Timestamp.Format("2006-01-02 15:04:05"),
 				entry.Action,
 				pvName,
@@ -142,6 +144,9 @@ func PrintEntries(entries []Entry, format string) {
 				entry.Path,
 				entry.Success,
 			)
+			if entry.ArchivePath != "" {
+				fmt.Printf("    archive=%s size=%d %s\n", entry.ArchivePath, entry.ArchiveSize, entry.Checksum)
+			}
 		}
 	}
 }
@@ -92,6 +92,23 @@ func NewLogger(config Config) (*Logger, error) {
 	return logger, nil
 }
 
+// Open opens the audit log at filePath for CLI commands. It returns nil when
+// filePath is empty, or prints a warning and returns nil when the log cannot
+// be opened, so a missing audit log never blocks the command.
+func Open(filePath string) *Logger {
+	if filePath == "" {
+		return nil
+	}
+	config := DefaultConfig()
+	config.FilePath = filePath
+	logger, err := NewLogger(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: audit logging disabled: %v\n", err)
+		return nil
+	}
+	return logger
+}
+
 // Log writes an audit entry
 func (l *Logger) Log(entry Entry) error {
 	if !l.enabled {
@@ -167,6 +184,25 @@ func (l *Logger) LogQuotaUpdate(pvName, path, projectName string, projectID uint
 	_ = l.Log(entry)
 }
 
+// LogPlanned logs a change that dry-run mode would have made
+func (l *Logger) LogPlanned(action Action, pvName, namespace, pvcName, path, projectName string, projectID uint32, oldQuota, newQuota int64, fsType, detail string) {
+	_ = l.Log(Entry{
+		Action:      action,
+		PVName:      pvName,
+		Namespace:   namespace,
+		PVCName:     pvcName,
+		Path:        path,
+		ProjectID:   projectID,
+		ProjectName: projectName,
+		OldQuota:    oldQuota,
+		NewQuota:    newQuota,
+		FSType:      fsType,
+		Success:     true,
+		DryRun:      true,
+		Detail:      detail,
+	})
+}
+
 // LogQuotaDelete logs quota deletion
 func (l *Logger) LogQuotaDelete(pvName, path, projectName string, projectID uint32, err error) {
 	entry := Entry{
@@ -183,6 +219,20 @@ func (l *Logger) LogQuotaDelete(pvName, path, projectName string, projectID uint
 	_ = l.Log(entry)
 }
 
+// LogPVDelete logs the deletion of a PV. The agent keeps the directory and
+// its quota, so the entry records who used the path until then.
+func (l *Logger) LogPVDelete(pvName, namespace, pvcName, path string) {
+	_ = l.Log(Entry{
+		Action:    ActionDelete,
+		PVName:    pvName,
+		Namespace: namespace,
+		PVCName:   pvcName,
+		Path:      path,
+		Success:   true,
+		Detail:    "PV deleted, directory and quota kept",
+	})
+}
+
 // LogCleanup logs cleanup operation
 func (l *Logger) LogCleanup(path, projectName string, projectID uint32, err error) {
 	entry := Entry{
@@ -198,6 +248,56 @@ func (l *Logger) LogCleanup(path, projectName string, projectID uint32, err erro
 	_ = l.Log(entry)
 }
 
+// LogTrash logs a quarantine, restore or purge of an orphaned directory.
+// detail holds the trash location.
+func (l *Logger) LogTrash(action Action, path, projectName string, projectID uint32, detail string, err error) {
+	entry := Entry{
+		Action:      action,
+		Path:        path,
+		ProjectID:   projectID,
+		ProjectName: projectName,
+		Detail:      detail,
+		Success:     err == nil,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	_ = l.Log(entry)
+}
+
+// LogArchive logs the archiving of an orphaned directory before it is
+// purged, with the archive location, size and checksum
+func (l *Logger) LogArchive(path, projectName string, projectID uint32, archivePath string, archiveSize int64, checksum string, err error) {
+	entry := Entry{
+		Action:      ActionArchive,
+		Path:        path,
+		ProjectID:   projectID,
+		ProjectName: projectName,
+		ArchivePath: archivePath,
+		ArchiveSize: archiveSize,
+		Checksum:    checksum,
+		Success:     err == nil,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	_ = l.Log(entry)
+}
+
+// LogAnomaly logs abnormal growth or a sudden drop of a directory's usage,
+// with the PV using it if known
+func (l *Logger) LogAnomaly(pvName, namespace, pvcName, path, detail string) {
+	_ = l.Log(Entry{
+		Action:    ActionAnomaly,
+		PVName:    pvName,
+		Namespace: namespace,
+		PVCName:   pvcName,
+		Path:      path,
+		Success:   true,
+		Detail:    detail,
+	})
+}
+
 // rotateIfNeeded rotates the log file if it exceeds max size
 func (l *Logger) rotateIfNeeded() error {
 	if l.file == nil || l.maxFileSize <= 0 {
@@ -235,6 +335,9 @@ func (l *Logger) rotateIfNeeded() error {
 	return nil
 }
 
+// FilePath returns the audit log file path
+func (l *Logger) FilePath() string { return l.filePath }
+
 // Close closes the audit logger
 func (l *Logger) Close() error {
 	l.mu.Lock()
@@ -0,0 +1,124 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Owner is the last PV the audit log shows using a path
+type Owner struct {
+	PVName    string
+	Namespace string
+	PVCName   string
+	Since     time.Time // first successful quota change for this PV
+	DeletedAt time.Time // zero unless the PV deletion was logged
+}
+
+// FindOwners returns the last known owner of each of paths, reading the
+// audit log and its rotated files oldest first. Paths never logged with a
+// PV are left out.
+func FindOwners(filePath string, paths []string) (map[string]Owner, error) {
+	want := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		want[p] = true
+	}
+
+	// Rotated files are named <file>.<YYYYMMDD-HHMMSS> and sort by time
+	rotated, _ := filepath.Glob(filePath + ".*")
+	sort.Strings(rotated)
+	files := append(rotated, filePath)
+
+	owners := make(map[string]Owner)
+	read := 0
+	for _, f := range files {
+		err := scanLog(f, func(e Entry) {
+			if want[e.Path] {
+				applyOwner(owners, e)
+			}
+		})
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		read++
+	}
+	if read == 0 {
+		return nil, &os.PathError{Op: "open", Path: filePath, Err: os.ErrNotExist}
+	}
+	return owners, nil
+}
+
+// applyOwner updates the owner of an entry's path. Quota changes name the
+// PV using the path; a DELETE marks the PV as gone.
+func applyOwner(owners map[string]Owner, e Entry) {
+	if !e.Success || e.DryRun || e.PVName == "" {
+		return
+	}
+
+	o, known := owners[e.Path]
+	switch e.Action {
+	case ActionCreate, ActionUpdate:
+		if !known || o.PVName != e.PVName {
+			o = Owner{PVName: e.PVName, Since: e.Timestamp}
+		}
+		o.DeletedAt = time.Time{}
+	case ActionDelete:
+		if known && o.PVName != e.PVName {
+			o = Owner{PVName: e.PVName}
+		}
+		o.PVName = e.PVName
+		o.DeletedAt = e.Timestamp
+	default:
+		return
+	}
+	if e.Namespace != "" {
+		o.Namespace = e.Namespace
+	}
+	if e.PVCName != "" {
+		o.PVCName = e.PVCName
+	}
+	owners[e.Path] = o
+}
+
+// scanLog calls fn for each well-formed entry of a log file, skipping
+// malformed or truncated lines
+func scanLog(filePath string, fn func(Entry)) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		fn(entry)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,288 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package chargeback turns usage history into storage cost per team
+package chargeback
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/dasomel/nfs-quota-agent/internal/history"
+)
+
+const (
+	// HoursPerMonth converts GiB-hours to GiB-months (365 * 24 / 12)
+	HoursPerMonth = 730
+
+	// AnyClass is the price key used for StorageClasses without a price
+	AnyClass = "*"
+
+	gib = 1 << 30
+)
+
+// Prices are costs per GiB-month by StorageClass
+type Prices struct {
+	ByClass  map[string]float64 `json:"byClass"`
+	Currency string             `json:"currency"`
+}
+
+// ParsePrices parses a price list such as "fast=0.10,standard=0.05,*=0.03";
+// "*" prices every StorageClass not listed, including none
+func ParsePrices(spec, currency string) (Prices, error) {
+	prices := Prices{ByClass: make(map[string]float64), Currency: currency}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		class, value, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(class) == "" {
+			return Prices{}, fmt.Errorf("invalid price %q (must be <storage-class>=<price>)", item)
+		}
+		price, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || price < 0 {
+			return Prices{}, fmt.Errorf("invalid price %q for %s", value, class)
+		}
+		prices.ByClass[strings.TrimSpace(class)] = price
+	}
+	return prices, nil
+}
+
+// For returns the price per GiB-month of a StorageClass
+func (p Prices) For(class string) float64 {
+	if price, ok := p.ByClass[class]; ok {
+		return price
+	}
+	return p.ByClass[AnyClass]
+}
+
+// Options configures a chargeback report
+type Options struct {
+	Start  time.Time
+	End    time.Time
+	Prices Prices
+
+	// Label groups namespaces by the value of this namespace label (e.g.
+	// "team") instead of by namespace; Labels maps namespaces to values
+	Label  string
+	Labels map[string]string
+
+	// Interval is the history collection interval. A rollup point counts
+	// for the snapshots behind it, Samples times Interval, up to its step;
+	// 0 bills every point for its full step.
+	Interval time.Duration
+}
+
+// Line is the cost of one group on one StorageClass
+type Line struct {
+	Group         string   `json:"group"`
+	StorageClass  string   `json:"storageClass"`
+	Namespaces    []string `json:"namespaces,omitempty"` // label grouping only
+	Paths         int      `json:"paths"`
+	QuotaGiBHours float64  `json:"quotaGiBHours"`
+	UsedGiBHours  float64  `json:"usedGiBHours"`
+	Price         float64  `json:"pricePerGiBMonth"`
+	QuotaCost     float64  `json:"quotaCost"`
+	UsedCost      float64  `json:"usedCost"`
+}
+
+// Report is the chargeback of a billing period
+type Report struct {
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	GroupBy    string    `json:"groupBy"` // "namespace" or "label:<key>"
+	Currency   string    `json:"currency"`
+	Resolution string    `json:"resolution"` // history resolution integrated
+	Lines      []Line    `json:"lines"`
+
+	QuotaGiBHours float64 `json:"quotaGiBHours"`
+	UsedGiBHours  float64 `json:"usedGiBHours"`
+	QuotaCost     float64 `json:"quotaCost"`
+	UsedCost      float64 `json:"usedCost"`
+}
+
+// Generate integrates the history of every directory over the billing
+// period into GiB-hours of quota and of usage, grouped by namespace (or
+// namespace label) and StorageClass. Each history point counts for the
+// snapshots behind it, so periods the agent did not record are not billed.
+// A period that has not ended yet is billed up to now.
+func Generate(store *history.Store, opts Options) *Report {
+	if now := time.Now(); opts.End.IsZero() || opts.End.After(now) {
+		opts.End = now
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = store.Interval()
+	}
+	points, resolution := store.QuerySelector(history.Selector{}, opts.Start, opts.End, time.Hour)
+	return Compute(points, resolution, opts)
+}
+
+// coverage returns the share of its step a point was recorded for: a
+// rollup of a PV that existed for one hour of a day, or of a day the agent
+// was down for, covers only the snapshots taken
+func coverage(p history.UsageHistory, resolution, interval time.Duration) float64 {
+	if p.Samples == 0 || interval <= 0 || resolution <= 0 {
+		return 1
+	}
+	recorded := time.Duration(p.Samples) * interval
+	if recorded >= resolution {
+		return 1
+	}
+	return float64(recorded) / float64(resolution)
+}
+
+// Compute builds a report from history points at the given resolution
+func Compute(points []history.UsageHistory, resolution time.Duration, opts Options) *Report {
+	report := &Report{
+		Start:      opts.Start,
+		End:        opts.End,
+		GroupBy:    "namespace",
+		Currency:   opts.Prices.Currency,
+		Resolution: resolution.String(),
+		Lines:      []Line{},
+	}
+	if opts.Label != "" {
+		report.GroupBy = "label:" + opts.Label
+	}
+
+	type key struct{ group, class string }
+	lines := make(map[key]*Line)
+	paths := make(map[key]map[string]bool)
+	namespaces := make(map[key]map[string]bool)
+
+	for _, p := range points {
+		from, to := p.Timestamp, p.Timestamp.Add(resolution)
+		if from.Before(opts.Start) {
+			from = opts.Start
+		}
+		if !opts.End.IsZero() && to.After(opts.End) {
+			to = opts.End
+		}
+		if !to.After(from) {
+			continue
+		}
+		hours := to.Sub(from).Hours() * coverage(p, resolution, opts.Interval)
+
+		group := p.Namespace
+		if opts.Label != "" {
+			group = opts.Labels[p.Namespace]
+		}
+		k := key{group, p.StorageClass}
+		line := lines[k]
+		if line == nil {
+			line = &Line{Group: group, StorageClass: p.StorageClass, Price: opts.Prices.For(p.StorageClass)}
+			lines[k] = line
+			paths[k] = make(map[string]bool)
+			namespaces[k] = make(map[string]bool)
+		}
+		line.QuotaGiBHours += float64(p.Quota) / gib * hours
+		line.UsedGiBHours += float64(p.Used) / gib * hours
+		paths[k][p.Path] = true
+		if p.Namespace != "" {
+			namespaces[k][p.Namespace] = true
+		}
+	}
+
+	for k, line := range lines {
+		line.Paths = len(paths[k])
+		if opts.Label != "" {
+			for ns := range namespaces[k] {
+				line.Namespaces = append(line.Namespaces, ns)
+			}
+			sort.Strings(line.Namespaces)
+		}
+		line.QuotaCost = line.QuotaGiBHours / HoursPerMonth * line.Price
+		line.UsedCost = line.UsedGiBHours / HoursPerMonth * line.Price
+
+		report.QuotaGiBHours += line.QuotaGiBHours
+		report.UsedGiBHours += line.UsedGiBHours
+		report.QuotaCost += line.QuotaCost
+		report.UsedCost += line.UsedCost
+		report.Lines = append(report.Lines, *line)
+	}
+
+	// Unassigned usage (no namespace or label) last
+	sort.Slice(report.Lines, func(i, j int) bool {
+		a, b := report.Lines[i], report.Lines[j]
+		if (a.Group == "") != (b.Group == "") {
+			return b.Group == ""
+		}
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		return a.StorageClass < b.StorageClass
+	})
+	return report
+}
+
+// NamespaceLabels maps every namespace to the value of its label key
+func NamespaceLabels(ctx context.Context, client kubernetes.Interface, key string) (map[string]string, error) {
+	list, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	labels := make(map[string]string, len(list.Items))
+	for _, ns := range list.Items {
+		if value, ok := ns.Labels[key]; ok {
+			labels[ns.Name] = value
+		}
+	}
+	return labels, nil
+}
+
+// ParsePeriod returns the billing period: since to until (now when empty)
+// when since is set, otherwise the month given as YYYY-MM, or the previous
+// month. Months are UTC. Times are parsed as by history.ParseTime.
+func ParsePeriod(month, since, until string, now time.Time) (time.Time, time.Time, error) {
+	if since != "" {
+		start, err := history.ParseTime(since, now)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		end, err := history.ParseTime(until, now)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		if end.IsZero() {
+			end = now
+		}
+		if !end.After(start) {
+			return time.Time{}, time.Time{}, fmt.Errorf("billing period ends before it starts")
+		}
+		return start, end, nil
+	}
+
+	var start time.Time
+	if month == "" {
+		now = now.UTC()
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+	} else {
+		t, err := time.Parse("2006-01", month)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid month %q (must be YYYY-MM)", month)
+		}
+		start = t
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chargeback
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dasomel/nfs-quota-agent/internal/history"
+)
+
+func TestParsePrices(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    map[string]float64
+		wantErr bool
+	}{
+		{"", map[string]float64{}, false},
+		{"fast=0.10, slow=0.02,*=0.05", map[string]float64{"fast": 0.10, "slow": 0.02, "*": 0.05}, false},
+		{"fast", nil, true},
+		{"fast=cheap", nil, true},
+		{"fast=-1", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			prices, err := ParsePrices(tt.spec, "EUR")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePrices(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(prices.ByClass) != len(tt.want) {
+				t.Fatalf("Expected %v, got %v", tt.want, prices.ByClass)
+			}
+			for class, price := range tt.want {
+				if prices.ByClass[class] != price {
+					t.Errorf("Expected %s=%v, got %v", class, price, prices.ByClass[class])
+				}
+			}
+		})
+	}
+
+	prices, _ := ParsePrices("fast=0.10,*=0.05", "")
+	if prices.For("fast") != 0.10 || prices.For("other") != 0.05 || prices.For("") != 0.05 {
+		t.Errorf("Expected the * price for unlisted classes, got %+v", prices)
+	}
+}
+
+func TestParsePeriod(t *testing.T) {
+	now := time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name               string
+		month, since, till string
+		start, end         time.Time
+		wantErr            bool
+	}{
+		{"last month", "", "", "", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), false},
+		{"month", "2023-12", "", "", time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{"since", "", "7d", "", now.Add(-7 * 24 * time.Hour), now, false},
+		{"range", "", "2024-03-01", "2024-03-08", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 8, 0, 0, 0, 0, time.UTC), false},
+		{"bad month", "March", "", "", time.Time{}, time.Time{}, true},
+		{"reversed", "", "2024-03-08", "2024-03-01", time.Time{}, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, err := ParsePeriod(tt.month, tt.since, tt.till, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePeriod error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !start.Equal(tt.start) || !end.Equal(tt.end) {
+				t.Errorf("Expected %v - %v, got %v - %v", tt.start, tt.end, start, end)
+			}
+		})
+	}
+}
+
+func TestCompute(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(10 * 24 * time.Hour)
+
+	var points []history.UsageHistory
+	add := func(path string, owner history.Owner, used, quota uint64, from time.Time, days int) {
+		for i := 0; i < days; i++ {
+			points = append(points, history.UsageHistory{
+				Timestamp: from.Add(time.Duration(i) * 24 * time.Hour),
+				Path:      path, Used: used, Quota: quota, Owner: owner,
+			})
+		}
+	}
+	fast := history.Owner{PVName: "pv-1", Namespace: "shop", StorageClass: "fast"}
+	slow := history.Owner{PVName: "pv-2", Namespace: "shop", StorageClass: "slow"}
+	search := history.Owner{PVName: "pv-3", Namespace: "search", StorageClass: "slow"}
+	// The day of /data/a before the period is not billed
+	add("/data/a", fast, 10<<30, 20<<30, start.Add(-24*time.Hour), 11)
+	add("/data/b", slow, 73<<30, 73<<30, start, 10)
+	add("/data/c", search, 1<<30, 2<<30, start.Add(5*24*time.Hour), 5)
+	add("/data/orphan", history.Owner{}, 1<<30, 0, start, 10)
+
+	prices, _ := ParsePrices("fast=0.73,*=0.073", "USD")
+	labels := map[string]string{"shop": "team-a", "search": "team-a"}
+
+	tests := []struct {
+		name  string
+		label string
+		want  map[[2]string][2]float64 // group/class -> quota, used GiB-hours
+	}{
+		{"namespace", "", map[[2]string][2]float64{
+			{"shop", "fast"}:   {4800, 2400},
+			{"shop", "slow"}:   {17520, 17520},
+			{"search", "slow"}: {240, 120},
+			{"", ""}:           {0, 240},
+		}},
+		{"label", "team", map[[2]string][2]float64{
+			{"team-a", "fast"}: {4800, 2400},
+			{"team-a", "slow"}: {17760, 17640},
+			{"", ""}:           {0, 240},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := Compute(points, 24*time.Hour, Options{Start: start, End: end, Prices: prices, Label: tt.label, Labels: labels})
+			if len(report.Lines) != len(tt.want) {
+				t.Fatalf("Expected %d lines, got %+v", len(tt.want), report.Lines)
+			}
+			if last := report.Lines[len(report.Lines)-1]; last.Group != "" {
+				t.Errorf("Expected unassigned usage last, got %q", last.Group)
+			}
+			var total float64
+			for _, l := range report.Lines {
+				want, ok := tt.want[[2]string{l.Group, l.StorageClass}]
+				if !ok || math.Abs(l.QuotaGiBHours-want[0]) > 1e-6 || math.Abs(l.UsedGiBHours-want[1]) > 1e-6 {
+					t.Errorf("%s/%s: expected GiB-hours %v, got %v/%v", l.Group, l.StorageClass, want, l.QuotaGiBHours, l.UsedGiBHours)
+				}
+				if math.Abs(l.UsedCost-l.UsedGiBHours/HoursPerMonth*prices.For(l.StorageClass)) > 1e-9 {
+					t.Errorf("%s/%s: wrong used cost %v", l.Group, l.StorageClass, l.UsedCost)
+				}
+				total += l.UsedCost
+			}
+			if math.Abs(report.UsedCost-total) > 1e-9 {
+				t.Errorf("Expected total %v, got %v", total, report.UsedCost)
+			}
+		})
+	}
+
+	// 73 GiB for 10 days at 0.073 per GiB-month: 73 * 240 / 730 * 0.073
+	report := Compute(points, 24*time.Hour, Options{Start: start, End: end, Prices: prices})
+	for _, format := range []string{FormatCSV, FormatJSON, FormatMarkdown} {
+		var buf bytes.Buffer
+		if err := Write(&buf, report, format); err != nil {
+			t.Fatalf("Write %s failed: %v", format, err)
+		}
+		if !strings.Contains(buf.String(), "1.75") {
+			t.Errorf("Expected the shop/slow cost in the %s output:\n%s", format, buf.String())
+		}
+	}
+}
+
+func TestComputeRollups(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	owner := history.Owner{PVName: "pv-1", Namespace: "shop", StorageClass: "fast"}
+	day := func(i, samples int) history.UsageHistory {
+		return history.UsageHistory{
+			Timestamp: start.Add(time.Duration(i) * 24 * time.Hour),
+			Path:      "/data/a", Used: 1 << 30, Quota: 2 << 30, Owner: owner, Samples: samples,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		points   []history.UsageHistory
+		interval time.Duration
+		want     float64 // used GiB-hours
+	}{
+		{"full day", []history.UsageHistory{day(0, 288)}, 5 * time.Minute, 24},
+		{"PV created for one hour", []history.UsageHistory{day(0, 12)}, 5 * time.Minute, 1},
+		{"agent down half a day", []history.UsageHistory{day(0, 288), day(1, 144)}, 5 * time.Minute, 36},
+		{"samples capped at the step", []history.UsageHistory{day(0, 400)}, 5 * time.Minute, 24},
+		{"unknown interval", []history.UsageHistory{day(0, 12)}, 0, 24},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := Compute(tt.points, 24*time.Hour, Options{Start: start, End: start.Add(48 * time.Hour), Interval: tt.interval})
+			if math.Abs(report.UsedGiBHours-tt.want) > 1e-9 || math.Abs(report.QuotaGiBHours-2*tt.want) > 1e-9 {
+				t.Errorf("Expected %v used GiB-hours, got %v (quota %v)", tt.want, report.UsedGiBHours, report.QuotaGiBHours)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chargeback
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/dasomel/nfs-quota-agent/internal/history"
+)
+
+// RunOptions configures the chargeback command
+type RunOptions struct {
+	HistoryPath string
+	Options
+	Client kubernetes.Interface // for label grouping
+	Format string
+	Output string // file to write, "-" or empty for stdout
+}
+
+// Run writes the chargeback report of a billing period from the agent's
+// history
+func Run(ctx context.Context, opts RunOptions) error {
+	if err := ValidateFormat(opts.Format); err != nil {
+		return err
+	}
+	store, err := history.Open(opts.HistoryPath)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	if opts.Label != "" {
+		if opts.Labels, err = NamespaceLabels(ctx, opts.Client, opts.Label); err != nil {
+			return err
+		}
+	}
+
+	out := os.Stdout
+	if opts.Output != "" && opts.Output != "-" {
+		f, err := os.Create(opts.Output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := Write(out, Generate(store, opts.Options), opts.Format); err != nil {
+		return err
+	}
+	if out != os.Stdout {
+		return out.Sync()
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chargeback
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Report formats
+const (
+	FormatCSV      = "csv"
+	FormatJSON     = "json"
+	FormatMarkdown = "markdown"
+)
+
+// ContentType returns the HTTP content type of a report format
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv"
+	case FormatMarkdown:
+		return "text/markdown; charset=utf-8"
+	}
+	return "application/json"
+}
+
+// ValidateFormat checks a report format
+func ValidateFormat(format string) error {
+	switch format {
+	case FormatCSV, FormatJSON, FormatMarkdown:
+		return nil
+	}
+	return fmt.Errorf("unsupported format %q (must be %s, %s or %s)", format, FormatCSV, FormatJSON, FormatMarkdown)
+}
+
+// Write writes a report as CSV, JSON or Markdown
+func Write(w io.Writer, report *Report, format string) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case FormatCSV:
+		return writeCSV(w, report)
+	case FormatMarkdown:
+		return writeMarkdown(w, report)
+	}
+	return ValidateFormat(format)
+}
+
+func writeCSV(w io.Writer, report *Report) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"group", "storage_class", "namespaces", "paths", "quota_gib_hours", "used_gib_hours",
+		"price_per_gib_month", "quota_cost", "used_cost", "currency", "start", "end"})
+	for _, l := range report.Lines {
+		_ = cw.Write([]string{
+			l.Group, l.StorageClass, strings.Join(l.Namespaces, " "), strconv.Itoa(l.Paths),
+			formatFloat(l.QuotaGiBHours), formatFloat(l.UsedGiBHours), formatFloat(l.Price),
+			formatFloat(l.QuotaCost), formatFloat(l.UsedCost), report.Currency,
+			report.Start.Format(time.RFC3339), report.End.Format(time.RFC3339),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeMarkdown(w io.Writer, report *Report) error {
+	group := "Namespace"
+	if label, ok := strings.CutPrefix(report.GroupBy, "label:"); ok {
+		group = label
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Storage chargeback %s – %s\n\n", report.Start.Format("2006-01-02"), report.End.Format("2006-01-02"))
+	fmt.Fprintf(&b, "| %s | StorageClass | Dirs | Quota GiB-h | Used GiB-h | Price / GiB-month | Quota cost | Used cost |\n", group)
+	b.WriteString("|---|---|---:|---:|---:|---:|---:|---:|\n")
+	for _, l := range report.Lines {
+		fmt.Fprintf(&b, "| %s | %s | %d | %.1f | %.1f | %s | %s | %s |\n",
+			orDash(l.Group), orDash(l.StorageClass), l.Paths, l.QuotaGiBHours, l.UsedGiBHours,
+			formatMoney(l.Price, report.Currency), formatMoney(l.QuotaCost, report.Currency), formatMoney(l.UsedCost, report.Currency))
+	}
+	fmt.Fprintf(&b, "| **Total** | | | %.1f | %.1f | | **%s** | **%s** |\n",
+		report.QuotaGiBHours, report.UsedGiBHours,
+		formatMoney(report.QuotaCost, report.Currency), formatMoney(report.UsedCost, report.Currency))
+	fmt.Fprintf(&b, "\nIntegrated from %s usage history; one GiB-month is %d GiB-hours.\n", report.Resolution, HoursPerMonth)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', 4, 64)
+}
+
+func formatMoney(v float64, currency string) string {
+	if currency == "" {
+		return fmt.Sprintf("%.2f", v)
+	}
+	return fmt.Sprintf("%.2f %s", v, currency)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
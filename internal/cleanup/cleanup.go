@@ -19,161 +19,153 @@ package cleanup
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
+	"text/tabwriter"
 	"time"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 
-	"github.com/dasomel/nfs-quota-agent/internal/quota"
-	"github.com/dasomel/nfs-quota-agent/internal/status"
+	"github.com/dasomel/nfs-quota-agent/internal/agent"
+	"github.com/dasomel/nfs-quota-agent/internal/audit"
+	"github.com/dasomel/nfs-quota-agent/internal/ui"
 	"github.com/dasomel/nfs-quota-agent/internal/util"
 )
 
-// OrphanedQuota represents a quota without corresponding PV
-type OrphanedQuota struct {
-	ProjectID   string
-	ProjectName string
-	Path        string
-	DirExists   bool
-	DirSize     uint64
-}
+// Cleanup actions
+const (
+	ActionTrash       = "trash"        // move the directory to the trash
+	ActionRemoveQuota = "remove-quota" // clear limits and projects file entries
+	ActionKeep        = "keep"         // protected by an exclusion or keep marker
+	ActionWait        = "wait"         // still in the orphan grace period
+	ActionDefer       = "defer"        // over the per-run budget, left for the next run
+	ActionNone        = "none"         // reported only, or left for the agent's quota sync
+)
 
-// Result contains the cleanup operation results
-type Result struct {
-	ScannedCount  int
-	OrphanedCount int
-	CleanedCount  int
-	Orphans       []OrphanedQuota
+// Options configures the cleanup command
+type Options struct {
+	NfsBasePath     string
+	NfsServerPath   string
+	ProvisionerName string
+	ProcessAllNFS   bool
+	ProjectsFile    string
+	ProjidFile      string
+	TrashDir        string
+	AuditLogPath    string
+	Excludes        []string
+	Output          string // "table" or "json"
+	DryRun          bool
+	Yes             bool
+	Client          kubernetes.Interface
+
+	// QuotaOnly only removes quotas whose directory is gone and leaves
+	// directories without a PV alone
+	QuotaOnly bool
+
+	// Safety rails shared with the agent's auto-cleanup
+	GracePeriod  time.Duration // directories must be orphaned this long
+	StateFile    string        // orphan tracker file (empty: the agent's default)
+	MaxCount     int           // directories moved per run (0 = unlimited)
+	MaxBytes     uint64        // bytes moved per run (0 = unlimited)
+	AbortPercent int           // abort when more directories look orphaned (0 = never)
 }
 
-// RunCleanup performs the cleanup operation
-func RunCleanup(basePath, kubeconfig string, dryRun, force bool) error {
-	fmt.Printf("NFS Quota Cleanup\n")
-	fmt.Printf("=================\n\n")
-	fmt.Printf("Path: %s\n", basePath)
-	fmt.Printf("Mode: %s\n\n", map[bool]string{true: "DRY-RUN (no changes)", false: "LIVE"}[dryRun])
+// Finding is an orphan finding with the action cleanup takes on it
+type Finding struct {
+	agent.OrphanFinding
+	Action string `json:"action"`
+	Result string `json:"result,omitempty"` // "done" or the error, empty in dry-run
+}
 
-	var config *rest.Config
-	var err error
+// Report is the result of a cleanup run
+type Report struct {
+	Timestamp time.Time      `json:"timestamp"`
+	BasePath  string         `json:"basePath"`
+	DryRun    bool           `json:"dryRun"`
+	QuotaOnly bool           `json:"quotaOnly,omitempty"`
+	Aborted   string         `json:"aborted,omitempty"` // why no action was taken
+	Findings  []Finding      `json:"findings"`
+	Summary   map[string]int `json:"summary"`
+	Resolved  int            `json:"resolved"`
+	Failed    int            `json:"failed"`
+}
 
-	if kubeconfig != "" {
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
-	} else {
-		config, err = rest.InClusterConfig()
-	}
+// Run finds orphans with the agent's detection engine and, unless in
+// dry-run mode, moves directories without a PV to the trash and removes
+// quotas whose directory is gone. Directories go through the same rails as
+// the agent's auto-cleanup: the grace period since the orphan tracker first
+// saw them, the per-run budget and the orphan ratio abort.
+func Run(ctx context.Context, opts Options) error {
+	rules, err := agent.ParseExcludeRules(opts.Excludes)
 	if err != nil {
-		return fmt.Errorf("failed to create Kubernetes config: %w", err)
+		return err
 	}
 
-	client, err := kubernetes.NewForConfig(config)
+	ag := agent.NewQuotaAgent(opts.Client, opts.NfsBasePath, opts.NfsServerPath, opts.ProvisionerName)
+	ag.SetProcessAllNFS(opts.ProcessAllNFS)
+	ag.SetProjectsFile(opts.ProjectsFile)
+	ag.SetProjidFile(opts.ProjidFile)
+	ag.SetTrashDir(opts.TrashDir)
+	ag.SetAuditLogPath(opts.AuditLogPath)
+	ag.SetOrphanExcludes(rules)
+	ag.SetDryRun(opts.DryRun)
+	ag.SetOrphanGracePeriodDuration(opts.GracePeriod)
+	ag.SetOrphanStateFile(opts.StateFile)
+	ag.SetCleanupMaxCount(opts.MaxCount)
+	ag.SetCleanupMaxBytes(opts.MaxBytes)
+	ag.SetCleanupAbortPercent(opts.AbortPercent)
+
+	findings, scanned, err := ag.FindOrphans(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to create Kubernetes client: %w", err)
+		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	pvList, err := client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to list PVs: %w", err)
+	var sel agent.OrphanSelection
+	if !opts.QuotaOnly {
+		sel = ag.SelectOrphans(findings, scanned)
 	}
 
-	validPaths := make(map[string]bool)
-	for _, pv := range pvList.Items {
-		if pv.Spec.NFS != nil {
-			dirName := filepath.Base(pv.Spec.NFS.Path)
-			validPaths[dirName] = true
-		}
-	}
-
-	fmt.Printf("Found %d NFS PersistentVolumes in cluster\n\n", len(validPaths))
-
-	projectsFile := filepath.Join(basePath, "projects")
-	projidFile := filepath.Join(basePath, "projid")
-
-	projects, err := quota.ReadProjectsFile(projectsFile)
-	if err != nil {
-		return fmt.Errorf("failed to read projects file: %w", err)
-	}
-
-	projids, err := quota.ReadProjidFile(projidFile)
-	if err != nil {
-		return fmt.Errorf("failed to read projid file: %w", err)
+	r := &Report{
+		Timestamp: time.Now(),
+		BasePath:  opts.NfsBasePath,
+		DryRun:    opts.DryRun,
+		QuotaOnly: opts.QuotaOnly,
+		Aborted:   sel.Aborted,
+		Findings:  make([]Finding, 0, len(findings)),
+		Summary:   make(map[string]int),
 	}
-
-	var orphans []OrphanedQuota
-	for projectID, projectPath := range projects {
-		dirName := filepath.Base(projectPath)
-
-		if !validPaths[dirName] {
-			dirExists := false
-			var dirSize uint64
-
-			if info, err := os.Stat(projectPath); err == nil && info.IsDir() {
-				dirExists = true
-				dirSize = status.GetDirSize(projectPath)
-			}
-
-			orphan := OrphanedQuota{
-				ProjectID:   projectID,
-				ProjectName: projids[projectID],
-				Path:        projectPath,
-				DirExists:   dirExists,
-				DirSize:     dirSize,
-			}
-			orphans = append(orphans, orphan)
+	actionable := 0
+	for _, f := range findings {
+		finding := Finding{OrphanFinding: f, Action: action(f, sel, opts.QuotaOnly)}
+		if finding.Action == ActionTrash || finding.Action == ActionRemoveQuota {
+			actionable++
 		}
+		r.Findings = append(r.Findings, finding)
+		r.Summary[f.Kind]++
 	}
 
-	result := Result{
-		ScannedCount:  len(projects),
-		OrphanedCount: len(orphans),
-		Orphans:       orphans,
+	if r.Aborted != "" && !opts.DryRun {
+		if err := r.print(opts.Output); err != nil {
+			return err
+		}
+		return fmt.Errorf("cleanup aborted: %s", r.Aborted)
 	}
 
-	if len(orphans) == 0 {
-		fmt.Println("No orphaned quotas found.")
-		return nil
+	if opts.DryRun || actionable == 0 {
+		return r.print(opts.Output)
 	}
 
-	fmt.Printf("Found %d orphaned quotas:\n\n", len(orphans))
-	fmt.Printf("%-12s %-25s %-40s %s\n", "PROJECT_ID", "PROJECT_NAME", "PATH", "STATUS")
-	fmt.Printf("%s\n", strings.Repeat("-", 90))
-
-	for _, o := range orphans {
-		st := "dir missing"
-		if o.DirExists {
-			st = fmt.Sprintf("dir exists (%s)", util.FormatBytes(int64(o.DirSize)))
+	// Moving data and dropping quotas is hard to undo, so ask first
+	if !opts.Yes {
+		if opts.Output == "json" {
+			return fmt.Errorf("refusing to clean up without --yes")
 		}
-
-		name := o.ProjectName
-		if len(name) > 25 {
-			name = name[:22] + "..."
-		}
-
-		path := o.Path
-		if len(path) > 40 {
-			path = "..." + path[len(path)-37:]
+		if err := r.print("table"); err != nil {
+			return err
 		}
-
-		fmt.Printf("%-12s %-25s %-40s %s\n", o.ProjectID, name, path, st)
-	}
-	fmt.Println()
-
-	if dryRun {
-		fmt.Println("Dry-run mode: No changes made.")
-		fmt.Println("Run with --force to remove orphaned quotas.")
-		return nil
-	}
-
-	if !force {
-		fmt.Print("Remove orphaned quotas? [y/N]: ")
+		fmt.Printf("\nApply %d cleanup actions? [y/N]: ", actionable)
 		reader := bufio.NewReader(os.Stdin)
 		answer, _ := reader.ReadString('\n')
 		answer = strings.TrimSpace(strings.ToLower(answer))
@@ -181,39 +173,146 @@ func RunCleanup(basePath, kubeconfig string, dryRun, force bool) error {
 			fmt.Println("Cleanup cancelled.")
 			return nil
 		}
+		fmt.Println()
 	}
 
-	fmt.Println("\nCleaning up orphaned quotas...")
-
-	fsType, err := quota.DetectFSType(basePath)
-	if err != nil {
-		return fmt.Errorf("failed to detect filesystem: %w", err)
+	if logger := audit.Open(opts.AuditLogPath); logger != nil {
+		defer logger.Close()
+		ag.SetAuditLogger(logger)
 	}
 
-	cleaned := 0
-	for _, o := range orphans {
-		projectID := o.ProjectID
-
-		if err := quota.RemoveQuotaByID(basePath, fsType, projectID); err != nil {
-			fmt.Printf("  [ERROR] Failed to remove quota for %s: %v\n", projectID, err)
+	for i := range r.Findings {
+		f := &r.Findings[i]
+		if f.Action != ActionTrash && f.Action != ActionRemoveQuota {
 			continue
 		}
+		if err := ag.ResolveOrphan(f.OrphanFinding); err != nil {
+			f.Result = err.Error()
+			r.Failed++
+			if opts.Output != "json" {
+				fmt.Fprintf(os.Stderr, "  [ERROR] %s %s: %v\n", f.Action, f.Path, err)
+			}
+			continue
+		}
+		f.Result = "done"
+		r.Resolved++
+		if opts.Output != "json" {
+			fmt.Printf("  [OK] %s %s\n", f.Action, f.Path)
+		}
+	}
 
-		if err := quota.RemoveLineFromFile(projectsFile, projectID+":"); err != nil {
-			fmt.Printf("  [WARN] Failed to update projects file: %v\n", err)
+	if opts.Output == "json" {
+		if err := r.print("json"); err != nil {
+			return err
 		}
+	} else {
+		fmt.Printf("\nCleanup complete: %d/%d actions applied\n", r.Resolved, actionable)
+	}
+	if r.Failed > 0 {
+		return fmt.Errorf("failed to clean up %d of %d orphans", r.Failed, actionable)
+	}
+	return nil
+}
 
-		if err := quota.RemoveLineFromFile(projidFile, o.ProjectName+":"); err != nil {
-			fmt.Printf("  [WARN] Failed to update projid file: %v\n", err)
+// action returns what cleanup does with a finding, given the directories
+// without PV selected by the safety rails
+func action(f agent.OrphanFinding, sel agent.OrphanSelection, quotaOnly bool) string {
+	switch {
+	case f.ProtectedBy != "":
+		return ActionKeep
+	case f.Kind == agent.OrphanDirWithoutPV:
+		switch {
+		case quotaOnly || sel.Aborted != "":
+			return ActionNone
+		case sel.Selected[f.Path]:
+			return ActionTrash
+		case sel.Deferred[f.Path]:
+			return ActionDefer
+		default:
+			return ActionWait
 		}
+	case f.Kind == agent.OrphanQuotaWithoutDir && sel.Aborted != "":
+		return ActionNone
+	case f.Kind == agent.OrphanQuotaWithoutDir:
+		return ActionRemoveQuota
+	default:
+		return ActionNone
+	}
+}
 
-		fmt.Printf("  [OK] Removed quota for project %s (%s)\n", projectID, o.ProjectName)
-		cleaned++
+// print writes the findings as a table or JSON
+func (r *Report) print(format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
 	}
 
-	result.CleanedCount = cleaned
+	if len(r.Findings) == 0 {
+		fmt.Println("No orphans found.")
+		return nil
+	}
 
-	fmt.Printf("\nCleanup complete: %d/%d orphaned quotas removed\n", cleaned, len(orphans))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tPATH\tPV\tPROJECT\tSIZE\tACTION\tDETAIL")
+	for _, f := range r.Findings {
+		pv, project, size := "-", "-", "-"
+		if f.PVName != "" {
+			pv = f.PVName
+		}
+		if f.ProjectID != 0 {
+			project = fmt.Sprintf("%d (%s)", f.ProjectID, f.ProjectName)
+		}
+		if f.Size > 0 {
+			size = util.FormatBytes(int64(f.Size))
+		}
+		detail := f.Detail
+		if f.ProtectedBy != "" {
+			detail = "protected by " + f.ProtectedBy
+		} else if detail == "" && f.Owner != nil {
+			detail = ownerDetail(f.Owner)
+		}
+		if f.Action == ActionWait && f.FirstSeen != nil {
+			detail = strings.TrimSuffix("orphaned since "+f.FirstSeen.Local().Format("2006-01-02 15:04")+", "+detail, ", ")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", f.Kind, f.Path, pv, project, size, f.Action, detail)
+	}
+	_ = w.Flush()
 
+	fmt.Printf("\nFound %d directories without PV, %d quotas without directory, %d PVs without quota\n",
+		r.Summary[agent.OrphanDirWithoutPV], r.Summary[agent.OrphanQuotaWithoutDir], r.Summary[agent.OrphanPVWithoutQuota])
+	if r.Aborted != "" {
+		fmt.Printf("Cleanup aborted: %s\n", r.Aborted)
+	}
+	if r.QuotaOnly {
+		fmt.Println("Quota-only mode: directories without PV are reported only.")
+	}
+	if r.DryRun {
+		fmt.Println("Dry-run mode: no changes made. Run with --dry-run=false to clean up.")
+	}
 	return nil
 }
+
+// ownerDetail describes the last known owner of a directory
+func ownerDetail(o *ui.OrphanOwner) string {
+	var who string
+	switch {
+	case o.PVCName != "":
+		who = fmt.Sprintf("%s/%s (%s)", o.Namespace, o.PVCName, o.PVName)
+	case o.PVName != "":
+		who = o.PVName
+	}
+
+	var parts []string
+	if who != "" {
+		parts = append(parts, "last used by "+who)
+	}
+	if o.DeletedAt != nil {
+		parts = append(parts, "PV deleted "+o.DeletedAt.Local().Format("2006-01-02 15:04"))
+	}
+	if o.LastRecorded != nil {
+		parts = append(parts, fmt.Sprintf("last recorded %s on %s",
+			util.FormatBytes(int64(o.LastUsed)), o.LastRecorded.Local().Format("2006-01-02")))
+	}
+	return strings.Join(parts, ", ")
+}
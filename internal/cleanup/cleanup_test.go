@@ -0,0 +1,172 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cleanup
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/dasomel/nfs-quota-agent/internal/agent"
+)
+
+func TestAction(t *testing.T) {
+	sel := agent.OrphanSelection{
+		Selected: map[string]bool{"/export/old": true},
+		Deferred: map[string]bool{"/export/over": true},
+	}
+	aborted := agent.OrphanSelection{Aborted: "6 of 6 directories look orphaned"}
+	dir := func(path, protectedBy string) agent.OrphanFinding {
+		return agent.OrphanFinding{Kind: agent.OrphanDirWithoutPV, Path: path, ProtectedBy: protectedBy}
+	}
+	stale := agent.OrphanFinding{Kind: agent.OrphanQuotaWithoutDir, Path: "/export/gone"}
+
+	tests := []struct {
+		name      string
+		finding   agent.OrphanFinding
+		sel       agent.OrphanSelection
+		quotaOnly bool
+		want      string
+	}{
+		{"past grace period", dir("/export/old", ""), sel, false, ActionTrash},
+		{"over budget", dir("/export/over", ""), sel, false, ActionDefer},
+		{"in grace period", dir("/export/new", ""), sel, false, ActionWait},
+		{"protected", dir("/export/old", "marker:old/.nfs-quota-keep"), sel, false, ActionKeep},
+		{"quota only", dir("/export/old", ""), sel, true, ActionNone},
+		{"aborted", dir("/export/old", ""), aborted, false, ActionNone},
+		{"stale quota", stale, sel, false, ActionRemoveQuota},
+		{"stale quota, quota only", stale, agent.OrphanSelection{}, true, ActionRemoveQuota},
+		{"stale quota, aborted", stale, aborted, false, ActionNone},
+		{"pv without quota", agent.OrphanFinding{Kind: agent.OrphanPVWithoutQuota}, sel, false, ActionNone},
+	}
+	for _, tt := range tests {
+		if got := action(tt.finding, tt.sel, tt.quotaOnly); got != tt.want {
+			t.Errorf("%s: action() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRunSafetyRails(t *testing.T) {
+	// Four directories with a PV, and orphans seen 48h ago (old-*) or
+	// never before (new)
+	setup := func(t *testing.T, orphans ...string) Options {
+		base := t.TempDir()
+		var pvs []*v1.PersistentVolume
+		for _, name := range []string{"pv-a", "pv-b", "pv-c", "pv-d"} {
+			pvs = append(pvs, &v1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: name},
+				Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: v1.PersistentVolumeSource{
+					NFS: &v1.NFSVolumeSource{Server: "nfs", Path: "/data/" + name},
+				}},
+			})
+		}
+		state := map[string]time.Time{}
+		for _, name := range append([]string{"pv-a", "pv-b", "pv-c", "pv-d"}, orphans...) {
+			if err := os.Mkdir(filepath.Join(base, name), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if strings.HasPrefix(name, "old") {
+				state[name] = time.Now().Add(-48 * time.Hour)
+			}
+		}
+		stateFile := filepath.Join(t.TempDir(), "orphans.json")
+		data, _ := json.Marshal(map[string]interface{}{"firstSeen": state})
+		if err := os.WriteFile(stateFile, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		client := fake.NewSimpleClientset()
+		for _, pv := range pvs {
+			if _, err := client.CoreV1().PersistentVolumes().Create(context.Background(), pv, metav1.CreateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		etc := t.TempDir()
+		return Options{
+			NfsBasePath:   base,
+			NfsServerPath: "/data",
+			ProjectsFile:  filepath.Join(etc, "projects"),
+			ProjidFile:    filepath.Join(etc, "projid"),
+			StateFile:     stateFile,
+			Output:        "json",
+			Yes:           true,
+			Client:        client,
+			GracePeriod:   24 * time.Hour,
+			AbortPercent:  agent.DefaultAbortPercent,
+		}
+	}
+	exists := func(opts Options, name string) bool {
+		_, err := os.Stat(filepath.Join(opts.NfsBasePath, name))
+		return err == nil
+	}
+
+	t.Run("grace period", func(t *testing.T) {
+		opts := setup(t, "old", "new")
+		if err := Run(context.Background(), opts); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if exists(opts, "old") || !exists(opts, "new") {
+			t.Errorf("Expected only the orphan past its grace period in the trash")
+		}
+		// The new orphan's grace period now runs across cleanup runs
+		data, err := os.ReadFile(opts.StateFile)
+		if err != nil || !strings.Contains(string(data), `"new"`) || strings.Contains(string(data), `"old"`) {
+			t.Errorf("Expected the tracker to hold only the new orphan, got %s (%v)", data, err)
+		}
+	})
+
+	t.Run("budget", func(t *testing.T) {
+		opts := setup(t, "old-1", "old-2")
+		opts.MaxCount = 1
+		if err := Run(context.Background(), opts); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if exists(opts, "old-1") == exists(opts, "old-2") {
+			t.Errorf("Expected exactly one orphan moved with a budget of one")
+		}
+	})
+
+	t.Run("ratio abort", func(t *testing.T) {
+		opts := setup(t, "old-1", "old-2", "old-3", "old-4", "old-5")
+		err := Run(context.Background(), opts)
+		if err == nil || !strings.Contains(err.Error(), "aborted") {
+			t.Fatalf("Expected the run to abort, got %v", err)
+		}
+		if !exists(opts, "old-1") {
+			t.Errorf("Expected nothing moved by an aborted run")
+		}
+	})
+
+	t.Run("quota only", func(t *testing.T) {
+		opts := setup(t, "old")
+		opts.QuotaOnly = true
+		if err := Run(context.Background(), opts); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if !exists(opts, "old") {
+			t.Errorf("Expected directories left alone with --quota-only")
+		}
+	})
+}
@@ -31,25 +31,38 @@ _nfs_quota_agent_completions() {
     prev="${COMP_WORDS[COMP_CWORD-1]}"
 
     # Main commands
-    commands="run status top report cleanup ui audit version help"
+    commands="run status top report cleanup ui audit quota doctor verify projects plan trash forecast history chargeback version help"
 
     # Global options
     global_opts="--help -h"
 
     # Command-specific options
-    run_opts="--kubeconfig --nfs-base-path --nfs-server-path --provisioner-name --process-all-nfs --sync-interval --metrics-addr --audit-log --help"
+    run_opts="--kubeconfig --nfs-base-path --nfs-server-path --provisioner-name --process-all-nfs --sync-interval --metrics-addr --audit-log --rebuild-projects --adopt-existing --dry-run --freeze-configmap --orphan-exclude --cleanup-max-count --cleanup-max-bytes --cleanup-abort-percent --cleanup-schedule --cleanup-window --orphan-state-file --trash-dir --trash-retention --archive-dir --archive-format --purge-files-per-sec --purge-bytes-per-sec --purge-idle-io --help"
     status_opts="--path --all --help"
     top_opts="--path -n --watch --help"
     report_opts="--path --format --output --help"
-    cleanup_opts="--path --kubeconfig --dry-run --force --help"
+    cleanup_opts="--kubeconfig --nfs-base-path --nfs-server-path --provisioner-name --process-all-nfs --projects-file --projid-file --trash-dir --audit-log --orphan-exclude --quota-only --orphan-grace-period --orphan-state-file --cleanup-max-count --cleanup-max-bytes --cleanup-abort-percent --output --dry-run --yes --help"
     ui_opts="--path --addr --help"
     audit_opts="--file --action --pv --namespace --start --end --fails-only --format --help"
+    quota_cmds="set get remove list"
+    quota_opts="--path --projects-file --projid-file --audit-log --output --inodes --soft --name --help"
+    doctor_opts="--kubeconfig --nfs-base-path --nfs-server-path --provisioner-name --process-all-nfs --projects-file --projid-file --output --help"
+    verify_opts="--kubeconfig --nfs-base-path --nfs-server-path --provisioner-name --process-all-nfs --adopt-existing --projects-file --projid-file --output --help"
+    projects_cmds="rebuild adopt"
+    plan_opts="--kubeconfig --nfs-base-path --nfs-server-path --provisioner-name --process-all-nfs --projects-file --projid-file --adopt-existing --output --help"
+    projects_opts="--kubeconfig --nfs-base-path --nfs-server-path --provisioner-name --process-all-nfs --projects-file --projid-file --dry-run --yes --output --help"
+    trash_cmds="list restore purge"
+    trash_opts="--nfs-base-path --trash-dir --retention --audit-log --output --to --all --yes --archive-dir --archive-format --files-per-sec --bytes-per-sec --idle-io --help"
+    forecast_opts="--history-path --path --dir --window --method --output --help"
+    history_cmds="export import summary"
+    history_opts="--history-path --format --out --path --namespace --pvc --since --until --by --step --output --history-interval --history-retention --history-raw-retention --history-hourly-retention --help"
+    chargeback_opts="--history-path --kubeconfig --month --since --until --prices --currency --label --format --out --help"
 
     # Determine which command is being used
     local cmd=""
     for ((i=1; i < COMP_CWORD; i++)); do
         case "${COMP_WORDS[i]}" in
-            run|status|top|report|cleanup|ui|audit|version|help)
+            run|status|top|report|cleanup|ui|audit|quota|doctor|verify|projects|plan|trash|forecast|history|chargeback|version|help)
                 cmd="${COMP_WORDS[i]}"
                 break
                 ;;
@@ -73,7 +86,7 @@ _nfs_quota_agent_completions() {
                 COMPREPLY=( $(compgen -W "$run_opts" -- "$cur") )
             fi
             case "$prev" in
-                --kubeconfig)
+                --kubeconfig|--orphan-state-file)
                     COMPREPLY=( $(compgen -f -- "$cur") )
                     ;;
                 --nfs-base-path|--nfs-server-path)
@@ -134,11 +147,17 @@ _nfs_quota_agent_completions() {
                 COMPREPLY=( $(compgen -W "$cleanup_opts" -- "$cur") )
             fi
             case "$prev" in
-                --path)
+                --kubeconfig|--projects-file|--projid-file|--audit-log)
+                    COMPREPLY=( $(compgen -f -- "$cur") )
+                    ;;
+                --nfs-base-path|--nfs-server-path|--trash-dir)
                     COMPREPLY=( $(compgen -d -- "$cur") )
                     ;;
-                --kubeconfig)
-                    COMPREPLY=( $(compgen -f -- "$cur") )
+                --provisioner-name)
+                    COMPREPLY=( $(compgen -W "nfs.csi.k8s.io cluster.local/nfs-subdir-external-provisioner" -- "$cur") )
+                    ;;
+                --output)
+                    COMPREPLY=( $(compgen -W "table json" -- "$cur") )
                     ;;
             esac
             ;;
@@ -164,13 +183,198 @@ _nfs_quota_agent_completions() {
                     COMPREPLY=( $(compgen -f -- "$cur") )
                     ;;
                 --action)
-                    COMPREPLY=( $(compgen -W "CREATE UPDATE DELETE CLEANUP" -- "$cur") )
+                    COMPREPLY=( $(compgen -W "CREATE UPDATE DELETE CLEANUP QUARANTINE RESTORE PURGE ARCHIVE ANOMALY" -- "$cur") )
                     ;;
                 --format)
                     COMPREPLY=( $(compgen -W "table json text" -- "$cur") )
                     ;;
             esac
             ;;
+        quota)
+            if [[ "$cur" == -* ]]; then
+                COMPREPLY=( $(compgen -W "$quota_opts" -- "$cur") )
+            elif [[ "$prev" == "quota" ]]; then
+                COMPREPLY=( $(compgen -W "$quota_cmds" -- "$cur") )
+            fi
+            case "$prev" in
+                --path|set|get|remove)
+                    COMPREPLY=( $(compgen -d -- "$cur") )
+                    ;;
+                --projects-file|--projid-file|--audit-log)
+                    COMPREPLY=( $(compgen -f -- "$cur") )
+                    ;;
+                --output)
+                    COMPREPLY=( $(compgen -W "table json" -- "$cur") )
+                    ;;
+            esac
+            ;;
+        doctor)
+            if [[ "$cur" == -* ]]; then
+                COMPREPLY=( $(compgen -W "$doctor_opts" -- "$cur") )
+            fi
+            case "$prev" in
+                --kubeconfig|--projects-file|--projid-file)
+                    COMPREPLY=( $(compgen -f -- "$cur") )
+                    ;;
+                --nfs-base-path|--nfs-server-path)
+                    COMPREPLY=( $(compgen -d -- "$cur") )
+                    ;;
+                --provisioner-name)
+                    COMPREPLY=( $(compgen -W "nfs.csi.k8s.io cluster.local/nfs-subdir-external-provisioner" -- "$cur") )
+                    ;;
+                --output)
+                    COMPREPLY=( $(compgen -W "table json" -- "$cur") )
+                    ;;
+            esac
+            ;;
+        verify)
+            if [[ "$cur" == -* ]]; then
+                COMPREPLY=( $(compgen -W "$verify_opts" -- "$cur") )
+            fi
+            case "$prev" in
+                --kubeconfig)
+                    COMPREPLY=( $(compgen -f -- "$cur") )
+                    ;;
+                --nfs-base-path|--nfs-server-path)
+                    COMPREPLY=( $(compgen -d -- "$cur") )
+                    ;;
+                --provisioner-name)
+                    COMPREPLY=( $(compgen -W "nfs.csi.k8s.io cluster.local/nfs-subdir-external-provisioner" -- "$cur") )
+                    ;;
+                --output)
+                    COMPREPLY=( $(compgen -W "table json" -- "$cur") )
+                    ;;
+            esac
+            ;;
+        plan)
+            if [[ "$cur" == -* ]]; then
+                COMPREPLY=( $(compgen -W "$plan_opts" -- "$cur") )
+            fi
+            case "$prev" in
+                --kubeconfig|--projects-file|--projid-file)
+                    COMPREPLY=( $(compgen -f -- "$cur") )
+                    ;;
+                --nfs-base-path|--nfs-server-path)
+                    COMPREPLY=( $(compgen -d -- "$cur") )
+                    ;;
+                --provisioner-name)
+                    COMPREPLY=( $(compgen -W "nfs.csi.k8s.io cluster.local/nfs-subdir-external-provisioner" -- "$cur") )
+                    ;;
+                --output)
+                    COMPREPLY=( $(compgen -W "table json" -- "$cur") )
+                    ;;
+            esac
+            ;;
+        projects)
+            if [[ "$cur" == -* ]]; then
+                COMPREPLY=( $(compgen -W "$projects_opts" -- "$cur") )
+            elif [[ "$prev" == "projects" ]]; then
+                COMPREPLY=( $(compgen -W "$projects_cmds" -- "$cur") )
+            fi
+            case "$prev" in
+                --kubeconfig|--projects-file|--projid-file)
+                    COMPREPLY=( $(compgen -f -- "$cur") )
+                    ;;
+                --nfs-base-path|--nfs-server-path)
+                    COMPREPLY=( $(compgen -d -- "$cur") )
+                    ;;
+                --provisioner-name)
+                    COMPREPLY=( $(compgen -W "nfs.csi.k8s.io cluster.local/nfs-subdir-external-provisioner" -- "$cur") )
+                    ;;
+                --output)
+                    COMPREPLY=( $(compgen -W "table json" -- "$cur") )
+                    ;;
+            esac
+            ;;
+        trash)
+            if [[ "$cur" == -* ]]; then
+                COMPREPLY=( $(compgen -W "$trash_opts" -- "$cur") )
+            elif [[ "$prev" == "trash" ]]; then
+                COMPREPLY=( $(compgen -W "$trash_cmds" -- "$cur") )
+            fi
+            case "$prev" in
+                --audit-log)
+                    COMPREPLY=( $(compgen -f -- "$cur") )
+                    ;;
+                --nfs-base-path|--trash-dir|--to|--archive-dir)
+                    COMPREPLY=( $(compgen -d -- "$cur") )
+                    ;;
+                --archive-format)
+                    COMPREPLY=( $(compgen -W "tar.gz tar.zst" -- "$cur") )
+                    ;;
+                --retention)
+                    COMPREPLY=( $(compgen -W "24h 72h 168h 720h" -- "$cur") )
+                    ;;
+                --output)
+                    COMPREPLY=( $(compgen -W "table json" -- "$cur") )
+                    ;;
+            esac
+            ;;
+        forecast)
+            if [[ "$cur" == -* ]]; then
+                COMPREPLY=( $(compgen -W "$forecast_opts" -- "$cur") )
+            fi
+            case "$prev" in
+                --history-path|--path)
+                    COMPREPLY=( $(compgen -d -- "$cur") )
+                    ;;
+                --window)
+                    COMPREPLY=( $(compgen -W "24h 72h 168h 720h" -- "$cur") )
+                    ;;
+                --method)
+                    COMPREPLY=( $(compgen -W "linear robust" -- "$cur") )
+                    ;;
+                --output)
+                    COMPREPLY=( $(compgen -W "table json" -- "$cur") )
+                    ;;
+            esac
+            ;;
+        history)
+            if [[ "$cur" == -* ]]; then
+                COMPREPLY=( $(compgen -W "$history_opts" -- "$cur") )
+            elif [[ "$prev" == "history" ]]; then
+                COMPREPLY=( $(compgen -W "$history_cmds" -- "$cur") )
+            else
+                COMPREPLY=( $(compgen -f -- "$cur") )
+            fi
+            case "$prev" in
+                --history-path)
+                    COMPREPLY=( $(compgen -d -- "$cur") )
+                    ;;
+                --out)
+                    COMPREPLY=( $(compgen -f -- "$cur") )
+                    ;;
+                --format)
+                    COMPREPLY=( $(compgen -W "csv json parquet" -- "$cur") )
+                    ;;
+                --since|--until)
+                    COMPREPLY=( $(compgen -W "24h 7d 30d" -- "$cur") )
+                    ;;
+                --by)
+                    COMPREPLY=( $(compgen -W "namespace storageclass export" -- "$cur") )
+                    ;;
+                --output)
+                    COMPREPLY=( $(compgen -W "table json" -- "$cur") )
+                    ;;
+            esac
+            ;;
+        chargeback)
+            COMPREPLY=( $(compgen -W "$chargeback_opts" -- "$cur") )
+            case "$prev" in
+                --history-path)
+                    COMPREPLY=( $(compgen -d -- "$cur") )
+                    ;;
+                --kubeconfig|--out)
+                    COMPREPLY=( $(compgen -f -- "$cur") )
+                    ;;
+                --format)
+                    COMPREPLY=( $(compgen -W "markdown csv json" -- "$cur") )
+                    ;;
+                --since|--until)
+                    COMPREPLY=( $(compgen -W "7d 30d" -- "$cur") )
+                    ;;
+            esac
+            ;;
     esac
 
     return 0
@@ -180,7 +384,7 @@ complete -F _nfs_quota_agent_completions nfs-quota-agent
 `
 
 // ZshCompletion contains the zsh completion script
-var ZshCompletion = "#compdef nfs-quota-agent\n\n_nfs_quota_agent() {\n    local -a commands\n    local -a global_opts\n\n    commands=(\n        'run:Run the quota enforcement agent'\n        'status:Show quota status and disk usage'\n        'top:Show top directories by usage'\n        'report:Generate quota report'\n        'cleanup:Remove orphaned quotas'\n        'ui:Start web UI dashboard'\n        'audit:Query audit logs'\n        'version:Print version information'\n        'help:Show help'\n    )\n\n    global_opts=(\n        '--help[Show help]'\n        '-h[Show help]'\n    )\n\n    _arguments -C \\\n        '1:command:->command' \\\n        '*::options:->options'\n\n    case $state in\n        command)\n            _describe -t commands 'nfs-quota-agent commands' commands\n            ;;\n        options)\n            case $words[1] in\n                run)\n                    _arguments \\\n                        '--kubeconfig[Path to kubeconfig file]:file:_files' \\\n                        '--nfs-base-path[Local path where NFS is mounted]:directory:_directories' \\\n                        '--nfs-server-path[NFS server'\\''s export path]:directory:_directories' \\\n                        '--provisioner-name[Provisioner name to filter PVs]:provisioner:(nfs.csi.k8s.io cluster.local/nfs-subdir-external-provisioner)' \\\n                        '--process-all-nfs[Process all NFS PVs regardless of provisioner]' \\\n                        '--sync-interval[Interval between quota syncs]:interval:(10s 30s 1m 5m)' \\\n                        '--metrics-addr[Address for Prometheus metrics endpoint]:address:(:9090 :8080 :9100)' \\\n                        '--audit-log[Path to audit log file]:file:_files' \\\n                        '--help[Show help]'\n                    ;;\n                status)\n                    _arguments \\\n                        '--path[NFS export path to check]:directory:_directories' \\\n                        '--all[Show all directories]' \\\n                        '--help[Show help]'\n                    ;;\n                top)\n                    _arguments \\\n                        '--path[NFS export path to check]:directory:_directories' \\\n                        '-n[Number of top directories to show]:count:(5 10 20 50 100)' \\\n                        '--watch[Watch mode (refresh every 5s)]' \\\n                        '--help[Show help]'\n                    ;;\n                report)\n                    _arguments \\\n                        '--path[NFS export path to check]:directory:_directories' \\\n                        '--format[Output format]:format:(table json yaml csv)' \\\n                        '--output[Output file]:file:_files' \\\n                        '--help[Show help]'\n                    ;;\n                cleanup)\n                    _arguments \\\n                        '--path[NFS export path]:directory:_directories' \\\n                        '--kubeconfig[Path to kubeconfig file]:file:_files' \\\n                        '--dry-run[Dry-run mode (no changes)]' \\\n                        '--force[Force cleanup without confirmation]' \\\n                        '--help[Show help]'\n                    ;;\n                ui)\n                    _arguments \\\n                        '--path[NFS export path]:directory:_directories' \\\n                        '--addr[Web UI listen address]:address:(:8080 :3000 :9000)' \\\n                        '--help[Show help]'\n                    ;;\n                audit)\n                    _arguments \\\n                        '--file[Audit log file path]:file:_files' \\\n                        '--action[Filter by action]:action:(CREATE UPDATE DELETE CLEANUP)' \\\n                        '--pv[Filter by PV name]:pv:' \\\n                        '--namespace[Filter by namespace]:namespace:' \\\n                        '--start[Start time (RFC3339)]:start:' \\\n                        '--end[End time (RFC3339)]:end:' \\\n                        '--fails-only[Show only failed operations]' \\\n                        '--format[Output format]:format:(table json text)' \\\n                        '--help[Show help]'\n                    ;;\n            esac\n            ;;\n    esac\n}\n\n_nfs_quota_agent \"$@\"\n"
+var ZshCompletion = "#compdef nfs-quota-agent\n\n_nfs_quota_agent() {\n    local -a commands\n    local -a global_opts\n\n    commands=(\n        'run:Run the quota enforcement agent'\n        'status:Show quota status and disk usage'\n        'top:Show top directories by usage'\n        'report:Generate quota report'\n        'cleanup:Find and clean up orphans'\n        'ui:Start web UI dashboard'\n        'audit:Query audit logs'\n        'quota:Manually manage project quotas'\n        'doctor:Diagnose quota setup'\n        'verify:Verify PV quota enforcement'\n        'projects:Maintain projects files'\n        'plan:Show planned quota changes'\n        'trash:Manage quarantined orphan directories'\n        'forecast:Predict when directories and the export disk fill up'\n        'history:Export, import or summarize usage history'\n        'chargeback:Storage cost per namespace or team'\n        'version:Print version information'\n        'help:Show help'\n    )\n\n    global_opts=(\n        '--help[Show help]'\n        '-h[Show help]'\n    )\n\n    _arguments -C \\\n        '1:command:->command' \\\n        '*::options:->options'\n\n    case $state in\n        command)\n            _describe -t commands 'nfs-quota-agent commands' commands\n            ;;\n        options)\n            case $words[1] in\n                run)\n                    _arguments \\\n                        '--kubeconfig[Path to kubeconfig file]:file:_files' \\\n                        '--nfs-base-path[Local path where NFS is mounted]:directory:_directories' \\\n                        '--nfs-server-path[NFS server'\\''s export path]:directory:_directories' \\\n                        '--provisioner-name[Provisioner name to filter PVs]:provisioner:(nfs.csi.k8s.io cluster.local/nfs-subdir-external-provisioner)' \\\n                        '--process-all-nfs[Process all NFS PVs regardless of provisioner]' \\\n                        '--sync-interval[Interval between quota syncs]:interval:(10s 30s 1m 5m)' \\\n                        '--metrics-addr[Address for Prometheus metrics endpoint]:address:(:9090 :8080 :9100)' \\\n                        '--audit-log[Path to audit log file]:file:_files' \\\n                        '--rebuild-projects[Regenerate projects files on startup]' \\\n                        '--adopt-existing[Keep existing project IDs and reconcile only limits]' \\\n                        '--dry-run[Plan quota changes without applying them]' \\\n                        '--freeze-configmap[ConfigMap (namespace/name) that freezes the agent]:configmap:' \\\n                        '*--orphan-exclude[Protect directories from cleanup (glob or regex)]:pattern:' \\\n                        '--cleanup-max-count[Maximum orphans removed per cleanup run]:count:(0 10 50 100)' \\\n                        '--cleanup-max-bytes[Maximum orphan data removed per cleanup run]:size:(10Gi 100Gi 1Ti)' \\\n                        '--cleanup-abort-percent[Abort cleanup above this orphan percentage]:percent:(0 25 50 75)' \\\n                        '--cleanup-schedule[Cron schedule for cleanup runs]:schedule:' \\\n                        '--cleanup-window[How long each scheduled cleanup may run]:duration:(1h 2h 4h 8h)' \\\n                        '--orphan-state-file[File keeping orphan first-seen times]:file:_files' \\\n                        '--trash-dir[Trash directory for removed orphans]:directory:_directories' \\\n                        '--trash-retention[How long trashed orphans are kept]:duration:(24h 72h 168h 720h)' \\\n                        '--archive-dir[Archive trashed orphans here before purging]:directory:_directories' \\\n                        '--archive-format[Archive format]:format:(tar.gz tar.zst)' \\\n                        '--purge-files-per-sec[Maximum files deleted per second when purging]:count:(100 500 1000)' \\\n                        '--purge-bytes-per-sec[Maximum data deleted per second when purging]:size:(50Mi 200Mi 1Gi)' \\\n                        '--purge-idle-io[Purge at idle I/O priority]:bool:(true false)' \\\n                        '--help[Show help]'\n                    ;;\n                status)\n                    _arguments \\\n                        '--path[NFS export path to check]:directory:_directories' \\\n                        '--all[Show all directories]' \\\n                        '--help[Show help]'\n                    ;;\n                top)\n                    _arguments \\\n                        '--path[NFS export path to check]:directory:_directories' \\\n                        '-n[Number of top directories to show]:count:(5 10 20 50 100)' \\\n                        '--watch[Watch mode (refresh every 5s)]' \\\n                        '--help[Show help]'\n                    ;;\n                report)\n                    _arguments \\\n                        '--path[NFS export path to check]:directory:_directories' \\\n                        '--format[Output format]:format:(table json yaml csv)' \\\n                        '--output[Output file]:file:_files' \\\n                        '--help[Show help]'\n                    ;;\n                cleanup)\n                    _arguments \\\n                        '--kubeconfig[Path to kubeconfig file]:file:_files' \\\n                        '--nfs-base-path[Local path where NFS is mounted]:directory:_directories' \\\n                        '--nfs-server-path[NFS server'\\''s export path]:directory:_directories' \\\n                        '--provisioner-name[Provisioner name to filter PVs]:provisioner:(nfs.csi.k8s.io cluster.local/nfs-subdir-external-provisioner)' \\\n                        '--process-all-nfs[Process all NFS PVs regardless of provisioner]' \\\n                        '--projects-file[Path to the projects file]:file:_files' \\\n                        '--projid-file[Path to the projid file]:file:_files' \\\n                        '--trash-dir[Trash directory for removed orphans]:directory:_directories' \\\n                        '--audit-log[Audit log file path]:file:_files' \\\n                        '*--orphan-exclude[Protect directories from cleanup (glob or regex)]:pattern:' \\\n                        '--quota-only[Only remove quotas whose directory is gone]' \\\n                        '--orphan-grace-period[Grace period before moving orphans to the trash]:duration:(1h 24h 72h 168h)' \\\n                        '--orphan-state-file[File keeping orphan first-seen times]:file:_files' \\\n                        '--cleanup-max-count[Maximum orphans moved per run]:count:(0 10 50 100)' \\\n                        '--cleanup-max-bytes[Maximum orphan data moved per run]:size:(10Gi 100Gi 1Ti)' \\\n                        '--cleanup-abort-percent[Abort above this orphan percentage]:percent:(0 25 50 75)' \\\n                        '--output[Output format]:format:(table json)' \\\n                        '--dry-run[Only report orphans (no changes)]' \\\n                        '--yes[Clean up without confirmation]' \\\n                        '--help[Show help]'\n                    ;;\n                ui)\n                    _arguments \\\n                        '--path[NFS export path]:directory:_directories' \\\n                        '--addr[Web UI listen address]:address:(:8080 :3000 :9000)' \\\n                        '--help[Show help]'\n                    ;;\n                audit)\n                    _arguments \\\n                        '--file[Audit log file path]:file:_files' \\\n                        '--action[Filter by action]:action:(CREATE UPDATE DELETE CLEANUP QUARANTINE RESTORE PURGE ARCHIVE ANOMALY)' \\\n                        '--pv[Filter by PV name]:pv:' \\\n                        '--namespace[Filter by namespace]:namespace:' \\\n                        '--start[Start time (RFC3339)]:start:' \\\n                        '--end[End time (RFC3339)]:end:' \\\n                        '--fails-only[Show only failed operations]' \\\n                        '--format[Output format]:format:(table json text)' \\\n                        '--help[Show help]'\n                    ;;\n                quota)\n                    _arguments \\\n                        '1:subcommand:(set get remove list)' \\\n                        '--path[Mount point of the quota filesystem]:directory:_directories' \\\n                        '--projects-file[Path to the projects file]:file:_files' \\\n                        '--projid-file[Path to the projid file]:file:_files' \\\n                        '--audit-log[Audit log file path]:file:_files' \\\n                        '--output[Output format]:format:(table json)' \\\n                        '--inodes[Inode limit]:inodes:' \\\n                        '--soft[Set soft limits]' \\\n                        '--name[Project name]:name:' \\\n                        '--help[Show help]'\n                    ;;\n                doctor)\n                    _arguments \\\n                        '--kubeconfig[Path to kubeconfig file]:file:_files' \\\n                        '--nfs-base-path[Local path where NFS is mounted]:directory:_directories' \\\n                        '--nfs-server-path[NFS server'\\''s export path]:directory:_directories' \\\n                        '--provisioner-name[Provisioner name to filter PVs]:provisioner:(nfs.csi.k8s.io cluster.local/nfs-subdir-external-provisioner)' \\\n                        '--process-all-nfs[Process all NFS PVs regardless of provisioner]' \\\n                        '--projects-file[Path to the projects file]:file:_files' \\\n                        '--projid-file[Path to the projid file]:file:_files' \\\n                        '--output[Output format]:format:(table json)' \\\n                        '--help[Show help]'\n                    ;;\n                verify)\n                    _arguments \\\n                        '--kubeconfig[Path to kubeconfig file]:file:_files' \\\n                        '--nfs-base-path[Local path where NFS is mounted]:directory:_directories' \\\n                        '--nfs-server-path[NFS server'\\''s export path]:directory:_directories' \\\n                        '--provisioner-name[Provisioner name to filter PVs]:provisioner:(nfs.csi.k8s.io cluster.local/nfs-subdir-external-provisioner)' \\\n                        '--process-all-nfs[Process all NFS PVs regardless of provisioner]' \\\n                        '--adopt-existing[Expect adopted project IDs]' \\\n                        '--projects-file[Path to the projects file]:file:_files' \\\n                        '--projid-file[Path to the projid file]:file:_files' \\\n                        '--output[Output format]:format:(table json)' \\\n                        '--help[Show help]'\n                    ;;\n                projects)\n                    _arguments \\\n                        '1:subcommand:(rebuild adopt)' \\\n                        '--kubeconfig[Path to kubeconfig file]:file:_files' \\\n                        '--nfs-base-path[Local path where NFS is mounted]:directory:_directories' \\\n                        '--nfs-server-path[NFS server'\\''s export path]:directory:_directories' \\\n                        '--provisioner-name[Provisioner name to filter PVs]:provisioner:(nfs.csi.k8s.io cluster.local/nfs-subdir-external-provisioner)' \\\n                        '--process-all-nfs[Process all NFS PVs regardless of provisioner]' \\\n                        '--projects-file[Path to the projects file]:file:_files' \\\n                        '--projid-file[Path to the projid file]:file:_files' \\\n                        '--dry-run[Show the diff without writing]' \\\n                        '--yes[Write without confirmation]' \\\n                        '--output[Output format]:format:(table json)' \\\n                        '--help[Show help]'\n                    ;;\n                plan)\n                    _arguments \\\n                        '--kubeconfig[Path to kubeconfig file]:file:_files' \\\n                        '--nfs-base-path[Local path where NFS is mounted]:directory:_directories' \\\n                        '--nfs-server-path[NFS server'\\''s export path]:directory:_directories' \\\n                        '--provisioner-name[Provisioner name to filter PVs]:provisioner:(nfs.csi.k8s.io cluster.local/nfs-subdir-external-provisioner)' \\\n                        '--process-all-nfs[Process all NFS PVs regardless of provisioner]' \\\n                        '--projects-file[Path to the projects file]:file:_files' \\\n                        '--projid-file[Path to the projid file]:file:_files' \\\n                        '--adopt-existing[Plan with adoption of existing project IDs]' \\\n                        '--output[Output format]:format:(table json)' \\\n                        '--help[Show help]'\n                    ;;\n                trash)\n                    _arguments \\\n                        '1:subcommand:(list restore purge)' \\\n                        '--nfs-base-path[Local path where NFS is mounted]:directory:_directories' \\\n                        '--trash-dir[Trash directory]:directory:_directories' \\\n                        '--retention[How long trashed orphans are kept]:duration:(24h 72h 168h 720h)' \\\n                        '--audit-log[Audit log file path]:file:_files' \\\n                        '--output[Output format]:format:(table json)' \\\n                        '--to[Restore to this path instead]:directory:_directories' \\\n                        '--all[Purge all entries]' \\\n                        '--yes[Purge without confirmation]' \\\n                        '--archive-dir[Archive before purging]:directory:_directories' \\\n                        '--archive-format[Archive format]:format:(tar.gz tar.zst)' \\\n                        '--files-per-sec[Maximum files deleted per second]:count:(100 500 1000)' \\\n                        '--bytes-per-sec[Maximum data deleted per second]:size:(50Mi 200Mi 1Gi)' \\\n                        '--idle-io[Delete at idle I/O priority]:bool:(true false)' \\\n                        '--help[Show help]'\n                    ;;\n                forecast)\n                    _arguments \\\n                        '--history-path[Usage history directory]:directory:_directories' \\\n                        '--path[NFS export path for the disk forecast]:directory:_directories' \\\n                        '--dir[Only forecast this directory]:directory:' \\\n                        '--window[How much history to fit]:duration:(24h 72h 168h 720h)' \\\n                        '--method[Fit method]:method:(linear robust)' \\\n                        '--output[Output format]:format:(table json)' \\\n                        '--help[Show help]'\n                    ;;\n                history)\n                    _arguments \\\n                        '1:subcommand:(export import summary)' \\\n                        '--history-path[Usage history directory]:directory:_directories' \\\n                        '--format[File format]:format:(csv json parquet)' \\\n                        '--out[Output file]:file:_files' \\\n                        '--path[Only export this directory]:directory:' \\\n                        '--namespace[Only export this namespace]:namespace:' \\\n                        '--pvc[Only export this PVC]:pvc:' \\\n                        '--since[Start time or duration ago]:time:(24h 7d 30d)' \\\n                        '--until[End time or duration ago]:time:' \\\n                        '--by[Group the summary by]:group:(namespace storageclass export)' \\\n                        '--step[Resolution of summary points]:duration:(1h 24h)' \\\n                        '--output[Summary output format]:format:(table json)' \\\n                        '--history-interval[History collection interval]:interval:(1m 5m 15m)' \\\n                        '--history-retention[How long daily rollups are kept]:duration:(8760h)' \\\n                        '--history-raw-retention[How long raw snapshots are kept]:duration:(48h 168h)' \\\n                        '--history-hourly-retention[How long hourly rollups are kept]:duration:(720h)' \\\n                        '--help[Show help]'\n                    ;;\n                chargeback)\n                    _arguments \\\n                        '--history-path[Usage history directory]:directory:_directories' \\\n                        '--kubeconfig[Path to kubeconfig file]:file:_files' \\\n                        '--month[Billing month (YYYY-MM)]:month:' \\\n                        '--since[Start of the period instead of --month]:time:(7d 30d)' \\\n                        '--until[End of the period]:time:' \\\n                        '--prices[Price per GiB-month by StorageClass]:prices:' \\\n                        '--currency[Currency shown with costs]:currency:(USD EUR KRW)' \\\n                        '--label[Group namespaces by this label]:label:(team)' \\\n                        '--format[Output format]:format:(markdown csv json)' \\\n                        '--out[Output file]:file:_files' \\\n                        '--help[Show help]'\n                    ;;\n            esac\n            ;;\n    esac\n}\n\n_nfs_quota_agent \"$@\"\n"
 
 // FishCompletion contains the fish completion script
 const FishCompletion = `# fish completion for nfs-quota-agent
@@ -193,9 +397,18 @@ complete -c nfs-quota-agent -n '__fish_use_subcommand' -a run -d 'Run the quota
 complete -c nfs-quota-agent -n '__fish_use_subcommand' -a status -d 'Show quota status and disk usage'
 complete -c nfs-quota-agent -n '__fish_use_subcommand' -a top -d 'Show top directories by usage'
 complete -c nfs-quota-agent -n '__fish_use_subcommand' -a report -d 'Generate quota report'
-complete -c nfs-quota-agent -n '__fish_use_subcommand' -a cleanup -d 'Remove orphaned quotas'
+complete -c nfs-quota-agent -n '__fish_use_subcommand' -a cleanup -d 'Find and clean up orphans'
 complete -c nfs-quota-agent -n '__fish_use_subcommand' -a ui -d 'Start web UI dashboard'
 complete -c nfs-quota-agent -n '__fish_use_subcommand' -a audit -d 'Query audit logs'
+complete -c nfs-quota-agent -n '__fish_use_subcommand' -a quota -d 'Manually manage project quotas'
+complete -c nfs-quota-agent -n '__fish_use_subcommand' -a doctor -d 'Diagnose quota setup'
+complete -c nfs-quota-agent -n '__fish_use_subcommand' -a verify -d 'Verify PV quota enforcement'
+complete -c nfs-quota-agent -n '__fish_use_subcommand' -a projects -d 'Maintain projects files'
+complete -c nfs-quota-agent -n '__fish_use_subcommand' -a plan -d 'Show planned quota changes'
+complete -c nfs-quota-agent -n '__fish_use_subcommand' -a trash -d 'Manage quarantined orphan directories'
+complete -c nfs-quota-agent -n '__fish_use_subcommand' -a forecast -d 'Predict when directories and the export disk fill up'
+complete -c nfs-quota-agent -n '__fish_use_subcommand' -a history -d 'Export, import or summarize usage history'
+complete -c nfs-quota-agent -n '__fish_use_subcommand' -a chargeback -d 'Storage cost per namespace or team'
 complete -c nfs-quota-agent -n '__fish_use_subcommand' -a version -d 'Print version information'
 complete -c nfs-quota-agent -n '__fish_use_subcommand' -a help -d 'Show help'
 
@@ -208,6 +421,24 @@ complete -c nfs-quota-agent -n '__fish_seen_subcommand_from run' -l process-all-
 complete -c nfs-quota-agent -n '__fish_seen_subcommand_from run' -l sync-interval -d 'Sync interval' -r -a '10s 30s 1m 5m'
 complete -c nfs-quota-agent -n '__fish_seen_subcommand_from run' -l metrics-addr -d 'Metrics endpoint address' -r -a ':9090 :8080 :9100'
 complete -c nfs-quota-agent -n '__fish_seen_subcommand_from run' -l audit-log -d 'Audit log file path' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from run' -l rebuild-projects -d 'Regenerate projects files on startup'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from run' -l adopt-existing -d 'Keep existing project IDs'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from run' -l dry-run -d 'Plan quota changes without applying them'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from run' -l freeze-configmap -d 'ConfigMap (namespace/name) that freezes the agent' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from run' -l orphan-exclude -d 'Protect directories from cleanup (glob or re:regex)' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from run' -l cleanup-max-count -d 'Maximum orphans removed per cleanup run' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from run' -l cleanup-max-bytes -d 'Maximum orphan data removed per cleanup run' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from run' -l cleanup-abort-percent -d 'Abort cleanup above this orphan percentage' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from run' -l cleanup-schedule -d 'Cron schedule for cleanup runs' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from run' -l cleanup-window -d 'How long each scheduled cleanup may run' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from run' -l orphan-state-file -d 'File keeping orphan first-seen times' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from run' -l trash-dir -d 'Trash directory for removed orphans' -r -a '(__fish_complete_directories)'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from run' -l trash-retention -d 'How long trashed orphans are kept' -r -a '24h 72h 168h 720h'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from run' -l archive-dir -d 'Archive trashed orphans here before purging' -r -a '(__fish_complete_directories)'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from run' -l archive-format -d 'Archive format' -r -a 'tar.gz tar.zst'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from run' -l purge-files-per-sec -d 'Maximum files deleted per second when purging' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from run' -l purge-bytes-per-sec -d 'Maximum data deleted per second when purging' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from run' -l purge-idle-io -d 'Purge at idle I/O priority' -r -a 'true false'
 
 # status command options
 complete -c nfs-quota-agent -n '__fish_seen_subcommand_from status' -l path -d 'NFS export path' -r -a '(__fish_complete_directories)'
@@ -224,10 +455,25 @@ complete -c nfs-quota-agent -n '__fish_seen_subcommand_from report' -l format -d
 complete -c nfs-quota-agent -n '__fish_seen_subcommand_from report' -l output -d 'Output file' -r -F
 
 # cleanup command options
-complete -c nfs-quota-agent -n '__fish_seen_subcommand_from cleanup' -l path -d 'NFS export path' -r -a '(__fish_complete_directories)'
 complete -c nfs-quota-agent -n '__fish_seen_subcommand_from cleanup' -l kubeconfig -d 'Path to kubeconfig file' -r -F
-complete -c nfs-quota-agent -n '__fish_seen_subcommand_from cleanup' -l dry-run -d 'Dry-run mode'
-complete -c nfs-quota-agent -n '__fish_seen_subcommand_from cleanup' -l force -d 'Force cleanup'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from cleanup' -l nfs-base-path -d 'Local path where NFS is mounted' -r -a '(__fish_complete_directories)'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from cleanup' -l nfs-server-path -d 'NFS server export path' -r -a '(__fish_complete_directories)'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from cleanup' -l provisioner-name -d 'Provisioner name' -r -a 'nfs.csi.k8s.io cluster.local/nfs-subdir-external-provisioner'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from cleanup' -l process-all-nfs -d 'Process all NFS PVs'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from cleanup' -l projects-file -d 'Projects file' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from cleanup' -l projid-file -d 'Projid file' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from cleanup' -l trash-dir -d 'Trash directory' -r -a '(__fish_complete_directories)'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from cleanup' -l audit-log -d 'Audit log file path' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from cleanup' -l orphan-exclude -d 'Protect directories from cleanup (glob or re:regex)' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from cleanup' -l quota-only -d 'Only remove quotas whose directory is gone'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from cleanup' -l orphan-grace-period -d 'Grace period before moving orphans to the trash' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from cleanup' -l orphan-state-file -d 'File keeping orphan first-seen times' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from cleanup' -l cleanup-max-count -d 'Maximum orphans moved per run' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from cleanup' -l cleanup-max-bytes -d 'Maximum orphan data moved per run' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from cleanup' -l cleanup-abort-percent -d 'Abort above this orphan percentage' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from cleanup' -l output -d 'Output format' -r -a 'table json'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from cleanup' -l dry-run -d 'Only report orphans'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from cleanup' -l yes -d 'Clean up without confirmation'
 
 # ui command options
 complete -c nfs-quota-agent -n '__fish_seen_subcommand_from ui' -l path -d 'NFS export path' -r -a '(__fish_complete_directories)'
@@ -235,13 +481,127 @@ complete -c nfs-quota-agent -n '__fish_seen_subcommand_from ui' -l addr -d 'List
 
 # audit command options
 complete -c nfs-quota-agent -n '__fish_seen_subcommand_from audit' -l file -d 'Audit log file' -r -F
-complete -c nfs-quota-agent -n '__fish_seen_subcommand_from audit' -l action -d 'Filter by action' -r -a 'CREATE UPDATE DELETE CLEANUP'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from audit' -l action -d 'Filter by action' -r -a 'CREATE UPDATE DELETE CLEANUP QUARANTINE RESTORE PURGE ARCHIVE ANOMALY'
 complete -c nfs-quota-agent -n '__fish_seen_subcommand_from audit' -l pv -d 'Filter by PV name' -r
 complete -c nfs-quota-agent -n '__fish_seen_subcommand_from audit' -l namespace -d 'Filter by namespace' -r
 complete -c nfs-quota-agent -n '__fish_seen_subcommand_from audit' -l start -d 'Start time (RFC3339)' -r
 complete -c nfs-quota-agent -n '__fish_seen_subcommand_from audit' -l end -d 'End time (RFC3339)' -r
 complete -c nfs-quota-agent -n '__fish_seen_subcommand_from audit' -l fails-only -d 'Show only failures'
 complete -c nfs-quota-agent -n '__fish_seen_subcommand_from audit' -l format -d 'Output format' -r -a 'table json text'
+
+# quota command options
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from quota; and not __fish_seen_subcommand_from set get remove list' -a 'set get remove list'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from quota' -l path -d 'Quota filesystem mount point' -r -a '(__fish_complete_directories)'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from quota' -l projects-file -d 'Projects file' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from quota' -l projid-file -d 'Projid file' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from quota' -l audit-log -d 'Audit log file path' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from quota' -l output -d 'Output format' -r -a 'table json'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from quota' -l inodes -d 'Inode limit' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from quota' -l soft -d 'Set soft limits'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from quota' -l name -d 'Project name' -r
+
+# doctor command options
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from doctor' -l kubeconfig -d 'Path to kubeconfig file' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from doctor' -l nfs-base-path -d 'Local path where NFS is mounted' -r -a '(__fish_complete_directories)'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from doctor' -l nfs-server-path -d 'NFS server export path' -r -a '(__fish_complete_directories)'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from doctor' -l provisioner-name -d 'Provisioner name' -r -a 'nfs.csi.k8s.io cluster.local/nfs-subdir-external-provisioner'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from doctor' -l process-all-nfs -d 'Process all NFS PVs'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from doctor' -l projects-file -d 'Projects file' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from doctor' -l projid-file -d 'Projid file' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from doctor' -l output -d 'Output format' -r -a 'table json'
+
+# verify command options
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from verify' -l kubeconfig -d 'Path to kubeconfig file' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from verify' -l nfs-base-path -d 'Local path where NFS is mounted' -r -a '(__fish_complete_directories)'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from verify' -l nfs-server-path -d 'NFS server export path' -r -a '(__fish_complete_directories)'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from verify' -l provisioner-name -d 'Provisioner name' -r -a 'nfs.csi.k8s.io cluster.local/nfs-subdir-external-provisioner'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from verify' -l process-all-nfs -d 'Process all NFS PVs'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from verify' -l adopt-existing -d 'Expect adopted project IDs'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from verify' -l projects-file -d 'Projects file' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from verify' -l projid-file -d 'Projid file' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from verify' -l output -d 'Output format' -r -a 'table json'
+
+# projects command options
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from projects; and not __fish_seen_subcommand_from rebuild adopt' -a 'rebuild adopt'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from projects' -l kubeconfig -d 'Path to kubeconfig file' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from projects' -l nfs-base-path -d 'Local path where NFS is mounted' -r -a '(__fish_complete_directories)'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from projects' -l nfs-server-path -d 'NFS server export path' -r -a '(__fish_complete_directories)'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from projects' -l provisioner-name -d 'Provisioner name' -r -a 'nfs.csi.k8s.io cluster.local/nfs-subdir-external-provisioner'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from projects' -l process-all-nfs -d 'Process all NFS PVs'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from projects' -l projects-file -d 'Projects file' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from projects' -l projid-file -d 'Projid file' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from projects' -l dry-run -d 'Show the diff without writing'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from projects' -l yes -d 'Write without confirmation'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from projects' -l output -d 'Output format' -r -a 'table json'
+
+# plan command options
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from plan' -l kubeconfig -d 'Path to kubeconfig file' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from plan' -l nfs-base-path -d 'Local path where NFS is mounted' -r -a '(__fish_complete_directories)'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from plan' -l nfs-server-path -d 'NFS server export path' -r -a '(__fish_complete_directories)'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from plan' -l provisioner-name -d 'Provisioner name' -r -a 'nfs.csi.k8s.io cluster.local/nfs-subdir-external-provisioner'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from plan' -l process-all-nfs -d 'Process all NFS PVs'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from plan' -l projects-file -d 'Projects file' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from plan' -l projid-file -d 'Projid file' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from plan' -l adopt-existing -d 'Plan with adoption of existing project IDs'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from plan' -l output -d 'Output format' -r -a 'table json'
+
+# trash command options
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from trash; and not __fish_seen_subcommand_from list restore purge' -a 'list' -d 'List trashed directories'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from trash; and not __fish_seen_subcommand_from list restore purge' -a 'restore' -d 'Restore a trashed directory'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from trash; and not __fish_seen_subcommand_from list restore purge' -a 'purge' -d 'Permanently delete trashed directories'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from trash' -l nfs-base-path -d 'Local path where NFS is mounted' -r -a '(__fish_complete_directories)'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from trash' -l trash-dir -d 'Trash directory' -r -a '(__fish_complete_directories)'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from trash' -l retention -d 'How long trashed orphans are kept' -r -a '24h 72h 168h 720h'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from trash' -l audit-log -d 'Audit log file path' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from trash' -l output -d 'Output format' -r -a 'table json'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from trash' -l to -d 'Restore to this path instead' -r -a '(__fish_complete_directories)'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from trash' -l all -d 'Purge all entries'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from trash' -l yes -d 'Purge without confirmation'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from trash' -l archive-dir -d 'Archive before purging' -r -a '(__fish_complete_directories)'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from trash' -l archive-format -d 'Archive format' -r -a 'tar.gz tar.zst'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from trash' -l files-per-sec -d 'Maximum files deleted per second' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from trash' -l bytes-per-sec -d 'Maximum data deleted per second' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from trash' -l idle-io -d 'Delete at idle I/O priority' -r -a 'true false'
+
+# forecast command options
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from forecast' -l history-path -d 'Usage history directory' -r -a '(__fish_complete_directories)'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from forecast' -l path -d 'NFS export path for the disk forecast' -r -a '(__fish_complete_directories)'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from forecast' -l dir -d 'Only forecast this directory' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from forecast' -l window -d 'How much history to fit' -r -a '24h 72h 168h 720h'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from forecast' -l method -d 'Fit method' -r -a 'linear robust'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from forecast' -l output -d 'Output format' -r -a 'table json'
+
+# history command options
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from history; and not __fish_seen_subcommand_from export import summary' -a 'export' -d 'Export usage history'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from history; and not __fish_seen_subcommand_from export import summary' -a 'import' -d 'Import an exported history file'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from history; and not __fish_seen_subcommand_from export import summary' -a 'summary' -d 'Print usage growth per namespace'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from history' -l history-path -d 'Usage history directory' -r -a '(__fish_complete_directories)'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from history' -l format -d 'File format' -r -a 'csv json parquet'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from history' -l out -d 'Output file' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from history' -l path -d 'Only export this directory' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from history' -l namespace -d 'Only export this namespace' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from history' -l pvc -d 'Only export this PVC' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from history' -l since -d 'Start time or duration ago' -r -a '24h 7d 30d'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from history' -l until -d 'End time or duration ago' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from history' -l by -d 'Group the summary by' -r -a 'namespace storageclass export'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from history' -l step -d 'Resolution of summary points' -r -a '1h 24h'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from history' -l output -d 'Summary output format' -r -a 'table json'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from history' -l history-interval -d 'History collection interval' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from history' -l history-retention -d 'How long daily rollups are kept' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from history' -l history-raw-retention -d 'How long raw snapshots are kept' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from history' -l history-hourly-retention -d 'How long hourly rollups are kept' -r
+
+# chargeback command options
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from chargeback' -l history-path -d 'Usage history directory' -r -a '(__fish_complete_directories)'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from chargeback' -l kubeconfig -d 'Path to kubeconfig file' -r -F
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from chargeback' -l month -d 'Billing month (YYYY-MM)' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from chargeback' -l since -d 'Start of the period instead of --month' -r -a '7d 30d'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from chargeback' -l until -d 'End of the period' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from chargeback' -l prices -d 'Price per GiB-month by StorageClass' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from chargeback' -l currency -d 'Currency shown with costs' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from chargeback' -l label -d 'Group namespaces by this label' -r
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from chargeback' -l format -d 'Output format' -r -a 'markdown csv json'
+complete -c nfs-quota-agent -n '__fish_seen_subcommand_from chargeback' -l out -d 'Output file' -r -F
 `
 
 // RunCompletion outputs shell completion script
@@ -0,0 +1,251 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	// Resolve CRON_TZ zones in images without a zoneinfo database
+	_ "time/tzdata"
+)
+
+// Schedule is a parsed five-field cron expression
+// ("minute hour day-of-month month day-of-week")
+type Schedule struct {
+	expr     string
+	minute   uint64
+	hour     uint64
+	dom      uint64
+	month    uint64
+	dow      uint64
+	anyDom   bool
+	anyDow   bool
+	location *time.Location
+}
+
+// field describes the valid range and names of a cron field
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is accepted as Sunday and folded onto 0
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a cron expression. Fields accept "*", values, names
+// (jan-dec, sun-sat), ranges "a-b", lists "a,b" and steps "*/n" or "a-b/n".
+// The macros @hourly, @daily, @weekly, @monthly and @yearly are supported,
+// and a "CRON_TZ=<zone> " prefix evaluates the schedule in that time zone
+// instead of local time.
+func Parse(expr string) (*Schedule, error) {
+	s := &Schedule{expr: strings.TrimSpace(expr), location: time.Local}
+
+	spec := s.expr
+	if rest, ok := strings.CutPrefix(spec, "CRON_TZ="); ok {
+		zone, fields, _ := strings.Cut(rest, " ")
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %w", zone, err)
+		}
+		s.location = loc
+		spec = strings.TrimSpace(fields)
+	}
+	if m, ok := macros[strings.ToLower(spec)]; ok {
+		spec = m
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields, got %d", expr, len(fields))
+	}
+
+	var err error
+	if s.minute, err = parseField(fields[0], minuteField); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseField(fields[1], hourField); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseField(fields[2], domField); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseField(fields[3], monthField); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseField(fields[4], dowField); err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.anyDom = fields[2] == "*" || fields[2] == "?"
+	s.anyDow = fields[4] == "*" || fields[4] == "?"
+	return s, nil
+}
+
+// String returns the expression the schedule was parsed from
+func (s *Schedule) String() string { return s.expr }
+
+// Location returns the time zone the schedule is evaluated in
+func (s *Schedule) Location() *time.Location { return s.location }
+
+// Next returns the first activation strictly after t, or the zero time if
+// the expression never matches (e.g. February 30th)
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.In(s.location).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !has(s.month, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.location)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.location)
+			continue
+		}
+		if !has(s.hour, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.location)
+			continue
+		}
+		if !has(s.minute, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// dayMatches applies the cron rule that a restricted day of month and a
+// restricted day of week match if either does
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := has(s.dom, t.Day())
+	dow := has(s.dow, int(t.Weekday()))
+	switch {
+	case s.anyDom && s.anyDow:
+		return true
+	case s.anyDom:
+		return dow
+	case s.anyDow:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+func has(set uint64, v int) bool { return set&(1<<uint(v)) != 0 }
+
+// parseField parses one comma-separated cron field into a bit set
+func parseField(expr string, f field) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(expr, ",") {
+		bits, err := parseRange(part, f)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q: %w", f.name, expr, err)
+		}
+		set |= bits
+	}
+	return set, nil
+}
+
+// parseRange parses "*", "v", "a-b" with an optional "/step"
+func parseRange(expr string, f field) (uint64, error) {
+	rangeExpr, stepExpr, hasStep := strings.Cut(expr, "/")
+
+	lo, hi := f.min, f.max
+	switch {
+	case rangeExpr == "*" || rangeExpr == "?":
+	case strings.Contains(rangeExpr, "-"):
+		a, b, _ := strings.Cut(rangeExpr, "-")
+		var err error
+		if lo, err = parseValue(a, f); err != nil {
+			return 0, err
+		}
+		if hi, err = parseValue(b, f); err != nil {
+			return 0, err
+		}
+		if lo > hi {
+			return 0, fmt.Errorf("range %d-%d is reversed", lo, hi)
+		}
+	default:
+		v, err := parseValue(rangeExpr, f)
+		if err != nil {
+			return 0, err
+		}
+		lo = v
+		// "5/10" means from 5 to the end in steps of 10
+		if !hasStep {
+			hi = v
+		}
+	}
+
+	step := 1
+	if hasStep {
+		n, err := strconv.Atoi(stepExpr)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid step %q", stepExpr)
+		}
+		step = n
+	}
+
+	var set uint64
+	for v := lo; v <= hi; v += step {
+		set |= 1 << uint(v)
+	}
+	return set, nil
+}
+
+// parseValue parses a number or name within the field's range
+func parseValue(s string, f field) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("%d is out of range %d-%d", v, f.min, f.max)
+	}
+	return v, nil
+}
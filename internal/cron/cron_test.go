@@ -0,0 +1,78 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * * funday",
+		"CRON_TZ=Nowhere/City 0 1 * * *",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// Wednesday 2024-01-10 12:34 UTC
+	from := time.Date(2024, 1, 10, 12, 34, 56, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"CRON_TZ=UTC * * * * *", time.Date(2024, 1, 10, 12, 35, 0, 0, time.UTC)},
+		{"CRON_TZ=UTC */15 * * * *", time.Date(2024, 1, 10, 12, 45, 0, 0, time.UTC)},
+		{"CRON_TZ=UTC 0 1 * * 1-5", time.Date(2024, 1, 11, 1, 0, 0, 0, time.UTC)},
+		{"CRON_TZ=UTC 0 1 * * sat,sun", time.Date(2024, 1, 13, 1, 0, 0, 0, time.UTC)},
+		{"CRON_TZ=UTC 0 3 * * 7", time.Date(2024, 1, 14, 3, 0, 0, 0, time.UTC)},
+		{"CRON_TZ=UTC 30 2 1 * *", time.Date(2024, 2, 1, 2, 30, 0, 0, time.UTC)},
+		{"CRON_TZ=UTC 0 0 29 feb *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Restricted day of month and day of week match if either does
+		{"CRON_TZ=UTC 0 0 15 * fri", time.Date(2024, 1, 12, 0, 0, 0, 0, time.UTC)},
+		{"CRON_TZ=UTC @daily", time.Date(2024, 1, 11, 0, 0, 0, 0, time.UTC)},
+		{"CRON_TZ=UTC 0 0 30 2 *", time.Time{}},
+		// 01:00 in Seoul is 16:00 UTC the day before
+		{"CRON_TZ=Asia/Seoul 0 1 * * *", time.Date(2024, 1, 10, 16, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := s.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
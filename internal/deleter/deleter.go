@@ -0,0 +1,323 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deleter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	// batchSize is how many directory entries are read at a time, so huge
+	// directories never have to fit in memory
+	batchSize = 256
+
+	// checkpointEvery is how often progress is handed to the checkpoint
+	// callback while deleting
+	checkpointEvery = 10 * time.Second
+)
+
+// Limits throttles deletions so that removing a large tree does not starve
+// the export of I/O
+type Limits struct {
+	FilesPerSec int   `json:"filesPerSec,omitempty"` // 0 = unlimited
+	BytesPerSec int64 `json:"bytesPerSec,omitempty"` // 0 = unlimited
+	IdleIO      bool  `json:"idleIO"`                // unlink at idle I/O priority
+}
+
+// Progress describes a deletion, possibly spanning several runs when it
+// was interrupted and resumed
+type Progress struct {
+	Path       string    `json:"path"`
+	Files      int64     `json:"files"`
+	Dirs       int64     `json:"dirs"`
+	Bytes      int64     `json:"bytes"`
+	TotalBytes int64     `json:"totalBytes,omitempty"` // expected size, if known
+	Started    time.Time `json:"started"`
+	Updated    time.Time `json:"updated"`
+	Resumes    int       `json:"resumes,omitempty"`
+}
+
+// Status is a snapshot of a deleter (for API and metrics)
+type Status struct {
+	Limits       Limits    `json:"limits"`
+	Current      *Progress `json:"current,omitempty"`
+	FilesDeleted int64     `json:"filesDeleted"`
+	BytesDeleted int64     `json:"bytesDeleted"`
+}
+
+// Deleter removes directory trees file by file within its limits. Deletions
+// run one at a time; a second RemoveAll waits for the first to finish.
+type Deleter struct {
+	limits Limits
+	run    sync.Mutex // serializes deletions
+
+	mu           sync.Mutex
+	current      *Progress
+	filesDeleted int64
+	bytesDeleted int64
+}
+
+// New creates a deleter with the given limits
+func New(limits Limits) *Deleter {
+	return &Deleter{limits: limits}
+}
+
+// Limits returns the deleter's limits
+func (d *Deleter) Limits() Limits { return d.limits }
+
+// Status returns the running deletion, if any, and totals since start
+func (d *Deleter) Status() Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := Status{Limits: d.limits, FilesDeleted: d.filesDeleted, BytesDeleted: d.bytesDeleted}
+	if d.current != nil {
+		p := *d.current
+		s.Current = &p
+	}
+	return s
+}
+
+// RemoveAll deletes path and everything below it, continuing the counters
+// of prev when resuming an interrupted deletion. checkpoint, if set, is
+// called periodically with the progress so far. When ctx is cancelled the
+// deletion stops after the current file and the partial progress is
+// returned with ctx's error; calling RemoveAll again picks up the rest.
+func (d *Deleter) RemoveAll(ctx context.Context, path string, prev Progress, checkpoint func(Progress)) (Progress, error) {
+	d.run.Lock()
+	defer d.run.Unlock()
+
+	p := prev
+	p.Path = path
+	if p.Started.IsZero() {
+		p.Started = time.Now()
+	} else {
+		p.Resumes++
+	}
+
+	// I/O priority is per thread, so keep every unlink on this one
+	if d.limits.IdleIO {
+		runtime.LockOSThread()
+		restore, err := setIdleIOPriority()
+		if err != nil {
+			slog.Warn("Failed to lower I/O priority for deletion", "error", err)
+		}
+		defer func() {
+			if restore != nil && restore() != nil {
+				// Leave the thread locked so it exits with the goroutine
+				// instead of running other work at idle priority
+				return
+			}
+			runtime.UnlockOSThread()
+		}()
+	}
+
+	w := &walk{
+		ctx:        ctx,
+		d:          d,
+		progress:   &p,
+		checkpoint: checkpoint,
+		files:      newPacer(float64(d.limits.FilesPerSec)),
+		bytes:      newPacer(float64(d.limits.BytesPerSec)),
+		lastSaved:  time.Now(),
+	}
+
+	d.setCurrent(&p)
+	err := w.remove(path)
+	d.setCurrent(nil)
+
+	p.Updated = time.Now()
+	if err == nil && checkpoint != nil {
+		checkpoint(p)
+	}
+	return p, err
+}
+
+func (d *Deleter) setCurrent(p *Progress) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if p == nil {
+		d.current = nil
+		return
+	}
+	cp := *p
+	d.current = &cp
+}
+
+// walk is one RemoveAll call
+type walk struct {
+	ctx        context.Context
+	d          *Deleter
+	progress   *Progress
+	checkpoint func(Progress)
+	files      *pacer
+	bytes      *pacer
+	lastSaved  time.Time
+}
+
+// remove deletes path depth-first. Directories are re-read after each batch
+// of unlinks instead of iterating while they shrink, which some
+// filesystems (NFS in particular) do not handle reliably.
+func (w *walk) remove(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return w.unlink(path, info.Size())
+	}
+
+	for {
+		entries, err := readBatch(path)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			break
+		}
+		for _, entry := range entries {
+			child := filepath.Join(path, entry.Name())
+			if entry.IsDir() {
+				if err := w.remove(child); err != nil {
+					return err
+				}
+				continue
+			}
+			var size int64
+			if fi, err := entry.Info(); err == nil {
+				size = fi.Size()
+			}
+			if err := w.unlink(child, size); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove directory %s: %w", path, err)
+	}
+	w.progress.Dirs++
+	w.done(0, 0)
+	return nil
+}
+
+// unlink removes one file once the rate limits allow it
+func (w *walk) unlink(path string, size int64) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	if err := w.files.wait(w.ctx, 1); err != nil {
+		return err
+	}
+	if err := w.bytes.wait(w.ctx, float64(size)); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	w.progress.Files++
+	w.progress.Bytes += size
+	w.done(1, size)
+	return nil
+}
+
+// done publishes progress and checkpoints it periodically
+func (w *walk) done(files, bytes int64) {
+	now := time.Now()
+	w.progress.Updated = now
+
+	w.d.mu.Lock()
+	w.d.filesDeleted += files
+	w.d.bytesDeleted += bytes
+	if w.d.current != nil {
+		*w.d.current = *w.progress
+	}
+	w.d.mu.Unlock()
+
+	if w.checkpoint != nil && now.Sub(w.lastSaved) >= checkpointEvery {
+		w.checkpoint(*w.progress)
+		w.lastSaved = now
+	}
+}
+
+// readBatch reads up to batchSize entries of a directory
+func readBatch(path string) ([]os.DirEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, err := f.ReadDir(batchSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// pacer spaces out operations so their average rate stays at or below
+// rate per second. Work is paid for up front, so a single large file
+// delays the files after it rather than exceeding the rate.
+type pacer struct {
+	rate float64
+	next time.Time
+}
+
+func newPacer(rate float64) *pacer {
+	return &pacer{rate: rate}
+}
+
+// wait blocks until n more units may be used
+func (p *pacer) wait(ctx context.Context, n float64) error {
+	if p.rate <= 0 || n <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	delay := p.next.Sub(now)
+	p.next = p.next.Add(time.Duration(n / p.rate * float64(time.Second)))
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deleter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func makeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := filepath.Join(t.TempDir(), "tree")
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestRemoveAll(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		"a.txt":       "12345",
+		"sub/b.txt":   "123",
+		"sub/x/c.txt": "",
+	})
+	if err := os.Symlink("/etc/passwd", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	d := New(Limits{})
+	var checkpoints int
+	p, err := d.RemoveAll(context.Background(), root, Progress{}, func(Progress) { checkpoints++ })
+	if err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if _, err := os.Lstat(root); !os.IsNotExist(err) {
+		t.Errorf("tree still exists after RemoveAll(): %v", err)
+	}
+	if _, err := os.Stat("/etc/passwd"); err != nil {
+		t.Errorf("symlink target removed: %v", err)
+	}
+	if p.Files != 4 || p.Dirs != 3 {
+		t.Errorf("RemoveAll() removed %d files and %d dirs, want 4 and 3", p.Files, p.Dirs)
+	}
+	if checkpoints == 0 {
+		t.Error("checkpoint not called on completion")
+	}
+
+	s := d.Status()
+	if s.Current != nil || s.FilesDeleted != 4 {
+		t.Errorf("Status() = %+v, want idle with 4 files deleted", s)
+	}
+}
+
+func TestRemoveAllResume(t *testing.T) {
+	root := makeTree(t, map[string]string{"a": "1", "b": "2", "c/d": "3"})
+	d := New(Limits{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p, err := d.RemoveAll(ctx, root, Progress{}, nil)
+	if err != context.Canceled {
+		t.Fatalf("RemoveAll(cancelled) error = %v, want context.Canceled", err)
+	}
+	if _, err := os.Stat(root); err != nil {
+		t.Fatalf("cancelled RemoveAll() removed the tree: %v", err)
+	}
+
+	p, err = d.RemoveAll(context.Background(), root, p, nil)
+	if err != nil {
+		t.Fatalf("resumed RemoveAll() error = %v", err)
+	}
+	if p.Resumes != 1 || p.Files != 3 || p.Bytes != 3 {
+		t.Errorf("resumed progress = %+v, want 1 resume, 3 files, 3 bytes", p)
+	}
+	if _, err := os.Lstat(root); !os.IsNotExist(err) {
+		t.Errorf("tree still exists after resumed RemoveAll(): %v", err)
+	}
+}
+
+func TestPacer(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    float64
+		ops     []float64
+		minTime time.Duration
+	}{
+		{"unlimited", 0, []float64{1, 1, 1}, 0},
+		{"files", 100, []float64{1, 1, 1, 1, 1, 1}, 50 * time.Millisecond},
+		// A large item is paid for before the next one may start
+		{"bytes", 1000, []float64{100, 10}, 100 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPacer(tt.rate)
+			start := time.Now()
+			for _, n := range tt.ops {
+				if err := p.wait(context.Background(), n); err != nil {
+					t.Fatalf("wait() error = %v", err)
+				}
+			}
+			if elapsed := time.Since(start); elapsed < tt.minTime {
+				t.Errorf("%d ops took %v, want at least %v", len(tt.ops), elapsed, tt.minTime)
+			}
+		})
+	}
+}
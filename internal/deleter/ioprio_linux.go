@@ -0,0 +1,46 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deleter
+
+import "syscall"
+
+// ioprio_set(2) constants
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+	ioprioClassIdle  = 3
+)
+
+// setIdleIOPriority moves the calling thread to the idle I/O class, so its
+// I/O is only served when the disk is otherwise unused. The class is
+// honoured by the BFQ and CFQ schedulers and ignored by the others. The
+// returned function restores the previous priority.
+func setIdleIOPriority() (func() error, error) {
+	old, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_GET, ioprioWhoProcess, 0, 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, 0, ioprioClassIdle<<ioprioClassShift); errno != 0 {
+		return nil, errno
+	}
+	return func() error {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, 0, old); errno != 0 {
+			return errno
+		}
+		return nil
+	}, nil
+}
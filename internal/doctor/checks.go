@@ -0,0 +1,518 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/dasomel/nfs-quota-agent/internal/agent"
+	"github.com/dasomel/nfs-quota-agent/internal/quota"
+)
+
+// requiredPermissions lists the RBAC permissions the agent needs
+var requiredPermissions = []authorizationv1.ResourceAttributes{
+	{Resource: "persistentvolumes", Verb: "list"},
+	{Resource: "persistentvolumes", Verb: "watch"},
+	{Resource: "persistentvolumes", Verb: "update"},
+	{Resource: "persistentvolumeclaims", Verb: "list"},
+	{Resource: "namespaces", Verb: "list"},
+	{Resource: "limitranges", Verb: "list"},
+	{Resource: "resourcequotas", Verb: "list"},
+	{Group: "storage.k8s.io", Resource: "storageclasses", Verb: "list"},
+}
+
+// checkFilesystem checks that the quota path exists and is XFS or ext4
+func checkFilesystem(r *Report, path string) string {
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		r.add(Check{
+			Category: "filesystem",
+			Name:     "quota path",
+			Status:   StatusFail,
+			Message:  fmt.Sprintf("%s is not an accessible directory", path),
+			Hint:     "mount the NFS export into the agent and pass it as --nfs-base-path",
+		})
+		return ""
+	}
+
+	fsType, err := quota.DetectFSTypeWithFindmnt(path)
+	if err != nil {
+		r.add(Check{
+			Category: "filesystem",
+			Name:     "filesystem type",
+			Status:   StatusFail,
+			Message:  fmt.Sprintf("failed to detect filesystem: %v", err),
+			Hint:     "make sure findmnt or df is available",
+		})
+		return ""
+	}
+
+	switch fsType {
+	case quota.FSTypeXFS, quota.FSTypeExt4:
+		r.add(Check{Category: "filesystem", Name: "filesystem type", Status: StatusPass, Message: fsType})
+	default:
+		r.add(Check{
+			Category: "filesystem",
+			Name:     "filesystem type",
+			Status:   StatusFail,
+			Message:  fmt.Sprintf("unsupported filesystem %q", fsType),
+			Hint:     "only xfs and ext4 support project quotas; check that --nfs-base-path points at the real export, not an NFS client mount",
+		})
+	}
+	return fsType
+}
+
+// checkTools checks that the quota tools for the filesystem are installed
+func checkTools(r *Report, fsType string) {
+	tools := []string{"findmnt"}
+	hint := ""
+	switch fsType {
+	case quota.FSTypeXFS:
+		tools = append(tools, "xfs_quota")
+		hint = "install xfsprogs (apk add xfsprogs-extra / apt install xfsprogs)"
+	case quota.FSTypeExt4:
+		tools = append(tools, "setquota", "repquota", "quotaon", "chattr")
+		hint = "install quota tools and e2fsprogs (apk add quota-tools e2fsprogs / apt install quota e2fsprogs)"
+	}
+
+	for _, tool := range tools {
+		if path, err := exec.LookPath(tool); err == nil {
+			r.add(Check{Category: "tools", Name: tool, Status: StatusPass, Message: path})
+		} else {
+			r.add(Check{
+				Category: "tools",
+				Name:     tool,
+				Status:   StatusFail,
+				Message:  "not found in PATH",
+				Hint:     hint,
+			})
+		}
+	}
+}
+
+// checkMountOptions checks that the filesystem is mounted with project quota
+func checkMountOptions(r *Report, path, fsType string) {
+	if fsType == "" {
+		return
+	}
+
+	output, err := exec.Command("findmnt", "-n", "-o", "OPTIONS", "--target", path).CombinedOutput()
+	if err != nil {
+		r.add(Check{
+			Category: "mount",
+			Name:     "mount options",
+			Status:   StatusWarn,
+			Message:  fmt.Sprintf("failed to read mount options: %v", err),
+		})
+		return
+	}
+
+	opts := strings.TrimSpace(string(output))
+	accounting, enforced := parseProjectQuotaOptions(opts)
+	switch {
+	case enforced:
+		r.add(Check{Category: "mount", Name: "mount options", Status: StatusPass, Message: "project quota enabled (" + opts + ")"})
+	case accounting:
+		r.add(Check{
+			Category: "mount",
+			Name:     "mount options",
+			Status:   StatusWarn,
+			Message:  "project quota accounting only (pqnoenforce)",
+			Hint:     "remount with prjquota to enforce limits",
+		})
+	case fsType == quota.FSTypeExt4:
+		// ext4 may enable project quota through the quota/project features instead of mount options
+		r.add(Check{
+			Category: "mount",
+			Name:     "mount options",
+			Status:   StatusWarn,
+			Message:  "prjquota mount option not found (" + opts + ")",
+			Hint:     "enable with 'tune2fs -O project,quota <device>' or mount with -o prjquota",
+		})
+	default:
+		r.add(Check{
+			Category: "mount",
+			Name:     "mount options",
+			Status:   StatusFail,
+			Message:  "prjquota mount option not found (" + opts + ")",
+			Hint:     "add prjquota to the mount options in /etc/fstab and remount (XFS requires a full unmount)",
+		})
+	}
+}
+
+// parseProjectQuotaOptions reports whether mount options enable project
+// quota accounting and enforcement
+func parseProjectQuotaOptions(opts string) (accounting, enforced bool) {
+	for _, opt := range strings.Split(opts, ",") {
+		switch strings.TrimSpace(opt) {
+		case "prjquota", "pquota":
+			accounting, enforced = true, true
+		case "pqnoenforce":
+			accounting = true
+		}
+	}
+	return accounting, enforced
+}
+
+// checkQuotaState checks that quota accounting and enforcement are on
+func checkQuotaState(r *Report, path, fsType string) {
+	switch fsType {
+	case quota.FSTypeXFS:
+		output, err := exec.Command("xfs_quota", "-x", "-c", "state -p", path).CombinedOutput()
+		if err != nil {
+			r.add(Check{
+				Category: "quota",
+				Name:     "quota state",
+				Status:   StatusFail,
+				Message:  fmt.Sprintf("xfs_quota state failed: %v", err),
+				Hint:     "the agent must run privileged with access to the block device",
+			})
+			return
+		}
+		accounting, enforcement, found := parseXFSState(string(output))
+		addStateChecks(r, accounting, enforcement, found)
+	case quota.FSTypeExt4:
+		output, _ := exec.Command("quotaon", "-p", "-P", path).CombinedOutput()
+		on, found := parseQuotaonState(string(output))
+		// On ext4 accounting is implied by the quota feature; quotaon reports enforcement
+		addStateChecks(r, found, on, found)
+	}
+}
+
+func addStateChecks(r *Report, accounting, enforcement, found bool) {
+	if !found {
+		r.add(Check{
+			Category: "quota",
+			Name:     "quota state",
+			Status:   StatusFail,
+			Message:  "project quota state not reported",
+			Hint:     "enable project quota (mount with prjquota)",
+		})
+		return
+	}
+
+	if accounting {
+		r.add(Check{Category: "quota", Name: "accounting", Status: StatusPass, Message: "ON"})
+	} else {
+		r.add(Check{
+			Category: "quota",
+			Name:     "accounting",
+			Status:   StatusFail,
+			Message:  "OFF",
+			Hint:     "usage is not tracked; remount with prjquota",
+		})
+	}
+
+	if enforcement {
+		r.add(Check{Category: "quota", Name: "enforcement", Status: StatusPass, Message: "ON"})
+	} else {
+		r.add(Check{
+			Category: "quota",
+			Name:     "enforcement",
+			Status:   StatusFail,
+			Message:  "OFF",
+			Hint:     "limits are not enforced; remount with prjquota (XFS) or run 'quotaon -P <mountpoint>' (ext4)",
+		})
+	}
+}
+
+// parseXFSState parses `xfs_quota -x -c "state -p"` output
+func parseXFSState(output string) (accounting, enforcement, found bool) {
+	inProject := false
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.Contains(line, "quota state on") {
+			inProject = strings.HasPrefix(line, "Project")
+			found = found || inProject
+			continue
+		}
+		if !inProject {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "Accounting:"):
+			accounting = strings.Contains(line, "ON")
+		case strings.HasPrefix(line, "Enforcement:"):
+			enforcement = strings.Contains(line, "ON")
+		}
+	}
+	return accounting, enforcement, found
+}
+
+// parseQuotaonState parses `quotaon -p -P` output
+// ("project quota on /export (/dev/sdb) is on")
+func parseQuotaonState(output string) (on, found bool) {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "project quota on") {
+			continue
+		}
+		found = true
+		on = strings.Contains(line, " is on")
+	}
+	return on, found
+}
+
+// checkProjectFiles checks the projects/projid files for consistency
+func checkProjectFiles(r *Report, basePath, projectsFile, projidFile string) {
+	_, projectsErr := os.Stat(projectsFile)
+	_, projidErr := os.Stat(projidFile)
+
+	if os.IsNotExist(projectsErr) || os.IsNotExist(projidErr) {
+		// A common mistake is keeping the files next to the data instead of /etc
+		misplaced := ""
+		for _, name := range []string{"projects", "projid"} {
+			if _, err := os.Stat(filepath.Join(basePath, name)); err == nil {
+				misplaced = filepath.Join(basePath, name)
+				break
+			}
+		}
+		if misplaced != "" {
+			r.add(Check{
+				Category: "projects",
+				Name:     "projects files",
+				Status:   StatusFail,
+				Message:  fmt.Sprintf("%s or %s missing, but found %s", projectsFile, projidFile, misplaced),
+				Hint:     "the agent and xfs_quota read " + projectsFile + " and " + projidFile + "; move the files or mount them there",
+			})
+			return
+		}
+		r.add(Check{
+			Category: "projects",
+			Name:     "projects files",
+			Status:   StatusWarn,
+			Message:  fmt.Sprintf("%s or %s does not exist", projectsFile, projidFile),
+			Hint:     "the files are created on the first applied quota; mount /etc/projects and /etc/projid from the host so they survive restarts",
+		})
+		return
+	}
+
+	projectsData, err := os.ReadFile(projectsFile)
+	if err != nil {
+		r.add(Check{Category: "projects", Name: "projects file", Status: StatusFail, Message: err.Error()})
+		return
+	}
+	projidData, err := os.ReadFile(projidFile)
+	if err != nil {
+		r.add(Check{Category: "projects", Name: "projid file", Status: StatusFail, Message: err.Error()})
+		return
+	}
+
+	issues := projectFileIssues(string(projectsData), string(projidData), basePath)
+	if len(issues) == 0 {
+		r.add(Check{Category: "projects", Name: "consistency", Status: StatusPass, Message: "projects and projid files are consistent"})
+		return
+	}
+
+	for _, issue := range issues {
+		r.add(Check{
+			Category: "projects",
+			Name:     "consistency",
+			Status:   StatusWarn,
+			Message:  issue,
+			Hint:     "run 'nfs-quota-agent quota list' to inspect, and remove stale entries",
+		})
+	}
+}
+
+// projectFileIssues returns consistency problems between the projects
+// (id:path) and projid (name:id) file contents
+func projectFileIssues(projectsData, projidData, basePath string) []string {
+	var issues []string
+
+	pathsByID := make(map[string][]string)
+	idsByPath := make(map[string][]string)
+	var ids []string
+	for _, line := range strings.Split(projectsData, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			issues = append(issues, fmt.Sprintf("malformed projects line %q", line))
+			continue
+		}
+		if _, ok := pathsByID[parts[0]]; !ok {
+			ids = append(ids, parts[0])
+		}
+		pathsByID[parts[0]] = append(pathsByID[parts[0]], parts[1])
+		idsByPath[parts[1]] = append(idsByPath[parts[1]], parts[0])
+	}
+
+	namesByID := make(map[string]string)
+	for _, line := range strings.Split(projidData, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			issues = append(issues, fmt.Sprintf("malformed projid line %q", line))
+			continue
+		}
+		if existing, ok := namesByID[parts[1]]; ok && existing != parts[0] {
+			issues = append(issues, fmt.Sprintf("project ID %s has several names (%s, %s)", parts[1], existing, parts[0]))
+		}
+		namesByID[parts[1]] = parts[0]
+		if _, ok := pathsByID[parts[1]]; !ok {
+			issues = append(issues, fmt.Sprintf("project %s (ID %s) has no projects entry", parts[0], parts[1]))
+		}
+	}
+
+	for _, id := range ids {
+		paths := pathsByID[id]
+		if len(paths) > 1 {
+			issues = append(issues, fmt.Sprintf("project ID %s is used by %d paths (%s)", id, len(paths), strings.Join(paths, ", ")))
+		}
+		if _, ok := namesByID[id]; !ok {
+			issues = append(issues, fmt.Sprintf("project ID %s (%s) has no projid entry", id, paths[0]))
+		}
+		for _, p := range paths {
+			if !pathWithin(basePath, p) || filepath.Clean(p) == filepath.Clean(basePath) {
+				issues = append(issues, fmt.Sprintf("project ID %s path %s is outside %s", id, p, basePath))
+			}
+		}
+	}
+
+	for path, pathIDs := range idsByPath {
+		if len(pathIDs) > 1 {
+			issues = append(issues, fmt.Sprintf("path %s is registered under several project IDs (%s)", path, strings.Join(pathIDs, ", ")))
+		}
+	}
+
+	return issues
+}
+
+// pathWithin reports whether path is base or lies below it. Unlike a plain
+// prefix match, /export2/x is not within /export.
+func pathWithin(base, path string) bool {
+	rel, err := filepath.Rel(base, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// checkRBAC checks the agent's permissions with SelfSubjectAccessReview
+func checkRBAC(ctx context.Context, r *Report, client kubernetes.Interface) {
+	for _, attrs := range requiredPermissions {
+		attrs := attrs
+		name := attrs.Verb + " " + attrs.Resource
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: &attrs},
+		}
+
+		result, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			r.add(Check{
+				Category: "rbac",
+				Name:     name,
+				Status:   StatusWarn,
+				Message:  fmt.Sprintf("access review failed: %v", err),
+			})
+			continue
+		}
+
+		if result.Status.Allowed {
+			r.add(Check{Category: "rbac", Name: name, Status: StatusPass, Message: "allowed"})
+		} else {
+			r.add(Check{
+				Category: "rbac",
+				Name:     name,
+				Status:   StatusFail,
+				Message:  "denied",
+				Hint:     "update the ClusterRole (see charts/nfs-quota-agent/templates/clusterrole.yaml)",
+			})
+		}
+	}
+}
+
+// checkPathMapping checks that every PV maps to an existing local directory
+func checkPathMapping(ctx context.Context, r *Report, ag *agent.QuotaAgent, opts Options) {
+	targets, err := ag.Targets(ctx)
+	if err != nil {
+		r.add(Check{
+			Category: "mapping",
+			Name:     "pv list",
+			Status:   StatusFail,
+			Message:  err.Error(),
+			Hint:     "check API connectivity and the persistentvolumes list permission",
+		})
+		return
+	}
+
+	if len(targets) == 0 {
+		r.add(Check{
+			Category: "mapping",
+			Name:     "pv selection",
+			Status:   StatusWarn,
+			Message:  fmt.Sprintf("no bound NFS PVs match provisioner %q", opts.ProvisionerName),
+			Hint:     "check --provisioner-name or use --process-all-nfs",
+		})
+		return
+	}
+	r.add(Check{Category: "mapping", Name: "pv selection", Status: StatusPass, Message: fmt.Sprintf("%d PVs selected", len(targets))})
+
+	var missing, outside []agent.PVTarget
+	for _, t := range targets {
+		if !pathWithin(opts.NfsServerPath, t.NFSPath) {
+			outside = append(outside, t)
+		}
+		if info, err := os.Stat(t.LocalPath); err != nil || !info.IsDir() {
+			missing = append(missing, t)
+		}
+	}
+
+	if len(outside) > 0 {
+		r.add(Check{
+			Category: "mapping",
+			Name:     "server path",
+			Status:   StatusWarn,
+			Message:  fmt.Sprintf("%d PVs are not under --nfs-server-path %s (e.g. %s)", len(outside), opts.NfsServerPath, outside[0].NFSPath),
+			Hint:     "set --nfs-server-path to the export path used in the PVs",
+		})
+	} else {
+		r.add(Check{Category: "mapping", Name: "server path", Status: StatusPass, Message: "all PV paths are under " + opts.NfsServerPath})
+	}
+
+	switch {
+	case len(missing) == 0:
+		r.add(Check{Category: "mapping", Name: "local directories", Status: StatusPass, Message: "all PV directories found"})
+	case len(missing) == len(targets):
+		r.add(Check{
+			Category: "mapping",
+			Name:     "local directories",
+			Status:   StatusFail,
+			Message:  fmt.Sprintf("none of %d PV directories exist (e.g. %s -> %s)", len(targets), missing[0].NFSPath, missing[0].LocalPath),
+			Hint:     "--nfs-server-path and --nfs-base-path do not match the export; the NFS path prefix is replaced by the base path",
+		})
+	default:
+		r.add(Check{
+			Category: "mapping",
+			Name:     "local directories",
+			Status:   StatusWarn,
+			Message:  fmt.Sprintf("%d of %d PV directories missing (e.g. %s: %s)", len(missing), len(targets), missing[0].PVName, missing[0].LocalPath),
+			Hint:     "the directories may have been removed manually or the provisioner uses a different layout",
+		})
+	}
+}
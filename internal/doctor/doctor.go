@@ -0,0 +1,155 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package doctor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/dasomel/nfs-quota-agent/internal/agent"
+)
+
+// Status is the outcome of a single check
+type Status string
+
+const (
+	StatusPass Status = "PASS"
+	StatusWarn Status = "WARN"
+	StatusFail Status = "FAIL"
+	StatusSkip Status = "SKIP"
+)
+
+// Check is a single diagnostic result
+type Check struct {
+	Category string `json:"category"`
+	Name     string `json:"name"`
+	Status   Status `json:"status"`
+	Message  string `json:"message"`
+	Hint     string `json:"hint,omitempty"`
+}
+
+// Report contains all diagnostic results
+type Report struct {
+	Timestamp time.Time `json:"timestamp"`
+	QuotaPath string    `json:"quotaPath"`
+	FSType    string    `json:"fsType"`
+	Checks    []Check   `json:"checks"`
+	Passed    int       `json:"passed"`
+	Warnings  int       `json:"warnings"`
+	Failures  int       `json:"failures"`
+}
+
+// Options configures the doctor command
+type Options struct {
+	NfsBasePath     string
+	NfsServerPath   string
+	ProvisionerName string
+	ProcessAllNFS   bool
+	ProjectsFile    string
+	ProjidFile      string
+	Client          kubernetes.Interface // nil skips Kubernetes checks
+	ClientErr       error                // reason the client is unavailable
+}
+
+// Run runs all checks and returns the report
+func Run(ctx context.Context, opts Options) *Report {
+	r := &Report{
+		Timestamp: time.Now(),
+		QuotaPath: opts.NfsBasePath,
+	}
+
+	r.FSType = checkFilesystem(r, opts.NfsBasePath)
+	checkTools(r, r.FSType)
+	checkMountOptions(r, opts.NfsBasePath, r.FSType)
+	checkQuotaState(r, opts.NfsBasePath, r.FSType)
+	checkProjectFiles(r, opts.NfsBasePath, opts.ProjectsFile, opts.ProjidFile)
+
+	if opts.Client == nil {
+		msg := "no Kubernetes client configured"
+		if opts.ClientErr != nil {
+			msg = opts.ClientErr.Error()
+		}
+		r.add(Check{
+			Category: "kubernetes",
+			Name:     "connection",
+			Status:   StatusSkip,
+			Message:  msg,
+			Hint:     "pass --kubeconfig or run inside the cluster to check PV path mapping and RBAC",
+		})
+	} else {
+		checkRBAC(ctx, r, opts.Client)
+
+		ag := agent.NewQuotaAgent(opts.Client, opts.NfsBasePath, opts.NfsServerPath, opts.ProvisionerName)
+		ag.SetProcessAllNFS(opts.ProcessAllNFS)
+		ag.SetProjectsFile(opts.ProjectsFile)
+		ag.SetProjidFile(opts.ProjidFile)
+		checkPathMapping(ctx, r, ag, opts)
+	}
+
+	return r
+}
+
+// add appends a check and updates the counters
+func (r *Report) add(c Check) {
+	r.Checks = append(r.Checks, c)
+	switch c.Status {
+	case StatusPass:
+		r.Passed++
+	case StatusWarn:
+		r.Warnings++
+	case StatusFail:
+		r.Failures++
+	}
+}
+
+// Print writes the report as a checklist (table) or JSON
+func (r *Report) Print(format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	}
+
+	fmt.Printf("NFS Quota Doctor\n")
+	fmt.Printf("================\n\n")
+	fmt.Printf("Path:       %s\n", r.QuotaPath)
+	fmt.Printf("Filesystem: %s\n\n", r.FSType)
+
+	category := ""
+	for _, c := range r.Checks {
+		if c.Category != category {
+			if category != "" {
+				fmt.Println()
+			}
+			category = c.Category
+			fmt.Printf("%s\n%s\n", strings.ToUpper(category), strings.Repeat("-", len(category)))
+		}
+		fmt.Printf("  [%s] %-24s %s\n", c.Status, c.Name, c.Message)
+		if c.Hint != "" && c.Status != StatusPass {
+			fmt.Printf("         %-24s hint: %s\n", "", c.Hint)
+		}
+	}
+
+	fmt.Printf("\nSummary: %d passed, %d warnings, %d failures\n", r.Passed, r.Warnings, r.Failures)
+	return nil
+}
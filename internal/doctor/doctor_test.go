@@ -0,0 +1,121 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package doctor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseProjectQuotaOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       string
+		accounting bool
+		enforced   bool
+	}{
+		{"prjquota", "rw,relatime,attr2,inode64,prjquota", true, true},
+		{"pquota", "rw,pquota", true, true},
+		{"accounting only", "rw,pqnoenforce", true, false},
+		{"no project quota", "rw,relatime,usrquota", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accounting, enforced := parseProjectQuotaOptions(tt.opts)
+			if accounting != tt.accounting || enforced != tt.enforced {
+				t.Errorf("parseProjectQuotaOptions(%q) = (%v, %v), want (%v, %v)",
+					tt.opts, accounting, enforced, tt.accounting, tt.enforced)
+			}
+		})
+	}
+}
+
+func TestParseXFSState(t *testing.T) {
+	output := `User quota state on /export (/dev/sdb)
+  Accounting: OFF
+  Enforcement: OFF
+Project quota state on /export (/dev/sdb)
+  Accounting: ON
+  Enforcement: OFF
+  Inode: #131 (2 blocks, 2 extents)
+`
+	accounting, enforcement, found := parseXFSState(output)
+	if !found || !accounting || enforcement {
+		t.Errorf("parseXFSState() = (%v, %v, %v), want (true, false, true)", accounting, enforcement, found)
+	}
+
+	if _, _, found := parseXFSState("User quota state on /export (/dev/sdb)\n  Accounting: ON\n"); found {
+		t.Error("Expected project quota state not to be found")
+	}
+}
+
+func TestParseQuotaonState(t *testing.T) {
+	on, found := parseQuotaonState("project quota on /export (/dev/sdb) is on\n")
+	if !on || !found {
+		t.Errorf("parseQuotaonState() = (%v, %v), want (true, true)", on, found)
+	}
+
+	on, found = parseQuotaonState("project quota on /export (/dev/sdb) is off\n")
+	if on || !found {
+		t.Errorf("parseQuotaonState() = (%v, %v), want (false, true)", on, found)
+	}
+}
+
+func TestProjectFileIssues(t *testing.T) {
+	projects := "1001:/export/a\n1002:/export/b\n1002:/export/c\n1003:/other/d\n1004:/export2/e\n"
+	projid := "pv_a:1001\npv_b:1002\npv_x:1999\npv_e:1004\n"
+
+	issues := projectFileIssues(projects, projid, "/export")
+	joined := strings.Join(issues, "\n")
+
+	for _, want := range []string{
+		"project ID 1002 is used by 2 paths",
+		"project ID 1003 (/other/d) has no projid entry",
+		"project ID 1003 path /other/d is outside /export",
+		"project ID 1004 path /export2/e is outside /export",
+		"project pv_x (ID 1999) has no projects entry",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("Expected issue containing %q, got:\n%s", want, joined)
+		}
+	}
+
+	if issues := projectFileIssues("1001:/export/a\n", "pv_a:1001\n", "/export"); len(issues) != 0 {
+		t.Errorf("Expected no issues for consistent files, got %v", issues)
+	}
+}
+
+func TestPathWithin(t *testing.T) {
+	tests := []struct {
+		base, path string
+		want       bool
+	}{
+		{"/export", "/export", true},
+		{"/export", "/export/pv-a", true},
+		{"/export/", "/export/pv-a/sub", true},
+		{"/export", "/export2/pv-a", false},
+		{"/data", "/data2", false},
+		{"/export", "/other", false},
+		{"/export", "/export/..data", true},
+	}
+	for _, tt := range tests {
+		if got := pathWithin(tt.base, tt.path); got != tt.want {
+			t.Errorf("pathWithin(%q, %q) = %v, want %v", tt.base, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Aggregation levels
+const (
+	GroupByNamespace    = "namespace"
+	GroupByStorageClass = "storageclass"
+	GroupByExport       = "export"
+)
+
+// ExportGroup names the single group of a GroupByExport aggregation
+const ExportGroup = "export"
+
+// ValidateGroupBy checks an aggregation level
+func ValidateGroupBy(by string) error {
+	switch by {
+	case GroupByNamespace, GroupByStorageClass, GroupByExport:
+		return nil
+	}
+	return fmt.Errorf("invalid grouping %q (must be %s, %s or %s)", by, GroupByNamespace, GroupByStorageClass, GroupByExport)
+}
+
+// AggregatePoint is the usage of a group summed over its directories at one
+// step
+type AggregatePoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Used      uint64    `json:"used"`
+	Quota     uint64    `json:"quota"`
+	Paths     int       `json:"paths"`
+}
+
+// GroupUsage is the usage of a group of directories over a range. Group is
+// the namespace or StorageClass recorded with the snapshots (empty for
+// directories without a PV) or ExportGroup.
+type GroupUsage struct {
+	Group        string           `json:"group"`
+	Paths        int              `json:"paths"` // directories seen in the range
+	StartUsed    uint64           `json:"startUsed"`
+	Used         uint64           `json:"used"`
+	PeakUsed     uint64           `json:"peakUsed"`
+	Quota        uint64           `json:"quota"`
+	Growth       int64            `json:"growth"`
+	GrowthPct    float64          `json:"growthPct"` // of StartUsed, 0 when it was empty
+	GrowthPerDay float64          `json:"growthPerDay"`
+	Points       []AggregatePoint `json:"points"`
+}
+
+// Aggregate sums the history of all directories per namespace, StorageClass
+// or for the whole export. Each snapshot counts towards the group of the
+// owner recorded with it, so a directory that changed PVs moves between
+// groups. Points are aligned to the resolution returned, the one QueryStep
+// picks for the range; groups are sorted by growth, largest first.
+func (h *Store) Aggregate(by string, start, end time.Time, step time.Duration) ([]GroupUsage, time.Duration, error) {
+	if err := ValidateGroupBy(by); err != nil {
+		return nil, 0, err
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	resolution := h.interval
+	if t := h.resolve(start, step); t != nil {
+		resolution = t.step
+	}
+	if step > resolution {
+		resolution = step
+	}
+
+	type bucket struct {
+		used, quota uint64
+		paths       int
+	}
+	groups := make(map[string]map[time.Time]*bucket)
+	groupPaths := make(map[string]map[string]bool)
+
+	for path := range h.paths() {
+		points, _ := h.queryStep(path, start, end, step)
+
+		// Raw snapshots may be a little off the grid; the last one in a
+		// step counts
+		last := make(map[time.Time]UsageHistory)
+		for _, p := range points {
+			last[p.Timestamp.UTC().Truncate(resolution)] = p
+		}
+
+		for ts, p := range last {
+			group := ExportGroup
+			switch by {
+			case GroupByNamespace:
+				group = p.Namespace
+			case GroupByStorageClass:
+				group = p.StorageClass
+			}
+			if groups[group] == nil {
+				groups[group] = make(map[time.Time]*bucket)
+				groupPaths[group] = make(map[string]bool)
+			}
+			b := groups[group][ts]
+			if b == nil {
+				b = &bucket{}
+				groups[group][ts] = b
+			}
+			b.used += p.Used
+			b.quota += p.Quota
+			b.paths++
+			groupPaths[group][path] = true
+		}
+	}
+
+	result := make([]GroupUsage, 0, len(groups))
+	for group, buckets := range groups {
+		g := GroupUsage{Group: group, Paths: len(groupPaths[group])}
+		for ts, b := range buckets {
+			g.Points = append(g.Points, AggregatePoint{Timestamp: ts, Used: b.used, Quota: b.quota, Paths: b.paths})
+		}
+		sort.Slice(g.Points, func(i, j int) bool {
+			return g.Points[i].Timestamp.Before(g.Points[j].Timestamp)
+		})
+
+		first, last := g.Points[0], g.Points[len(g.Points)-1]
+		g.StartUsed = first.Used
+		g.Used = last.Used
+		g.Quota = last.Quota
+		g.Growth = int64(last.Used) - int64(first.Used)
+		if first.Used > 0 {
+			g.GrowthPct = float64(g.Growth) / float64(first.Used) * 100
+		}
+		if span := last.Timestamp.Sub(first.Timestamp); span > 0 {
+			g.GrowthPerDay = float64(g.Growth) / span.Hours() * 24
+		}
+		for _, p := range g.Points {
+			if p.Used > g.PeakUsed {
+				g.PeakUsed = p.Used
+			}
+		}
+		result = append(result, g)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Growth != result[j].Growth {
+			return result[i].Growth > result[j].Growth
+		}
+		return result[i].Group < result[j].Group
+	})
+	return result, resolution, nil
+}
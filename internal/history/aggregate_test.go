@@ -0,0 +1,85 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dasomel/nfs-quota-agent/internal/status"
+)
+
+func TestAggregate(t *testing.T) {
+	start := time.Now().Add(-6 * time.Hour).Truncate(time.Hour)
+	c := &clock{t: start}
+	store := newTestStore(t, filepath.Join(t.TempDir(), "history"), c, Retention{Raw: 48 * time.Hour})
+
+	a := Owner{PVName: "pv-a", Namespace: "team-a", StorageClass: "fast"}
+	b := Owner{PVName: "pv-b", Namespace: "team-a", StorageClass: "slow"}
+	other := Owner{PVName: "pv-c", Namespace: "team-b", StorageClass: "slow"}
+	for i := uint64(0); i < 3; i++ {
+		if err := store.Record([]status.DirUsage{
+			{Path: "/data/a", Used: 100 + i*50, Quota: 1000},
+			{Path: "/data/b", Used: 200, Quota: 500},
+			{Path: "/data/c", Used: 300 - i*100, Quota: 400},
+			{Path: "/data/orphan", Used: 5},
+		}, map[string]Owner{"/data/a": a, "/data/b": b, "/data/c": other}); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+		c.t = c.t.Add(time.Hour)
+	}
+
+	tests := []struct {
+		by     string
+		groups map[string][3]int64 // paths, used, growth
+	}{
+		{GroupByNamespace, map[string][3]int64{"team-a": {2, 400, 100}, "team-b": {1, 100, -200}, "": {1, 5, 0}}},
+		{GroupByStorageClass, map[string][3]int64{"fast": {1, 200, 100}, "slow": {2, 300, -200}, "": {1, 5, 0}}},
+		{GroupByExport, map[string][3]int64{ExportGroup: {4, 505, -100}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.by, func(t *testing.T) {
+			groups, step, err := store.Aggregate(tt.by, start, c.t, 0)
+			if err != nil {
+				t.Fatalf("Aggregate failed: %v", err)
+			}
+			if step != 5*time.Minute {
+				t.Errorf("Expected raw resolution, got %v", step)
+			}
+			if len(groups) != len(tt.groups) {
+				t.Fatalf("Expected %d groups, got %+v", len(tt.groups), groups)
+			}
+			for i, g := range groups {
+				want := tt.groups[g.Group]
+				if int64(g.Paths) != want[0] || int64(g.Used) != want[1] || g.Growth != want[2] {
+					t.Errorf("Group %q: expected paths/used/growth %v, got %d/%d/%d", g.Group, want, g.Paths, g.Used, g.Growth)
+				}
+				if len(g.Points) != 3 {
+					t.Errorf("Group %q: expected 3 points, got %d", g.Group, len(g.Points))
+				}
+				if i > 0 && g.Growth > groups[i-1].Growth {
+					t.Errorf("Expected groups sorted by growth, got %+v", groups)
+				}
+			}
+		})
+	}
+
+	if _, _, err := store.Aggregate("pvc", start, c.t, 0); err == nil {
+		t.Error("Expected an error for an unknown grouping")
+	}
+}
@@ -0,0 +1,427 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/dasomel/nfs-quota-agent/internal/util"
+)
+
+// Anomaly kinds
+const (
+	// AnomalyGrowth is usage growing much faster than the path's baseline,
+	// e.g. a runaway log writer
+	AnomalyGrowth = "growth"
+	// AnomalyDrop is usage falling suddenly, e.g. data loss or an
+	// unexpected cleanup
+	AnomalyDrop = "drop"
+)
+
+const (
+	// anomalyFile keeps recent anomalies in the history directory
+	anomalyFile = "anomalies.jsonl"
+
+	// anomalyKeep and maxAnomalies bound the anomalies kept
+	anomalyKeep  = 30 * 24 * time.Hour
+	maxAnomalies = 500
+
+	// minBaselineSamples is how many growth rates a path needs before it
+	// is checked
+	minBaselineSamples = 6
+
+	// maxBaselineSamples bounds the growth rates kept per path
+	maxBaselineSamples = 2000
+
+	// growthFactor: growth is only abnormal at this multiple of the
+	// baseline rate or more, however steady the baseline is
+	growthFactor = 2
+
+	// madScale turns a median absolute deviation into a standard deviation
+	madScale = 1.4826
+
+	// minSpread is the smallest spread of growth rates (1 byte per hour)
+	minSpread = 1.0 / 3600
+)
+
+// AnomalyConfig configures anomaly detection
+type AnomalyConfig struct {
+	Enabled     bool
+	Baseline    time.Duration // history a path's usual growth rate is taken from
+	Sensitivity float64       // robust z-score above which a rate is abnormal
+	MinGrowth   uint64        // growth per hour below which nothing is flagged
+	MinDrop     uint64        // smallest drop between two samples flagged
+}
+
+// Anomaly is abnormal growth or a sudden drop of one path's usage,
+// compared with its own recent growth rates
+type Anomaly struct {
+	Path       string     `json:"path"`
+	DirName    string     `json:"dirName"`
+	Kind       string     `json:"kind"`
+	DetectedAt time.Time  `json:"detectedAt"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+	Previous   uint64     `json:"previous"` // usage before the anomaly
+	Used       uint64     `json:"used"`     // usage at detection (at the peak rate for growth)
+	Quota      uint64     `json:"quota"`
+	Rate       float64    `json:"rate"`     // bytes per hour (peak for growth)
+	Baseline   float64    `json:"baseline"` // usual bytes per hour
+	Score      float64    `json:"score"`    // deviations from the baseline
+	Message    string     `json:"message"`
+}
+
+// Active reports whether the anomaly is still going on
+func (a Anomaly) Active() bool {
+	return a.ResolvedAt == nil
+}
+
+// baseline is the recent growth of one path
+type baseline struct {
+	last  UsageHistory
+	rates []float64 // bytes per second between consecutive samples, oldest first
+}
+
+// SetAnomalyDetection configures anomaly detection
+func (h *Store) SetAnomalyDetection(cfg AnomalyConfig) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.anomalyConfig = cfg
+	h.baselines = make(map[string]*baseline)
+}
+
+// AnomalyDetection returns the anomaly detection settings
+func (h *Store) AnomalyDetection() AnomalyConfig {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.anomalyConfig
+}
+
+// SetAnomalyHandler sets a function called (outside the store lock) for
+// each anomaly when it is first detected
+func (h *Store) SetAnomalyHandler(fn func(Anomaly)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onAnomaly = fn
+}
+
+// Anomalies returns the anomalies detected since the given time (zero = all
+// kept), newest first
+func (h *Store) Anomalies(since time.Time) []Anomaly {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var result []Anomaly
+	for i := len(h.anomalies) - 1; i >= 0; i-- {
+		a := h.anomalies[i]
+		if a.DetectedAt.Before(since) && !a.Active() {
+			continue
+		}
+		result = append(result, a)
+	}
+	return result
+}
+
+// AnomalyCounts returns how many anomalies of each kind were detected
+// since the store was opened
+func (h *Store) AnomalyCounts() map[string]int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	counts := map[string]int{AnomalyGrowth: 0, AnomalyDrop: 0}
+	for kind, n := range h.anomalyCounts {
+		counts[kind] = n
+	}
+	return counts
+}
+
+// detectAnomalies checks a new snapshot against each path's baseline,
+// resolves anomalies that are over and returns the new ones (must be called
+// with lock held, before the snapshot is appended)
+func (h *Store) detectAnomalies(entries []UsageHistory) []Anomaly {
+	cfg := h.anomalyConfig
+	if !cfg.Enabled || len(entries) == 0 {
+		return nil
+	}
+	if h.baselines == nil {
+		h.baselines = make(map[string]*baseline)
+	}
+	if h.anomalyCounts == nil {
+		h.anomalyCounts = make(map[string]int)
+	}
+
+	active := make(map[string]int)
+	for i, a := range h.anomalies {
+		if a.Active() {
+			active[anomalyKey(a.Path, a.Kind)] = i
+		}
+	}
+
+	var raised []Anomaly
+	changed := false
+	for _, e := range entries {
+		b := h.baselines[e.Path]
+		if b == nil {
+			b = h.seedBaseline(e.Path, e.Timestamp, cfg.Baseline)
+			h.baselines[e.Path] = b
+		}
+		// A carried size is not a measurement; the next walk is compared
+		// with the last one over the whole time between them
+		if e.Carried {
+			continue
+		}
+		if b.last.Timestamp.IsZero() || !e.Timestamp.After(b.last.Timestamp) {
+			b.last = e
+			continue
+		}
+		if b.last.PVName != "" && e.PVName != "" && b.last.PVName != e.PVName {
+			// A new PV at the same path: its usage has nothing to do with
+			// the old one's
+			*b = baseline{last: e}
+			continue
+		}
+
+		rate := (float64(e.Used) - float64(b.last.Used)) / e.Timestamp.Sub(b.last.Timestamp).Seconds()
+		kind, base, score := classify(b.rates, rate, b.last.Used, e.Used, cfg)
+
+		for _, k := range []string{AnomalyGrowth, AnomalyDrop} {
+			i, ok := active[anomalyKey(e.Path, k)]
+			switch {
+			case ok && k == kind:
+				// Still going on: keep the peak
+				if k == AnomalyGrowth && rate*3600 > h.anomalies[i].Rate {
+					h.anomalies[i].Rate = rate * 3600
+					h.anomalies[i].Used = e.Used
+					h.anomalies[i].Score = score
+					changed = true
+				}
+			case ok:
+				resolved := e.Timestamp
+				h.anomalies[i].ResolvedAt = &resolved
+				delete(active, anomalyKey(e.Path, k))
+				changed = true
+			case k == kind:
+				a := Anomaly{
+					Path:       e.Path,
+					DirName:    e.DirName,
+					Kind:       kind,
+					DetectedAt: e.Timestamp,
+					Previous:   b.last.Used,
+					Used:       e.Used,
+					Quota:      e.Quota,
+					Rate:       rate * 3600,
+					Baseline:   base * 3600,
+					Score:      score,
+				}
+				a.Message = anomalyMessage(a)
+				h.anomalies = append(h.anomalies, a)
+				active[anomalyKey(e.Path, k)] = len(h.anomalies) - 1
+				h.anomalyCounts[kind]++
+				raised = append(raised, a)
+				changed = true
+			}
+		}
+
+		// Abnormal rates would drag the baseline along with them
+		if kind == "" {
+			b.rates = append(b.rates, rate)
+			if limit := baselineLimit(cfg.Baseline, h.interval); len(b.rates) > limit {
+				b.rates = b.rates[len(b.rates)-limit:]
+			}
+		}
+		b.last = e
+	}
+
+	// Directories that are gone take their anomalies with them
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		seen[e.Path] = true
+	}
+	for _, i := range active {
+		if !seen[h.anomalies[i].Path] {
+			resolved := entries[0].Timestamp
+			h.anomalies[i].ResolvedAt = &resolved
+			delete(h.baselines, h.anomalies[i].Path)
+			changed = true
+		}
+	}
+
+	if changed {
+		h.trimAnomalies(entries[0].Timestamp)
+		if err := h.saveAnomalies(); err != nil {
+			slog.Warn("Failed to save usage anomalies", "error", err)
+		}
+	}
+	return raised
+}
+
+func anomalyKey(path, kind string) string {
+	return path + "/" + kind
+}
+
+// seedBaseline builds the baseline of a path from its recorded history
+// (must be called with lock held)
+func (h *Store) seedBaseline(path string, now time.Time, window time.Duration) *baseline {
+	b := &baseline{}
+	for _, p := range currentOwner(h.query(path, now.Add(-window), now)) {
+		if p.Carried {
+			continue
+		}
+		if !b.last.Timestamp.IsZero() && p.Timestamp.After(b.last.Timestamp) {
+			b.rates = append(b.rates, (float64(p.Used)-float64(b.last.Used))/p.Timestamp.Sub(b.last.Timestamp).Seconds())
+		}
+		b.last = p
+	}
+	if limit := baselineLimit(window, h.interval); len(b.rates) > limit {
+		b.rates = b.rates[len(b.rates)-limit:]
+	}
+	return b
+}
+
+// baselineLimit returns how many rates cover the baseline window
+func baselineLimit(window, interval time.Duration) int {
+	if interval <= 0 {
+		return maxBaselineSamples
+	}
+	n := int(window / interval)
+	if n < minBaselineSamples {
+		n = minBaselineSamples
+	}
+	if n > maxBaselineSamples {
+		n = maxBaselineSamples
+	}
+	return n
+}
+
+// classify compares a growth rate (bytes/second) from prev to used against
+// the baseline rates. It returns the anomaly kind ("" for none), the
+// baseline rate and the robust z-score of the rate.
+func classify(rates []float64, rate float64, prev, used uint64, cfg AnomalyConfig) (string, float64, float64) {
+	if len(rates) < minBaselineSamples {
+		return "", 0, 0
+	}
+
+	sorted := append([]float64(nil), rates...)
+	base := median(sorted)
+	deviations := make([]float64, len(rates))
+	for i, r := range rates {
+		deviations[i] = math.Abs(r - base)
+	}
+	// A perfectly steady baseline still gets some spread so scores stay
+	// finite; MinGrowth, MinDrop and growthFactor keep it from flagging
+	// noise
+	spread := math.Max(madScale*median(deviations), math.Max(0.01*math.Abs(base), minSpread))
+	score := (rate - base) / spread
+
+	switch {
+	case rate*3600 >= float64(cfg.MinGrowth) &&
+		score > cfg.Sensitivity &&
+		rate >= growthFactor*base:
+		return AnomalyGrowth, base, score
+	case used < prev &&
+		prev-used >= cfg.MinDrop &&
+		score < -cfg.Sensitivity:
+		return AnomalyDrop, base, score
+	}
+	return "", base, score
+}
+
+// anomalyMessage describes an anomaly for logs, events and audit entries
+func anomalyMessage(a Anomaly) string {
+	if a.Kind == AnomalyDrop {
+		pct := 100.0
+		if a.Previous > 0 {
+			pct = float64(a.Previous-a.Used) / float64(a.Previous) * 100
+		}
+		return fmt.Sprintf("usage of %s dropped by %s (%.0f%%) to %s",
+			a.DirName, util.FormatBytes(int64(a.Previous-a.Used)), pct, util.FormatBytes(int64(a.Used)))
+	}
+	return fmt.Sprintf("usage of %s growing %s/h, baseline %s/h, now %s",
+		a.DirName, util.FormatBytes(int64(a.Rate)), formatRate(a.Baseline), util.FormatBytes(int64(a.Used)))
+}
+
+func formatRate(perHour float64) string {
+	if perHour < 0 {
+		return "-" + util.FormatBytes(int64(-perHour))
+	}
+	return util.FormatBytes(int64(perHour))
+}
+
+// trimAnomalies drops resolved anomalies older than anomalyKeep and caps
+// the list at maxAnomalies (must be called with lock held)
+func (h *Store) trimAnomalies(now time.Time) {
+	cutoff := now.Add(-anomalyKeep)
+	kept := h.anomalies[:0]
+	for _, a := range h.anomalies {
+		if a.Active() || !a.DetectedAt.Before(cutoff) {
+			kept = append(kept, a)
+		}
+	}
+	if len(kept) > maxAnomalies {
+		kept = kept[len(kept)-maxAnomalies:]
+	}
+	h.anomalies = kept
+}
+
+// loadAnomalies reads the kept anomalies from the history directory
+func (h *Store) loadAnomalies() error {
+	data, err := os.ReadFile(filepath.Join(h.dir, anomalyFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var a Anomaly
+		if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+			return err
+		}
+		h.anomalies = append(h.anomalies, a)
+	}
+	sort.SliceStable(h.anomalies, func(i, j int) bool {
+		return h.anomalies[i].DetectedAt.Before(h.anomalies[j].DetectedAt)
+	})
+	return scanner.Err()
+}
+
+// saveAnomalies rewrites the kept anomalies (must be called with lock held)
+func (h *Store) saveAnomalies() error {
+	var buf bytes.Buffer
+	for _, a := range h.anomalies {
+		line, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return util.WriteFileAtomic(filepath.Join(h.dir, anomalyFile), buf.Bytes(), 0644)
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dasomel/nfs-quota-agent/internal/status"
+)
+
+func TestClassify(t *testing.T) {
+	const (
+		mi = 1 << 20
+		gi = 1 << 30
+	)
+	perHour := func(bytes float64) float64 { return bytes / 3600 }
+	// about 100Mi/h with some noise
+	noisy := []float64{perHour(90 * mi), perHour(110 * mi), perHour(100 * mi), perHour(95 * mi), perHour(105 * mi), perHour(100 * mi)}
+	flat := []float64{0, 0, 0, 0, 0, 0}
+	cfg := AnomalyConfig{Enabled: true, Baseline: 24 * time.Hour, Sensitivity: 6, MinGrowth: gi, MinDrop: gi}
+
+	tests := []struct {
+		name  string
+		rates []float64
+		rate  float64
+		prev  uint64
+		used  uint64
+		want  string
+	}{
+		{"usual growth", noisy, perHour(120 * mi), 10 * gi, 10*gi + 10*mi, ""},
+		{"runaway writer", noisy, perHour(50 * gi), 10 * gi, 14 * gi, AnomalyGrowth},
+		{"fast but below min growth", flat, perHour(500 * mi), 10 * gi, 10*gi + 40*mi, ""},
+		{"flat then growing", flat, perHour(2 * gi), 10 * gi, 10*gi + 170*mi, AnomalyGrowth},
+		{"sudden drop", noisy, -perHour(200 * gi), 40 * gi, 20 * gi, AnomalyDrop},
+		{"drop below min drop", noisy, -perHour(2 * gi), 10 * gi, 10*gi - 200*mi, ""},
+		{"too little baseline", noisy[:3], perHour(50 * gi), 10 * gi, 14 * gi, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, _ := classify(tt.rates, tt.rate, tt.prev, tt.used, cfg)
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestStoreAnomalies(t *testing.T) {
+	const (
+		mi = 1 << 20
+		gi = 1 << 30
+	)
+	dir := filepath.Join(t.TempDir(), "history")
+	c := &clock{t: time.Now().Add(-5 * time.Hour)}
+	store := newTestStore(t, dir, c, Retention{Raw: 48 * time.Hour})
+	store.SetAnomalyDetection(AnomalyConfig{
+		Enabled:     true,
+		Baseline:    24 * time.Hour,
+		Sensitivity: 6,
+		MinGrowth:   gi,
+		MinDrop:     gi,
+	})
+	var raised []Anomaly
+	store.SetAnomalyHandler(func(a Anomaly) { raised = append(raised, a) })
+
+	logs := uint64(10 * gi)
+	db := uint64(50 * gi)
+	record := func() {
+		t.Helper()
+		if err := store.Record([]status.DirUsage{
+			{Path: "/data/logs", Used: logs, Quota: 500 * gi},
+			{Path: "/data/db", Used: db, Quota: 100 * gi},
+		}, nil); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+		c.t = c.t.Add(5 * time.Minute)
+	}
+
+	// Baseline: logs grow about 10Mi per sample, db is steady
+	for i := 0; i < 12; i++ {
+		logs += uint64(8+i%5) * mi
+		record()
+	}
+	if len(raised) != 0 {
+		t.Fatalf("Expected no anomalies on the baseline, got %+v", raised)
+	}
+
+	// A runaway writer: 5Gi per sample for three samples, raised once
+	for i := 0; i < 3; i++ {
+		logs += 5 * gi
+		record()
+	}
+	if len(raised) != 1 || raised[0].Kind != AnomalyGrowth || raised[0].Path != "/data/logs" {
+		t.Fatalf("Expected one growth anomaly for /data/logs, got %+v", raised)
+	}
+	if active := store.Anomalies(time.Time{}); len(active) != 1 || !active[0].Active() {
+		t.Fatalf("Expected the growth anomaly to be active, got %+v", active)
+	}
+
+	// Back to normal, and the database loses most of its data
+	logs += 10 * mi
+	db = 5 * gi
+	record()
+	if len(raised) != 2 || raised[1].Kind != AnomalyDrop || raised[1].Path != "/data/db" {
+		t.Fatalf("Expected a drop anomaly for /data/db, got %+v", raised)
+	}
+	anomalies := store.Anomalies(time.Time{})
+	if len(anomalies) != 2 || anomalies[1].Active() {
+		t.Fatalf("Expected the growth anomaly resolved, got %+v", anomalies)
+	}
+
+	// Kept across restarts
+	reopened, err := NewStore(dir, 5*time.Minute, Retention{Raw: 48 * time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to reopen history store: %v", err)
+	}
+	if got := reopened.Anomalies(time.Time{}); len(got) != 2 || got[0].Kind != AnomalyDrop {
+		t.Errorf("Expected 2 anomalies after reopening, newest first, got %+v", got)
+	}
+	if counts := store.AnomalyCounts(); counts[AnomalyGrowth] != 1 || counts[AnomalyDrop] != 1 {
+		t.Errorf("Expected one anomaly of each kind, got %v", counts)
+	}
+}
+
+func TestStoreAnomaliesCarried(t *testing.T) {
+	const mi = 1 << 20
+	dir := filepath.Join(t.TempDir(), "history")
+	c := &clock{t: time.Now().Add(-5 * time.Hour)}
+	store := newTestStore(t, dir, c, Retention{Raw: 48 * time.Hour})
+	store.SetAnomalyDetection(AnomalyConfig{Enabled: true, Baseline: 24 * time.Hour, Sensitivity: 6, MinGrowth: 1 << 30, MinDrop: 1 << 30})
+	var raised []Anomaly
+	store.SetAnomalyHandler(func(a Anomaly) { raised = append(raised, a) })
+
+	used := uint64(10 << 30)
+	record := func(carried bool) {
+		t.Helper()
+		if err := store.Record([]status.DirUsage{{Path: "/data/scratch", Used: used, Carried: carried}}, nil); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+		c.t = c.t.Add(5 * time.Minute)
+	}
+
+	// Walked every snapshot: about 500Mi per snapshot
+	for i := 0; i < 12; i++ {
+		used += uint64(450+i%5*25) * mi
+		record(false)
+	}
+	// Carried for half an hour, then walked again with the growth since
+	walked := used
+	for i := 0; i < 6; i++ {
+		record(true)
+		walked += 500 * mi
+	}
+	used = walked + 500*mi
+	record(false)
+
+	if len(raised) != 0 {
+		t.Errorf("Expected the walk after carried sizes to match the baseline, got %+v", raised)
+	}
+	if f := store.Forecast("/data/scratch", 24*time.Hour, MethodLinear); f == nil || f.Points != 13 {
+		t.Errorf("Expected the forecast to fit only the 13 walked snapshots, got %+v", f)
+	}
+}
@@ -0,0 +1,322 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dasomel/nfs-quota-agent/internal/status"
+	"github.com/dasomel/nfs-quota-agent/internal/util"
+)
+
+// ForecastOptions configures the forecast command
+type ForecastOptions struct {
+	HistoryPath string
+	BasePath    string // export path, for the disk forecast
+	Dir         string // only this directory (path or name)
+	Window      time.Duration
+	Method      string
+	Output      string // "table" or "json"
+}
+
+// ForecastReport is the output of the forecast command
+type ForecastReport struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Method    string          `json:"method"`
+	Window    string          `json:"window"`
+	Forecasts []Forecast      `json:"forecasts"`
+	Export    *ExportForecast `json:"export,omitempty"`
+}
+
+// RunForecast prints time-to-full forecasts from the agent's history
+func RunForecast(opts ForecastOptions) error {
+	if err := ValidateMethod(opts.Method); err != nil {
+		return err
+	}
+	store, err := Open(opts.HistoryPath)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+
+	all := store.ForecastAll(opts.Window, opts.Method)
+	forecasts := all
+	if opts.Dir != "" {
+		forecasts = nil
+		for _, f := range all {
+			if f.Path == opts.Dir || f.DirName == filepath.Base(opts.Dir) {
+				forecasts = append(forecasts, f)
+			}
+		}
+	}
+
+	now := time.Now()
+	report := ForecastReport{
+		Timestamp: now,
+		Method:    opts.Method,
+		Window:    opts.Window.String(),
+		Forecasts: forecasts,
+	}
+	if report.Forecasts == nil {
+		report.Forecasts = []Forecast{}
+	}
+	if opts.Dir == "" && opts.BasePath != "" {
+		if disk, err := status.GetDiskUsage(opts.BasePath); err == nil {
+			report.Export = ForecastExport(all, opts.BasePath, disk, now)
+		}
+	}
+
+	if opts.Output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	if len(forecasts) == 0 {
+		fmt.Println("No usage history to forecast from.")
+		return nil
+	}
+
+	fmt.Printf("Forecast from %s of history (%s fit)\n\n", util.FormatDuration(opts.Window), opts.Method)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DIRECTORY\tUSED\tQUOTA\tGROWTH/DAY\tFULL IN\tFULL AT\tR²")
+	for _, f := range forecasts {
+		quota := "-"
+		if f.Quota > 0 {
+			quota = util.FormatBytes(int64(f.Quota))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%.2f\n",
+			f.DirName, util.FormatBytes(int64(f.Used)), quota, formatGrowth(f.GrowthPerDay),
+			fullIn(now, f.FullAt), fullAt(f.FullAt), f.R2)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if e := report.Export; e != nil {
+		fmt.Printf("\nExport %s: %s of %s used, %s/day, full in %s (%s)\n",
+			e.Path, util.FormatBytes(int64(e.Used)), util.FormatBytes(int64(e.Total)),
+			formatGrowth(e.GrowthPerDay), fullIn(now, e.FullAt), fullAt(e.FullAt))
+	}
+	return nil
+}
+
+func formatGrowth(perDay float64) string {
+	if perDay < 0 {
+		return "-" + util.FormatBytes(int64(-perDay))
+	}
+	return "+" + util.FormatBytes(int64(perDay))
+}
+
+func fullIn(now time.Time, t *time.Time) string {
+	if t == nil {
+		return "never"
+	}
+	if !t.After(now) {
+		return "full"
+	}
+	return util.FormatDuration(t.Sub(now))
+}
+
+func fullAt(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+// ExportOptions configures the history export command
+type ExportOptions struct {
+	HistoryPath string
+	Format      string
+	Output      string // file to write, "-" or empty for stdout
+	Filter      ExportFilter
+}
+
+// RunExport writes the agent's history as CSV, JSON or Parquet
+func RunExport(opts ExportOptions) error {
+	store, err := Open(opts.HistoryPath)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+
+	out := os.Stdout
+	if opts.Output != "" && opts.Output != "-" {
+		f, err := os.Create(opts.Output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	w, err := NewRecordWriter(out, opts.Format)
+	if err != nil {
+		return err
+	}
+	count := 0
+	if err := store.Export(opts.Filter, func(r ExportRecord) error {
+		count++
+		return w.Write(r)
+	}); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if out != os.Stdout {
+		if err := out.Sync(); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Exported %d history points to %s\n", count, opts.Output)
+	}
+	return nil
+}
+
+// ImportOptions configures the history import command
+type ImportOptions struct {
+	HistoryPath string
+	Input       string // file to read, "-" for stdin
+	Format      string // csv or json; empty guesses from the file extension
+	Interval    time.Duration
+	Retention   Retention
+}
+
+// RunImport merges an exported history file into a history directory. The
+// agent writing to that directory must be stopped first.
+func RunImport(opts ImportOptions) error {
+	format := opts.Format
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(opts.Input), ".")
+	}
+
+	in := os.Stdin
+	if opts.Input != "-" {
+		f, err := os.Open(opts.Input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	records, err := ReadRecords(in, format)
+	if err != nil {
+		return err
+	}
+
+	store, err := NewStore(opts.HistoryPath, opts.Interval, opts.Retention)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	result, err := store.Import(records)
+	if err != nil {
+		return fmt.Errorf("import failed after %d points: %w", result.Imported, err)
+	}
+	fmt.Printf("Imported %d history points into %s (%d already present, %d skipped)\n",
+		result.Imported, store.Dir(), result.Duplicates, result.Skipped)
+	return nil
+}
+
+// SummaryOptions configures the history summary command
+type SummaryOptions struct {
+	HistoryPath string
+	GroupBy     string // namespace, storageclass or export
+	Since       time.Time
+	Until       time.Time // zero for now
+	Step        time.Duration
+	Output      string // "table" or "json"
+}
+
+// SummaryReport is the output of the history summary command
+type SummaryReport struct {
+	Timestamp time.Time    `json:"timestamp"`
+	GroupBy   string       `json:"groupBy"`
+	Since     time.Time    `json:"since"`
+	Until     time.Time    `json:"until"`
+	Step      string       `json:"step"`
+	Groups    []GroupUsage `json:"groups"`
+}
+
+// RunSummary prints the usage growth of each namespace, StorageClass or the
+// whole export over a period
+func RunSummary(opts SummaryOptions) error {
+	if err := ValidateGroupBy(opts.GroupBy); err != nil {
+		return err
+	}
+	store, err := Open(opts.HistoryPath)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+
+	now := time.Now()
+	until := opts.Until
+	if until.IsZero() {
+		until = now
+	}
+	groups, step, err := store.Aggregate(opts.GroupBy, opts.Since, until, opts.Step)
+	if err != nil {
+		return err
+	}
+	report := SummaryReport{
+		Timestamp: now,
+		GroupBy:   opts.GroupBy,
+		Since:     opts.Since,
+		Until:     until,
+		Step:      step.String(),
+		Groups:    groups,
+	}
+
+	if opts.Output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	if len(groups) == 0 {
+		fmt.Println("No usage history in this period.")
+		return nil
+	}
+
+	fmt.Printf("Usage growth by %s from %s to %s (%s steps)\n\n", opts.GroupBy,
+		opts.Since.Local().Format("2006-01-02 15:04"), until.Local().Format("2006-01-02 15:04"), util.FormatDuration(step))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\tDIRS\tSTART\tUSED\tPEAK\tQUOTA\tGROWTH\tGROWTH %%\tGROWTH/DAY\n", strings.ToUpper(opts.GroupBy))
+	for _, g := range groups {
+		name := g.Group
+		if name == "" {
+			name = "-"
+		}
+		quota := "-"
+		if g.Quota > 0 {
+			quota = util.FormatBytes(int64(g.Quota))
+		}
+		pct := "-"
+		if g.StartUsed > 0 {
+			pct = fmt.Sprintf("%+.1f%%", g.GrowthPct)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			name, g.Paths, util.FormatBytes(int64(g.StartUsed)), util.FormatBytes(int64(g.Used)),
+			util.FormatBytes(int64(g.PeakUsed)), quota, formatGrowth(float64(g.Growth)), pct,
+			formatGrowth(g.GrowthPerDay))
+	}
+	return w.Flush()
+}
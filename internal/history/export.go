@@ -0,0 +1,667 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats
+const (
+	FormatCSV     = "csv"
+	FormatJSON    = "json"
+	FormatParquet = "parquet"
+)
+
+// Resolutions of exported points
+const (
+	ResolutionRaw    = "raw"
+	ResolutionHourly = "hourly"
+	ResolutionDaily  = "daily"
+)
+
+// ExportRecord is one exported history point. Each day is exported at the
+// finest resolution still kept for it, so recent days are raw snapshots and
+// older ones hourly or daily rollups.
+type ExportRecord struct {
+	Resolution string `json:"resolution"`
+	UsageHistory
+}
+
+// ExportFilter selects the history to export; zero values match everything
+type ExportFilter struct {
+	Path  string // directory path or name
+	Since time.Time
+	Until time.Time
+	Selector
+}
+
+func (f ExportFilter) matches(e UsageHistory) bool {
+	if f.Path != "" && e.Path != f.Path && e.DirName != filepath.Base(f.Path) {
+		return false
+	}
+	if !f.Selector.Matches(e.Owner) {
+		return false
+	}
+	if !f.Since.IsZero() && e.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Timestamp.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// exportDay is one day of history to export at one resolution
+type exportDay struct {
+	dir        string
+	seg        *segment
+	resolution string
+}
+
+// Export calls fn for every point matching filter, oldest day first and
+// ordered by timestamp and path within a day. The store is locked only
+// while each day is read, so collection carries on during long exports.
+func (h *Store) Export(filter ExportFilter, fn func(ExportRecord) error) error {
+	for _, d := range h.exportPlan(filter) {
+		records, err := h.exportRecords(d, filter)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // pruned since the plan was made
+			}
+			return err
+		}
+		for _, r := range records {
+			if err := fn(r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// exportPlan picks the segments to export: raw days, then the finest rollup
+// of each day without raw snapshots
+func (h *Store) exportPlan(filter ExportFilter) []exportDay {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	overlaps := func(s *segment) bool {
+		if s.entries == 0 {
+			return false
+		}
+		return (filter.Since.IsZero() || !s.end.Before(filter.Since)) &&
+			(filter.Until.IsZero() || !s.start.After(filter.Until))
+	}
+
+	covered := make(map[time.Time]bool)
+	var plan []exportDay
+	for _, s := range h.segments() {
+		covered[s.day] = true
+		if overlaps(s) {
+			plan = append(plan, exportDay{h.dir, s, ResolutionRaw})
+		}
+	}
+	for _, t := range h.tiers {
+		for _, s := range t.segments {
+			if covered[s.day] {
+				continue
+			}
+			covered[s.day] = true
+			if overlaps(s) {
+				plan = append(plan, exportDay{t.dir, s, t.name})
+			}
+		}
+	}
+
+	sort.SliceStable(plan, func(i, j int) bool { return plan[i].seg.day.Before(plan[j].seg.day) })
+	return plan
+}
+
+// exportRecords reads the matching points of one planned day
+func (h *Store) exportRecords(d exportDay, filter ExportFilter) ([]ExportRecord, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	samples, err := d.seg.readAll(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	var records []ExportRecord
+	for _, points := range samples {
+		for _, e := range points {
+			if filter.matches(e) {
+				records = append(records, ExportRecord{Resolution: d.resolution, UsageHistory: e})
+			}
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].Timestamp.Equal(records[j].Timestamp) {
+			return records[i].Timestamp.Before(records[j].Timestamp)
+		}
+		return records[i].Path < records[j].Path
+	})
+	return records, nil
+}
+
+// RecordWriter writes export records in one format
+type RecordWriter interface {
+	Write(ExportRecord) error
+	// Close finishes the output; it does not close the underlying writer
+	Close() error
+}
+
+// NewRecordWriter returns a writer for format (csv, json or parquet)
+func NewRecordWriter(w io.Writer, format string) (RecordWriter, error) {
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return nil, err
+		}
+		return &csvWriter{w: cw}, nil
+	case FormatJSON:
+		return &jsonWriter{w: bufio.NewWriter(w)}, nil
+	case FormatParquet:
+		return newParquetWriter(w)
+	default:
+		return nil, fmt.Errorf("unknown export format %q (use csv, json or parquet)", format)
+	}
+}
+
+// ContentType returns the MIME type of an export format
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv"
+	case FormatParquet:
+		return "application/vnd.apache.parquet"
+	default:
+		return "application/json"
+	}
+}
+
+var csvHeader = []string{"timestamp", "resolution", "path", "dir_name", "used", "quota", "used_pct", "min_used", "max_used", "samples",
+	"pv_name", "pvc_name", "namespace", "storage_class", "project_id"}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Write(r ExportRecord) error {
+	return c.w.Write([]string{
+		r.Timestamp.UTC().Format(time.RFC3339Nano),
+		r.Resolution,
+		r.Path,
+		r.DirName,
+		strconv.FormatUint(r.Used, 10),
+		strconv.FormatUint(r.Quota, 10),
+		strconv.FormatFloat(r.UsedPct, 'f', -1, 64),
+		strconv.FormatUint(r.MinUsed, 10),
+		strconv.FormatUint(r.MaxUsed, 10),
+		strconv.Itoa(r.Samples),
+		r.PVName,
+		r.PVCName,
+		r.Namespace,
+		r.StorageClass,
+		strconv.FormatUint(uint64(r.ProjectID), 10),
+	})
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonWriter streams records as one JSON array
+type jsonWriter struct {
+	w     *bufio.Writer
+	count int
+}
+
+func (j *jsonWriter) Write(r ExportRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	sep := ",\n  "
+	if j.count == 0 {
+		sep = "[\n  "
+	}
+	j.count++
+	if _, err := j.w.WriteString(sep); err != nil {
+		return err
+	}
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonWriter) Close() error {
+	end := "\n]\n"
+	if j.count == 0 {
+		end = "[]\n"
+	}
+	if _, err := j.w.WriteString(end); err != nil {
+		return err
+	}
+	return j.w.Flush()
+}
+
+// ReadRecords reads records written by a csv or json RecordWriter
+func ReadRecords(r io.Reader, format string) ([]ExportRecord, error) {
+	switch format {
+	case FormatJSON:
+		var records []ExportRecord
+		if err := json.NewDecoder(r).Decode(&records); err != nil {
+			return nil, fmt.Errorf("failed to parse JSON history: %w", err)
+		}
+		return records, nil
+	case FormatCSV:
+		return readCSV(r)
+	case FormatParquet:
+		return nil, fmt.Errorf("importing parquet is not supported, export as csv or json instead")
+	default:
+		return nil, fmt.Errorf("unknown import format %q (use csv or json)", format)
+	}
+}
+
+func readCSV(r io.Reader) ([]ExportRecord, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	column := make(map[string]int, len(header))
+	for i, name := range header {
+		column[name] = i
+	}
+	for _, name := range []string{"timestamp", "path", "used"} {
+		if _, ok := column[name]; !ok {
+			return nil, fmt.Errorf("CSV history has no %q column", name)
+		}
+	}
+	field := func(row []string, name string) string {
+		if i, ok := column[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	uintField := func(row []string, name string) (uint64, error) {
+		if s := field(row, name); s != "" {
+			return strconv.ParseUint(s, 10, 64)
+		}
+		return 0, nil
+	}
+
+	var records []ExportRecord
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var rec ExportRecord
+		fail := func(err error) ([]ExportRecord, error) {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if rec.Timestamp, err = time.Parse(time.RFC3339Nano, field(row, "timestamp")); err != nil {
+			return fail(err)
+		}
+		rec.Resolution = field(row, "resolution")
+		rec.Path = field(row, "path")
+		rec.DirName = field(row, "dir_name")
+		if rec.Used, err = uintField(row, "used"); err != nil {
+			return fail(err)
+		}
+		if rec.Quota, err = uintField(row, "quota"); err != nil {
+			return fail(err)
+		}
+		if rec.MinUsed, err = uintField(row, "min_used"); err != nil {
+			return fail(err)
+		}
+		if rec.MaxUsed, err = uintField(row, "max_used"); err != nil {
+			return fail(err)
+		}
+		if s := field(row, "used_pct"); s != "" {
+			if rec.UsedPct, err = strconv.ParseFloat(s, 64); err != nil {
+				return fail(err)
+			}
+		}
+		if s := field(row, "samples"); s != "" {
+			if rec.Samples, err = strconv.Atoi(s); err != nil {
+				return fail(err)
+			}
+		}
+		rec.PVName = field(row, "pv_name")
+		rec.PVCName = field(row, "pvc_name")
+		rec.Namespace = field(row, "namespace")
+		rec.StorageClass = field(row, "storage_class")
+		if s := field(row, "project_id"); s != "" {
+			id, err := strconv.ParseUint(s, 10, 32)
+			if err != nil {
+				return fail(err)
+			}
+			rec.ProjectID = uint32(id)
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// ImportResult counts what an import did with the records it was given
+type ImportResult struct {
+	Imported   int `json:"imported"`
+	Duplicates int `json:"duplicates"` // already in the store
+	Skipped    int `json:"skipped"`    // future, invalid, or for a disabled rollup
+}
+
+// Import merges exported records into the store, e.g. to move history to
+// a new node or to combine the stores of two agents. Points already in the
+// store win over imported ones; for rollups, the point built from more
+// snapshots wins. Imported raw days are rolled up again for the imported
+// paths, and everything past retention is pruned as usual.
+func (h *Store) Import(records []ExportRecord) (ImportResult, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var result ImportResult
+	if h.readOnly {
+		return result, fmt.Errorf("history store %s is opened read-only", h.dir)
+	}
+
+	now := h.now()
+	today := dayOf(now)
+	raw := make(map[time.Time]map[string][]UsageHistory)
+	rollups := make(map[*tier]map[time.Time]map[string][]UsageHistory)
+	tierByName := make(map[string]*tier, len(h.tiers))
+	for _, t := range h.tiers {
+		tierByName[t.name] = t
+	}
+
+	for _, r := range records {
+		e := r.UsageHistory
+		if e.Path == "" || e.Timestamp.IsZero() || e.Timestamp.After(now) {
+			result.Skipped++
+			continue
+		}
+		if e.DirName == "" {
+			e.DirName = filepath.Base(e.Path)
+		}
+		day := dayOf(e.Timestamp)
+
+		switch r.Resolution {
+		case ResolutionRaw, "":
+			e.MinUsed, e.MaxUsed, e.Samples = 0, 0, 0
+			addSample(raw, day, e)
+		default:
+			t := tierByName[r.Resolution]
+			if t == nil || e.Samples <= 0 {
+				result.Skipped++
+				continue
+			}
+			if rollups[t] == nil {
+				rollups[t] = make(map[time.Time]map[string][]UsageHistory)
+			}
+			addSample(rollups[t], day, e)
+		}
+	}
+
+	byMoreSamples := func(existing, imported UsageHistory) bool {
+		return imported.Samples > existing.Samples
+	}
+
+	for day, samples := range raw {
+		if day.Equal(today) {
+			n, dup, err := h.importActive(now, samples)
+			result.Imported += n
+			result.Duplicates += dup
+			if err != nil {
+				return result, err
+			}
+			continue
+		}
+
+		old := h.sealedDay(day)
+		seg, n, dup, err := mergeSegment(h.dir, old, day, samples, nil)
+		result.Imported += n
+		result.Duplicates += dup
+		if err != nil {
+			return result, err
+		}
+		if seg == nil {
+			continue
+		}
+		h.replaceSealed(old, seg)
+
+		// Roll up the merged day again for the paths that changed
+		all, err := seg.readAll(h.dir)
+		if err != nil {
+			return result, err
+		}
+		for _, t := range h.tiers {
+			if seg.end.Before(now.Add(-t.retention)) {
+				continue
+			}
+			points := make(map[string][]UsageHistory, len(samples))
+			for path := range samples {
+				points[path] = downsample(all[path], t.step)
+			}
+			if _, _, err := t.merge(day, points, byMoreSamples); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	for t, days := range rollups {
+		for day, samples := range days {
+			if day.Equal(today) {
+				// Today is rolled up from raw snapshots at query time
+				for _, points := range samples {
+					result.Skipped += len(points)
+				}
+				continue
+			}
+			n, dup, err := t.merge(day, samples, byMoreSamples)
+			result.Imported += n
+			result.Duplicates += dup
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+
+	h.sortSealed()
+	h.rollupMissing(now)
+	h.prune(now)
+	h.forecasts = nil
+	h.baselines = nil
+	return result, nil
+}
+
+func addSample(days map[time.Time]map[string][]UsageHistory, day time.Time, e UsageHistory) {
+	if days[day] == nil {
+		days[day] = make(map[string][]UsageHistory)
+	}
+	days[day][e.Path] = append(days[day][e.Path], e)
+}
+
+// importActive appends imported snapshots of today to the active segment
+// (must be called with lock held)
+func (h *Store) importActive(now time.Time, samples map[string][]UsageHistory) (imported, duplicates int, err error) {
+	if err := h.rotate(now); err != nil {
+		return 0, 0, err
+	}
+	var entries []UsageHistory
+	for path, points := range samples {
+		seen := make(map[time.Time]bool, len(h.active.samples[path]))
+		for _, e := range h.active.samples[path] {
+			seen[e.Timestamp.UTC()] = true
+		}
+		for _, e := range points {
+			if seen[e.Timestamp.UTC()] {
+				duplicates++
+				continue
+			}
+			seen[e.Timestamp.UTC()] = true
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) == 0 {
+		return 0, duplicates, nil
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Timestamp.Before(entries[j].Timestamp) })
+	if err := h.active.appendBatch(entries); err != nil {
+		return 0, duplicates, err
+	}
+	return len(entries), duplicates, nil
+}
+
+// replaceSealed swaps a rewritten raw segment into the store (must be called
+// with lock held)
+func (h *Store) replaceSealed(old, seg *segment) {
+	for i, s := range h.sealed {
+		if s == old {
+			h.sealed[i] = seg
+			return
+		}
+	}
+	h.sealed = append(h.sealed, seg)
+	h.sortSealed()
+}
+
+// merge adds rollup points of one day to the tier and counts the points
+// added and those already present
+func (t *tier) merge(day time.Time, samples map[string][]UsageHistory, replace func(existing, imported UsageHistory) bool) (int, int, error) {
+	var old *segment
+	for _, s := range t.segments {
+		if s.day.Equal(day) {
+			old = s
+		}
+	}
+	seg, n, dup, err := mergeSegment(t.dir, old, day, samples, replace)
+	if err != nil || seg == nil {
+		return n, dup, err
+	}
+	if old == nil {
+		t.segments = append(t.segments, seg)
+		sort.Slice(t.segments, func(i, j int) bool { return t.segments[i].day.Before(t.segments[j].day) })
+		return n, dup, nil
+	}
+	for i, s := range t.segments {
+		if s == old {
+			t.segments[i] = seg
+		}
+	}
+	return n, dup, nil
+}
+
+// mergeSegment seals the union of a day's existing samples and new ones.
+// A new sample with the timestamp of an existing one of the same path is a
+// duplicate, and only replaces it when replace says so. It returns nil when
+// nothing changed.
+func mergeSegment(dir string, old *segment, day time.Time, samples map[string][]UsageHistory, replace func(existing, imported UsageHistory) bool) (*segment, int, int, error) {
+	merged := &segment{day: day, samples: make(map[string][]UsageHistory)}
+	if old != nil {
+		existing, err := old.readAll(dir)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		for path, points := range existing {
+			merged.samples[path] = append([]UsageHistory(nil), points...)
+		}
+	}
+
+	imported, duplicates, changed := 0, 0, false
+	for path, points := range samples {
+		at := make(map[time.Time]int, len(merged.samples[path]))
+		for i, e := range merged.samples[path] {
+			at[e.Timestamp.UTC()] = i
+		}
+		for _, e := range points {
+			key := e.Timestamp.UTC()
+			if i, ok := at[key]; ok {
+				duplicates++
+				if replace != nil && replace(merged.samples[path][i], e) {
+					merged.samples[path][i] = e
+					changed = true
+				}
+				continue
+			}
+			at[key] = len(merged.samples[path])
+			merged.samples[path] = append(merged.samples[path], e)
+			imported++
+			changed = true
+		}
+	}
+	if !changed {
+		return nil, imported, duplicates, nil
+	}
+
+	for _, points := range merged.samples {
+		for _, e := range points {
+			merged.add(e)
+		}
+	}
+	seg, err := merged.seal(dir)
+	if err != nil {
+		return nil, imported, duplicates, fmt.Errorf("failed to write history segment %s: %w", day.Format(dayLayout), err)
+	}
+	return seg, imported, duplicates, nil
+}
+
+// ParseTime parses an export bound: an RFC 3339 time, a date (UTC
+// midnight), or a duration before now such as "36h" or "7d". Empty means
+// unbounded.
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(dayLayout, s); err == nil {
+		return t, nil
+	}
+
+	var d time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("invalid time %q", s)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil || d < 0 {
+			return time.Time{}, fmt.Errorf("invalid time %q (use RFC 3339, YYYY-MM-DD or a duration such as 7d)", s)
+		}
+	}
+	return now.Add(-d), nil
+}
@@ -0,0 +1,376 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dasomel/nfs-quota-agent/internal/status"
+)
+
+func exportAll(t *testing.T, store *Store, filter ExportFilter) []ExportRecord {
+	t.Helper()
+	var records []ExportRecord
+	if err := store.Export(filter, func(r ExportRecord) error {
+		records = append(records, r)
+		return nil
+	}); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	return records
+}
+
+func TestExportImport(t *testing.T) {
+	retention := Retention{Raw: 7 * 24 * time.Hour, Hourly: 30 * 24 * time.Hour, Daily: 365 * 24 * time.Hour}
+	start := dayOf(time.Now()).AddDate(0, 0, -3)
+	c := &clock{t: start}
+	src := newTestStore(t, filepath.Join(t.TempDir(), "src"), c, retention)
+
+	// Three past days and today, every six hours
+	for i := 0; c.t.Before(time.Now()); i++ {
+		if err := src.Record([]status.DirUsage{
+			{Path: "/data/a", Used: uint64(i) * 1000, Quota: 1 << 20, QuotaPct: float64(i) / 10},
+			{Path: "/data/b", Used: 500, Quota: 1 << 20},
+		}, map[string]Owner{
+			"/data/a": {PVName: "pv-a", PVCName: "logs", Namespace: "team-a", StorageClass: "nfs", ProjectID: 42},
+		}); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+		c.t = c.t.Add(6 * time.Hour)
+	}
+	c.t = time.Now()
+
+	records := exportAll(t, src, ExportFilter{})
+	if len(records) == 0 || records[0].Resolution != ResolutionRaw {
+		t.Fatalf("Expected raw records, got %+v", records)
+	}
+	// An hourly point from before the raw history
+	old := ExportRecord{Resolution: ResolutionHourly, UsageHistory: UsageHistory{
+		Timestamp: start.AddDate(0, 0, -5).Add(3 * time.Hour),
+		Path:      "/data/a", DirName: "a", Used: 42, MinUsed: 40, MaxUsed: 44, Samples: 12,
+	}}
+	records = append([]ExportRecord{old}, records...)
+
+	for _, format := range []string{FormatCSV, FormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewRecordWriter(&buf, format)
+			if err != nil {
+				t.Fatalf("NewRecordWriter failed: %v", err)
+			}
+			for _, r := range records {
+				if err := w.Write(r); err != nil {
+					t.Fatalf("Write failed: %v", err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+			read, err := ReadRecords(bytes.NewReader(buf.Bytes()), format)
+			if err != nil {
+				t.Fatalf("ReadRecords failed: %v", err)
+			}
+
+			dst := newTestStore(t, filepath.Join(t.TempDir(), "dst"), c, retention)
+			result, err := dst.Import(read)
+			if err != nil {
+				t.Fatalf("Import failed: %v", err)
+			}
+			if result.Imported != len(records) || result.Duplicates != 0 || result.Skipped != 0 {
+				t.Errorf("Expected %d imported, got %+v", len(records), result)
+			}
+
+			got := exportAll(t, dst, ExportFilter{})
+			if len(got) != len(records) {
+				t.Fatalf("Expected %d records after import, got %d", len(records), len(got))
+			}
+			for i := range got {
+				if !got[i].Timestamp.Equal(records[i].Timestamp) {
+					t.Fatalf("Record %d: expected time %v, got %v", i, records[i].Timestamp, got[i].Timestamp)
+				}
+				got[i].Timestamp = records[i].Timestamp
+				if !reflect.DeepEqual(got[i], records[i]) {
+					t.Fatalf("Record %d: expected %+v, got %+v", i, records[i], got[i])
+				}
+			}
+
+			// Importing again changes nothing
+			again, err := dst.Import(read)
+			if err != nil {
+				t.Fatalf("Second import failed: %v", err)
+			}
+			if again.Imported != 0 || again.Duplicates != len(records) {
+				t.Errorf("Expected only duplicates, got %+v", again)
+			}
+		})
+	}
+
+	filtered := exportAll(t, src, ExportFilter{Path: "b", Since: start.Add(24 * time.Hour), Until: start.Add(48 * time.Hour)})
+	if len(filtered) != 5 {
+		t.Errorf("Expected 5 points of /data/b in one day, got %d", len(filtered))
+	}
+	for _, r := range filtered {
+		if r.Path != "/data/b" {
+			t.Errorf("Expected only /data/b, got %s", r.Path)
+		}
+	}
+
+	for _, r := range exportAll(t, src, ExportFilter{Selector: Selector{Namespace: "team-a"}}) {
+		if r.Path != "/data/a" || r.ProjectID != 42 {
+			t.Fatalf("Expected only /data/a of team-a, got %+v", r)
+		}
+	}
+}
+
+func TestParquetWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewRecordWriter(&buf, FormatParquet)
+	if err != nil {
+		t.Fatalf("NewRecordWriter failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := w.Write(ExportRecord{Resolution: ResolutionRaw, UsageHistory: UsageHistory{
+			Timestamp: time.Unix(int64(i)*300, 0), Path: "/data/a", DirName: "a", Used: uint64(i),
+		}}); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, []byte(parquetMagic)) || !bytes.HasSuffix(data, []byte(parquetMagic)) {
+		t.Fatal("Expected the file to start and end with PAR1")
+	}
+	footer := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if footer <= 0 || footer > len(data)-12 {
+		t.Fatalf("Invalid footer length %d for a %d byte file", footer, len(data))
+	}
+	footerStart := len(data) - 8 - footer
+	r := &thriftReader{data: data[footerStart : len(data)-8]}
+	meta := r.readStruct()
+	if r.err != nil || r.pos != footer {
+		t.Fatalf("Footer is not one FileMetaData struct: %v (read %d of %d bytes)", r.err, r.pos, footer)
+	}
+
+	if meta[1] != int64(1) || meta[3] != int64(3) || string(meta[6].([]byte)) != "nfs-quota-agent" {
+		t.Errorf("Expected version 1, 3 rows and created_by, got %v %v %q", meta[1], meta[3], meta[6])
+	}
+	schema := meta[2].([]any)
+	if len(schema) != len(exportColumns)+1 {
+		t.Fatalf("Expected %d schema elements, got %d", len(exportColumns)+1, len(schema))
+	}
+	root := schema[0].(map[int16]any)
+	if string(root[4].([]byte)) != "usage_history" || root[5] != int64(len(exportColumns)) {
+		t.Errorf("Unexpected root schema element %v", root)
+	}
+	for i, col := range exportColumns {
+		el := schema[i+1].(map[int16]any)
+		if string(el[4].([]byte)) != col.name || el[1] != int64(col.typ) || el[3] != int64(pqRequired) {
+			t.Errorf("Schema element %d: expected %s of type %d, got %v", i, col.name, col.typ, el)
+		}
+	}
+	tsLogical := schema[1].(map[int16]any)[10].(map[int16]any)[8].(map[int16]any)
+	if tsLogical[1] != true || tsLogical[2].(map[int16]any)[2] == nil {
+		t.Errorf("Expected TIMESTAMP(UTC, MICROS) on timestamp, got %v", tsLogical)
+	}
+
+	rowGroups := meta[4].([]any)
+	if len(rowGroups) != 1 {
+		t.Fatalf("Expected 1 row group, got %d", len(rowGroups))
+	}
+	rg := rowGroups[0].(map[int16]any)
+	chunks := rg[1].([]any)
+	if rg[3] != int64(3) || len(chunks) != len(exportColumns) {
+		t.Fatalf("Expected 3 rows in %d chunks, got %v rows in %d", len(exportColumns), rg[3], len(chunks))
+	}
+
+	// Decode every column chunk and check its PLAIN values
+	columns := map[string][]any{}
+	for i, c := range chunks {
+		col := exportColumns[i]
+		cm := c.(map[int16]any)[3].(map[int16]any)
+		path := cm[3].([]any)
+		if len(path) != 1 || string(path[0].([]byte)) != col.name || cm[1] != int64(col.typ) || cm[5] != int64(3) {
+			t.Fatalf("Column chunk %d: unexpected metadata %v", i, cm)
+		}
+		offset := int(cm[9].(int64))
+		if offset < len(parquetMagic) || offset >= footerStart {
+			t.Fatalf("Column %s: data page offset %d outside the data", col.name, offset)
+		}
+		pr := &thriftReader{data: data[offset:footerStart]}
+		page := pr.readStruct()
+		if pr.err != nil {
+			t.Fatalf("Column %s: invalid page header: %v", col.name, pr.err)
+		}
+		dp := page[5].(map[int16]any)
+		if page[1] != int64(pqPageData) || dp[1] != int64(3) || dp[2] != int64(pqEncodingPlain) {
+			t.Fatalf("Column %s: unexpected page header %v", col.name, page)
+		}
+		size := int(page[3].(int64))
+		if int(cm[7].(int64)) != pr.pos+size {
+			t.Errorf("Column %s: chunk size %v does not match header %d + values %d", col.name, cm[7], pr.pos, size)
+		}
+		columns[col.name] = plainValues(t, col.typ, data[offset+pr.pos:offset+pr.pos+size], 3)
+	}
+
+	for i := 0; i < 3; i++ {
+		if got := columns["timestamp"][i]; got != int64(i)*300*1e6 {
+			t.Errorf("Row %d: expected timestamp %d, got %v", i, int64(i)*300*1e6, got)
+		}
+		if got := columns["used"][i]; got != int64(i) {
+			t.Errorf("Row %d: expected used %d, got %v", i, i, got)
+		}
+		if columns["path"][i] != "/data/a" || columns["dir_name"][i] != "a" || columns["resolution"][i] != ResolutionRaw {
+			t.Errorf("Row %d: unexpected strings %v %v %v", i, columns["path"][i], columns["dir_name"][i], columns["resolution"][i])
+		}
+		if columns["used_pct"][i] != 0.0 || columns["samples"][i] != int64(0) || columns["pv_name"][i] != "" {
+			t.Errorf("Row %d: expected zero values, got %v %v %q", i, columns["used_pct"][i], columns["samples"][i], columns["pv_name"][i])
+		}
+	}
+}
+
+// plainValues decodes n PLAIN values of a physical type
+func plainValues(t *testing.T, typ int32, data []byte, n int) []any {
+	t.Helper()
+	var values []any
+	for i := 0; i < n; i++ {
+		size := 8
+		switch typ {
+		case pqInt32:
+			size = 4
+		case pqByteArray:
+			if len(data) >= 4 {
+				size = 4 + int(binary.LittleEndian.Uint32(data))
+			}
+		}
+		if len(data) < size {
+			t.Fatalf("Truncated value %d of type %d", i, typ)
+		}
+		switch typ {
+		case pqInt32:
+			values = append(values, int64(int32(binary.LittleEndian.Uint32(data))))
+		case pqInt64:
+			values = append(values, int64(binary.LittleEndian.Uint64(data)))
+		case pqDouble:
+			values = append(values, math.Float64frombits(binary.LittleEndian.Uint64(data)))
+		case pqByteArray:
+			values = append(values, string(data[4:size]))
+		}
+		data = data[size:]
+	}
+	if len(data) != 0 {
+		t.Fatalf("%d bytes left after %d values of type %d", len(data), n, typ)
+	}
+	return values
+}
+
+// thriftReader decodes the Thrift compact protocol into generic values:
+// structs as maps of field ID, lists as slices, integers as int64
+type thriftReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *thriftReader) byte() byte {
+	if r.pos >= len(r.data) {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.data[min(r.pos, len(r.data)):])
+	if n <= 0 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) any {
+	switch typ {
+	case thriftTrue, thriftFalse:
+		return typ == thriftTrue
+	case thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftBinary:
+		n := int(r.varint())
+		if r.err != nil || r.pos+n > len(r.data) {
+			r.err = io.ErrUnexpectedEOF
+			return []byte(nil)
+		}
+		r.pos += n
+		return r.data[r.pos-n : r.pos]
+	case thriftList:
+		h := r.byte()
+		size, elem := int(h>>4), h&0x0f
+		if size == 0x0f {
+			size = int(r.varint())
+		}
+		list := []any{}
+		for i := 0; i < size && r.err == nil; i++ {
+			if elem == thriftTrue || elem == thriftFalse {
+				list = append(list, r.byte() == thriftTrue)
+				continue
+			}
+			list = append(list, r.value(elem))
+		}
+		return list
+	case thriftStruct:
+		return r.readStruct()
+	}
+	r.err = fmt.Errorf("unsupported thrift type %d", typ)
+	return nil
+}
+
+func (r *thriftReader) readStruct() map[int16]any {
+	fields := map[int16]any{}
+	var last int16
+	for r.err == nil {
+		h := r.byte()
+		if h == 0 {
+			break
+		}
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		fields[id] = r.value(h & 0x0f)
+		last = id
+	}
+	return fields
+}
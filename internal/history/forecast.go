@@ -0,0 +1,353 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/dasomel/nfs-quota-agent/internal/status"
+)
+
+// Forecast methods
+const (
+	// MethodLinear fits growth with least squares
+	MethodLinear = "linear"
+	// MethodRobust fits growth with the Theil-Sen estimator (median of
+	// pairwise slopes), which ignores one-off spikes and cleanups
+	MethodRobust = "robust"
+)
+
+const (
+	// DefaultForecastWindow is how much history forecasts fit by default
+	DefaultForecastWindow = 7 * 24 * time.Hour
+
+	// maxFitPoints bounds the points fitted per path; longer histories are
+	// averaged down first (Theil-Sen is quadratic in the number of points)
+	maxFitPoints = 500
+
+	// maxHorizon is how far ahead a fill time is still reported
+	maxHorizon = 10 * 365 * 24 * time.Hour
+)
+
+// Forecast is the fitted growth of one path and when it reaches its quota
+type Forecast struct {
+	Path         string     `json:"path"`
+	DirName      string     `json:"dirName"`
+	Method       string     `json:"method"`
+	Window       string     `json:"window"`
+	Points       int        `json:"points"`
+	Used         uint64     `json:"used"`
+	Quota        uint64     `json:"quota"`
+	GrowthPerDay float64    `json:"growthPerDay"` // bytes per day
+	R2           float64    `json:"r2"`           // goodness of fit (0-1)
+	FullAt       *time.Time `json:"fullAt,omitempty"`
+}
+
+// ExportForecast is the combined growth of all paths against the export
+// disk
+type ExportForecast struct {
+	Path         string     `json:"path"`
+	Total        uint64     `json:"total"`
+	Used         uint64     `json:"used"`
+	Available    uint64     `json:"available"`
+	GrowthPerDay float64    `json:"growthPerDay"`
+	Paths        int        `json:"paths"`
+	FullAt       *time.Time `json:"fullAt,omitempty"`
+}
+
+// ValidateMethod checks a forecast method name
+func ValidateMethod(method string) error {
+	switch method {
+	case MethodLinear, MethodRobust:
+		return nil
+	}
+	return fmt.Errorf("unknown forecast method %q (use %s or %s)", method, MethodLinear, MethodRobust)
+}
+
+// SetForecast sets the window and method used by trends and ForecastAll
+// when none is given
+func (h *Store) SetForecast(window time.Duration, method string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.forecastWindow = window
+	h.forecastMethod = method
+	h.forecasts = nil
+}
+
+// ForecastDefaults returns the default forecast window and method
+func (h *Store) ForecastDefaults() (time.Duration, string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.forecastSettings(0, "")
+}
+
+// forecastSettings fills in the defaults (must be called with lock held)
+func (h *Store) forecastSettings(window time.Duration, method string) (time.Duration, string) {
+	if window <= 0 {
+		window = h.forecastWindow
+	}
+	if window <= 0 {
+		window = DefaultForecastWindow
+	}
+	if method == "" {
+		method = h.forecastMethod
+	}
+	if method == "" {
+		method = MethodLinear
+	}
+	return window, method
+}
+
+// Forecast fits the growth of path over window (0 = default) and estimates
+// when it reaches its quota. It returns nil when there is no history.
+func (h *Store) Forecast(path string, window time.Duration, method string) *Forecast {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	window, method = h.forecastSettings(window, method)
+	return h.forecast(path, window, method)
+}
+
+// ForecastAll forecasts every tracked path, soonest to fill first. Results
+// for the default window and method are cached until the next Record.
+func (h *Store) ForecastAll(window time.Duration, method string) []Forecast {
+	h.mu.RLock()
+	window, method = h.forecastSettings(window, method)
+	if h.forecasts != nil && h.forecastsKey == window.String()+"/"+method {
+		cached := h.forecasts
+		h.mu.RUnlock()
+		return cached
+	}
+
+	var forecasts []Forecast
+	for path := range h.paths() {
+		if f := h.forecast(path, window, method); f != nil {
+			forecasts = append(forecasts, *f)
+		}
+	}
+	h.mu.RUnlock()
+
+	sortForecasts(forecasts)
+
+	h.mu.Lock()
+	if def, defMethod := h.forecastSettings(0, ""); def == window && defMethod == method {
+		h.forecasts = forecasts
+		h.forecastsKey = window.String() + "/" + method
+	}
+	h.mu.Unlock()
+
+	return forecasts
+}
+
+// sortForecasts orders forecasts by fill time (never last), then growth
+func sortForecasts(forecasts []Forecast) {
+	sort.Slice(forecasts, func(i, j int) bool {
+		a, b := forecasts[i].FullAt, forecasts[j].FullAt
+		switch {
+		case a != nil && b != nil:
+			return a.Before(*b)
+		case a != nil:
+			return true
+		case b != nil:
+			return false
+		}
+		return forecasts[i].GrowthPerDay > forecasts[j].GrowthPerDay
+	})
+}
+
+// forecast implements Forecast (must be called with lock held)
+func (h *Store) forecast(path string, window time.Duration, method string) *Forecast {
+	now := h.now()
+	points, _ := h.queryStep(path, now.Add(-window), now, 0)
+	points = measured(currentOwner(points))
+	if len(points) == 0 {
+		return nil
+	}
+	if len(points) > maxFitPoints {
+		points = downsample(points, window/maxFitPoints)
+	}
+
+	last := points[len(points)-1]
+	f := &Forecast{
+		Path:    path,
+		DirName: last.DirName,
+		Method:  method,
+		Window:  window.String(),
+		Points:  len(points),
+		Used:    last.Used,
+		Quota:   last.Quota,
+	}
+	if len(points) < 2 {
+		return f
+	}
+
+	slope, r2 := fitGrowth(points, method)
+	f.GrowthPerDay = slope * 24 * 3600
+	f.R2 = r2
+	if last.Quota > 0 {
+		f.FullAt = fillTime(now, float64(last.Used), float64(last.Quota), slope)
+	}
+	return f
+}
+
+// ForecastExport combines per-path forecasts into a forecast for the export
+// disk: the disk fills when the summed growth uses up its free space
+func ForecastExport(forecasts []Forecast, path string, disk *status.DiskUsage, now time.Time) *ExportForecast {
+	if disk == nil {
+		return nil
+	}
+	e := &ExportForecast{
+		Path:      path,
+		Total:     disk.Total,
+		Used:      disk.Used,
+		Available: disk.Available,
+		Paths:     len(forecasts),
+	}
+	for _, f := range forecasts {
+		e.GrowthPerDay += f.GrowthPerDay
+	}
+	e.FullAt = fillTime(now, float64(disk.Used), float64(disk.Used+disk.Available), e.GrowthPerDay/(24*3600))
+	return e
+}
+
+// fillTime returns when used reaches limit growing at slope bytes/second,
+// or nil when it does not grow or not within maxHorizon
+func fillTime(now time.Time, used, limit, slope float64) *time.Time {
+	if used >= limit {
+		return &now
+	}
+	if slope <= 0 {
+		return nil
+	}
+	seconds := (limit - used) / slope
+	if seconds > maxHorizon.Seconds() {
+		return nil
+	}
+	t := now.Add(time.Duration(seconds * float64(time.Second)))
+	return &t
+}
+
+// measured drops snapshots whose usage was carried forward from an earlier
+// walk, which would show flat stretches followed by steps
+func measured(points []UsageHistory) []UsageHistory {
+	kept := points[:0:0]
+	for _, p := range points {
+		if !p.Carried {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// fitGrowth fits used bytes over time and returns the slope in bytes per
+// second and the R² of the fitted line
+func fitGrowth(points []UsageHistory, method string) (float64, float64) {
+	xs := make([]float64, len(points))
+	ys := make([]float64, len(points))
+	t0 := points[0].Timestamp
+	for i, p := range points {
+		xs[i] = p.Timestamp.Sub(t0).Seconds()
+		ys[i] = float64(p.Used)
+	}
+
+	var slope, intercept float64
+	if method == MethodRobust {
+		slope, intercept = theilSen(xs, ys)
+	} else {
+		slope, intercept = leastSquares(xs, ys)
+	}
+	return slope, rSquared(xs, ys, slope, intercept)
+}
+
+// leastSquares fits y = intercept + slope*x
+func leastSquares(xs, ys []float64) (float64, float64) {
+	n := float64(len(xs))
+	var sx, sy, sxx, sxy float64
+	for i := range xs {
+		sx += xs[i]
+		sy += ys[i]
+		sxx += xs[i] * xs[i]
+		sxy += xs[i] * ys[i]
+	}
+	den := n*sxx - sx*sx
+	if den == 0 {
+		return 0, sy / n
+	}
+	slope := (n*sxy - sx*sy) / den
+	return slope, (sy - slope*sx) / n
+}
+
+// theilSen fits y = intercept + slope*x with the median of the slopes
+// between all pairs of points and the median of the residual intercepts
+func theilSen(xs, ys []float64) (float64, float64) {
+	var slopes []float64
+	for i := range xs {
+		for j := i + 1; j < len(xs); j++ {
+			if dx := xs[j] - xs[i]; dx != 0 {
+				slopes = append(slopes, (ys[j]-ys[i])/dx)
+			}
+		}
+	}
+	if len(slopes) == 0 {
+		return 0, median(append([]float64(nil), ys...))
+	}
+	slope := median(slopes)
+
+	intercepts := make([]float64, len(xs))
+	for i := range xs {
+		intercepts[i] = ys[i] - slope*xs[i]
+	}
+	return slope, median(intercepts)
+}
+
+// median sorts values in place and returns their median
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// rSquared returns the coefficient of determination of a fitted line,
+// clamped to [0, 1]; a flat series that the line matches counts as 1
+func rSquared(xs, ys []float64, slope, intercept float64) float64 {
+	var mean float64
+	for _, y := range ys {
+		mean += y
+	}
+	mean /= float64(len(ys))
+
+	var ssRes, ssTot float64
+	for i := range xs {
+		r := ys[i] - (intercept + slope*xs[i])
+		ssRes += r * r
+		d := ys[i] - mean
+		ssTot += d * d
+	}
+	if ssTot == 0 {
+		if ssRes == 0 {
+			return 1
+		}
+		return 0
+	}
+	return math.Max(0, math.Min(1, 1-ssRes/ssTot))
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dasomel/nfs-quota-agent/internal/status"
+)
+
+func TestFitGrowth(t *testing.T) {
+	base := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	series := func(used ...uint64) []UsageHistory {
+		points := make([]UsageHistory, len(used))
+		for i, u := range used {
+			points[i] = UsageHistory{Timestamp: base.Add(time.Duration(i) * time.Hour), Used: u}
+		}
+		return points
+	}
+	const perHour = 1.0 / 3600
+
+	tests := []struct {
+		name   string
+		points []UsageHistory
+		method string
+		slope  float64 // bytes per second
+	}{
+		{"linear growth", series(0, 100, 200, 300, 400), MethodLinear, 100 * perHour},
+		{"flat", series(50, 50, 50), MethodLinear, 0},
+		{"shrinking", series(400, 300, 200), MethodRobust, -100 * perHour},
+		// One spike pulls least squares up but not Theil-Sen
+		{"spike robust", series(0, 100, 200, 10000, 400, 500), MethodRobust, 100 * perHour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slope, r2 := fitGrowth(tt.points, tt.method)
+			if math.Abs(slope-tt.slope) > 1e-9 {
+				t.Errorf("Expected slope %g, got %g", tt.slope, slope)
+			}
+			if r2 < 0 || r2 > 1 {
+				t.Errorf("R² out of range: %g", r2)
+			}
+		})
+	}
+
+	if linear, _ := fitGrowth(series(0, 100, 200, 10000, 400, 500), MethodLinear); linear <= 100*perHour {
+		t.Errorf("Expected the spike to skew the linear fit, got %g", linear)
+	}
+}
+
+func TestFillTime(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		used  float64
+		limit float64
+		slope float64
+		want  *time.Duration
+	}{
+		{"fills in a day", 0, 86400, 1, durationPtr(24 * time.Hour)},
+		{"already full", 100, 100, 0, durationPtr(0)},
+		{"not growing", 10, 100, 0, nil},
+		{"beyond horizon", 0, 1e18, 1, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fillTime(now, tt.used, tt.limit, tt.slope)
+			if (got == nil) != (tt.want == nil) {
+				t.Fatalf("Expected %v, got %v", tt.want, got)
+			}
+			if got != nil && got.Sub(now) != *tt.want {
+				t.Errorf("Expected full in %v, got %v", *tt.want, got.Sub(now))
+			}
+		})
+	}
+}
+
+func durationPtr(d time.Duration) *time.Duration { return &d }
+
+func TestStoreForecast(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "history")
+	c := &clock{t: time.Now().Add(-10 * time.Hour)}
+	store := newTestStore(t, dir, c, Retention{Raw: 48 * time.Hour})
+
+	// /data/a grows 1Gi per hour towards a 20Gi quota, /data/b is flat
+	const gi = 1 << 30
+	for i := 0; i <= 10; i++ {
+		usages := []status.DirUsage{
+			{Path: "/data/a", Used: uint64(i) * gi, Quota: 20 * gi},
+			{Path: "/data/b", Used: 5 * gi, Quota: 20 * gi},
+		}
+		if err := store.Record(usages, nil); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+		if i < 10 {
+			c.t = c.t.Add(time.Hour)
+		}
+	}
+
+	forecasts := store.ForecastAll(24*time.Hour, MethodLinear)
+	if len(forecasts) != 2 {
+		t.Fatalf("Expected 2 forecasts, got %d", len(forecasts))
+	}
+	a := forecasts[0]
+	if a.Path != "/data/a" || a.FullAt == nil {
+		t.Fatalf("Expected /data/a to fill first, got %+v", a)
+	}
+	if got := a.FullAt.Sub(c.t); math.Abs(got.Hours()-10) > 0.01 {
+		t.Errorf("Expected /data/a full in 10h, got %v", got)
+	}
+	if math.Abs(a.GrowthPerDay-24*gi) > 1 {
+		t.Errorf("Expected 24Gi/day, got %g", a.GrowthPerDay)
+	}
+	if forecasts[1].FullAt != nil {
+		t.Errorf("Expected flat /data/b never to fill, got %v", forecasts[1].FullAt)
+	}
+
+	disk := &status.DiskUsage{Total: 100 * gi, Used: 52 * gi, Available: 48 * gi}
+	export := ForecastExport(forecasts, "/data", disk, c.t)
+	if export.FullAt == nil || math.Abs(export.FullAt.Sub(c.t).Hours()-48) > 0.01 {
+		t.Errorf("Expected export full in 48h, got %+v", export)
+	}
+
+	// A read-only store sees the same history
+	ro, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	ro.now = c.now
+	if f := ro.Forecast("/data/a", 24*time.Hour, MethodRobust); f == nil || f.FullAt == nil {
+		t.Errorf("Expected a forecast from the read-only store, got %+v", f)
+	}
+	if err := ro.Record(nil, nil); err == nil {
+		t.Error("Expected Record on a read-only store to fail")
+	}
+}
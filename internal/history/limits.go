@@ -0,0 +1,353 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/dasomel/nfs-quota-agent/internal/util"
+)
+
+// pathsFile keeps what the store knows about each tracked path, for Limits
+const pathsFile = "paths.json"
+
+// Limits caps the history kept on top of Retention
+type Limits struct {
+	// MaxBytes caps the size on disk of raw segments and rollups; the
+	// oldest raw days are dropped first, then the oldest rollups
+	MaxBytes int64
+
+	// MaxPaths caps the number of paths with history; snapshots of new
+	// paths are not recorded while the cap is reached
+	MaxPaths int
+
+	// EvictAfter drops all history of a path once its PV has been gone
+	// this long (the directory was removed or left without a PV)
+	EvictAfter time.Duration
+}
+
+// enabled reports whether any limit is set
+func (l Limits) enabled() bool {
+	return l.MaxBytes > 0 || l.MaxPaths > 0 || l.EvictAfter > 0
+}
+
+// pathState is what the store remembers of a tracked path
+type pathState struct {
+	LastSeen time.Time `json:"lastSeen"`
+	LastPV   time.Time `json:"lastPV,omitempty"` // last snapshot with a PV
+	PVName   string    `json:"pvName,omitempty"`
+}
+
+// evictedPath is a path whose history was evicted. It is not recorded
+// again until a new PV uses it.
+type evictedPath struct {
+	PVName    string    `json:"pvName,omitempty"`
+	EvictedAt time.Time `json:"evictedAt"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// pathsData is the content of pathsFile
+type pathsData struct {
+	Paths   map[string]*pathState   `json:"paths"`
+	Evicted map[string]*evictedPath `json:"evicted,omitempty"`
+}
+
+// evictionStats counts what the limits removed since the agent started
+type evictionStats struct {
+	evictedPaths  int
+	lastEviction  time.Time
+	droppedDays   int
+	droppedBytes  int64
+	rejectedPaths int // new paths not recorded in the last snapshot
+}
+
+// SetLimits configures size and path limits. Paths already on disk without
+// saved state start their eviction period now.
+func (h *Store) SetLimits(l Limits) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.limits = l
+	if !l.enabled() || h.tracked != nil {
+		return
+	}
+
+	h.tracked = make(map[string]*pathState)
+	h.evicted = make(map[string]*evictedPath)
+	if err := h.loadPaths(); err != nil {
+		slog.Warn("Failed to load history path state", "error", err)
+	}
+	now := h.now()
+	onDisk := h.paths()
+	for path := range onDisk {
+		if h.tracked[path] == nil {
+			h.tracked[path] = &pathState{LastSeen: now}
+		}
+	}
+	for path := range h.tracked {
+		if !onDisk[path] {
+			delete(h.tracked, path)
+		}
+	}
+}
+
+// trackPaths updates the state of the paths in a snapshot and returns the
+// entries to record: evicted paths and, at MaxPaths, new paths are left out
+// (must be called with lock held)
+func (h *Store) trackPaths(entries []UsageHistory, now time.Time) []UsageHistory {
+	if h.tracked == nil {
+		return entries
+	}
+
+	kept := entries[:0]
+	rejected := 0
+	for _, e := range entries {
+		if ev := h.evicted[e.Path]; ev != nil {
+			if e.PVName == "" || e.PVName == ev.PVName {
+				ev.LastSeen = now
+				continue
+			}
+			// A new PV uses the directory; its history starts over
+			delete(h.evicted, e.Path)
+		}
+
+		st := h.tracked[e.Path]
+		if st == nil {
+			if h.limits.MaxPaths > 0 && len(h.tracked) >= h.limits.MaxPaths {
+				rejected++
+				continue
+			}
+			st = &pathState{}
+			h.tracked[e.Path] = st
+		}
+		st.LastSeen = now
+		if e.PVName != "" {
+			st.LastPV = now
+			st.PVName = e.PVName
+		}
+		kept = append(kept, e)
+	}
+
+	if rejected > 0 && h.eviction.rejectedPaths == 0 {
+		slog.Warn("History path limit reached, new paths are not recorded", "maxPaths", h.limits.MaxPaths, "rejected", rejected)
+	}
+	h.eviction.rejectedPaths = rejected
+	return kept
+}
+
+// evictGone evicts the history of paths whose PV has been gone longer than
+// EvictAfter, forgets paths with no history left and removes evicted paths
+// from every segment (must be called with lock held). A path that had a PV
+// is gone once it was last recorded with it EvictAfter before the newest
+// snapshot that had any PV, so a failing PV listing evicts nothing; a path
+// that never had one is gone once it was last recorded EvictAfter ago.
+func (h *Store) evictGone(now time.Time) {
+	if h.tracked == nil {
+		return
+	}
+
+	var ownersSeen time.Time
+	for _, st := range h.tracked {
+		if st.LastPV.After(ownersSeen) {
+			ownersSeen = st.LastPV
+		}
+	}
+
+	if after := h.limits.EvictAfter; after > 0 {
+		var gone []string
+		for path, st := range h.tracked {
+			if st.LastPV.IsZero() {
+				if now.Sub(st.LastSeen) > after {
+					gone = append(gone, path)
+				}
+			} else if ownersSeen.Sub(st.LastPV) > after {
+				gone = append(gone, path)
+			}
+		}
+		sort.Strings(gone)
+		for _, path := range gone {
+			st := h.tracked[path]
+			h.evicted[path] = &evictedPath{PVName: st.PVName, EvictedAt: now, LastSeen: st.LastSeen}
+			delete(h.tracked, path)
+			delete(h.baselines, path)
+			h.eviction.evictedPaths++
+			h.eviction.lastEviction = now
+		}
+		if len(gone) > 0 {
+			slog.Info("Evicted history of paths whose PV is gone", "paths", len(gone), "after", after)
+		}
+	}
+
+	h.purgeEvicted()
+
+	// Directories gone for good need no tombstone once a day they may
+	// still be in was sealed and purged too
+	for path, ev := range h.evicted {
+		if now.Sub(ev.LastSeen) > h.limits.EvictAfter && now.Sub(ev.EvictedAt) > 24*time.Hour {
+			delete(h.evicted, path)
+		}
+	}
+
+	onDisk := h.paths()
+	for path := range h.tracked {
+		if !onDisk[path] {
+			delete(h.tracked, path)
+		}
+	}
+	h.forecasts = nil
+	if err := h.savePaths(); err != nil {
+		slog.Warn("Failed to save history path state", "error", err)
+	}
+}
+
+// purgeEvicted rewrites the sealed segments and rollups that still hold
+// evicted paths (must be called with lock held)
+func (h *Store) purgeEvicted() {
+	if len(h.evicted) == 0 {
+		return
+	}
+	drop := func(dir string, segs []*segment) []*segment {
+		kept := segs[:0]
+		for _, s := range segs {
+			rewritten, err := s.without(dir, h.evicted)
+			if err != nil {
+				slog.Warn("Failed to remove evicted paths from history", "day", s.name(), "error", err)
+				kept = append(kept, s)
+				continue
+			}
+			if rewritten != nil {
+				kept = append(kept, rewritten)
+			}
+		}
+		return kept
+	}
+	h.sealed = drop(h.dir, h.sealed)
+	for _, t := range h.tiers {
+		t.segments = drop(t.dir, t.segments)
+	}
+}
+
+// enforceSize drops the oldest days while the history is larger than
+// MaxBytes: raw days first, as their hours and days are kept in rollups,
+// then hourly and then daily rollups. The current day is never dropped.
+// Queries stop using a level from before its oldest day left (must be
+// called with lock held).
+func (h *Store) enforceSize() {
+	if h.limits.MaxBytes <= 0 {
+		return
+	}
+	size := h.diskBytes()
+	for size > h.limits.MaxBytes {
+		var s *segment
+		switch {
+		case len(h.sealed) > 0:
+			s, h.sealed = h.sealed[0], h.sealed[1:]
+			s.remove(h.dir)
+			h.rawFloor = s.day.Add(24 * time.Hour)
+		default:
+			for _, t := range h.tiers {
+				if len(t.segments) > 0 {
+					s, t.segments = t.segments[0], t.segments[1:]
+					s.remove(t.dir)
+					t.floor = s.day.Add(24 * time.Hour)
+					break
+				}
+			}
+		}
+		if s == nil {
+			return
+		}
+		size -= s.size
+		h.eviction.droppedDays++
+		h.eviction.droppedBytes += s.size
+		slog.Info("Dropped history day over the size limit", "day", s.name(), "bytes", s.size, "maxBytes", h.limits.MaxBytes)
+	}
+}
+
+// diskBytes returns the size on disk of all segments and rollups (must be
+// called with lock held)
+func (h *Store) diskBytes() int64 {
+	var size int64
+	for _, s := range h.segments() {
+		size += s.size
+	}
+	for _, t := range h.tiers {
+		for _, s := range t.segments {
+			size += s.size
+		}
+	}
+	return size
+}
+
+// loadPaths reads the saved path state (must be called with lock held)
+func (h *Store) loadPaths() error {
+	data, err := os.ReadFile(filepath.Join(h.dir, pathsFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var saved pathsData
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	for path, st := range saved.Paths {
+		h.tracked[path] = st
+	}
+	for path, ev := range saved.Evicted {
+		h.evicted[path] = ev
+	}
+	return nil
+}
+
+// savePaths writes the path state (must be called with lock held)
+func (h *Store) savePaths() error {
+	data, err := json.Marshal(pathsData{Paths: h.tracked, Evicted: h.evicted})
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(filepath.Join(h.dir, pathsFile), data, 0644)
+}
+
+// limitStats returns the limits and what they removed, for
+// GetHistoryStats (must be called with lock held)
+func (h *Store) limitStats() map[string]interface{} {
+	evictAfter := "never"
+	if h.limits.EvictAfter > 0 {
+		evictAfter = h.limits.EvictAfter.String()
+	}
+	stats := map[string]interface{}{
+		"maxBytes":      h.limits.MaxBytes,
+		"maxPaths":      h.limits.MaxPaths,
+		"evictAfter":    evictAfter,
+		"trackedPaths":  len(h.tracked),
+		"evictedPaths":  h.eviction.evictedPaths,
+		"droppedDays":   h.eviction.droppedDays,
+		"droppedBytes":  h.eviction.droppedBytes,
+		"rejectedPaths": h.eviction.rejectedPaths,
+		"tombstones":    len(h.evicted),
+	}
+	if !h.eviction.lastEviction.IsZero() {
+		stats["lastEviction"] = h.eviction.lastEviction
+	}
+	return stats
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dasomel/nfs-quota-agent/internal/status"
+)
+
+func TestStoreEvictGone(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "history")
+	start := dayOf(time.Now()).AddDate(0, 0, -5)
+	c := &clock{t: start.Add(time.Hour)}
+	store := newTestStore(t, dir, c, Retention{Raw: 30 * 24 * time.Hour, Hourly: 30 * 24 * time.Hour})
+	store.SetLimits(Limits{EvictAfter: 36 * time.Hour})
+
+	// a keeps its PV, b loses its PV after a day but the directory stays,
+	// c is deleted after 12 hours
+	for i := 0; i < 4*24; i++ {
+		usages := []status.DirUsage{{Path: "/data/a", Used: 1}, {Path: "/data/b", Used: 2}}
+		owners := map[string]Owner{"/data/a": {PVName: "pv-a"}}
+		if i < 24 {
+			owners["/data/b"] = Owner{PVName: "pv-b"}
+		}
+		if i < 12 {
+			usages = append(usages, status.DirUsage{Path: "/data/c", Used: 3})
+			owners["/data/c"] = Owner{PVName: "pv-c"}
+		}
+		if err := store.Record(usages, owners); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+		c.t = c.t.Add(time.Hour)
+	}
+
+	for _, path := range []string{"/data/b", "/data/c"} {
+		if points := store.Query(path, time.Time{}, time.Time{}); len(points) != 0 {
+			t.Errorf("Expected the raw history of %s to be evicted, got %d points", path, len(points))
+		}
+		if points, _ := store.QueryStep(path, start, c.t, time.Hour); len(points) != 0 {
+			t.Errorf("Expected the rollups of %s to be evicted, got %d points", path, len(points))
+		}
+	}
+	if points := store.Query("/data/a", time.Time{}, time.Time{}); len(points) != 4*24 {
+		t.Errorf("Expected all %d points of /data/a, got %d", 4*24, len(points))
+	}
+	stats := store.GetHistoryStats()["limits"].(map[string]interface{})
+	if stats["evictedPaths"] != 2 || stats["trackedPaths"] != 1 {
+		t.Errorf("Expected 2 evicted and 1 tracked path, got %v", stats)
+	}
+
+	// The orphaned directory is not recorded again until a new PV uses it
+	record := func(pv string) {
+		t.Helper()
+		owners := map[string]Owner{"/data/a": {PVName: "pv-a"}, "/data/b": {PVName: pv}}
+		if err := store.Record([]status.DirUsage{{Path: "/data/a"}, {Path: "/data/b"}}, owners); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+		c.t = c.t.Add(time.Hour)
+	}
+	record("")
+	record("pv-b")
+	if points := store.Query("/data/b", time.Time{}, time.Time{}); len(points) != 0 {
+		t.Errorf("Expected the evicted path to stay untracked, got %d points", len(points))
+	}
+	record("pv-b2")
+	if points := store.Query("/data/b", time.Time{}, time.Time{}); len(points) != 1 || points[0].PVName != "pv-b2" {
+		t.Errorf("Expected history of the new PV only, got %+v", points)
+	}
+
+	// Eviction survives a restart
+	reopened := newTestStore(t, dir, c, Retention{Raw: 30 * 24 * time.Hour, Hourly: 30 * 24 * time.Hour})
+	if points := reopened.Query("/data/c", time.Time{}, time.Time{}); len(points) != 0 {
+		t.Errorf("Expected evicted history to stay gone after reopening, got %d points", len(points))
+	}
+	if points := reopened.Query("/data/a", time.Time{}, time.Time{}); len(points) != 4*24+3 {
+		t.Errorf("Expected %d points of /data/a after reopening, got %d", 4*24+3, len(points))
+	}
+}
+
+func TestStoreLimitPaths(t *testing.T) {
+	c := &clock{t: time.Now()}
+	store := newTestStore(t, filepath.Join(t.TempDir(), "history"), c, Retention{Raw: 24 * time.Hour})
+	store.SetLimits(Limits{MaxPaths: 2})
+
+	usages := []status.DirUsage{{Path: "/data/a"}, {Path: "/data/b"}, {Path: "/data/c"}}
+	if err := store.Record(usages, nil); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	if points := store.Query("/data/c", time.Time{}, time.Time{}); len(points) != 0 {
+		t.Errorf("Expected the path over the limit not to be recorded, got %d points", len(points))
+	}
+	stats := store.GetHistoryStats()["limits"].(map[string]interface{})
+	if stats["rejectedPaths"] != 1 || stats["trackedPaths"] != 2 {
+		t.Errorf("Expected 1 rejected and 2 tracked paths, got %v", stats)
+	}
+}
+
+func TestStoreLimitSize(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "history")
+	start := dayOf(time.Now()).AddDate(0, 0, -4)
+	c := &clock{t: start}
+	store := newTestStore(t, dir, c, Retention{Raw: 30 * 24 * time.Hour, Hourly: 30 * 24 * time.Hour})
+
+	record := func(hours int) {
+		for i := 0; i < hours; i++ {
+			if err := store.Record([]status.DirUsage{{Path: "/data/a", Used: uint64(i)}}, nil); err != nil {
+				t.Fatalf("Record failed: %v", err)
+			}
+			c.t = c.t.Add(time.Hour)
+		}
+	}
+	record(3*24 + 1)
+
+	// Room for all but the oldest raw day, and a few more snapshots
+	oldest := store.sealed[0]
+	store.SetLimits(Limits{MaxBytes: store.diskBytes() - oldest.size + 1000})
+	record(1)
+
+	if len(store.sealed) != 2 || !store.sealed[0].day.Equal(start.AddDate(0, 0, 1)) {
+		t.Fatalf("Expected the oldest raw day to be dropped, got %d days", len(store.sealed))
+	}
+	if size := store.diskBytes(); size > store.limits.MaxBytes {
+		t.Errorf("Expected at most %d bytes, got %d", store.limits.MaxBytes, size)
+	}
+
+	// The dropped day is still answered from hourly rollups
+	points, resolution := store.QueryStep("/data/a", start, start.Add(23*time.Hour), 0)
+	if resolution != time.Hour || len(points) != 24 {
+		t.Errorf("Expected 24 hourly points for the dropped day, got %d at %v", len(points), resolution)
+	}
+	stats := store.GetHistoryStats()["limits"].(map[string]interface{})
+	if stats["droppedDays"] != 1 {
+		t.Errorf("Expected 1 dropped day, got %v", stats)
+	}
+}
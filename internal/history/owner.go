@@ -0,0 +1,119 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"sort"
+	"time"
+)
+
+// Owner identifies the PV using a directory when a snapshot was taken.
+// Directories without a PV (orphans, or history recorded before owners were
+// tracked) have an empty PVName.
+type Owner struct {
+	PVName       string `json:"pvName,omitempty"`
+	PVCName      string `json:"pvcName,omitempty"`
+	Namespace    string `json:"namespace,omitempty"`
+	StorageClass string `json:"storageClass,omitempty"`
+	ProjectID    uint32 `json:"projectId,omitempty"`
+}
+
+// Selector picks history by the owner recorded with each snapshot; empty
+// fields match everything
+type Selector struct {
+	Namespace string
+	PVCName   string
+}
+
+// IsZero reports whether the selector matches everything
+func (s Selector) IsZero() bool {
+	return s.Namespace == "" && s.PVCName == ""
+}
+
+// Matches reports whether a snapshot owned by o is selected
+func (s Selector) Matches(o Owner) bool {
+	if s.Namespace != "" && o.Namespace != s.Namespace {
+		return false
+	}
+	if s.PVCName != "" && o.PVCName != s.PVCName {
+		return false
+	}
+	return true
+}
+
+// currentOwner drops the points of a path that belong to an earlier PV, so
+// a PV recreated at the same path starts its trend and forecast afresh.
+// Points without a recorded owner are kept.
+func currentOwner(points []UsageHistory) []UsageHistory {
+	if len(points) == 0 {
+		return points
+	}
+	pv := points[len(points)-1].PVName
+	if pv == "" {
+		return points
+	}
+	for i := len(points) - 1; i >= 0; i-- {
+		if p := points[i].PVName; p != "" && p != pv {
+			return points[i+1:]
+		}
+	}
+	return points
+}
+
+// filterOwner returns the points selected by sel
+func filterOwner(points []UsageHistory, sel Selector) []UsageHistory {
+	if sel.IsZero() {
+		return points
+	}
+	var result []UsageHistory
+	for _, p := range points {
+		if sel.Matches(p.Owner) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// QuerySelector returns the history of every path with snapshots selected
+// by sel, ordered by timestamp and path, at the resolution QueryStep picks
+// for the range
+func (h *Store) QuerySelector(sel Selector, start, end time.Time, step time.Duration) ([]UsageHistory, time.Duration) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	resolution := h.interval
+	if t := h.resolve(start, step); t != nil {
+		resolution = t.step
+	}
+	if step > resolution {
+		resolution = step
+	}
+
+	var result []UsageHistory
+	for path := range h.paths() {
+		points, _ := h.queryStep(path, start, end, step)
+		result = append(result, filterOwner(points, sel)...)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].Timestamp.Equal(result[j].Timestamp) {
+			return result[i].Timestamp.Before(result[j].Timestamp)
+		}
+		return result[i].Path < result[j].Path
+	})
+	return result, resolution
+}
@@ -0,0 +1,399 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// A minimal Parquet writer for history exports: one flat schema of
+// required columns, PLAIN encoding, no compression, one data page per
+// column chunk. That is enough for pandas, DuckDB, Spark and friends,
+// without pulling a Parquet library into the agent.
+
+const (
+	parquetMagic = "PAR1"
+
+	// parquetRowGroupRows bounds the rows buffered per row group
+	parquetRowGroupRows = 64 * 1024
+)
+
+// Parquet physical types, converted types and other enum values used here
+const (
+	pqInt32     = 1
+	pqInt64     = 2
+	pqDouble    = 5
+	pqByteArray = 6
+
+	pqRequired = 0
+
+	pqConvertedUTF8            = 0
+	pqConvertedTimestampMicros = 10
+
+	pqEncodingPlain = 0
+	pqEncodingRLE   = 3
+
+	pqCodecUncompressed = 0
+	pqPageData          = 0
+)
+
+// parquetColumn is one column of the export schema
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32 // -1 for none
+	timestamp bool  // logical type TIMESTAMP(UTC, MICROS)
+	value     func(r ExportRecord, buf *bytes.Buffer)
+}
+
+var exportColumns = []parquetColumn{
+	{"timestamp", pqInt64, pqConvertedTimestampMicros, true, func(r ExportRecord, buf *bytes.Buffer) {
+		putInt64(buf, r.Timestamp.UnixMicro())
+	}},
+	{"resolution", pqByteArray, pqConvertedUTF8, false, func(r ExportRecord, buf *bytes.Buffer) {
+		putByteArray(buf, r.Resolution)
+	}},
+	{"path", pqByteArray, pqConvertedUTF8, false, func(r ExportRecord, buf *bytes.Buffer) {
+		putByteArray(buf, r.Path)
+	}},
+	{"dir_name", pqByteArray, pqConvertedUTF8, false, func(r ExportRecord, buf *bytes.Buffer) {
+		putByteArray(buf, r.DirName)
+	}},
+	{"used", pqInt64, -1, false, func(r ExportRecord, buf *bytes.Buffer) {
+		putInt64(buf, int64(r.Used))
+	}},
+	{"quota", pqInt64, -1, false, func(r ExportRecord, buf *bytes.Buffer) {
+		putInt64(buf, int64(r.Quota))
+	}},
+	{"used_pct", pqDouble, -1, false, func(r ExportRecord, buf *bytes.Buffer) {
+		_ = binary.Write(buf, binary.LittleEndian, math.Float64bits(r.UsedPct))
+	}},
+	{"min_used", pqInt64, -1, false, func(r ExportRecord, buf *bytes.Buffer) {
+		putInt64(buf, int64(r.MinUsed))
+	}},
+	{"max_used", pqInt64, -1, false, func(r ExportRecord, buf *bytes.Buffer) {
+		putInt64(buf, int64(r.MaxUsed))
+	}},
+	{"samples", pqInt32, -1, false, func(r ExportRecord, buf *bytes.Buffer) {
+		_ = binary.Write(buf, binary.LittleEndian, int32(r.Samples))
+	}},
+	{"pv_name", pqByteArray, pqConvertedUTF8, false, func(r ExportRecord, buf *bytes.Buffer) {
+		putByteArray(buf, r.PVName)
+	}},
+	{"pvc_name", pqByteArray, pqConvertedUTF8, false, func(r ExportRecord, buf *bytes.Buffer) {
+		putByteArray(buf, r.PVCName)
+	}},
+	{"namespace", pqByteArray, pqConvertedUTF8, false, func(r ExportRecord, buf *bytes.Buffer) {
+		putByteArray(buf, r.Namespace)
+	}},
+	{"storage_class", pqByteArray, pqConvertedUTF8, false, func(r ExportRecord, buf *bytes.Buffer) {
+		putByteArray(buf, r.StorageClass)
+	}},
+	{"project_id", pqInt64, -1, false, func(r ExportRecord, buf *bytes.Buffer) {
+		putInt64(buf, int64(r.ProjectID))
+	}},
+}
+
+func putInt64(buf *bytes.Buffer, v int64) {
+	_ = binary.Write(buf, binary.LittleEndian, v)
+}
+
+func putByteArray(buf *bytes.Buffer, s string) {
+	_ = binary.Write(buf, binary.LittleEndian, uint32(len(s)))
+	buf.WriteString(s)
+}
+
+// parquetWriter writes export records as a Parquet file
+type parquetWriter struct {
+	w         io.Writer
+	offset    int64
+	rows      []ExportRecord
+	rowGroups [][]byte // encoded RowGroup structs
+	numRows   int64
+}
+
+func newParquetWriter(w io.Writer) (*parquetWriter, error) {
+	pw := &parquetWriter{w: w}
+	if err := pw.write([]byte(parquetMagic)); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+func (pw *parquetWriter) write(data []byte) error {
+	n, err := pw.w.Write(data)
+	pw.offset += int64(n)
+	return err
+}
+
+// Write buffers a record, flushing a row group when it is full
+func (pw *parquetWriter) Write(r ExportRecord) error {
+	pw.rows = append(pw.rows, r)
+	if len(pw.rows) >= parquetRowGroupRows {
+		return pw.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered rows as one row group
+func (pw *parquetWriter) flush() error {
+	if len(pw.rows) == 0 {
+		return nil
+	}
+
+	var (
+		chunks    thriftWriter
+		totalSize int64
+	)
+	chunks.listHeader(len(exportColumns), thriftStruct)
+	for _, col := range exportColumns {
+		var values bytes.Buffer
+		for _, r := range pw.rows {
+			col.value(r, &values)
+		}
+
+		var header thriftWriter
+		header.i32Field(1, pqPageData)
+		header.i32Field(2, int32(values.Len()))
+		header.i32Field(3, int32(values.Len()))
+		header.structField(5)
+		header.i32Field(1, int32(len(pw.rows)))
+		header.i32Field(2, pqEncodingPlain)
+		header.i32Field(3, pqEncodingRLE)
+		header.i32Field(4, pqEncodingRLE)
+		header.structEnd()
+		header.structEnd()
+
+		pageOffset := pw.offset
+		if err := pw.write(header.buf.Bytes()); err != nil {
+			return err
+		}
+		if err := pw.write(values.Bytes()); err != nil {
+			return err
+		}
+		size := int64(header.buf.Len() + values.Len())
+		totalSize += size
+
+		// ColumnChunk
+		chunks.beginStruct()
+		chunks.i64Field(2, pageOffset)
+		chunks.structField(3)
+		chunks.i32Field(1, col.typ)
+		chunks.listField(2, 2, thriftI32)
+		chunks.i32(pqEncodingPlain)
+		chunks.i32(pqEncodingRLE)
+		chunks.listField(3, 1, thriftBinary)
+		chunks.binary(col.name)
+		chunks.i32Field(4, pqCodecUncompressed)
+		chunks.i64Field(5, int64(len(pw.rows)))
+		chunks.i64Field(6, size)
+		chunks.i64Field(7, size)
+		chunks.i64Field(9, pageOffset)
+		chunks.structEnd()
+		chunks.structEnd()
+	}
+
+	// RowGroup
+	var rg thriftWriter
+	rg.beginStruct()
+	rg.listField(1, len(exportColumns), thriftStruct)
+	rg.buf.Write(chunks.buf.Bytes()[listHeaderLen(len(exportColumns)):])
+	rg.i64Field(2, totalSize)
+	rg.i64Field(3, int64(len(pw.rows)))
+	rg.structEnd()
+
+	pw.rowGroups = append(pw.rowGroups, rg.buf.Bytes())
+	pw.numRows += int64(len(pw.rows))
+	pw.rows = pw.rows[:0]
+	return nil
+}
+
+// Close writes the last row group and the file footer
+func (pw *parquetWriter) Close() error {
+	if err := pw.flush(); err != nil {
+		return err
+	}
+
+	var meta thriftWriter
+	meta.i32Field(1, 1)
+
+	meta.listField(2, len(exportColumns)+1, thriftStruct)
+	meta.beginStruct()
+	meta.binaryField(4, "usage_history")
+	meta.i32Field(5, int32(len(exportColumns)))
+	meta.structEnd()
+	for _, col := range exportColumns {
+		meta.beginStruct()
+		meta.i32Field(1, col.typ)
+		meta.i32Field(3, pqRequired)
+		meta.binaryField(4, col.name)
+		if col.converted >= 0 {
+			meta.i32Field(6, col.converted)
+		}
+		// LogicalType union
+		switch {
+		case col.timestamp:
+			meta.structField(10)
+			meta.structField(8) // TIMESTAMP
+			meta.boolField(1, true)
+			meta.structField(2) // unit
+			meta.structField(2) // MICROS
+			meta.structEnd()
+			meta.structEnd()
+			meta.structEnd()
+			meta.structEnd()
+		case col.converted == pqConvertedUTF8:
+			meta.structField(10)
+			meta.structField(1) // STRING
+			meta.structEnd()
+			meta.structEnd()
+		}
+		meta.structEnd()
+	}
+
+	meta.i64Field(3, pw.numRows)
+	meta.listField(4, len(pw.rowGroups), thriftStruct)
+	for _, rg := range pw.rowGroups {
+		meta.buf.Write(rg)
+	}
+	meta.binaryField(6, "nfs-quota-agent")
+	meta.structEnd()
+
+	if err := pw.write(meta.buf.Bytes()); err != nil {
+		return err
+	}
+	var tail [8]byte
+	binary.LittleEndian.PutUint32(tail[:4], uint32(meta.buf.Len()))
+	copy(tail[4:], parquetMagic)
+	return pw.write(tail[:])
+}
+
+// Thrift compact protocol, as much as the Parquet metadata needs
+
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+type thriftWriter struct {
+	buf    bytes.Buffer
+	last   int16   // last field ID of the current struct
+	nested []int16 // last field IDs of the enclosing structs
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	t.buf.Write(b[:n])
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(zigzag(int64(id)))
+	}
+	t.last = id
+}
+
+func (t *thriftWriter) i32(v int32) {
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) binary(s string) {
+	t.varint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+func (t *thriftWriter) i32Field(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.i32(v)
+}
+
+func (t *thriftWriter) i64Field(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) boolField(id int16, v bool) {
+	if v {
+		t.fieldHeader(id, thriftTrue)
+	} else {
+		t.fieldHeader(id, thriftFalse)
+	}
+}
+
+func (t *thriftWriter) binaryField(id int16, s string) {
+	t.fieldHeader(id, thriftBinary)
+	t.binary(s)
+}
+
+func listHeaderLen(size int) int {
+	if size < 15 {
+		return 1
+	}
+	var b [binary.MaxVarintLen64]byte
+	return 1 + binary.PutUvarint(b[:], uint64(size))
+}
+
+func (t *thriftWriter) listHeader(size int, elem byte) {
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elem)
+		return
+	}
+	t.buf.WriteByte(0xf0 | elem)
+	t.varint(uint64(size))
+}
+
+func (t *thriftWriter) listField(id int16, size int, elem byte) {
+	t.fieldHeader(id, thriftList)
+	t.listHeader(size, elem)
+}
+
+// structField starts a struct-typed field; close it with structEnd
+func (t *thriftWriter) structField(id int16) {
+	t.fieldHeader(id, thriftStruct)
+	t.beginStruct()
+}
+
+// beginStruct starts a struct (a list element or a field value)
+func (t *thriftWriter) beginStruct() {
+	t.nested = append(t.nested, t.last)
+	t.last = 0
+}
+
+// structEnd writes the stop byte of the current struct
+func (t *thriftWriter) structEnd() {
+	t.buf.WriteByte(0)
+	if n := len(t.nested); n > 0 {
+		t.last = t.nested[n-1]
+		t.nested = t.nested[:n-1]
+	}
+}
@@ -0,0 +1,312 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Retention says how long each resolution of history is kept. Raw
+// snapshots are rolled up into hourly and daily points when their day is
+// sealed, so old history costs one point per path per hour or day instead
+// of one per interval. A zero Hourly or Daily disables that rollup; a zero
+// Raw keeps raw snapshots forever.
+type Retention struct {
+	Raw    time.Duration
+	Hourly time.Duration
+	Daily  time.Duration
+}
+
+// Max returns how far back any history is kept (0 = forever)
+func (r Retention) Max() time.Duration {
+	if r.Raw <= 0 {
+		return 0
+	}
+	max := r.Raw
+	if r.Hourly > max {
+		max = r.Hourly
+	}
+	if r.Daily > max {
+		max = r.Daily
+	}
+	return max
+}
+
+// tier is one rollup resolution, stored as sealed daily segments in its own
+// subdirectory
+type tier struct {
+	name      string
+	step      time.Duration
+	retention time.Duration
+	dir       string
+	segments  []*segment // oldest first
+	floor     time.Time  // oldest day left by the size limit
+}
+
+func newTier(storeDir, name string, step, retention time.Duration) (*tier, error) {
+	t := &tier{
+		name:      name,
+		step:      step,
+		retention: retention,
+		dir:       filepath.Join(storeDir, name),
+	}
+	if err := os.MkdirAll(t.dir, 0755); err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(t.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if filepath.Ext(f.Name()) != indexExt {
+			continue
+		}
+		day, err := time.Parse(dayLayout, f.Name()[:len(f.Name())-len(indexExt)])
+		if err != nil {
+			continue
+		}
+		seg, err := loadSealed(t.dir, day)
+		if err != nil {
+			slog.Warn("Skipping unreadable history rollup", "tier", name, "day", day.Format(dayLayout), "error", err)
+			continue
+		}
+		t.segments = append(t.segments, seg)
+	}
+	sort.Slice(t.segments, func(i, j int) bool { return t.segments[i].day.Before(t.segments[j].day) })
+	return t, nil
+}
+
+func (t *tier) has(day time.Time) bool {
+	for _, s := range t.segments {
+		if s.day.Equal(day) {
+			return true
+		}
+	}
+	return false
+}
+
+// coveredUntil returns the end of the last day rolled up into the tier
+func (t *tier) coveredUntil() time.Time {
+	if len(t.segments) == 0 {
+		return time.Time{}
+	}
+	return t.segments[len(t.segments)-1].day.Add(24 * time.Hour)
+}
+
+// add writes the rollup of one raw day
+func (t *tier) add(day time.Time, samples map[string][]UsageHistory) error {
+	seg := &segment{day: day, samples: make(map[string][]UsageHistory, len(samples))}
+	for path, points := range samples {
+		for _, p := range downsample(points, t.step) {
+			seg.samples[path] = append(seg.samples[path], p)
+			seg.add(p)
+		}
+	}
+	if seg.entries == 0 {
+		return nil
+	}
+
+	sealed, err := seg.seal(t.dir)
+	if err != nil {
+		return fmt.Errorf("failed to write %s rollup for %s: %w", t.name, day.Format(dayLayout), err)
+	}
+	t.segments = append(t.segments, sealed)
+	sort.Slice(t.segments, func(i, j int) bool { return t.segments[i].day.Before(t.segments[j].day) })
+	return nil
+}
+
+// prune drops days past the tier's retention
+func (t *tier) prune(now time.Time) {
+	cutoff := now.Add(-t.retention)
+	kept := t.segments[:0]
+	for _, s := range t.segments {
+		if s.end.Before(cutoff) {
+			s.remove(t.dir)
+			continue
+		}
+		kept = append(kept, s)
+	}
+	t.segments = kept
+}
+
+// downsample merges the points of one path into buckets of step, aligned to
+// UTC. Used becomes the average over the bucket (weighted by the samples
+// behind each point), MinUsed/MaxUsed its range, and Quota the last value.
+func downsample(points []UsageHistory, step time.Duration) []UsageHistory {
+	var (
+		result []UsageHistory
+		cur    *UsageHistory
+		sum    float64
+		pctSum float64
+	)
+	flush := func() {
+		if cur == nil {
+			return
+		}
+		cur.Used = uint64(sum/float64(cur.Samples) + 0.5)
+		cur.UsedPct = pctSum / float64(cur.Samples)
+		result = append(result, *cur)
+	}
+
+	for _, p := range points {
+		bucket := p.Timestamp.UTC().Truncate(step)
+		weight := p.Samples
+		min, max := p.MinUsed, p.MaxUsed
+		if weight == 0 {
+			// raw snapshot
+			weight = 1
+			min, max = p.Used, p.Used
+		}
+
+		if cur == nil || !cur.Timestamp.Equal(bucket) {
+			flush()
+			cur = &UsageHistory{
+				Timestamp: bucket,
+				Path:      p.Path,
+				MinUsed:   min,
+				MaxUsed:   max,
+			}
+			sum, pctSum = 0, 0
+		}
+		cur.DirName = p.DirName
+		cur.Quota = p.Quota
+		cur.Owner = p.Owner
+		if min < cur.MinUsed {
+			cur.MinUsed = min
+		}
+		if max > cur.MaxUsed {
+			cur.MaxUsed = max
+		}
+		cur.Samples += weight
+		sum += float64(p.Used) * float64(weight)
+		pctSum += p.UsedPct * float64(weight)
+	}
+	flush()
+	return result
+}
+
+// rollupMissing rolls up sealed raw days that a tier does not have yet and
+// that are still within its retention (must be called with lock held)
+func (h *Store) rollupMissing(now time.Time) {
+	for _, s := range h.sealed {
+		var missing []*tier
+		for _, t := range h.tiers {
+			if !t.has(s.day) && !s.end.Before(now.Add(-t.retention)) {
+				missing = append(missing, t)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		if err := h.rollup(s, missing); err != nil {
+			slog.Warn("Failed to roll up history", "day", s.name(), "error", err)
+		}
+	}
+}
+
+// rollup writes the rollups of one raw day into the given tiers
+func (h *Store) rollup(s *segment, tiers []*tier) error {
+	samples, err := s.readAll(h.dir)
+	if err != nil {
+		return err
+	}
+	for _, t := range tiers {
+		if err := t.add(s.day, samples); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve picks where a query for [start, end] at step is answered from:
+// the coarsest level not coarser than step (the finest one for step 0)
+// among those still holding start. nil means raw snapshots.
+//
+// Retention drops whole days, so a level holds everything from the start
+// of the day its retention reaches back to. Comparing against that day
+// rather than the exact cutoff keeps a start of now minus the retention,
+// computed a moment earlier by the caller, on that level.
+func (h *Store) resolve(start time.Time, step time.Duration) *tier {
+	now := h.now()
+	covers := func(retention time.Duration, floor time.Time) bool {
+		if !floor.IsZero() && (start.IsZero() || start.Before(floor)) {
+			return false
+		}
+		return retention <= 0 || (!start.IsZero() && !start.Before(dayOf(now.Add(-retention))))
+	}
+
+	var candidates []*tier
+	rawCovers := covers(h.retention.Raw, h.rawFloor)
+	for _, t := range h.tiers {
+		if covers(t.retention, t.floor) {
+			candidates = append(candidates, t)
+		}
+	}
+	if !rawCovers && len(candidates) == 0 {
+		// Nothing reaches back that far; use the longest-kept level
+		if len(h.tiers) == 0 {
+			return nil
+		}
+		return h.tiers[len(h.tiers)-1]
+	}
+
+	if step <= 0 {
+		if rawCovers {
+			return nil
+		}
+		return candidates[0]
+	}
+
+	var chosen *tier
+	for _, t := range candidates {
+		if t.step <= step {
+			chosen = t
+		}
+	}
+	if chosen == nil && !rawCovers {
+		chosen = candidates[0]
+	}
+	return chosen
+}
+
+// queryTier returns the rollup points of path in [start, end]. The part of
+// the range not rolled up yet (the current day) is rolled up on the fly
+// from raw snapshots (must be called with lock held).
+func (h *Store) queryTier(t *tier, path string, start, end time.Time) []UsageHistory {
+	if t.retention > 0 {
+		if cutoff := h.now().Add(-t.retention); start.Before(cutoff) {
+			start = cutoff
+		}
+	}
+	result := querySegments(t.dir, t.segments, path, start, end)
+
+	rawStart := t.coveredUntil()
+	if rawStart.Before(start) {
+		rawStart = start
+	}
+	if end.IsZero() || rawStart.Before(end) {
+		raw := h.query(path, rawStart, end)
+		result = append(result, downsample(raw, t.step)...)
+	}
+	return result
+}
@@ -0,0 +1,468 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/dasomel/nfs-quota-agent/internal/util"
+)
+
+// History is partitioned into one segment per UTC day. The current day is
+// an append-only JSON-lines file; when the day is over it is sealed into a
+// file with each path's samples stored contiguously, plus an index of where
+// every path starts, so a range query for one path reads only its own bytes.
+const (
+	dayLayout = "2006-01-02"
+
+	activeExt = ".jsonl" // current day, one sample per line, append-only
+	sealedExt = ".seg"   // past day, samples grouped by path
+	indexExt  = ".idx"   // index of a sealed segment; written last
+)
+
+// span locates one path's samples inside a sealed segment
+type span struct {
+	Offset int64     `json:"offset"`
+	Length int64     `json:"length"`
+	Count  int       `json:"count"`
+	First  time.Time `json:"first"`
+	Last   time.Time `json:"last"`
+}
+
+// segmentIndex is the on-disk index of a sealed segment
+type segmentIndex struct {
+	Day     string          `json:"day"`
+	Start   time.Time       `json:"start"`
+	End     time.Time       `json:"end"`
+	Entries int             `json:"entries"`
+	Paths   map[string]span `json:"paths"`
+}
+
+// segment is one day of history
+type segment struct {
+	day     time.Time // midnight UTC
+	start   time.Time // first sample
+	end     time.Time // last sample
+	entries int
+	size    int64 // bytes on disk
+
+	// sealed segments: where each path's samples are
+	paths map[string]span
+
+	// active segment: the day's samples, by path, and the file appended to
+	samples map[string][]UsageHistory
+	file    *os.File
+}
+
+func dayOf(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func (s *segment) name() string {
+	return s.day.Format(dayLayout)
+}
+
+// add updates the segment bounds for a new sample
+func (s *segment) add(e UsageHistory) {
+	if s.entries == 0 || e.Timestamp.Before(s.start) {
+		s.start = e.Timestamp
+	}
+	if s.entries == 0 || e.Timestamp.After(s.end) {
+		s.end = e.Timestamp
+	}
+	s.entries++
+}
+
+// hasPath reports whether the segment has samples of path
+func (s *segment) hasPath(path string) bool {
+	if s.samples != nil {
+		return len(s.samples[path]) > 0
+	}
+	_, ok := s.paths[path]
+	return ok
+}
+
+// openActive opens (or creates) the append-only segment of a day and loads
+// its samples. A torn last line left by a crash mid-append is cut off so
+// the next append starts on a clean line.
+func openActive(dir string, day time.Time) (*segment, error) {
+	s := &segment{day: day, samples: make(map[string][]UsageHistory)}
+	path := filepath.Join(dir, s.name()+activeExt)
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	valid := int64(bytes.LastIndexByte(data, '\n') + 1)
+	if valid < int64(len(data)) {
+		slog.Warn("Discarding torn history record", "file", path, "bytes", int64(len(data))-valid)
+		if err := f.Truncate(valid); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if err := decodeLines(data[:valid], func(e UsageHistory) {
+		s.samples[e.Path] = append(s.samples[e.Path], e)
+		s.add(e)
+	}); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	s.file = f
+	s.size = valid
+	return s, nil
+}
+
+// readActive loads the samples of a day's append-only segment without
+// opening it for writing; a torn last line is ignored
+func readActive(dir string, day time.Time) (*segment, error) {
+	s := &segment{day: day, samples: make(map[string][]UsageHistory)}
+	path := filepath.Join(dir, s.name()+activeExt)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	valid := bytes.LastIndexByte(data, '\n') + 1
+	if err := decodeLines(data[:valid], func(e UsageHistory) {
+		s.samples[e.Path] = append(s.samples[e.Path], e)
+		s.add(e)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	s.size = int64(len(data))
+	return s, nil
+}
+
+// appendBatch writes one snapshot with a single write and syncs it. If the
+// write fails half-way the file is truncated back so no partial record is
+// left behind.
+func (s *segment) appendBatch(entries []UsageHistory) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.file.Write(buf.Bytes()); err != nil {
+		if terr := s.file.Truncate(s.size); terr == nil {
+			_, _ = s.file.Seek(s.size, io.SeekStart)
+		}
+		return fmt.Errorf("failed to append history: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync history: %w", err)
+	}
+	s.size += int64(buf.Len())
+
+	for _, e := range entries {
+		s.samples[e.Path] = append(s.samples[e.Path], e)
+		s.add(e)
+	}
+	return nil
+}
+
+// seal rewrites a finished day grouped by path and writes its index. The
+// index is renamed into place last, so a segment without one is treated as
+// unsealed and its append-only file is sealed again on the next start.
+func (s *segment) seal(dir string) (*segment, error) {
+	paths := make([]string, 0, len(s.samples))
+	for p := range s.samples {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	idx := segmentIndex{
+		Day:     s.name(),
+		Start:   s.start,
+		End:     s.end,
+		Entries: s.entries,
+		Paths:   make(map[string]span, len(paths)),
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, p := range paths {
+		samples := s.samples[p]
+		sort.SliceStable(samples, func(i, j int) bool {
+			return samples[i].Timestamp.Before(samples[j].Timestamp)
+		})
+		offset := int64(buf.Len())
+		for _, e := range samples {
+			if err := enc.Encode(e); err != nil {
+				return nil, err
+			}
+		}
+		idx.Paths[p] = span{
+			Offset: offset,
+			Length: int64(buf.Len()) - offset,
+			Count:  len(samples),
+			First:  samples[0].Timestamp,
+			Last:   samples[len(samples)-1].Timestamp,
+		}
+	}
+
+	base := filepath.Join(dir, s.name())
+	if err := util.WriteFileAtomic(base+sealedExt, buf.Bytes(), 0644); err != nil {
+		return nil, err
+	}
+	indexData, err := json.Marshal(idx)
+	if err != nil {
+		return nil, err
+	}
+	if err := util.WriteFileAtomic(base+indexExt, indexData, 0644); err != nil {
+		return nil, err
+	}
+
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	if err := os.Remove(base + activeExt); err != nil && !os.IsNotExist(err) {
+		slog.Warn("Failed to remove sealed history segment", "file", base+activeExt, "error", err)
+	}
+
+	return &segment{
+		day:     s.day,
+		start:   s.start,
+		end:     s.end,
+		entries: s.entries,
+		size:    int64(buf.Len() + len(indexData)),
+		paths:   idx.Paths,
+	}, nil
+}
+
+// loadSealed reads the index of a sealed segment
+func loadSealed(dir string, day time.Time) (*segment, error) {
+	s := &segment{day: day}
+	base := filepath.Join(dir, s.name())
+
+	indexData, err := os.ReadFile(base + indexExt)
+	if err != nil {
+		return nil, err
+	}
+	var idx segmentIndex
+	if err := json.Unmarshal(indexData, &idx); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", base+indexExt, err)
+	}
+	info, err := os.Stat(base + sealedExt)
+	if err != nil {
+		return nil, err
+	}
+
+	s.start = idx.Start
+	s.end = idx.End
+	s.entries = idx.Entries
+	s.paths = idx.Paths
+	s.size = info.Size() + int64(len(indexData))
+	return s, nil
+}
+
+// read returns the samples of path in the segment between start and end
+// (zero means unbounded)
+func (s *segment) read(dir, path string, start, end time.Time) ([]UsageHistory, error) {
+	var samples []UsageHistory
+	if s.samples != nil {
+		samples = s.samples[path]
+	} else {
+		sp, ok := s.paths[path]
+		if !ok {
+			return nil, nil
+		}
+		if (!start.IsZero() && sp.Last.Before(start)) || (!end.IsZero() && sp.First.After(end)) {
+			return nil, nil
+		}
+
+		f, err := os.Open(filepath.Join(dir, s.name()+sealedExt))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		data := make([]byte, sp.Length)
+		if _, err := f.ReadAt(data, sp.Offset); err != nil {
+			return nil, fmt.Errorf("failed to read history segment %s: %w", s.name(), err)
+		}
+		samples = make([]UsageHistory, 0, sp.Count)
+		if err := decodeLines(data, func(e UsageHistory) {
+			samples = append(samples, e)
+		}); err != nil {
+			return nil, fmt.Errorf("failed to read history segment %s: %w", s.name(), err)
+		}
+	}
+
+	var result []UsageHistory
+	for _, e := range samples {
+		if !start.IsZero() && e.Timestamp.Before(start) {
+			continue
+		}
+		if !end.IsZero() && e.Timestamp.After(end) {
+			continue
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+// readAll returns all samples of the segment by path
+func (s *segment) readAll(dir string) (map[string][]UsageHistory, error) {
+	if s.samples != nil {
+		return s.samples, nil
+	}
+	data, err := os.ReadFile(filepath.Join(dir, s.name()+sealedExt))
+	if err != nil {
+		return nil, err
+	}
+	samples := make(map[string][]UsageHistory, len(s.paths))
+	if err := decodeLines(data, func(e UsageHistory) {
+		samples[e.Path] = append(samples[e.Path], e)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to read history segment %s: %w", s.name(), err)
+	}
+	return samples, nil
+}
+
+// querySegments reads the samples of path in [start, end] from the
+// segments overlapping the range that hold path
+func querySegments(dir string, segs []*segment, path string, start, end time.Time) []UsageHistory {
+	var result []UsageHistory
+	for _, s := range segs {
+		if s.entries == 0 || !s.hasPath(path) {
+			continue
+		}
+		if (!start.IsZero() && s.end.Before(start)) || (!end.IsZero() && s.start.After(end)) {
+			continue
+		}
+		samples, err := s.read(dir, path, start, end)
+		if err != nil {
+			slog.Warn("Failed to read history", "path", path, "error", err)
+			continue
+		}
+		result = append(result, samples...)
+	}
+
+	// Sort by timestamp
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+	return result
+}
+
+// without rewrites a sealed segment without the given paths and returns
+// it, or nil when nothing is left. The remaining samples are first written
+// as the day's append-only file, which the next start favours over the
+// sealed files, so a crash half-way seals the rewritten data again.
+func (s *segment) without(dir string, drop map[string]*evictedPath) (*segment, error) {
+	found := false
+	for path := range drop {
+		if s.hasPath(path) {
+			found = true
+			break
+		}
+	}
+	if !found || s.samples != nil {
+		return s, nil
+	}
+
+	samples, err := s.readAll(dir)
+	if err != nil {
+		return nil, err
+	}
+	kept := &segment{day: s.day, samples: make(map[string][]UsageHistory, len(samples))}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for path, points := range samples {
+		if drop[path] != nil {
+			continue
+		}
+		kept.samples[path] = points
+		for _, e := range points {
+			if err := enc.Encode(e); err != nil {
+				return nil, err
+			}
+			kept.add(e)
+		}
+	}
+	if kept.entries == 0 {
+		s.remove(dir)
+		return nil, nil
+	}
+
+	base := filepath.Join(dir, s.name())
+	if err := util.WriteFileAtomic(base+activeExt, buf.Bytes(), 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(base + indexExt); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return kept.seal(dir)
+}
+
+// remove deletes the segment's files
+func (s *segment) remove(dir string) {
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	base := filepath.Join(dir, s.name())
+	for _, ext := range []string{indexExt, sealedExt, activeExt} {
+		if err := os.Remove(base + ext); err != nil && !os.IsNotExist(err) {
+			slog.Warn("Failed to remove history segment", "file", base+ext, "error", err)
+		}
+	}
+}
+
+// decodeLines calls fn for every JSON line in data
+func decodeLines(data []byte, fn func(UsageHistory)) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var e UsageHistory
+		if err := json.Unmarshal(line, &e); err != nil {
+			return err
+		}
+		fn(e)
+	}
+	return scanner.Err()
+}
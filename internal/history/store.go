@@ -18,10 +18,12 @@ package history
 
 import (
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -37,9 +39,24 @@ type UsageHistory struct {
 	Used      uint64    `json:"used"`
 	Quota     uint64    `json:"quota"`
 	UsedPct   float64   `json:"usedPct"`
+
+	// The PV using the directory when the snapshot was taken
+	Owner
+
+	// Rollup points only: Used is the average over the bucket, MinUsed and
+	// MaxUsed its range and Samples the number of snapshots behind it
+	MinUsed uint64 `json:"minUsed,omitempty"`
+	MaxUsed uint64 `json:"maxUsed,omitempty"`
+	Samples int    `json:"samples,omitempty"`
+
+	// Carried marks a raw snapshot whose usage was carried forward from an
+	// earlier walk rather than measured. It keeps the directory in sums
+	// over paths, but anomaly detection and forecasts skip it.
+	Carried bool `json:"carried,omitempty"`
 }
 
-// Data stores all history entries
+// Data is the legacy single-file history format, imported into segments
+// on first start
 type Data struct {
 	Entries []UsageHistory `json:"entries"`
 }
@@ -57,163 +74,607 @@ type TrendData struct {
 	Change30d  int64          `json:"change30d"`
 	Trend      string         `json:"trend"` // "up", "down", "stable"
 	History    []UsageHistory `json:"history"`
+	Forecast   *Forecast      `json:"forecast,omitempty"`
+
+	// The current PV of the directory; history of earlier PVs at the same
+	// path is left out of the trend
+	Owner
 }
 
-// Store manages usage history storage
+// Store manages usage history storage. History is kept in a directory of
+// daily segments (see segment.go): snapshots are appended to the current
+// day's file and synced, past days are sealed with a per-path index, and
+// retention drops whole days. Sealed days are also rolled up into coarser
+// tiers (see rollup.go) that outlive the raw snapshots.
 type Store struct {
-	filePath   string
-	interval   time.Duration
-	retention  time.Duration
-	maxEntries int
-	data       Data
-	mu         sync.RWMutex
-}
-
-// NewStore creates a new history store
-func NewStore(filePath string, interval, retention time.Duration) (*Store, error) {
-	// Ensure directory exists
-	dir := filepath.Dir(filePath)
+	dir       string
+	interval  time.Duration
+	retention Retention
+
+	mu     sync.RWMutex
+	sealed []*segment // oldest first
+	active *segment   // current day, nil until the first Record
+	tiers  []*tier    // enabled rollups, finest first
+
+	// readOnly stores (see Open) never write, seal, roll up or prune
+	readOnly bool
+
+	forecastWindow time.Duration
+	forecastMethod string
+	forecasts      []Forecast // cached ForecastAll for the defaults
+	forecastsKey   string
+
+	anomalyConfig AnomalyConfig
+	baselines     map[string]*baseline // per path, built on first Record
+	anomalies     []Anomaly            // kept anomalies, oldest first
+	anomalyCounts map[string]int       // detected since start, by kind
+	onAnomaly     func(Anomaly)
+
+	collection *Collection // how the last snapshot was gathered
+
+	limits   Limits
+	tracked  map[string]*pathState   // nil unless limits are set
+	evicted  map[string]*evictedPath // evicted paths not to record again
+	eviction evictionStats
+	rawFloor time.Time // oldest raw day left by the size limit
+
+	now func() time.Time
+}
+
+// Collection describes how the usages of a snapshot were gathered
+type Collection struct {
+	Time     time.Time        `json:"time"`
+	Duration time.Duration    `json:"duration"` // scanning and recording
+	Scan     status.ScanStats `json:"scan"`
+	Reused   bool             `json:"reused"` // scan made earlier by the metrics endpoint or web UI
+}
+
+// NewStore opens the history in the directory path, creating it if needed.
+// A trailing ".json" is dropped, and a history file at "<dir>.json" written
+// by earlier versions is imported once and renamed to "*.migrated".
+func NewStore(path string, interval time.Duration, retention Retention) (*Store, error) {
+	dir := strings.TrimSuffix(path, ".json")
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, err
 	}
 
 	store := &Store{
-		filePath:   filePath,
-		interval:   interval,
-		retention:  retention,
-		maxEntries: 100000, // Max entries to prevent unbounded growth
-		data:       Data{Entries: []UsageHistory{}},
+		dir:       dir,
+		interval:  interval,
+		retention: retention,
+		now:       time.Now,
+	}
+
+	for _, r := range []struct {
+		name      string
+		step      time.Duration
+		retention time.Duration
+	}{
+		{"hourly", time.Hour, retention.Hourly},
+		{"daily", 24 * time.Hour, retention.Daily},
+	} {
+		if r.retention <= 0 {
+			continue
+		}
+		t, err := newTier(dir, r.name, r.step, r.retention)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s history rollups: %w", r.name, err)
+		}
+		store.tiers = append(store.tiers, t)
 	}
 
-	// Load existing data
 	if err := store.load(); err != nil {
-		slog.Warn("Failed to load existing history", "error", err)
+		return nil, fmt.Errorf("failed to load history from %s: %w", dir, err)
+	}
+	if err := store.importLegacy(dir + ".json"); err != nil {
+		slog.Warn("Failed to import legacy history", "file", dir+".json", "error", err)
+	}
+	if err := store.loadAnomalies(); err != nil {
+		slog.Warn("Failed to load usage anomalies", "error", err)
 	}
 
 	return store, nil
 }
 
-// Interval returns the collection interval
+// Open opens an existing history directory read-only, e.g. for CLI
+// commands running next to the agent. Nothing is sealed, rolled up or
+// pruned, and each resolution is assumed to reach back as far as its oldest
+// day on disk.
+func Open(path string) (*Store, error) {
+	dir := strings.TrimSuffix(path, ".json")
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a history directory", dir)
+	}
+
+	store := &Store{
+		dir:      dir,
+		readOnly: true,
+		now:      time.Now,
+	}
+	if err := store.load(); err != nil {
+		return nil, fmt.Errorf("failed to load history from %s: %w", dir, err)
+	}
+
+	store.interval = store.inferInterval()
+
+	now := store.now()
+	store.retention.Raw = time.Nanosecond // nothing but what is on disk
+	if segs := store.segments(); len(segs) > 0 {
+		store.retention.Raw = now.Sub(segs[0].day)
+	}
+	for _, r := range []struct {
+		name string
+		step time.Duration
+	}{
+		{"hourly", time.Hour},
+		{"daily", 24 * time.Hour},
+	} {
+		if _, err := os.Stat(filepath.Join(dir, r.name)); err != nil {
+			continue
+		}
+		t, err := newTier(dir, r.name, r.step, 0)
+		if err != nil || len(t.segments) == 0 {
+			continue
+		}
+		t.retention = now.Sub(t.segments[0].day)
+		store.tiers = append(store.tiers, t)
+	}
+	return store, nil
+}
+
+// Interval returns the collection interval. For stores opened read-only it
+// is inferred from the snapshots on disk, or 0 if there are none.
 func (h *Store) Interval() time.Duration {
 	return h.interval
 }
 
-// load reads history from file
+// inferInterval estimates the collection interval as the median gap between
+// consecutive snapshots of a path in the newest raw segment
+func (h *Store) inferInterval() time.Duration {
+	segs := h.segments()
+	for i := len(segs) - 1; i >= 0; i-- {
+		samples, err := segs[i].readAll(h.dir)
+		if err != nil {
+			continue
+		}
+		var gaps []time.Duration
+		for _, points := range samples {
+			for j := 1; j < len(points); j++ {
+				if gap := points[j].Timestamp.Sub(points[j-1].Timestamp); gap > 0 {
+					gaps = append(gaps, gap)
+				}
+			}
+		}
+		if len(gaps) == 0 {
+			continue
+		}
+		sort.Slice(gaps, func(a, b int) bool { return gaps[a] < gaps[b] })
+		return gaps[len(gaps)/2]
+	}
+	return 0
+}
+
+// Dir returns the directory holding the segments
+func (h *Store) Dir() string {
+	return h.dir
+}
+
+// load opens the segments in the store directory. Append-only files of past
+// days (the agent was down at midnight, or crashed while sealing) are
+// sealed now.
 func (h *Store) load() error {
-	data, err := os.ReadFile(h.filePath)
+	files, err := os.ReadDir(h.dir)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
-		}
 		return err
 	}
 
-	h.mu.Lock()
-	defer h.mu.Unlock()
+	days := make(map[time.Time]map[string]bool)
+	for _, f := range files {
+		name := f.Name()
+		if strings.HasPrefix(name, ".") && strings.Contains(name, ".tmp-") {
+			if !h.readOnly {
+				_ = os.Remove(filepath.Join(h.dir, name))
+			}
+			continue
+		}
+		ext := filepath.Ext(name)
+		day, err := time.Parse(dayLayout, strings.TrimSuffix(name, ext))
+		if err != nil {
+			continue
+		}
+		if days[day] == nil {
+			days[day] = make(map[string]bool)
+		}
+		days[day][ext] = true
+	}
 
-	if err := json.Unmarshal(data, &h.data); err != nil {
-		return err
+	sorted := make([]time.Time, 0, len(days))
+	for day := range days {
+		sorted = append(sorted, day)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+
+	today := dayOf(h.now())
+	for _, day := range sorted {
+		exts := days[day]
+		if exts[activeExt] && (exts[indexExt] || exts[sealedExt]) {
+			// The append-only file is removed only after its sealed copy is
+			// complete, and an eviction rewrites it before replacing the
+			// sealed copy, so it always holds the newest data: seal it again
+			if !h.readOnly {
+				base := filepath.Join(h.dir, day.Format(dayLayout))
+				_ = os.Remove(base + indexExt)
+				_ = os.Remove(base + sealedExt)
+			}
+			exts[indexExt], exts[sealedExt] = false, false
+		}
+		switch {
+		case exts[indexExt] && exts[sealedExt]:
+			seg, err := loadSealed(h.dir, day)
+			if err != nil {
+				slog.Warn("Skipping unreadable history segment", "day", day.Format(dayLayout), "error", err)
+				continue
+			}
+			h.sealed = append(h.sealed, seg)
+		case exts[activeExt] && h.readOnly:
+			seg, err := readActive(h.dir, day)
+			if err != nil {
+				slog.Warn("Skipping unreadable history segment", "day", day.Format(dayLayout), "error", err)
+				continue
+			}
+			if !day.Before(today) && h.active == nil {
+				h.active = seg
+				continue
+			}
+			h.sealed = append(h.sealed, seg)
+		case exts[activeExt]:
+			seg, err := openActive(h.dir, day)
+			if err != nil {
+				slog.Warn("Skipping unreadable history segment", "day", day.Format(dayLayout), "error", err)
+				continue
+			}
+			if !day.Before(today) && h.active == nil {
+				h.active = seg
+				continue
+			}
+			sealed, err := seg.seal(h.dir)
+			if err != nil {
+				seg.file.Close()
+				slog.Warn("Failed to seal history segment", "day", day.Format(dayLayout), "error", err)
+				continue
+			}
+			h.sealed = append(h.sealed, sealed)
+		}
 	}
 
-	slog.Info("Loaded history data", "entries", len(h.data.Entries))
+	if !h.readOnly {
+		h.rollupMissing(h.now())
+		h.prune(h.now())
+	}
+
+	entries := 0
+	for _, s := range h.segments() {
+		entries += s.entries
+	}
+	slog.Info("Loaded history data", "dir", h.dir, "segments", len(h.segments()), "entries", entries)
 	return nil
 }
 
-// save writes history to file
-func (h *Store) save() error {
-	h.mu.RLock()
-	data, err := json.Marshal(h.data)
-	h.mu.RUnlock()
-
+// importLegacy moves the entries of a single-file history into segments and
+// renames the file out of the way
+func (h *Store) importLegacy(path string) error {
+	data, err := os.ReadFile(path)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var legacy Data
+	if err := json.Unmarshal(data, &legacy); err != nil {
 		return err
 	}
 
-	// Write to temp file first
-	tmpPath := h.filePath + ".tmp"
-	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+	today := dayOf(now)
+	byDay := make(map[time.Time][]UsageHistory)
+	for _, e := range legacy.Entries {
+		day := dayOf(e.Timestamp)
+		if day.After(today) {
+			day = today
+		}
+		byDay[day] = append(byDay[day], e)
+	}
+
+	for day, entries := range byDay {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Timestamp.Before(entries[j].Timestamp)
+		})
+		if day.Equal(today) {
+			if err := h.rotate(now); err != nil {
+				return err
+			}
+			if err := h.active.appendBatch(entries); err != nil {
+				return err
+			}
+			continue
+		}
+		if h.sealedDay(day) != nil {
+			slog.Warn("History segment already exists, skipping legacy entries", "day", day.Format(dayLayout), "entries", len(entries))
+			continue
+		}
+		seg := &segment{day: day, samples: make(map[string][]UsageHistory)}
+		for _, e := range entries {
+			seg.samples[e.Path] = append(seg.samples[e.Path], e)
+			seg.add(e)
+		}
+		sealed, err := seg.seal(h.dir)
+		if err != nil {
+			return err
+		}
+		h.sealed = append(h.sealed, sealed)
+	}
+	h.sortSealed()
+	h.rollupMissing(now)
+	h.prune(now)
+
+	if err := os.Rename(path, path+".migrated"); err != nil {
 		return err
 	}
+	slog.Info("Imported legacy history", "file", path, "entries", len(legacy.Entries))
+	return nil
+}
 
-	// Atomic rename
-	return os.Rename(tmpPath, h.filePath)
+// Record appends the current usage snapshot to today's segment, with the
+// owner of each path taken from owners (keyed by path, may be nil). With
+// anomaly detection enabled, the snapshot is first checked against each
+// path's baseline and the anomaly handler is called for new anomalies.
+func (h *Store) Record(usages []status.DirUsage, owners map[string]Owner) error {
+	raised, handler, err := h.record(usages, owners)
+	if handler != nil {
+		for _, a := range raised {
+			handler(a)
+		}
+	}
+	return err
 }
 
-// Record records current usage snapshot
-func (h *Store) Record(usages []status.DirUsage) error {
+// record implements Record and returns the new anomalies and the handler to
+// report them to
+func (h *Store) record(usages []status.DirUsage, owners map[string]Owner) ([]Anomaly, func(Anomaly), error) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	now := time.Now()
+	if h.readOnly {
+		return nil, nil, fmt.Errorf("history store %s is opened read-only", h.dir)
+	}
+
+	now := h.now()
+	if err := h.rotate(now); err != nil {
+		return nil, nil, err
+	}
+	h.forecasts = nil
 
-	// Add new entries
+	entries := make([]UsageHistory, 0, len(usages))
 	for _, u := range usages {
-		entry := UsageHistory{
+		entries = append(entries, UsageHistory{
 			Timestamp: now,
 			Path:      u.Path,
 			DirName:   filepath.Base(u.Path),
 			Used:      u.Used,
 			Quota:     u.Quota,
 			UsedPct:   u.QuotaPct,
+			Owner:     owners[u.Path],
+			Carried:   u.Carried,
+		})
+	}
+	entries = h.trackPaths(entries, now)
+	raised := h.detectAnomalies(entries)
+	if err := h.active.appendBatch(entries); err != nil {
+		return raised, h.onAnomaly, err
+	}
+	h.enforceSize()
+	return raised, h.onAnomaly, nil
+}
+
+// rotate makes sure the active segment is the one for now, sealing the
+// previous day and dropping days past retention (must be called with lock
+// held)
+func (h *Store) rotate(now time.Time) error {
+	day := dayOf(now)
+	if h.active != nil && h.active.day.Equal(day) {
+		return nil
+	}
+
+	if h.active != nil {
+		var missing []*tier
+		for _, t := range h.tiers {
+			if !t.has(h.active.day) {
+				missing = append(missing, t)
+			}
+		}
+		if err := h.rollup(h.active, missing); err != nil {
+			slog.Warn("Failed to roll up history", "day", h.active.name(), "error", err)
 		}
-		h.data.Entries = append(h.data.Entries, entry)
+		sealed, err := h.active.seal(h.dir)
+		if err != nil {
+			return fmt.Errorf("failed to seal history segment %s: %w", h.active.name(), err)
+		}
+		h.sealed = append(h.sealed, sealed)
+		h.sortSealed()
+		h.active = nil
 	}
 
-	// Prune old entries
-	h.prune()
+	active, err := openActive(h.dir, day)
+	if err != nil {
+		return fmt.Errorf("failed to open history segment: %w", err)
+	}
+	h.active = active
+	h.prune(now)
+	h.evictGone(now)
+	return nil
+}
 
-	// Save to disk
-	h.mu.Unlock()
-	err := h.save()
-	h.mu.Lock()
+// prune drops sealed days and rollups whose newest point is past their
+// retention (must be called with lock held)
+func (h *Store) prune(now time.Time) {
+	for _, t := range h.tiers {
+		t.prune(now)
+	}
+	if h.retention.Raw <= 0 {
+		return
+	}
+	cutoff := now.Add(-h.retention.Raw)
 
-	return err
+	kept := h.sealed[:0]
+	for _, s := range h.sealed {
+		if s.end.Before(cutoff) {
+			s.remove(h.dir)
+			continue
+		}
+		kept = append(kept, s)
+	}
+	h.sealed = kept
 }
 
-// prune removes old entries (must be called with lock held)
-func (h *Store) prune() {
-	cutoff := time.Now().Add(-h.retention)
+func (h *Store) sortSealed() {
+	sort.Slice(h.sealed, func(i, j int) bool { return h.sealed[i].day.Before(h.sealed[j].day) })
+}
 
-	// Filter entries within retention period
-	var kept []UsageHistory
-	for _, e := range h.data.Entries {
-		if e.Timestamp.After(cutoff) {
-			kept = append(kept, e)
+func (h *Store) sealedDay(day time.Time) *segment {
+	for _, s := range h.sealed {
+		if s.day.Equal(day) {
+			return s
 		}
 	}
+	return nil
+}
+
+// segments returns all segments, oldest first
+func (h *Store) segments() []*segment {
+	segs := make([]*segment, 0, len(h.sealed)+1)
+	segs = append(segs, h.sealed...)
+	if h.active != nil {
+		segs = append(segs, h.active)
+	}
+	return segs
+}
 
-	// Also limit total entries
-	if len(kept) > h.maxEntries {
-		kept = kept[len(kept)-h.maxEntries:]
+// paths returns every path with history
+func (h *Store) paths() map[string]bool {
+	segs := h.segments()
+	for _, t := range h.tiers {
+		segs = append(segs, t.segments...)
 	}
 
-	h.data.Entries = kept
+	pathSet := make(map[string]bool)
+	for _, s := range segs {
+		if s.samples != nil {
+			for p := range s.samples {
+				pathSet[p] = true
+			}
+			continue
+		}
+		for p := range s.paths {
+			pathSet[p] = true
+		}
+	}
+	return pathSet
 }
 
-// Query returns history for a specific path
+// Query returns history for a specific path at the finest resolution still
+// kept for start
 func (h *Store) Query(path string, start, end time.Time) []UsageHistory {
+	points, _ := h.QueryStep(path, start, end, 0)
+	return points
+}
+
+// Last returns the newest point of path at any resolution. Only the newest
+// segment holding path is read at each level, so it is cheap to call for
+// many paths.
+func (h *Store) Last(path string) (UsageHistory, bool) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	var result []UsageHistory
-	for _, e := range h.data.Entries {
-		if e.Path == path {
-			if !start.IsZero() && e.Timestamp.Before(start) {
+	var (
+		last  UsageHistory
+		found bool
+	)
+	newest := func(dir string, segs []*segment) {
+		for i := len(segs) - 1; i >= 0; i-- {
+			s := segs[i]
+			if s.entries == 0 || !s.hasPath(path) {
 				continue
 			}
-			if !end.IsZero() && e.Timestamp.After(end) {
+			if found && !s.end.After(last.Timestamp) {
+				return
+			}
+			samples, err := s.read(dir, path, time.Time{}, time.Time{})
+			if err != nil {
+				slog.Warn("Failed to read history", "path", path, "error", err)
 				continue
 			}
-			result = append(result, e)
+			if n := len(samples); n > 0 && (!found || samples[n-1].Timestamp.After(last.Timestamp)) {
+				last, found = samples[n-1], true
+			}
+			return
 		}
 	}
 
-	// Sort by timestamp
-	sort.Slice(result, func(i, j int) bool {
-		return result[i].Timestamp.Before(result[j].Timestamp)
-	})
+	newest(h.dir, h.segments())
+	for _, t := range h.tiers {
+		newest(t.dir, t.segments)
+	}
+	return last, found
+}
+
+// QueryStep returns history for a specific path with points at least step
+// apart, together with the resolution of the points returned. Rollups are
+// used where raw snapshots are no longer kept or step is coarse enough;
+// steps coarser than the stored resolution are averaged on the fly.
+func (h *Store) QueryStep(path string, start, end time.Time, step time.Duration) ([]UsageHistory, time.Duration) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
 
-	return result
+	return h.queryStep(path, start, end, step)
+}
+
+// queryStep implements QueryStep (must be called with lock held)
+func (h *Store) queryStep(path string, start, end time.Time, step time.Duration) ([]UsageHistory, time.Duration) {
+	var (
+		points     []UsageHistory
+		resolution time.Duration
+	)
+	if t := h.resolve(start, step); t != nil {
+		points = h.queryTier(t, path, start, end)
+		resolution = t.step
+	} else {
+		points = h.query(path, start, end)
+		resolution = h.interval
+	}
+
+	if step > resolution {
+		points = downsample(points, step)
+		resolution = step
+	}
+	return points, resolution
+}
+
+// query returns raw snapshots of path (must be called with lock held)
+func (h *Store) query(path string, start, end time.Time) []UsageHistory {
+	if h.retention.Raw > 0 {
+		if cutoff := h.now().Add(-h.retention.Raw); start.Before(cutoff) {
+			start = cutoff
+		}
+	}
+	return querySegments(h.dir, h.segments(), path, start, end)
 }
 
 // GetTrend calculates usage trend for a path
@@ -221,14 +682,29 @@ func (h *Store) GetTrend(path string) *TrendData {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	now := time.Now()
-	history := h.Query(path, now.Add(-30*24*time.Hour), now)
+	return h.trend(path)
+}
+
+// trend calculates usage trend for a path (must be called with lock held)
+func (h *Store) trend(path string) *TrendData {
+	now := h.now()
+	history, _ := h.queryStep(path, now.Add(-30*24*time.Hour), now, 0)
+	history = currentOwner(history)
 
 	if len(history) == 0 {
 		return nil
 	}
 
-	current := history[len(history)-1]
+	// Rollup points average a whole hour or day, so the current usage and
+	// the 24h change come from raw snapshots while they are kept
+	recent := history
+	if history[len(history)-1].Samples > 0 {
+		raw := currentOwner(h.query(path, now.Add(-25*time.Hour), now))
+		if len(raw) > 0 && raw[len(raw)-1].PVName == history[len(history)-1].PVName {
+			recent = raw
+		}
+	}
+	current := recent[len(recent)-1]
 
 	trend := &TrendData{
 		Path:       path,
@@ -238,12 +714,13 @@ func (h *Store) GetTrend(path string) *TrendData {
 		Quota:      current.Quota,
 		QuotaStr:   util.FormatBytes(int64(current.Quota)),
 		History:    history,
+		Owner:      current.Owner,
 	}
 
 	// Calculate changes
-	trend.Change24h = h.calculateChange(history, now.Add(-24*time.Hour))
-	trend.Change7d = h.calculateChange(history, now.Add(-7*24*time.Hour))
-	trend.Change30d = h.calculateChange(history, now.Add(-30*24*time.Hour))
+	trend.Change24h = calculateChange(recent, current.Used, now.Add(-24*time.Hour))
+	trend.Change7d = calculateChange(history, current.Used, now.Add(-7*24*time.Hour))
+	trend.Change30d = calculateChange(history, current.Used, now.Add(-30*24*time.Hour))
 
 	// Determine trend direction
 	if trend.Change24h > 0 {
@@ -257,14 +734,13 @@ func (h *Store) GetTrend(path string) *TrendData {
 	return trend
 }
 
-// calculateChange calculates usage change since a point in time
-func (h *Store) calculateChange(history []UsageHistory, since time.Time) int64 {
+// calculateChange calculates the change from the usage at a point in time
+// to current
+func calculateChange(history []UsageHistory, current uint64, since time.Time) int64 {
 	if len(history) == 0 {
 		return 0
 	}
 
-	current := history[len(history)-1].Used
-
 	// Find entry closest to 'since'
 	var oldEntry *UsageHistory
 	for i := range history {
@@ -288,18 +764,18 @@ func (h *Store) calculateChange(history []UsageHistory, since time.Time) int64 {
 
 // GetAllTrends returns trends for all tracked paths
 func (h *Store) GetAllTrends() []TrendData {
+	return h.GetTrends(Selector{})
+}
+
+// GetTrends returns trends for the tracked paths whose current owner is
+// selected by sel
+func (h *Store) GetTrends(sel Selector) []TrendData {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	// Get unique paths
-	pathSet := make(map[string]bool)
-	for _, e := range h.data.Entries {
-		pathSet[e.Path] = true
-	}
-
 	var trends []TrendData
-	for path := range pathSet {
-		if trend := h.GetTrend(path); trend != nil {
+	for path := range h.paths() {
+		if trend := h.trend(path); trend != nil && sel.Matches(trend.Owner) {
 			trends = append(trends, *trend)
 		}
 	}
@@ -312,12 +788,76 @@ func (h *Store) GetAllTrends() []TrendData {
 	return trends
 }
 
+// SetCollection records how the last snapshot was gathered, for
+// GetHistoryStats
+func (h *Store) SetCollection(c Collection) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.collection = &c
+}
+
+// LastCollection returns how the last snapshot was gathered
+func (h *Store) LastCollection() (Collection, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.collection == nil {
+		return Collection{}, false
+	}
+	return *h.collection, true
+}
+
 // GetHistoryStats returns statistics about stored history
 func (h *Store) GetHistoryStats() map[string]interface{} {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	if len(h.data.Entries) == 0 {
+	var (
+		entries   int
+		diskBytes int64
+		oldest    time.Time
+		newest    time.Time
+	)
+	segs := h.segments()
+	for _, s := range segs {
+		if s.entries == 0 {
+			continue
+		}
+		if entries == 0 || s.start.Before(oldest) {
+			oldest = s.start
+		}
+		if entries == 0 || s.end.After(newest) {
+			newest = s.end
+		}
+		entries += s.entries
+		diskBytes += s.size
+	}
+
+	rollups := make([]map[string]interface{}, 0, len(h.tiers))
+	for _, t := range h.tiers {
+		points := 0
+		var size int64
+		for _, s := range t.segments {
+			points += s.entries
+			size += s.size
+			if s.start.Before(oldest) || oldest.IsZero() {
+				oldest = s.start
+			}
+			if s.end.After(newest) {
+				newest = s.end
+			}
+		}
+		diskBytes += size
+		rollups = append(rollups, map[string]interface{}{
+			"tier":      t.name,
+			"step":      t.step.String(),
+			"retention": t.retention.String(),
+			"days":      len(t.segments),
+			"points":    points,
+			"diskBytes": size,
+		})
+	}
+
+	if entries == 0 && oldest.IsZero() {
 		return map[string]interface{}{
 			"entries":   0,
 			"paths":     0,
@@ -326,29 +866,45 @@ func (h *Store) GetHistoryStats() map[string]interface{} {
 		}
 	}
 
-	// Get unique paths
-	pathSet := make(map[string]bool)
-	oldest := h.data.Entries[0].Timestamp
-	newest := h.data.Entries[0].Timestamp
-
-	for _, e := range h.data.Entries {
-		pathSet[e.Path] = true
-		if e.Timestamp.Before(oldest) {
-			oldest = e.Timestamp
-		}
-		if e.Timestamp.After(newest) {
-			newest = e.Timestamp
-		}
+	retention := "forever"
+	if max := h.retention.Max(); max > 0 {
+		retention = max.String()
+	}
+	rawRetention := "forever"
+	if h.retention.Raw > 0 {
+		rawRetention = h.retention.Raw.String()
 	}
 
-	return map[string]interface{}{
-		"entries":   len(h.data.Entries),
-		"paths":     len(pathSet),
-		"oldest":    oldest,
-		"newest":    newest,
-		"oldestStr": oldest.Format(time.RFC3339),
-		"newestStr": newest.Format(time.RFC3339),
-		"retention": h.retention.String(),
-		"interval":  h.interval.String(),
+	stats := map[string]interface{}{
+		"entries":      entries,
+		"paths":        len(h.paths()),
+		"segments":     len(segs),
+		"diskBytes":    diskBytes,
+		"oldest":       oldest,
+		"newest":       newest,
+		"oldestStr":    oldest.Format(time.RFC3339),
+		"newestStr":    newest.Format(time.RFC3339),
+		"retention":    retention,
+		"rawRetention": rawRetention,
+		"rollups":      rollups,
+		"interval":     h.interval.String(),
+	}
+	if h.limits.enabled() {
+		stats["limits"] = h.limitStats()
+	}
+	if c := h.collection; c != nil {
+		stats["collection"] = map[string]interface{}{
+			"time":            c.Time,
+			"duration":        c.Duration.String(),
+			"durationSeconds": c.Duration.Seconds(),
+			"dirs":            c.Scan.Dirs,
+			"fromReport":      c.Scan.FromReport,
+			"walked":          c.Scan.Walked,
+			"carried":         c.Scan.Carried,
+			"skipped":         c.Scan.Skipped,
+			"reportFailed":    c.Scan.ReportFailed,
+			"reused":          c.Reused,
+		}
 	}
+	return stats
 }
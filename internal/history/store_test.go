@@ -17,6 +17,8 @@ limitations under the License.
 package history
 
 import (
+	"bytes"
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"testing"
@@ -29,7 +31,7 @@ func TestNewStore(t *testing.T) {
 	tmpDir := t.TempDir()
 	historyPath := filepath.Join(tmpDir, "history.json")
 
-	store, err := NewStore(historyPath, 5*time.Minute, 24*time.Hour)
+	store, err := NewStore(historyPath, 5*time.Minute, Retention{Raw: 24 * time.Hour})
 	if err != nil {
 		t.Fatalf("Failed to create history store: %v", err)
 	}
@@ -38,8 +40,8 @@ func TestNewStore(t *testing.T) {
 		t.Fatal("Expected non-nil store")
 	}
 
-	if store.filePath != historyPath {
-		t.Errorf("Expected filePath %s, got %s", historyPath, store.filePath)
+	if want := filepath.Join(tmpDir, "history"); store.Dir() != want {
+		t.Errorf("Expected dir %s, got %s", want, store.Dir())
 	}
 }
 
@@ -47,7 +49,7 @@ func TestStoreRecord(t *testing.T) {
 	tmpDir := t.TempDir()
 	historyPath := filepath.Join(tmpDir, "history.json")
 
-	store, err := NewStore(historyPath, 5*time.Minute, 24*time.Hour)
+	store, err := NewStore(historyPath, 5*time.Minute, Retention{Raw: 24 * time.Hour})
 	if err != nil {
 		t.Fatalf("Failed to create history store: %v", err)
 	}
@@ -58,18 +60,19 @@ func TestStoreRecord(t *testing.T) {
 		{Path: "/data/test2", Used: 512, Quota: 1024},
 	}
 
-	if err := store.Record(usages); err != nil {
+	if err := store.Record(usages, nil); err != nil {
 		t.Fatalf("Failed to record usage: %v", err)
 	}
 
 	// Check that data was saved
-	if _, err := os.Stat(historyPath); os.IsNotExist(err) {
-		t.Error("History file was not created")
+	segment := filepath.Join(store.Dir(), time.Now().UTC().Format(dayLayout)+activeExt)
+	if _, err := os.Stat(segment); os.IsNotExist(err) {
+		t.Error("History segment was not created")
 	}
 
 	// Verify entries
-	if len(store.data.Entries) != 2 {
-		t.Errorf("Expected 2 entries, got %d", len(store.data.Entries))
+	if n := store.GetHistoryStats()["entries"]; n != 2 {
+		t.Errorf("Expected 2 entries, got %v", n)
 	}
 }
 
@@ -77,7 +80,7 @@ func TestStoreQuery(t *testing.T) {
 	tmpDir := t.TempDir()
 	historyPath := filepath.Join(tmpDir, "history.json")
 
-	store, err := NewStore(historyPath, 5*time.Minute, 24*time.Hour)
+	store, err := NewStore(historyPath, 5*time.Minute, Retention{Raw: 24 * time.Hour})
 	if err != nil {
 		t.Fatalf("Failed to create history store: %v", err)
 	}
@@ -87,7 +90,7 @@ func TestStoreQuery(t *testing.T) {
 		{Path: "/data/test1", Used: 1024, Quota: 2048},
 		{Path: "/data/test2", Used: 512, Quota: 1024},
 	}
-	_ = store.Record(usages)
+	_ = store.Record(usages, nil)
 
 	// Query specific path
 	result := store.Query("/data/test1", time.Time{}, time.Time{})
@@ -106,7 +109,7 @@ func TestStoreTrend(t *testing.T) {
 	tmpDir := t.TempDir()
 	historyPath := filepath.Join(tmpDir, "history.json")
 
-	store, err := NewStore(historyPath, 5*time.Minute, 24*time.Hour)
+	store, err := NewStore(historyPath, 5*time.Minute, Retention{Raw: 24 * time.Hour})
 	if err != nil {
 		t.Fatalf("Failed to create history store: %v", err)
 	}
@@ -115,13 +118,13 @@ func TestStoreTrend(t *testing.T) {
 	usages := []status.DirUsage{
 		{Path: "/data/test1", Used: 1024, Quota: 2048},
 	}
-	_ = store.Record(usages)
+	_ = store.Record(usages, nil)
 
 	// Record more data (simulating growth)
 	usages = []status.DirUsage{
 		{Path: "/data/test1", Used: 2048, Quota: 2048},
 	}
-	_ = store.Record(usages)
+	_ = store.Record(usages, nil)
 
 	// Get trend
 	trend := store.GetTrend("/data/test1")
@@ -139,12 +142,55 @@ func TestStoreTrend(t *testing.T) {
 	}
 }
 
+func TestStoreTrendDefaultRetention(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "history")
+	first := dayOf(time.Now()).AddDate(0, 0, -3)
+	c := &clock{t: first}
+	store := newTestStore(t, dir, c, Retention{Raw: 48 * time.Hour, Hourly: 30 * 24 * time.Hour, Daily: 365 * 24 * time.Hour})
+
+	// Three and a half days at 1000 bytes, sealed and rolled up, then a jump
+	for i := 0; i <= 3*24+12; i++ {
+		if err := store.Record([]status.DirUsage{{Path: "/data/a", Used: 1000, Quota: 10000}}, nil); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+		c.t = c.t.Add(time.Hour)
+	}
+	c.t = c.t.Add(-30 * time.Minute)
+	if err := store.Record([]status.DirUsage{{Path: "/data/a", Used: 9000, Quota: 10000}}, nil); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+
+	// Time moves on between the calls made while computing the trend
+	store.now = func() time.Time {
+		c.t = c.t.Add(time.Millisecond)
+		return c.t
+	}
+	trend := store.GetTrend("/data/a")
+	if trend == nil {
+		t.Fatal("Expected non-nil trend")
+	}
+	if trend.Current != 9000 {
+		t.Errorf("Current = %d, want 9000 (newest snapshot, not a rollup average)", trend.Current)
+	}
+	if trend.Change24h != 8000 || trend.Trend != "up" {
+		t.Errorf("Change24h = %d, Trend = %s, want 8000 and up", trend.Change24h, trend.Trend)
+	}
+	if len(trend.History) < 3*24 {
+		t.Errorf("Expected hourly history points over 30 days, got %d", len(trend.History))
+	}
+	for _, p := range trend.History[:len(trend.History)-1] {
+		if p.Samples > 1 {
+			t.Fatalf("Expected hourly rollups, got a point of %d samples at %v", p.Samples, p.Timestamp)
+		}
+	}
+}
+
 func TestStorePrune(t *testing.T) {
 	tmpDir := t.TempDir()
 	historyPath := filepath.Join(tmpDir, "history.json")
 
 	// Create store with very short retention
-	store, err := NewStore(historyPath, 5*time.Minute, 1*time.Millisecond)
+	store, err := NewStore(historyPath, 5*time.Minute, Retention{Raw: time.Millisecond})
 	if err != nil {
 		t.Fatalf("Failed to create history store: %v", err)
 	}
@@ -153,19 +199,14 @@ func TestStorePrune(t *testing.T) {
 	usages := []status.DirUsage{
 		{Path: "/data/test1", Used: 1024, Quota: 2048},
 	}
-	_ = store.Record(usages)
-
-	initialCount := len(store.data.Entries)
+	_ = store.Record(usages, nil)
 
 	// Wait for entries to expire
 	time.Sleep(10 * time.Millisecond)
 
-	// Record more data (triggers prune)
-	_ = store.Record(usages)
-
-	// Old entries should be pruned
-	if len(store.data.Entries) > initialCount {
-		t.Logf("Entries not pruned as expected, but this could be timing-dependent")
+	// Expired entries are no longer returned
+	if result := store.Query("/data/test1", time.Time{}, time.Time{}); len(result) != 0 {
+		t.Errorf("Expected expired entries to be filtered, got %d", len(result))
 	}
 }
 
@@ -174,19 +215,380 @@ func TestStoreLoadExisting(t *testing.T) {
 	historyPath := filepath.Join(tmpDir, "history.json")
 
 	// Create and populate store
-	store1, _ := NewStore(historyPath, 5*time.Minute, 24*time.Hour)
+	store1, _ := NewStore(historyPath, 5*time.Minute, Retention{Raw: 24 * time.Hour})
 	usages := []status.DirUsage{
 		{Path: "/data/test1", Used: 1024, Quota: 2048},
 	}
-	_ = store1.Record(usages)
+	_ = store1.Record(usages, nil)
 
 	// Create new store (should load existing data)
-	store2, err := NewStore(historyPath, 5*time.Minute, 24*time.Hour)
+	store2, err := NewStore(historyPath, 5*time.Minute, Retention{Raw: 24 * time.Hour})
 	if err != nil {
 		t.Fatalf("Failed to create second store: %v", err)
 	}
 
-	if len(store2.data.Entries) != 1 {
-		t.Errorf("Expected 1 entry to be loaded, got %d", len(store2.data.Entries))
+	if result := store2.Query("/data/test1", time.Time{}, time.Time{}); len(result) != 1 {
+		t.Errorf("Expected 1 entry to be loaded, got %d", len(result))
+	}
+}
+
+// clock is a settable time source for tests that span several days
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func newTestStore(t *testing.T, dir string, c *clock, retention Retention) *Store {
+	t.Helper()
+	store, err := NewStore(dir, 5*time.Minute, retention)
+	if err != nil {
+		t.Fatalf("Failed to create history store: %v", err)
+	}
+	store.now = c.now
+	return store
+}
+
+func TestStoreSegments(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "history")
+	first := dayOf(time.Now()).AddDate(0, 0, -3)
+	c := &clock{t: first.Add(-2 * time.Hour)}
+	store := newTestStore(t, dir, c, Retention{Raw: 30 * 24 * time.Hour})
+
+	// Three days of samples for two paths
+	for i := 0; i < 3*24; i++ {
+		usages := []status.DirUsage{
+			{Path: "/data/a", Used: uint64(i), Quota: 1000},
+			{Path: "/data/b", Used: uint64(2 * i), Quota: 1000},
+		}
+		if err := store.Record(usages, nil); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+		c.t = c.t.Add(time.Hour)
+	}
+
+	for i := -1; i < 2; i++ {
+		day := first.AddDate(0, 0, i).Format(dayLayout)
+		for _, ext := range []string{sealedExt, indexExt} {
+			if _, err := os.Stat(filepath.Join(dir, day+ext)); err != nil {
+				t.Errorf("Expected sealed segment file %s: %v", day+ext, err)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(dir, day+activeExt)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed after sealing", day+activeExt)
+		}
+	}
+
+	// Reopen and query a range spanning sealed and active segments
+	reopened := newTestStore(t, dir, c, Retention{Raw: 30 * 24 * time.Hour})
+	start := first.Add(44 * time.Hour)
+	end := first.Add(50 * time.Hour)
+	result := reopened.Query("/data/b", start, end)
+	if len(result) != 7 {
+		t.Fatalf("Expected 7 samples, got %d", len(result))
+	}
+	for i, e := range result {
+		if e.Path != "/data/b" {
+			t.Errorf("Unexpected path %s", e.Path)
+		}
+		if want := start.Add(time.Duration(i) * time.Hour); !e.Timestamp.Equal(want) {
+			t.Errorf("Sample %d: expected %v, got %v", i, want, e.Timestamp)
+		}
+	}
+	if all := reopened.Query("/data/a", time.Time{}, time.Time{}); len(all) != 72 {
+		t.Errorf("Expected 72 samples, got %d", len(all))
+	}
+	if last, ok := reopened.Last("/data/b"); !ok || last.Used != 2*71 {
+		t.Errorf("Last() = %+v, %v, want the newest sample (Used %d)", last, ok, 2*71)
+	}
+	if trends := reopened.GetAllTrends(); len(trends) != 2 {
+		t.Errorf("Expected 2 trends, got %d", len(trends))
+	}
+}
+
+func TestStoreRetentionDropsSegments(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "history")
+	first := dayOf(time.Now()).AddDate(0, 0, -4)
+	c := &clock{t: first.Add(12 * time.Hour)}
+	store := newTestStore(t, dir, c, Retention{Raw: 48 * time.Hour})
+
+	for i := 0; i < 5; i++ {
+		if err := store.Record([]status.DirUsage{{Path: "/data/a", Used: 1}}, nil); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+		c.t = c.t.Add(24 * time.Hour)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, first.Format(dayLayout)+sealedExt)); !os.IsNotExist(err) {
+		t.Error("Expected segment past retention to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, first.AddDate(0, 0, 3).Format(dayLayout)+sealedExt)); err != nil {
+		t.Errorf("Expected recent segment to be kept: %v", err)
+	}
+	if n := len(store.Query("/data/a", time.Time{}, time.Time{})); n != 2 {
+		t.Errorf("Expected 2 samples within retention, got %d", n)
+	}
+}
+
+func TestStoreRewriteCrash(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "history")
+	first := dayOf(time.Now()).AddDate(0, 0, -2)
+	c := &clock{t: first}
+	store := newTestStore(t, dir, c, Retention{Raw: 30 * 24 * time.Hour})
+
+	for i := 0; i < 2*24; i++ {
+		usages := []status.DirUsage{{Path: "/data/a", Used: 1}, {Path: "/data/b", Used: 2}}
+		if err := store.Record(usages, nil); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+		c.t = c.t.Add(time.Hour)
+	}
+
+	// Simulate a crash while evicting /data/b from the sealed first day:
+	// the rewritten append-only file is written, the old sealed files remain
+	base := filepath.Join(dir, first.Format(dayLayout))
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range store.Query("/data/a", first, first.Add(23*time.Hour)) {
+		if err := enc.Encode(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(base+activeExt, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := newTestStore(t, dir, c, Retention{Raw: 30 * 24 * time.Hour})
+	if n := len(reopened.Query("/data/b", first, first.Add(23*time.Hour))); n != 0 {
+		t.Errorf("Expected the rewritten day without /data/b, got %d points", n)
+	}
+	if n := len(reopened.Query("/data/a", first, first.Add(23*time.Hour))); n != 24 {
+		t.Errorf("Expected 24 points of /data/a, got %d", n)
+	}
+	if _, err := os.Stat(base + activeExt); !os.IsNotExist(err) {
+		t.Errorf("Expected the rewritten day to be sealed again, %s remains", base+activeExt)
+	}
+}
+
+func TestStoreTornAppend(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "history")
+	c := &clock{t: dayOf(time.Now())}
+	store := newTestStore(t, dir, c, Retention{Raw: 30 * 24 * time.Hour})
+	if err := store.Record([]status.DirUsage{{Path: "/data/a", Used: 1}}, nil); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+
+	// Simulate a crash in the middle of an append
+	f, err := os.OpenFile(filepath.Join(dir, c.t.Format(dayLayout)+activeExt), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"timestamp":"2024-03-01T00:05:00Z","path":"/da`)
+	f.Close()
+
+	reopened := newTestStore(t, dir, c, Retention{Raw: 30 * 24 * time.Hour})
+	c.t = c.t.Add(5 * time.Minute)
+	if err := reopened.Record([]status.DirUsage{{Path: "/data/a", Used: 2}}, nil); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+
+	again := newTestStore(t, dir, c, Retention{Raw: 30 * 24 * time.Hour})
+	result := again.Query("/data/a", time.Time{}, time.Time{})
+	if len(result) != 2 || result[1].Used != 2 {
+		t.Errorf("Expected torn record to be discarded, got %+v", result)
+	}
+}
+
+func TestStoreImportLegacy(t *testing.T) {
+	tmpDir := t.TempDir()
+	legacyPath := filepath.Join(tmpDir, "history.json")
+	now := time.Now().UTC()
+
+	legacy := Data{Entries: []UsageHistory{
+		{Timestamp: now.Add(-48 * time.Hour), Path: "/data/a", Used: 1},
+		{Timestamp: now.Add(-24 * time.Hour), Path: "/data/a", Used: 2},
+		{Timestamp: now, Path: "/data/a", Used: 3},
+	}}
+	data, _ := json.Marshal(legacy)
+	if err := os.WriteFile(legacyPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewStore(legacyPath, 5*time.Minute, Retention{Raw: 30 * 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to create history store: %v", err)
+	}
+	if _, err := os.Stat(legacyPath + ".migrated"); err != nil {
+		t.Errorf("Expected legacy file to be renamed: %v", err)
+	}
+	result := store.Query("/data/a", time.Time{}, time.Time{})
+	if len(result) != 3 {
+		t.Fatalf("Expected 3 imported samples, got %d", len(result))
+	}
+	for i, e := range result {
+		if e.Used != uint64(i+1) {
+			t.Errorf("Sample %d: expected used %d, got %d", i, i+1, e.Used)
+		}
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	base := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	raw := func(minute int, used uint64) UsageHistory {
+		return UsageHistory{Timestamp: base.Add(time.Duration(minute) * time.Minute), Path: "/data/a", Used: used, Quota: 100}
+	}
+
+	tests := []struct {
+		name   string
+		points []UsageHistory
+		step   time.Duration
+		want   []UsageHistory
+	}{
+		{
+			name:   "raw into hours",
+			points: []UsageHistory{raw(0, 10), raw(30, 20), raw(60, 40), raw(90, 40), raw(119, 70)},
+			step:   time.Hour,
+			want: []UsageHistory{
+				{Timestamp: base, Used: 15, MinUsed: 10, MaxUsed: 20, Samples: 2},
+				{Timestamp: base.Add(time.Hour), Used: 50, MinUsed: 40, MaxUsed: 70, Samples: 3},
+			},
+		},
+		{
+			name: "rollups weighted by samples",
+			points: []UsageHistory{
+				{Timestamp: base, Used: 10, MinUsed: 5, MaxUsed: 15, Samples: 3},
+				{Timestamp: base.Add(time.Hour), Used: 30, MinUsed: 30, MaxUsed: 30, Samples: 1},
+			},
+			step: 24 * time.Hour,
+			want: []UsageHistory{
+				{Timestamp: dayOf(base), Used: 15, MinUsed: 5, MaxUsed: 30, Samples: 4},
+			},
+		},
+		{
+			name: "empty",
+			step: time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := downsample(tt.points, tt.step)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Expected %d points, got %d: %+v", len(tt.want), len(got), got)
+			}
+			for i, w := range tt.want {
+				g := got[i]
+				if !g.Timestamp.Equal(w.Timestamp) || g.Used != w.Used || g.MinUsed != w.MinUsed || g.MaxUsed != w.MaxUsed || g.Samples != w.Samples {
+					t.Errorf("Point %d: expected %+v, got %+v", i, w, g)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreRollups(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "history")
+	first := dayOf(time.Now()).AddDate(0, 0, -5)
+	c := &clock{t: first}
+	retention := Retention{Raw: 48 * time.Hour, Hourly: 30 * 24 * time.Hour, Daily: 365 * 24 * time.Hour}
+	store := newTestStore(t, dir, c, retention)
+
+	// Five days of 15-minute samples, then one snapshot today
+	for c.t.Before(first.AddDate(0, 0, 5)) {
+		if err := store.Record([]status.DirUsage{{Path: "/data/a", Used: 100, Quota: 1000}}, nil); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+		c.t = c.t.Add(15 * time.Minute)
+	}
+	c.t = c.t.Add(30 * time.Minute)
+	if err := store.Record([]status.DirUsage{{Path: "/data/a", Used: 200, Quota: 1000}}, nil); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+
+	// Raw days past retention are gone, rollups of every day are kept
+	if _, err := os.Stat(filepath.Join(dir, first.Format(dayLayout)+sealedExt)); !os.IsNotExist(err) {
+		t.Error("Expected raw segment past retention to be removed")
+	}
+	for _, tier := range []string{"hourly", "daily"} {
+		if _, err := os.Stat(filepath.Join(dir, tier, first.Format(dayLayout)+indexExt)); err != nil {
+			t.Errorf("Expected %s rollup of the first day: %v", tier, err)
+		}
+	}
+
+	now := c.t
+	tests := []struct {
+		name       string
+		start      time.Time
+		step       time.Duration
+		resolution time.Duration
+		points     int
+	}{
+		{"recent range uses raw", now.Add(-2 * time.Hour), 0, 5 * time.Minute, 7},
+		{"old range uses hourly", first, 0, time.Hour, 5*24 + 1},
+		{"daily step uses daily", first, 24 * time.Hour, 24 * time.Hour, 6},
+		{"coarser step is averaged", first, 12 * time.Hour, 12 * time.Hour, 5*2 + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points, resolution := store.QueryStep("/data/a", tt.start, now, tt.step)
+			if resolution != tt.resolution {
+				t.Errorf("Expected resolution %v, got %v", tt.resolution, resolution)
+			}
+			if len(points) != tt.points {
+				t.Errorf("Expected %d points, got %d", tt.points, len(points))
+			}
+			if len(points) > 0 && points[len(points)-1].MaxUsed != 200 && tt.resolution != 5*time.Minute {
+				t.Errorf("Expected today's snapshot in the last point, got %+v", points[len(points)-1])
+			}
+		})
+	}
+
+	// Rollups survive a restart and are not computed twice
+	reopened := newTestStore(t, dir, c, retention)
+	if points, _ := reopened.QueryStep("/data/a", first, now, 24*time.Hour); len(points) != 6 || points[0].Samples != 96 {
+		t.Errorf("Expected 6 daily points of 96 samples, got %+v", points)
+	}
+}
+
+func TestStoreOwners(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "history")
+	c := &clock{t: time.Now().Add(-4 * time.Hour)}
+	store := newTestStore(t, dir, c, Retention{Raw: 48 * time.Hour})
+
+	oldPV := Owner{PVName: "pv-1", PVCName: "data", Namespace: "team-a", StorageClass: "nfs", ProjectID: 7}
+	newPV := Owner{PVName: "pv-2", PVCName: "data", Namespace: "team-b", StorageClass: "nfs", ProjectID: 7}
+	record := func(used uint64, owner Owner) {
+		t.Helper()
+		if err := store.Record([]status.DirUsage{
+			{Path: "/data/shared", Used: used, Quota: 1000},
+			{Path: "/data/other", Used: 10, Quota: 1000},
+		}, map[string]Owner{"/data/shared": owner}); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+		c.t = c.t.Add(time.Hour)
+	}
+
+	// The PV is deleted and a new one created at the same path
+	record(800, oldPV)
+	record(900, oldPV)
+	record(50, newPV)
+	record(60, newPV)
+
+	trend := store.GetTrend("/data/shared")
+	if trend == nil || trend.PVName != "pv-2" || trend.Namespace != "team-b" {
+		t.Fatalf("Expected the trend of pv-2, got %+v", trend)
+	}
+	if len(trend.History) != 2 || trend.Change24h != 10 {
+		t.Errorf("Expected only the new PV in the trend, got %d points and change %d", len(trend.History), trend.Change24h)
+	}
+
+	if trends := store.GetTrends(Selector{Namespace: "team-a"}); len(trends) != 0 {
+		t.Errorf("Expected no current trend in team-a, got %+v", trends)
+	}
+	if trends := store.GetTrends(Selector{Namespace: "team-b", PVCName: "data"}); len(trends) != 1 {
+		t.Errorf("Expected one trend for team-b/data, got %d", len(trends))
+	}
+
+	points, _ := store.QuerySelector(Selector{Namespace: "team-a"}, time.Time{}, time.Time{}, 0)
+	if len(points) != 2 || points[0].Used != 800 || points[1].PVName != "pv-1" {
+		t.Errorf("Expected the 2 snapshots of pv-1, got %+v", points)
 	}
 }
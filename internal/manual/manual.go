@@ -0,0 +1,391 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manual
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dasomel/nfs-quota-agent/internal/audit"
+	"github.com/dasomel/nfs-quota-agent/internal/quota"
+	"github.com/dasomel/nfs-quota-agent/internal/util"
+)
+
+// Options configures manual quota operations
+type Options struct {
+	QuotaPath    string
+	ProjectsFile string
+	ProjidFile   string
+	AuditLogPath string
+	Output       string // "table" or "json"
+}
+
+// SetRequest describes the limits to apply to a directory. Only the limits
+// named by the request change; the others keep their current values.
+type SetRequest struct {
+	Path        string
+	SizeBytes   int64
+	Inodes      int64
+	InodesSet   bool // Inodes was given; otherwise inode limits are kept
+	Soft        bool
+	ProjectName string
+}
+
+// limits returns current with the limits named by the request replaced:
+// the block and (if given) inode soft limits with Soft, the hard ones
+// otherwise
+func (req SetRequest) limits(current quota.Limits) quota.Limits {
+	limits := current
+	if req.Soft {
+		limits.BlockSoft = req.SizeBytes
+		if req.InodesSet {
+			limits.InodeSoft = req.Inodes
+		}
+	} else {
+		limits.BlockHard = req.SizeBytes
+		if req.InodesSet {
+			limits.InodeHard = req.Inodes
+		}
+	}
+	return limits
+}
+
+// Entry describes the project quota of a directory
+type Entry struct {
+	Path        string `json:"path"`
+	ProjectID   uint32 `json:"projectId"`
+	ProjectName string `json:"projectName"`
+	FSType      string `json:"fsType"`
+	DirExists   bool   `json:"dirExists"`
+	Used        uint64 `json:"used"`
+	UsedStr     string `json:"usedStr"`
+	InodesUsed  uint64 `json:"inodesUsed"`
+	quota.Limits
+}
+
+// Set applies (or updates) a project quota on a directory
+func Set(opts Options, req SetRequest) error {
+	fsType, err := detectFSType(opts.QuotaPath)
+	if err != nil {
+		return err
+	}
+
+	path, err := resolvePath(opts.QuotaPath, req.Path)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+
+	projectID, projectName, exists, err := quota.FindProjectByPath(path, opts.ProjectsFile, opts.ProjidFile)
+	if err != nil {
+		return fmt.Errorf("failed to read projects file: %w", err)
+	}
+	if !exists {
+		projectName = req.ProjectName
+		if projectName == "" {
+			projectName = quota.ProjectName("dir_", filepath.Base(path))
+		}
+		projectID = quota.GenerateProjectID(projectName)
+
+		if err := checkProjectIDFree(opts, projectID, path); err != nil {
+			return err
+		}
+	}
+
+	var current quota.Limits
+	if exists {
+		pq, err := quota.GetProjectQuota(fsType, opts.QuotaPath, projectID)
+		if err != nil {
+			return fmt.Errorf("failed to read current limits of %s: %w", path, err)
+		}
+		current = pq.Limits
+	}
+	limits := req.limits(current)
+
+	applyErr := quota.ApplyQuotaLimits(fsType, opts.QuotaPath, path, projectName, projectID, limits, opts.ProjectsFile, opts.ProjidFile)
+
+	if logger := audit.Open(opts.AuditLogPath); logger != nil {
+		entry := audit.Entry{
+			Action:      audit.ActionCreate,
+			Path:        path,
+			ProjectID:   projectID,
+			ProjectName: projectName,
+			NewQuota:    limits.BlockHard,
+			FSType:      fsType,
+			Success:     applyErr == nil,
+			Detail:      limitChanges(current, limits),
+		}
+		if exists {
+			entry.Action = audit.ActionUpdate
+			entry.OldQuota = current.BlockHard
+		}
+		if applyErr != nil {
+			entry.Error = applyErr.Error()
+		}
+		_ = logger.Log(entry)
+		logger.Close()
+	}
+
+	if applyErr != nil {
+		return fmt.Errorf("failed to apply quota for %s: %w", path, applyErr)
+	}
+
+	return Get(opts, path)
+}
+
+// limitChanges describes which limits changed, e.g. "soft - -> 8.0 GiB"
+func limitChanges(old, new quota.Limits) string {
+	var changes []string
+	for _, l := range []struct {
+		name     string
+		old, new int64
+		bytes    bool
+	}{
+		{"hard", old.BlockHard, new.BlockHard, true},
+		{"soft", old.BlockSoft, new.BlockSoft, true},
+		{"inode hard", old.InodeHard, new.InodeHard, false},
+		{"inode soft", old.InodeSoft, new.InodeSoft, false},
+	} {
+		if l.old != l.new {
+			changes = append(changes, fmt.Sprintf("%s %s -> %s", l.name, formatLimit(l.old, l.bytes), formatLimit(l.new, l.bytes)))
+		}
+	}
+	return strings.Join(changes, ", ")
+}
+
+// Get prints the project quota of a directory
+func Get(opts Options, path string) error {
+	fsType, err := detectFSType(opts.QuotaPath)
+	if err != nil {
+		return err
+	}
+
+	path, err = resolvePath(opts.QuotaPath, path)
+	if err != nil {
+		return err
+	}
+
+	projectID, projectName, ok, err := quota.FindProjectByPath(path, opts.ProjectsFile, opts.ProjidFile)
+	if err != nil {
+		return fmt.Errorf("failed to read projects file: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("no project quota configured for %s", path)
+	}
+
+	pq, err := quota.GetProjectQuota(fsType, opts.QuotaPath, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to read quota: %w", err)
+	}
+
+	return printEntries(opts.Output, []Entry{newEntry(path, projectID, projectName, fsType, pq)})
+}
+
+// Remove clears the project quota of a directory and removes its project entries
+func Remove(opts Options, path string) error {
+	fsType, err := detectFSType(opts.QuotaPath)
+	if err != nil {
+		return err
+	}
+
+	path, err = resolvePath(opts.QuotaPath, path)
+	if err != nil {
+		return err
+	}
+
+	projectID, projectName, ok, err := quota.FindProjectByPath(path, opts.ProjectsFile, opts.ProjidFile)
+	if err != nil {
+		return fmt.Errorf("failed to read projects file: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("no project quota configured for %s", path)
+	}
+
+	_, statErr := os.Stat(path)
+	removeErr := quota.DetachProjectPath(fsType, opts.QuotaPath, path, !os.IsNotExist(statErr), projectID, projectName, opts.ProjectsFile, opts.ProjidFile)
+
+	if logger := audit.Open(opts.AuditLogPath); logger != nil {
+		logger.LogQuotaDelete("", path, projectName, projectID, removeErr)
+		logger.Close()
+	}
+
+	if removeErr != nil {
+		return fmt.Errorf("failed to remove quota for %s: %w", path, removeErr)
+	}
+
+	if opts.Output == "json" {
+		return json.NewEncoder(os.Stdout).Encode(map[string]interface{}{
+			"path":        path,
+			"projectId":   projectID,
+			"projectName": projectName,
+			"removed":     true,
+		})
+	}
+	fmt.Printf("Removed quota for %s (project %d, %s)\n", path, projectID, projectName)
+	return nil
+}
+
+// List prints all projects registered in the projects file
+func List(opts Options) error {
+	fsType, err := detectFSType(opts.QuotaPath)
+	if err != nil {
+		return err
+	}
+
+	projects, err := quota.ReadProjectsFile(opts.ProjectsFile)
+	if err != nil {
+		return fmt.Errorf("failed to read projects file: %w", err)
+	}
+	projids, err := quota.ReadProjidFile(opts.ProjidFile)
+	if err != nil {
+		return fmt.Errorf("failed to read projid file: %w", err)
+	}
+
+	quotas, err := quota.GetProjectQuotas(fsType, opts.QuotaPath)
+	if err != nil {
+		return fmt.Errorf("failed to read quota report: %w", err)
+	}
+
+	entries := make([]Entry, 0, len(projects))
+	for id, path := range projects {
+		parsed, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			continue
+		}
+		projectID := uint32(parsed)
+		pq, ok := quotas[projectID]
+		if !ok {
+			pq = &quota.ProjectQuota{ProjectID: projectID}
+		}
+		entries = append(entries, newEntry(path, projectID, projids[id], fsType, pq))
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	return printEntries(opts.Output, entries)
+}
+
+// detectFSType detects and validates the filesystem type of the quota path
+func detectFSType(quotaPath string) (string, error) {
+	fsType, err := quota.DetectFSTypeWithFindmnt(quotaPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to detect filesystem: %w", err)
+	}
+	switch fsType {
+	case quota.FSTypeXFS, quota.FSTypeExt4:
+		return fsType, nil
+	default:
+		return "", fmt.Errorf("unsupported filesystem type: %s (only xfs and ext4 are supported)", fsType)
+	}
+}
+
+// resolvePath makes path absolute and checks that it lives under quotaPath
+func resolvePath(quotaPath, path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	root := filepath.Clean(quotaPath)
+	if abs != root && !strings.HasPrefix(abs, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not under quota path %s", abs, root)
+	}
+	return abs, nil
+}
+
+// checkProjectIDFree returns an error if projectID is already used by another path
+func checkProjectIDFree(opts Options, projectID uint32, path string) error {
+	projects, err := quota.ReadProjectsFile(opts.ProjectsFile)
+	if err != nil {
+		return fmt.Errorf("failed to read projects file: %w", err)
+	}
+	if existing, ok := projects[strconv.FormatUint(uint64(projectID), 10)]; ok && existing != path {
+		return fmt.Errorf("project ID %d is already used by %s (use --name to choose another project name)", projectID, existing)
+	}
+	return nil
+}
+
+func newEntry(path string, projectID uint32, projectName, fsType string, pq *quota.ProjectQuota) Entry {
+	_, statErr := os.Stat(path)
+	return Entry{
+		Path:        path,
+		ProjectID:   projectID,
+		ProjectName: projectName,
+		FSType:      fsType,
+		DirExists:   statErr == nil,
+		Used:        pq.BlockUsed,
+		UsedStr:     util.FormatBytes(int64(pq.BlockUsed)),
+		InodesUsed:  pq.InodeUsed,
+		Limits:      pq.Limits,
+	}
+}
+
+func printEntries(format string, entries []Entry) error {
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if len(entries) == 1 {
+			return encoder.Encode(entries[0])
+		}
+		return encoder.Encode(entries)
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No project quotas configured.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tPROJECT_ID\tPROJECT_NAME\tUSED\tSOFT\tHARD\tINODES\tINODE_SOFT\tINODE_HARD")
+	for _, e := range entries {
+		path := e.Path
+		if !e.DirExists {
+			path += " (missing)"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			path,
+			e.ProjectID,
+			e.ProjectName,
+			e.UsedStr,
+			formatLimit(e.BlockSoft, true),
+			formatLimit(e.BlockHard, true),
+			e.InodesUsed,
+			formatLimit(e.InodeSoft, false),
+			formatLimit(e.InodeHard, false),
+		)
+	}
+	return w.Flush()
+}
+
+func formatLimit(v int64, bytes bool) string {
+	if v == 0 {
+		return "-"
+	}
+	if bytes {
+		return util.FormatBytes(v)
+	}
+	return strconv.FormatInt(v, 10)
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manual
+
+import (
+	"testing"
+
+	"github.com/dasomel/nfs-quota-agent/internal/quota"
+)
+
+func TestSetRequestLimits(t *testing.T) {
+	current := quota.Limits{BlockHard: 10 << 30, BlockSoft: 8 << 30, InodeHard: 1000, InodeSoft: 800}
+
+	tests := []struct {
+		name string
+		req  SetRequest
+		want quota.Limits
+	}{
+		{
+			name: "hard limit keeps soft and inode limits",
+			req:  SetRequest{SizeBytes: 20 << 30},
+			want: quota.Limits{BlockHard: 20 << 30, BlockSoft: 8 << 30, InodeHard: 1000, InodeSoft: 800},
+		},
+		{
+			name: "soft limit keeps the hard limit",
+			req:  SetRequest{SizeBytes: 9 << 30, Soft: true},
+			want: quota.Limits{BlockHard: 10 << 30, BlockSoft: 9 << 30, InodeHard: 1000, InodeSoft: 800},
+		},
+		{
+			name: "inodes given",
+			req:  SetRequest{SizeBytes: 10 << 30, Inodes: 5000, InodesSet: true},
+			want: quota.Limits{BlockHard: 10 << 30, BlockSoft: 8 << 30, InodeHard: 5000, InodeSoft: 800},
+		},
+		{
+			name: "inode limit cleared",
+			req:  SetRequest{SizeBytes: 9 << 30, InodesSet: true, Soft: true},
+			want: quota.Limits{BlockHard: 10 << 30, BlockSoft: 9 << 30, InodeHard: 1000},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.req.limits(current); got != tt.want {
+				t.Errorf("limits() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitChanges(t *testing.T) {
+	old := quota.Limits{BlockHard: 10 << 30}
+	got := limitChanges(old, quota.Limits{BlockHard: 10 << 30, BlockSoft: 8 << 30, InodeHard: 100})
+	if want := "soft - -> 8.0 GiB, inode hard - -> 100"; got != want {
+		t.Errorf("limitChanges() = %q, want %q", got, want)
+	}
+	if got := limitChanges(old, old); got != "" {
+		t.Errorf("limitChanges() = %q for unchanged limits", got)
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{"/data", false},
+		{"/data/shared", false},
+		{"/data/../etc", true},
+		{"/data2/shared", true},
+	}
+	for _, tt := range tests {
+		if _, err := resolvePath("/data", tt.path); (err != nil) != tt.wantErr {
+			t.Errorf("resolvePath(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+		}
+	}
+}
@@ -25,7 +25,8 @@ import (
 	"sync"
 	"time"
 
-	"github.com/dasomel/nfs-quota-agent/internal/quota"
+	"github.com/dasomel/nfs-quota-agent/internal/deleter"
+	"github.com/dasomel/nfs-quota-agent/internal/history"
 	"github.com/dasomel/nfs-quota-agent/internal/status"
 )
 
@@ -33,6 +34,13 @@ import (
 type AgentInfo interface {
 	BasePath() string
 	AppliedQuotaCount() int
+	Frozen() bool
+	QueuedEventCount() int
+	PurgeStatus() deleter.Status
+	Forecasts() ([]history.Forecast, *history.ExportForecast)
+	Anomalies() ([]history.Anomaly, map[string]int)
+	HistoryCollection() (history.Collection, bool)
+	UsageCache() *status.UsageCache
 }
 
 // Collector collects quota metrics for Prometheus
@@ -54,7 +62,7 @@ func StartServer(addr string, agent AgentInfo, version string) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/metrics", collector.handleMetrics)
 	mux.HandleFunc("/health", handleHealth)
-	mux.HandleFunc("/ready", handleReady)
+	mux.HandleFunc("/ready", collector.handleReady)
 
 	slog.Info("Starting metrics server", "addr", addr)
 	if err := http.ListenAndServe(addr, mux); err != nil {
@@ -73,6 +81,66 @@ func (c *Collector) handleMetrics(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
 	fmt.Fprint(w, c.metrics)
+	fmt.Fprint(w, c.freezeMetrics())
+	fmt.Fprint(w, c.purgeMetrics())
+}
+
+// freezeMetrics renders the maintenance freeze state. It is not cached so
+// that a freeze shows up immediately.
+func (c *Collector) freezeMetrics() string {
+	var sb strings.Builder
+
+	frozen := 0
+	if c.agent.Frozen() {
+		frozen = 1
+	}
+
+	sb.WriteString("\n# HELP nfs_quota_agent_frozen Whether the agent is frozen for maintenance (1 = frozen)\n")
+	sb.WriteString("# TYPE nfs_quota_agent_frozen gauge\n")
+	sb.WriteString(fmt.Sprintf("nfs_quota_agent_frozen %d\n\n", frozen))
+
+	sb.WriteString("# HELP nfs_quota_agent_queued_events PV events queued while the agent is frozen\n")
+	sb.WriteString("# TYPE nfs_quota_agent_queued_events gauge\n")
+	sb.WriteString(fmt.Sprintf("nfs_quota_agent_queued_events %d\n", c.agent.QueuedEventCount()))
+
+	return sb.String()
+}
+
+// purgeMetrics renders trash purge progress. It is not cached so that a
+// long purge can be followed as it runs.
+func (c *Collector) purgeMetrics() string {
+	var sb strings.Builder
+	s := c.agent.PurgeStatus()
+
+	active := 0
+	var current, total int64
+	if s.Current != nil {
+		active = 1
+		current = s.Current.Bytes
+		total = s.Current.TotalBytes
+	}
+
+	sb.WriteString("\n# HELP nfs_quota_purge_active Whether a trashed directory is being purged (1 = purging)\n")
+	sb.WriteString("# TYPE nfs_quota_purge_active gauge\n")
+	sb.WriteString(fmt.Sprintf("nfs_quota_purge_active %d\n\n", active))
+
+	sb.WriteString("# HELP nfs_quota_purge_current_bytes Bytes deleted so far from the directory being purged\n")
+	sb.WriteString("# TYPE nfs_quota_purge_current_bytes gauge\n")
+	sb.WriteString(fmt.Sprintf("nfs_quota_purge_current_bytes %d\n\n", current))
+
+	sb.WriteString("# HELP nfs_quota_purge_current_total_bytes Size of the directory being purged\n")
+	sb.WriteString("# TYPE nfs_quota_purge_current_total_bytes gauge\n")
+	sb.WriteString(fmt.Sprintf("nfs_quota_purge_current_total_bytes %d\n\n", total))
+
+	sb.WriteString("# HELP nfs_quota_purge_files_deleted_total Files deleted by trash purges since start\n")
+	sb.WriteString("# TYPE nfs_quota_purge_files_deleted_total counter\n")
+	sb.WriteString(fmt.Sprintf("nfs_quota_purge_files_deleted_total %d\n\n", s.FilesDeleted))
+
+	sb.WriteString("# HELP nfs_quota_purge_bytes_deleted_total Bytes deleted by trash purges since start\n")
+	sb.WriteString("# TYPE nfs_quota_purge_bytes_deleted_total counter\n")
+	sb.WriteString(fmt.Sprintf("nfs_quota_purge_bytes_deleted_total %d\n", s.BytesDeleted))
+
+	return sb.String()
 }
 
 func (c *Collector) updateMetrics() {
@@ -104,11 +172,8 @@ func (c *Collector) updateMetrics() {
 		sb.WriteString(fmt.Sprintf("nfs_disk_used_percent{path=\"%s\"} %.2f\n\n", basePath, diskUsage.UsedPct))
 	}
 
-	// Get filesystem type
-	fsType, _ := quota.DetectFSType(basePath)
-
-	// Get directory quotas
-	dirUsages, err := status.GetDirUsages(basePath, fsType)
+	// Get directory quotas, sharing the scan with the history collector
+	dirUsages, _, err := c.agent.UsageCache().Get(status.UsageMaxAge, true)
 	if err == nil && len(dirUsages) > 0 {
 		sb.WriteString("# HELP nfs_quota_used_bytes Used space by directory in bytes\n")
 		sb.WriteString("# TYPE nfs_quota_used_bytes gauge\n")
@@ -164,6 +229,55 @@ func (c *Collector) updateMetrics() {
 		sb.WriteString(fmt.Sprintf("nfs_quota_exceeded_count %d\n\n", exceededCount))
 	}
 
+	// Time-to-full forecasts (history only)
+	forecasts, export := c.agent.Forecasts()
+	now := time.Now()
+	if len(forecasts) > 0 || export != nil {
+		sb.WriteString("# HELP nfs_quota_predicted_full_seconds Predicted seconds until a directory reaches its quota (scope=directory) or the export disk fills (scope=export), from usage history\n")
+		sb.WriteString("# TYPE nfs_quota_predicted_full_seconds gauge\n")
+		for _, f := range forecasts {
+			if f.FullAt != nil {
+				sb.WriteString(fmt.Sprintf("nfs_quota_predicted_full_seconds{scope=\"directory\",directory=\"%s\"} %.0f\n", f.DirName, secondsUntil(now, *f.FullAt)))
+			}
+		}
+		if export != nil && export.FullAt != nil {
+			sb.WriteString(fmt.Sprintf("nfs_quota_predicted_full_seconds{scope=\"export\",path=\"%s\"} %.0f\n", export.Path, secondsUntil(now, *export.FullAt)))
+		}
+		sb.WriteString("\n")
+	}
+
+	// Usage anomalies (anomaly detection only)
+	anomalies, anomalyCounts := c.agent.Anomalies()
+	if anomalyCounts != nil {
+		sb.WriteString("# HELP nfs_quota_usage_anomaly Ongoing usage anomaly of a directory (abnormal growth or a sudden drop)\n")
+		sb.WriteString("# TYPE nfs_quota_usage_anomaly gauge\n")
+		for _, a := range anomalies {
+			sb.WriteString(fmt.Sprintf("nfs_quota_usage_anomaly{directory=\"%s\",kind=\"%s\"} 1\n", a.DirName, a.Kind))
+		}
+		sb.WriteString("\n")
+
+		sb.WriteString("# HELP nfs_quota_usage_anomalies_total Usage anomalies detected since the agent started\n")
+		sb.WriteString("# TYPE nfs_quota_usage_anomalies_total counter\n")
+		for _, kind := range []string{history.AnomalyGrowth, history.AnomalyDrop} {
+			sb.WriteString(fmt.Sprintf("nfs_quota_usage_anomalies_total{kind=\"%s\"} %d\n", kind, anomalyCounts[kind]))
+		}
+		sb.WriteString("\n")
+	}
+
+	// History collection cost
+	if hc, ok := c.agent.HistoryCollection(); ok {
+		sb.WriteString("# HELP nfs_quota_history_collection_seconds Time taken to gather and record the last usage history snapshot\n")
+		sb.WriteString("# TYPE nfs_quota_history_collection_seconds gauge\n")
+		sb.WriteString(fmt.Sprintf("nfs_quota_history_collection_seconds %.3f\n\n", hc.Duration.Seconds()))
+
+		sb.WriteString("# HELP nfs_quota_history_collection_directories Directories in the last history snapshot by usage source (report, walked, carried, skipped)\n")
+		sb.WriteString("# TYPE nfs_quota_history_collection_directories gauge\n")
+		sb.WriteString(fmt.Sprintf("nfs_quota_history_collection_directories{source=\"report\"} %d\n", hc.Scan.FromReport))
+		sb.WriteString(fmt.Sprintf("nfs_quota_history_collection_directories{source=\"walked\"} %d\n", hc.Scan.Walked))
+		sb.WriteString(fmt.Sprintf("nfs_quota_history_collection_directories{source=\"carried\"} %d\n", hc.Scan.Carried))
+		sb.WriteString(fmt.Sprintf("nfs_quota_history_collection_directories{source=\"skipped\"} %d\n\n", hc.Scan.Skipped))
+	}
+
 	// Applied quotas count
 	appliedCount := c.agent.AppliedQuotaCount()
 
@@ -175,12 +289,26 @@ func (c *Collector) updateMetrics() {
 	c.lastUpdate = time.Now()
 }
 
+// secondsUntil returns the seconds from now to t, 0 if t has passed
+func secondsUntil(now, t time.Time) float64 {
+	if d := t.Sub(now).Seconds(); d > 0 {
+		return d
+	}
+	return 0
+}
+
 func handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprint(w, "ok")
 }
 
-func handleReady(w http.ResponseWriter, r *http.Request) {
+// handleReady stays ready while frozen so that metrics and the UI remain
+// reachable through the Service; the body reports the freeze instead
+func (c *Collector) handleReady(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
+	if c.agent.Frozen() {
+		fmt.Fprint(w, "ok (frozen)")
+		return
+	}
 	fmt.Fprint(w, "ok")
 }
@@ -0,0 +1,112 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/dasomel/nfs-quota-agent/internal/agent"
+	"github.com/dasomel/nfs-quota-agent/internal/util"
+)
+
+// Options configures the plan command
+type Options struct {
+	NfsBasePath     string
+	NfsServerPath   string
+	ProvisionerName string
+	ProcessAllNFS   bool
+	ProjectsFile    string
+	ProjidFile      string
+	AdoptExisting   bool
+	Client          kubernetes.Interface
+}
+
+// Plan is the result of a one-shot dry-run sync
+type Plan struct {
+	Timestamp time.Time             `json:"timestamp"`
+	QuotaPath string                `json:"quotaPath"`
+	Changes   []agent.PlannedChange `json:"changes"`
+	Summary   map[string]int        `json:"summary"`
+}
+
+// Run performs a full PV sync in dry-run mode and returns every change the
+// agent would make
+func Run(ctx context.Context, opts Options) (*Plan, error) {
+	ag := agent.NewQuotaAgent(opts.Client, opts.NfsBasePath, opts.NfsServerPath, opts.ProvisionerName)
+	ag.SetProcessAllNFS(opts.ProcessAllNFS)
+	ag.SetProjectsFile(opts.ProjectsFile)
+	ag.SetProjidFile(opts.ProjidFile)
+	ag.SetAdoptExisting(opts.AdoptExisting)
+	ag.SetDryRun(true)
+
+	changes, err := ag.Plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Plan{
+		Timestamp: time.Now(),
+		QuotaPath: opts.NfsBasePath,
+		Changes:   changes,
+		Summary:   make(map[string]int),
+	}
+	for _, c := range changes {
+		p.Summary[c.Action]++
+	}
+	return p, nil
+}
+
+// Print writes the plan as a table or JSON
+func (p *Plan) Print(format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(p)
+	}
+
+	if len(p.Changes) == 0 {
+		fmt.Println("No changes. Quotas and projects files are up to date.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tPV\tPATH\tPROJECT\tOLD\tNEW\tDETAIL")
+	for _, c := range p.Changes {
+		oldSize, newSize := "-", "-"
+		if c.OldBytes > 0 {
+			oldSize = util.FormatBytes(c.OldBytes)
+		}
+		if c.NewBytes > 0 {
+			newSize = util.FormatBytes(c.NewBytes)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			c.Action, c.PVName, c.Path, c.ProjectID, oldSize, newSize, c.Detail)
+	}
+	_ = w.Flush()
+
+	fmt.Printf("\nPlan: %d to create, %d to update, %d to shrink, %d project assignments, %d projects file edits\n",
+		p.Summary[agent.PlanCreate], p.Summary[agent.PlanUpdate], p.Summary[agent.PlanShrink],
+		p.Summary[agent.PlanSetProject], p.Summary[agent.PlanProjectsFile])
+	return nil
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package projects
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/dasomel/nfs-quota-agent/internal/agent"
+)
+
+// Options configures the projects commands
+type Options struct {
+	NfsBasePath     string
+	NfsServerPath   string
+	ProvisionerName string
+	ProcessAllNFS   bool
+	ProjectsFile    string
+	ProjidFile      string
+	Client          kubernetes.Interface
+	DryRun          bool
+	Yes             bool
+	Output          string
+}
+
+// rebuildResult is the JSON output of Rebuild
+type rebuildResult struct {
+	*agent.ProjectsRebuild
+	Diff    []agent.FileDiff `json:"diff"`
+	Written bool             `json:"written"`
+}
+
+// Rebuild regenerates the projects and projid files from the PV list and the
+// project IDs stored on the directories, shows the diff against the current
+// files and writes them after confirmation
+func Rebuild(ctx context.Context, opts Options) error {
+	ag := agent.NewQuotaAgent(opts.Client, opts.NfsBasePath, opts.NfsServerPath, opts.ProvisionerName)
+	ag.SetProcessAllNFS(opts.ProcessAllNFS)
+	ag.SetProjectsFile(opts.ProjectsFile)
+	ag.SetProjidFile(opts.ProjidFile)
+
+	plan, err := ag.PlanProjectsRebuild(ctx)
+	if err != nil {
+		return err
+	}
+	diffs := plan.Diff()
+
+	if opts.Output == "json" {
+		// JSON output is non-interactive: only --yes writes
+		res := rebuildResult{ProjectsRebuild: plan, Diff: diffs}
+		if len(diffs) > 0 && opts.Yes && !opts.DryRun {
+			if err := plan.Write(); err != nil {
+				return err
+			}
+			res.Written = true
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(res)
+	}
+
+	if len(plan.Warnings) > 0 {
+		fmt.Println("Warnings:")
+		for _, w := range plan.Warnings {
+			fmt.Printf("  - %s\n", w)
+		}
+		fmt.Println()
+	}
+
+	if len(diffs) == 0 {
+		fmt.Printf("Projects files are up to date (%d entries).\n", len(plan.Entries))
+		return nil
+	}
+
+	for _, d := range diffs {
+		fmt.Printf("--- %s\n+++ %s (rebuilt)\n", d.File, d.File)
+		for _, line := range d.Removed {
+			fmt.Printf("-%s\n", line)
+		}
+		for _, line := range d.Added {
+			fmt.Printf("+%s\n", line)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("%d entries after rebuild.\n\n", len(plan.Entries))
+
+	if opts.DryRun {
+		fmt.Println("Dry-run mode: No changes made.")
+		return nil
+	}
+
+	if !opts.Yes {
+		fmt.Print("Write rebuilt projects files? [y/N]: ")
+		reader := bufio.NewReader(os.Stdin)
+		answer, _ := reader.ReadString('\n')
+		answer = strings.TrimSpace(strings.ToLower(answer))
+		if answer != "y" && answer != "yes" {
+			fmt.Println("Rebuild cancelled.")
+			return nil
+		}
+	}
+
+	if err := plan.Write(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s and %s.\n", plan.ProjectsFile, plan.ProjidFile)
+	return nil
+}
+
+// Adopt reports which existing project every PV directory would be bound to
+// in adoption mode (run --adopt-existing) and lists conflicts. It returns the
+// number of PVs blocked by conflicts.
+func Adopt(ctx context.Context, opts Options) (int, error) {
+	ag := agent.NewQuotaAgent(opts.Client, opts.NfsBasePath, opts.NfsServerPath, opts.ProvisionerName)
+	ag.SetProcessAllNFS(opts.ProcessAllNFS)
+	ag.SetProjectsFile(opts.ProjectsFile)
+	ag.SetProjidFile(opts.ProjidFile)
+
+	adoptions, err := ag.PlanAdoption(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	blocked := 0
+	for _, ad := range adoptions {
+		if ad.Blocked {
+			blocked++
+		}
+	}
+
+	if opts.Output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return blocked, encoder.Encode(adoptions)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PV\tPATH\tPROJECT ID\tPROJECT NAME\tSOURCE\tACTION")
+	for _, ad := range adoptions {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n",
+			ad.PVName, ad.Path, ad.ProjectID, ad.ProjectName, ad.Source, adoptionAction(ad))
+	}
+	_ = w.Flush()
+
+	var conflicts []string
+	for _, ad := range adoptions {
+		for _, c := range ad.Conflicts {
+			conflicts = append(conflicts, fmt.Sprintf("%s: %s", ad.PVName, c))
+		}
+	}
+	if len(conflicts) > 0 {
+		fmt.Println("\nConflicts:")
+		for _, c := range conflicts {
+			fmt.Printf("  - %s\n", c)
+		}
+	}
+
+	fmt.Printf("\nTotal: %d PVs, %d blocked by conflicts\n", len(adoptions), blocked)
+	return blocked, nil
+}
+
+// adoptionAction describes what the agent does with a PV in adoption mode
+func adoptionAction(ad agent.Adoption) string {
+	switch {
+	case ad.Blocked:
+		return "skip (conflict)"
+	case ad.DirTagged && ad.Recorded:
+		return "reconcile limit"
+	case ad.DirTagged:
+		return "record + reconcile limit"
+	case ad.Source == agent.AdoptSourceProjectsFile:
+		return "tag directory + set limit"
+	default:
+		return "create project"
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quota
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// GetDirProjectID reads the project ID stored on a directory's inode.
+// Zero means the directory is not assigned to any project.
+func GetDirProjectID(fsType, path string) (uint32, error) {
+	switch fsType {
+	case FSTypeXFS:
+		cmd := exec.Command("xfs_io", "-r", "-c", "lsproj", path)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return 0, fmt.Errorf("xfs_io lsproj failed: %w, output: %s", err, string(output))
+		}
+		return parseLsprojOutput(string(output))
+	case FSTypeExt4:
+		cmd := exec.Command("lsattr", "-p", "-d", path)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return 0, fmt.Errorf("lsattr failed: %w, output: %s", err, string(output))
+		}
+		return parseLsattrProjectOutput(string(output))
+	default:
+		return 0, fmt.Errorf("unsupported filesystem type: %s", fsType)
+	}
+}
+
+// parseLsprojOutput parses `xfs_io -c lsproj` output ("projid = 1234")
+func parseLsprojOutput(output string) (uint32, error) {
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(key) != "projid" {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSpace(value), 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid project id in lsproj output: %q", line)
+		}
+		return uint32(id), nil
+	}
+	return 0, fmt.Errorf("unexpected lsproj output: %q", output)
+}
+
+// parseLsattrProjectOutput parses `lsattr -p -d` output ("1234 --------------e------- /path")
+func parseLsattrProjectOutput(output string) (uint32, error) {
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected lsattr output: %q", output)
+	}
+	id, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid project id in lsattr output: %q", output)
+	}
+	return uint32(id), nil
+}
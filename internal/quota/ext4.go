@@ -47,8 +47,54 @@ func CheckExt4QuotaAvailable(quotaPath string) error {
 	return nil
 }
 
-// ApplyExt4Quota applies ext4 project quota
-func ApplyExt4Quota(quotaPath, path, projectName string, projectID uint32, sizeBytes int64, projectsFile, projidFile string) error {
+// ApplyExt4Quota applies ext4 project quota with a block hard limit. The
+// soft and inode limits in current, the project's limits from the last quota
+// report, are kept.
+func ApplyExt4Quota(quotaPath, path, projectName string, projectID uint32, sizeBytes int64, current Limits, projectsFile, projidFile string) error {
+	if err := initExt4Project(path, projectName, projectID, projectsFile, projidFile); err != nil {
+		return err
+	}
+	if err := SetExt4BlockHardLimit(quotaPath, projectID, sizeBytes, current); err != nil {
+		return err
+	}
+
+	slog.Debug("ext4 quota applied",
+		"path", path,
+		"projectName", projectName,
+		"projectID", projectID,
+		"sizeKB", kbLimit(sizeBytes),
+	)
+
+	return nil
+}
+
+// ApplyExt4QuotaLimits applies ext4 project quota with block and inode limits
+func ApplyExt4QuotaLimits(quotaPath, path, projectName string, projectID uint32, limits Limits, projectsFile, projidFile string) error {
+	if err := initExt4Project(path, projectName, projectID, projectsFile, projidFile); err != nil {
+		return err
+	}
+
+	// Set the quota limit using setquota
+	if err := SetExt4ProjectLimits(quotaPath, projectID, limits); err != nil {
+		return err
+	}
+
+	slog.Debug("ext4 quota applied",
+		"path", path,
+		"projectName", projectName,
+		"projectID", projectID,
+		"sizeKB", kbLimit(limits.BlockHard),
+		"softKB", kbLimit(limits.BlockSoft),
+		"inodeHard", limits.InodeHard,
+		"inodeSoft", limits.InodeSoft,
+	)
+
+	return nil
+}
+
+// initExt4Project records the project in the projects files and sets the
+// project attribute on the directory
+func initExt4Project(path, projectName string, projectID uint32, projectsFile, projidFile string) error {
 	// 1. Add project to projects file
 	if err := AddProject(path, projectName, projectID, projectsFile, projidFile); err != nil {
 		return fmt.Errorf("failed to add project: %w", err)
@@ -68,33 +114,76 @@ func ApplyExt4Quota(quotaPath, path, projectName string, projectID uint32, sizeB
 			slog.Warn("Failed to set project attribute", "path", path, "error", err)
 		}
 	}
+	return nil
+}
 
-	// 3. Set the quota limit using setquota
-	// Convert bytes to KB (setquota uses KB for block limits)
-	sizeKB := sizeBytes / 1024
-	if sizeKB == 0 {
-		sizeKB = 1
-	}
+// SetExt4BlockHardLimit sets the block hard limit of an ext4 project.
+// setquota always sets all four limits, so the other limits are taken from
+// current; a zero current sets the block hard limit alone.
+func SetExt4BlockHardLimit(quotaPath string, projectID uint32, sizeBytes int64, current Limits) error {
+	current.BlockHard = sizeBytes
+	return SetExt4ProjectLimits(quotaPath, projectID, current)
+}
 
+// SetExt4ProjectLimits sets the limits of an existing ext4 project
+func SetExt4ProjectLimits(quotaPath string, projectID uint32, limits Limits) error {
 	// setquota -P <project_id> <block-softlimit> <block-hardlimit> <inode-softlimit> <inode-hardlimit> <filesystem>
-	// We set block hard limit only (soft limit = 0 means no soft limit, inode limits = 0 means no inode limits)
-	cmd = exec.Command("setquota", "-P",
+	// Block limits are in KB; a limit of 0 means no limit
+	cmd := exec.Command("setquota", "-P",
 		fmt.Sprintf("%d", projectID),
-		"0",                       // block soft limit (0 = no limit)
-		fmt.Sprintf("%d", sizeKB), // block hard limit in KB
-		"0",                       // inode soft limit
-		"0",                       // inode hard limit
+		fmt.Sprintf("%d", kbLimit(limits.BlockSoft)), // block soft limit in KB
+		fmt.Sprintf("%d", kbLimit(limits.BlockHard)), // block hard limit in KB
+		fmt.Sprintf("%d", limits.InodeSoft),          // inode soft limit
+		fmt.Sprintf("%d", limits.InodeHard),          // inode hard limit
 		quotaPath)
 	if output, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("failed to set quota limit: %w, output: %s", err, string(output))
 	}
+	return nil
+}
 
-	slog.Debug("ext4 quota applied",
-		"path", path,
-		"projectName", projectName,
-		"projectID", projectID,
-		"sizeKB", sizeKB,
-	)
+// GetExt4ProjectQuotas reads usage and limits of all ext4 projects on quotaPath
+func GetExt4ProjectQuotas(quotaPath string) (map[uint32]*ProjectQuota, error) {
+	cmd := exec.Command("repquota", "-P", "-n", quotaPath)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("repquota failed: %w, output: %s", err, string(output))
+	}
 
+	result := make(map[uint32]*ProjectQuota)
+	for _, line := range strings.Split(string(output), "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		pq, err := parseRepquotaLine(line)
+		if err != nil {
+			slog.Debug("Skipping unparsable repquota line", "line", line, "error", err)
+			continue
+		}
+		result[pq.ProjectID] = pq
+	}
+
+	return result, nil
+}
+
+// RemoveExt4ProjectQuota clears the limits of an ext4 project and the project ID of its directory
+func RemoveExt4ProjectQuota(quotaPath, path string, projectID uint32) error {
+	cmd := exec.Command("setquota", "-P", fmt.Sprintf("%d", projectID), "0", "0", "0", "0", quotaPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to clear quota limit: %w, output: %s", err, string(output))
+	}
+
+	if path != "" {
+		return clearExt4ProjectDir(path)
+	}
+	return nil
+}
+
+// clearExt4ProjectDir detaches a directory tree from its project
+func clearExt4ProjectDir(path string) error {
+	cmd := exec.Command("chattr", "-R", "-P", "-p", "0", path)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to clear project attribute: %w, output: %s", err, string(output))
+	}
 	return nil
 }
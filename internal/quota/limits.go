@@ -0,0 +1,240 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quota
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dasomel/nfs-quota-agent/internal/util"
+)
+
+// Limits holds the block (bytes) and inode limits of a project quota.
+// Zero means no limit.
+type Limits struct {
+	BlockHard int64 `json:"blockHard"`
+	BlockSoft int64 `json:"blockSoft"`
+	InodeHard int64 `json:"inodeHard"`
+	InodeSoft int64 `json:"inodeSoft"`
+}
+
+// ProjectQuota holds current usage and limits of a single project
+type ProjectQuota struct {
+	ProjectID uint32 `json:"projectId"`
+	BlockUsed uint64 `json:"blockUsed"`
+	InodeUsed uint64 `json:"inodeUsed"`
+	Limits
+}
+
+// ApplyQuotaLimits applies project quota limits based on filesystem type
+func ApplyQuotaLimits(fsType, quotaPath, path, projectName string, projectID uint32, limits Limits, projectsFile, projidFile string) error {
+	switch fsType {
+	case FSTypeXFS:
+		return ApplyXFSQuotaLimits(quotaPath, path, projectName, projectID, limits, projectsFile, projidFile)
+	case FSTypeExt4:
+		return ApplyExt4QuotaLimits(quotaPath, path, projectName, projectID, limits, projectsFile, projidFile)
+	default:
+		return fmt.Errorf("unsupported filesystem type: %s", fsType)
+	}
+}
+
+// SetProjectBlockHardLimit sets only the block hard limit of an existing
+// project, keeping its soft and inode limits. current holds the project's
+// limits from the last quota report; only ext4 needs them.
+func SetProjectBlockHardLimit(fsType, quotaPath string, projectID uint32, sizeBytes int64, current Limits) error {
+	switch fsType {
+	case FSTypeXFS:
+		return SetXFSBlockHardLimit(quotaPath, projectID, sizeBytes)
+	case FSTypeExt4:
+		return SetExt4BlockHardLimit(quotaPath, projectID, sizeBytes, current)
+	default:
+		return fmt.Errorf("unsupported filesystem type: %s", fsType)
+	}
+}
+
+// GetProjectQuotas reads usage and limits of all projects based on filesystem type
+func GetProjectQuotas(fsType, quotaPath string) (map[uint32]*ProjectQuota, error) {
+	switch fsType {
+	case FSTypeXFS:
+		return GetXFSProjectQuotas(quotaPath)
+	case FSTypeExt4:
+		return GetExt4ProjectQuotas(quotaPath)
+	default:
+		return nil, fmt.Errorf("unsupported filesystem type: %s", fsType)
+	}
+}
+
+// GetProjectQuota reads usage and limits for a single project ID.
+// A project without recorded usage or limits returns zero values.
+func GetProjectQuota(fsType, quotaPath string, projectID uint32) (*ProjectQuota, error) {
+	quotas, err := GetProjectQuotas(fsType, quotaPath)
+	if err != nil {
+		return nil, err
+	}
+	if pq, ok := quotas[projectID]; ok {
+		return pq, nil
+	}
+	return &ProjectQuota{ProjectID: projectID}, nil
+}
+
+// RemoveProjectQuota clears all limits of a project and detaches the directory from it
+func RemoveProjectQuota(fsType, quotaPath, path string, projectID uint32) error {
+	switch fsType {
+	case FSTypeXFS:
+		return RemoveXFSProjectQuota(quotaPath, path, projectID)
+	case FSTypeExt4:
+		return RemoveExt4ProjectQuota(quotaPath, path, projectID)
+	default:
+		return fmt.Errorf("unsupported filesystem type: %s", fsType)
+	}
+}
+
+// DetachProjectPath removes path from its project: the projects file line
+// for path is removed, and the project's limits and projid entry are cleared
+// only when no other projects line uses the ID, so the other directories of a
+// multi-path project keep their quota. With clearDir, the project attribute
+// of the directory at path is cleared too.
+func DetachProjectPath(fsType, quotaPath, path string, clearDir bool, projectID uint32, projectName, projectsFile, projidFile string) error {
+	shared, err := ProjectShared(projectID, path, projectsFile, projidFile)
+	if err != nil {
+		return fmt.Errorf("failed to read projects file: %w", err)
+	}
+
+	dirPath := ""
+	if clearDir {
+		dirPath = path
+	}
+	switch {
+	case !shared:
+		err = RemoveProjectQuota(fsType, quotaPath, dirPath, projectID)
+	case dirPath == "":
+	case fsType == FSTypeXFS:
+		err = clearXFSProjectDir(quotaPath, dirPath, projectID)
+	case fsType == FSTypeExt4:
+		err = clearExt4ProjectDir(dirPath)
+	default:
+		err = fmt.Errorf("unsupported filesystem type: %s", fsType)
+	}
+	if err != nil {
+		return err
+	}
+	return RemoveProjectPath(projectID, path, projectName, projectsFile, projidFile)
+}
+
+// BlockLimitBytes returns the block limit as it is stored by the quota tools
+// for a requested size in bytes (whole KB, at least 1KB)
+func BlockLimitBytes(sizeBytes int64) int64 {
+	return kbLimit(sizeBytes) * 1024
+}
+
+// kbLimit converts a byte limit to KB, rounding non-zero values up to 1KB
+func kbLimit(sizeBytes int64) int64 {
+	if sizeBytes <= 0 {
+		return 0
+	}
+	sizeKB := sizeBytes / 1024
+	if sizeKB == 0 {
+		sizeKB = 1
+	}
+	return sizeKB
+}
+
+// parseXFSReportLine parses a single `xfs_quota -c "report -p -b -i -n -N"` line
+// of the form "#ID bused bsoft bhard bwarn [bgrace] iused isoft ihard iwarn [igrace]".
+// Grace periods may span several tokens (e.g. "[7 days]").
+func parseXFSReportLine(line string) (*ProjectQuota, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 9 {
+		return nil, fmt.Errorf("unexpected xfs_quota report line: %q", line)
+	}
+
+	var pq ProjectQuota
+	var id uint64
+	if _, err := fmt.Sscanf(strings.TrimPrefix(fields[0], "#"), "%d", &id); err != nil {
+		return nil, fmt.Errorf("invalid project id in xfs_quota report line: %q", line)
+	}
+	pq.ProjectID = uint32(id)
+
+	parse := func(s string) uint64 {
+		v, _ := util.ParseSize(s)
+		return v
+	}
+
+	pq.BlockUsed = parse(fields[1]) * 1024
+	pq.BlockSoft = int64(parse(fields[2]) * 1024)
+	pq.BlockHard = int64(parse(fields[3]) * 1024)
+
+	// Skip the warning count and the bracketed grace period
+	i := 5
+	for i < len(fields) && !strings.HasSuffix(fields[i], "]") {
+		i++
+	}
+	i++
+	if i+2 >= len(fields) {
+		return &pq, nil
+	}
+
+	pq.InodeUsed = parse(fields[i])
+	pq.InodeSoft = int64(parse(fields[i+1]))
+	pq.InodeHard = int64(parse(fields[i+2]))
+
+	return &pq, nil
+}
+
+// parseRepquotaLine parses a single `repquota -P -n` line of the form
+// "#ID flags bused bsoft bhard [bgrace] iused isoft ihard [igrace]"
+func parseRepquotaLine(line string) (*ProjectQuota, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 8 {
+		return nil, fmt.Errorf("unexpected repquota line: %q", line)
+	}
+
+	var pq ProjectQuota
+	var id uint64
+	if _, err := fmt.Sscanf(strings.TrimPrefix(fields[0], "#"), "%d", &id); err != nil {
+		return nil, fmt.Errorf("invalid project id in repquota line: %q", line)
+	}
+	pq.ProjectID = uint32(id)
+
+	flags := fields[1]
+	values := fields[2:]
+
+	// A grace column follows the block limits only when over the soft limit
+	blockGrace := len(flags) > 0 && flags[0] == '+'
+
+	parse := func(i int) uint64 {
+		if i >= len(values) {
+			return 0
+		}
+		v, _ := util.ParseSize(values[i])
+		return v
+	}
+
+	pq.BlockUsed = parse(0) * 1024
+	pq.BlockSoft = int64(parse(1) * 1024)
+	pq.BlockHard = int64(parse(2) * 1024)
+
+	i := 3
+	if blockGrace {
+		i++
+	}
+	pq.InodeUsed = parse(i)
+	pq.InodeSoft = int64(parse(i + 1))
+	pq.InodeHard = int64(parse(i + 2))
+
+	return &pq, nil
+}
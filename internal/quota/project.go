@@ -19,6 +19,7 @@ package quota
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -136,16 +137,88 @@ func ReadProjidFile(filename string) (map[string]string, error) {
 	return result, nil
 }
 
-// RemoveQuotaByID removes quota for a project ID
-func RemoveQuotaByID(basePath, fsType, projectID string) error {
-	switch fsType {
-	case FSTypeXFS:
-		// Set quota to 0 (unlimited) - effectively removes it
-		return nil
-	case FSTypeExt4:
-		// Similar to XFS, the quota is effectively removed when entries are deleted
-		return nil
-	default:
-		return fmt.Errorf("unsupported filesystem: %s", fsType)
+// ProjectName builds a project name from a prefix and an object name
+// (dashes replaced with underscores, name truncated to 32 characters)
+func ProjectName(prefix, name string) string {
+	name = strings.ReplaceAll(name, "-", "_")
+	if len(name) > 32 {
+		name = name[:32]
 	}
+	return prefix + name
+}
+
+// GenerateProjectID generates a numeric project ID from project name (FNV-1a)
+func GenerateProjectID(projectName string) uint32 {
+	var hash uint32 = 2166136261
+	for _, c := range projectName {
+		hash ^= uint32(c)
+		hash *= 16777619
+	}
+	return (hash % 4294967293) + 1
+}
+
+// FindProjectByPath looks up the project registered for path in the projects
+// and projid files. ok is false if the path has no projects file entry.
+func FindProjectByPath(path, projectsFile, projidFile string) (projectID uint32, projectName string, ok bool, err error) {
+	entries, err := ReadProjectEntries(projectsFile, projidFile)
+	if err != nil {
+		return 0, "", false, err
+	}
+	for _, e := range entries {
+		if filepath.Clean(e.Path) == filepath.Clean(path) {
+			return e.ID, e.Name, true, nil
+		}
+	}
+	return 0, "", false, nil
+}
+
+// ProjectShared reports whether a projects file line other than the one for
+// path uses projectID, as in a project spanning several directories
+func ProjectShared(projectID uint32, path, projectsFile, projidFile string) (bool, error) {
+	entries, err := ReadProjectEntries(projectsFile, projidFile)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		if e.ID == projectID && filepath.Clean(e.Path) != filepath.Clean(path) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RemoveProjectPath removes the projects file line mapping projectID to path,
+// leaving the project's other paths alone. The projid entry of projectName
+// is removed too once no projects line uses projectID.
+func RemoveProjectPath(projectID uint32, path, projectName, projectsFile, projidFile string) error {
+	data, err := os.ReadFile(projectsFile)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read projects file: %w", err)
+	}
+
+	id := strconv.FormatUint(uint64(projectID), 10)
+	var lines []string
+	shared := false
+	for _, line := range strings.Split(string(data), "\n") {
+		lineID, linePath, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if ok && lineID == id {
+			if filepath.Clean(linePath) == filepath.Clean(path) {
+				continue
+			}
+			shared = true
+		}
+		lines = append(lines, line)
+	}
+	if err == nil {
+		if err := WriteFileAtomic(projectsFile, []byte(strings.Join(lines, "\n"))); err != nil {
+			return fmt.Errorf("failed to update projects file: %w", err)
+		}
+	}
+
+	if !shared && projectName != "" {
+		if err := RemoveLineFromFile(projidFile, projectName+":"); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to update projid file: %w", err)
+		}
+	}
+	return nil
 }
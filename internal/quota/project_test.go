@@ -0,0 +1,254 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quota
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProjectName(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefix   string
+		input    string
+		expected string
+	}{
+		{"pv name", "pv_", "pvc-1234", "pv_pvc_1234"},
+		{"directory name", "dir_", "shared-data", "dir_shared_data"},
+		{"truncated", "pv_", "pvc-0123456789-0123456789-0123456789", "pv_pvc_0123456789_0123456789_012345"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ProjectName(tt.prefix, tt.input); got != tt.expected {
+				t.Errorf("ProjectName(%q, %q) = %q, want %q", tt.prefix, tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGenerateProjectID(t *testing.T) {
+	id1 := GenerateProjectID("pv_test")
+	id2 := GenerateProjectID("pv_test")
+	id3 := GenerateProjectID("pv_other")
+
+	if id1 != id2 {
+		t.Errorf("GenerateProjectID is not deterministic: %d != %d", id1, id2)
+	}
+	if id1 == id3 {
+		t.Errorf("Expected different IDs for different names, got %d", id1)
+	}
+	if id1 == 0 {
+		t.Error("Project ID must not be zero")
+	}
+}
+
+func TestFindAndRemoveProject(t *testing.T) {
+	tmpDir := t.TempDir()
+	projectsFile := filepath.Join(tmpDir, "projects")
+	projidFile := filepath.Join(tmpDir, "projid")
+
+	if err := AddProject("/data/a", "dir_a", 1001, projectsFile, projidFile); err != nil {
+		t.Fatalf("AddProject failed: %v", err)
+	}
+	if err := AddProject("/data/b", "dir_b", 1002, projectsFile, projidFile); err != nil {
+		t.Fatalf("AddProject failed: %v", err)
+	}
+
+	id, name, ok, err := FindProjectByPath("/data/b", projectsFile, projidFile)
+	if err != nil || !ok {
+		t.Fatalf("FindProjectByPath failed: ok=%v err=%v", ok, err)
+	}
+	if id != 1002 || name != "dir_b" {
+		t.Errorf("FindProjectByPath = (%d, %q), want (1002, \"dir_b\")", id, name)
+	}
+
+	if _, _, ok, _ := FindProjectByPath("/data/missing", projectsFile, projidFile); ok {
+		t.Error("Expected no project for unknown path")
+	}
+
+	if err := RemoveProjectPath(1002, "/data/b", "dir_b", projectsFile, projidFile); err != nil {
+		t.Fatalf("RemoveProjectPath failed: %v", err)
+	}
+	if _, _, ok, _ := FindProjectByPath("/data/b", projectsFile, projidFile); ok {
+		t.Error("Expected project to be removed")
+	}
+
+	data, _ := os.ReadFile(projidFile)
+	if string(data) != "dir_a:1001\n" {
+		t.Errorf("Unexpected projid content: %q", string(data))
+	}
+}
+
+func TestRemoveProjectPathShared(t *testing.T) {
+	tmpDir := t.TempDir()
+	projectsFile := filepath.Join(tmpDir, "projects")
+	projidFile := filepath.Join(tmpDir, "projid")
+
+	// One project spanning two directories, next to another project
+	if err := os.WriteFile(projectsFile, []byte("1001:/data/a\n1001:/data/a2/\n1002:/data/b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(projidFile, []byte("dir_a:1001\ndir_b:1002\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if shared, err := ProjectShared(1001, "/data/a2", projectsFile, projidFile); err != nil || !shared {
+		t.Fatalf("ProjectShared(1001, /data/a2) = %v, %v, want true", shared, err)
+	}
+	if shared, _ := ProjectShared(1002, "/data/b", projectsFile, projidFile); shared {
+		t.Error("Expected /data/b to be the only path of project 1002")
+	}
+
+	// Removing one path keeps the other and the project name
+	if err := RemoveProjectPath(1001, "/data/a2", "dir_a", projectsFile, projidFile); err != nil {
+		t.Fatalf("RemoveProjectPath failed: %v", err)
+	}
+	projects, _ := os.ReadFile(projectsFile)
+	projid, _ := os.ReadFile(projidFile)
+	if string(projects) != "1001:/data/a\n1002:/data/b\n" || string(projid) != "dir_a:1001\ndir_b:1002\n" {
+		t.Errorf("Unexpected files after removing one path: %q %q", projects, projid)
+	}
+	if id, _, ok, _ := FindProjectByPath("/data/a", projectsFile, projidFile); !ok || id != 1001 {
+		t.Errorf("Expected /data/a to keep project 1001, got %d %v", id, ok)
+	}
+
+	// Removing the last path drops the project name too
+	if err := RemoveProjectPath(1001, "/data/a", "dir_a", projectsFile, projidFile); err != nil {
+		t.Fatalf("RemoveProjectPath failed: %v", err)
+	}
+	projects, _ = os.ReadFile(projectsFile)
+	projid, _ = os.ReadFile(projidFile)
+	if string(projects) != "1002:/data/b\n" || string(projid) != "dir_b:1002\n" {
+		t.Errorf("Unexpected files after removing the last path: %q %q", projects, projid)
+	}
+}
+
+func TestParseXFSReportLine(t *testing.T) {
+	line := "#1001      1024      0   2048     00 [--------]      5      0    100     00 [--------]"
+	pq, err := parseXFSReportLine(line)
+	if err != nil {
+		t.Fatalf("parseXFSReportLine failed: %v", err)
+	}
+	if pq.ProjectID != 1001 || pq.BlockUsed != 1024*1024 || pq.BlockHard != 2048*1024 {
+		t.Errorf("Unexpected block values: %+v", pq)
+	}
+	if pq.InodeUsed != 5 || pq.InodeHard != 100 {
+		t.Errorf("Unexpected inode values: %+v", pq)
+	}
+
+	// Grace periods spanning several tokens
+	line = "#1002      3072   1024   4096     01 [7 days]      9      0      0     00 [--------]"
+	pq, err = parseXFSReportLine(line)
+	if err != nil {
+		t.Fatalf("parseXFSReportLine failed: %v", err)
+	}
+	if pq.BlockSoft != 1024*1024 || pq.InodeUsed != 9 {
+		t.Errorf("Unexpected values with grace period: %+v", pq)
+	}
+}
+
+func TestParseRepquotaLine(t *testing.T) {
+	pq, err := parseRepquotaLine("#1001     --    1024       0    2048              5     0   100")
+	if err != nil {
+		t.Fatalf("parseRepquotaLine failed: %v", err)
+	}
+	if pq.ProjectID != 1001 || pq.BlockUsed != 1024*1024 || pq.BlockHard != 2048*1024 || pq.InodeHard != 100 {
+		t.Errorf("Unexpected values: %+v", pq)
+	}
+
+	pq, err = parseRepquotaLine("#1002     +-    3072    1024    4096  6days      9     0     0")
+	if err != nil {
+		t.Fatalf("parseRepquotaLine failed: %v", err)
+	}
+	if pq.BlockSoft != 1024*1024 || pq.InodeUsed != 9 {
+		t.Errorf("Unexpected values with grace period: %+v", pq)
+	}
+}
+
+func TestParseDirProjectID(t *testing.T) {
+	id, err := parseLsprojOutput("projid = 1234\n")
+	if err != nil || id != 1234 {
+		t.Errorf("parseLsprojOutput() = (%d, %v), want 1234", id, err)
+	}
+	if _, err := parseLsprojOutput("foo: not a project\n"); err == nil {
+		t.Error("Expected error for unexpected lsproj output")
+	}
+
+	id, err = parseLsattrProjectOutput("    5678 --------------e------- /export/pvc-1\n")
+	if err != nil || id != 5678 {
+		t.Errorf("parseLsattrProjectOutput() = (%d, %v), want 5678", id, err)
+	}
+	if _, err := parseLsattrProjectOutput("--------------e------- /export/pvc-1\n"); err == nil {
+		t.Error("Expected error for lsattr output without project id")
+	}
+}
+
+func TestProjectFilesRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	projectsFile := filepath.Join(dir, "projects")
+	projidFile := filepath.Join(dir, "projid")
+
+	entries := []ProjectEntry{
+		{ID: 2002, Name: "pv_b", Path: "/export/b"},
+		{ID: 1001, Name: "pv_a", Path: "/export/a"},
+		{ID: 1001, Name: "pv_a", Path: "/export/a2"},
+		{ID: 3003, Path: "/export/c"},
+	}
+	SortProjectEntries(entries)
+
+	projects, projid := FormatProjectFiles(entries)
+	if string(projects) != "1001:/export/a\n1001:/export/a2\n2002:/export/b\n3003:/export/c\n" {
+		t.Errorf("Unexpected projects file:\n%s", projects)
+	}
+	if string(projid) != "pv_a:1001\npv_b:2002\n" {
+		t.Errorf("Unexpected projid file:\n%s", projid)
+	}
+
+	if err := os.WriteFile(projectsFile, []byte("stale\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileAtomic(projectsFile, projects); err != nil {
+		t.Fatalf("WriteFileAtomic failed: %v", err)
+	}
+	if err := WriteFileAtomic(projidFile, projid); err != nil {
+		t.Fatalf("WriteFileAtomic failed: %v", err)
+	}
+
+	got, err := ReadProjectEntries(projectsFile, projidFile)
+	if err != nil {
+		t.Fatalf("ReadProjectEntries failed: %v", err)
+	}
+	if len(got) != 4 || got[0] != entries[0] || got[3] != entries[3] {
+		t.Errorf("ReadProjectEntries() = %+v, want %+v", got, entries)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	removed, added := DiffLines(
+		[]byte("# comment\n1001:/export/a\n2002:/export/b\n"),
+		[]byte("2002:/export/b\n3003:/export/c\n\n"),
+	)
+	if len(removed) != 1 || removed[0] != "1001:/export/a" {
+		t.Errorf("removed = %v", removed)
+	}
+	if len(added) != 1 || added[0] != "3003:/export/c" {
+		t.Errorf("added = %v", added)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quota
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dasomel/nfs-quota-agent/internal/util"
+)
+
+// ProjectEntry is a project as recorded in the projects and projid files
+type ProjectEntry struct {
+	ID   uint32 `json:"id"`
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// ReadProjectEntries reads the projects and projid files into entries sorted
+// by project ID and path. Entries without a projid line have an empty name.
+func ReadProjectEntries(projectsFile, projidFile string) ([]ProjectEntry, error) {
+	data, err := os.ReadFile(projectsFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	names, err := ReadProjidFile(projidFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ProjectEntry
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		idStr, path, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		id, err := strconv.ParseUint(idStr, 10, 32)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, ProjectEntry{ID: uint32(id), Name: names[idStr], Path: path})
+	}
+
+	SortProjectEntries(entries)
+	return entries, nil
+}
+
+// SortProjectEntries sorts entries by project ID and path
+func SortProjectEntries(entries []ProjectEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].ID != entries[j].ID {
+			return entries[i].ID < entries[j].ID
+		}
+		return entries[i].Path < entries[j].Path
+	})
+}
+
+// FormatProjectFiles renders the contents of the projects ("id:path") and
+// projid ("name:id") files. A project spanning several paths gets a single
+// projid line; entries without a name get no projid line.
+func FormatProjectFiles(entries []ProjectEntry) (projects, projid []byte) {
+	var pb, ib bytes.Buffer
+	named := make(map[uint32]bool)
+	for _, e := range entries {
+		fmt.Fprintf(&pb, "%d:%s\n", e.ID, e.Path)
+		if e.Name != "" && !named[e.ID] {
+			fmt.Fprintf(&ib, "%s:%d\n", e.Name, e.ID)
+			named[e.ID] = true
+		}
+	}
+	return pb.Bytes(), ib.Bytes()
+}
+
+// DiffLines compares two project files line by line, ignoring blank lines,
+// comments and ordering. Both files hold one unique entry per line.
+func DiffLines(oldData, newData []byte) (removed, added []string) {
+	lines := func(data []byte) map[string]bool {
+		set := make(map[string]bool)
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				set[line] = true
+			}
+		}
+		return set
+	}
+
+	oldSet, newSet := lines(oldData), lines(newData)
+	for line := range oldSet {
+		if !newSet[line] {
+			removed = append(removed, line)
+		}
+	}
+	for line := range newSet {
+		if !oldSet[line] {
+			added = append(added, line)
+		}
+	}
+	sort.Strings(removed)
+	sort.Strings(added)
+	return removed, added
+}
+
+// WriteFileAtomic replaces filename with data atomically. Files
+// bind-mounted into a container cannot be renamed over; those are rewritten
+// in place instead.
+func WriteFileAtomic(filename string, data []byte) error {
+	err := util.WriteFileAtomic(filename, data, 0644)
+	var linkErr *os.LinkError
+	if errors.As(err, &linkErr) {
+		slog.Warn("Atomic rename failed, rewriting file in place", "file", filename, "error", err)
+		return os.WriteFile(filename, data, 0644)
+	}
+	return err
+}
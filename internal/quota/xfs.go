@@ -45,8 +45,51 @@ func CheckXFSQuotaAvailable(quotaPath string) error {
 	return nil
 }
 
-// ApplyXFSQuota applies XFS project quota
+// ApplyXFSQuota applies XFS project quota with a block hard limit. Soft
+// and inode limits already set on the project are kept.
 func ApplyXFSQuota(quotaPath, path, projectName string, projectID uint32, sizeBytes int64, projectsFile, projidFile string) error {
+	if err := initXFSProject(quotaPath, path, projectName, projectID, projectsFile, projidFile); err != nil {
+		return err
+	}
+	if err := SetXFSBlockHardLimit(quotaPath, projectID, sizeBytes); err != nil {
+		return err
+	}
+
+	slog.Debug("XFS quota applied",
+		"path", path,
+		"projectName", projectName,
+		"projectID", projectID,
+		"sizeKB", kbLimit(sizeBytes),
+	)
+
+	return nil
+}
+
+// ApplyXFSQuotaLimits applies XFS project quota with block and inode limits
+func ApplyXFSQuotaLimits(quotaPath, path, projectName string, projectID uint32, limits Limits, projectsFile, projidFile string) error {
+	if err := initXFSProject(quotaPath, path, projectName, projectID, projectsFile, projidFile); err != nil {
+		return err
+	}
+	if err := SetXFSProjectLimits(quotaPath, projectID, limits); err != nil {
+		return err
+	}
+
+	slog.Debug("XFS quota applied",
+		"path", path,
+		"projectName", projectName,
+		"projectID", projectID,
+		"sizeKB", kbLimit(limits.BlockHard),
+		"softKB", kbLimit(limits.BlockSoft),
+		"inodeHard", limits.InodeHard,
+		"inodeSoft", limits.InodeSoft,
+	)
+
+	return nil
+}
+
+// initXFSProject records the project in the projects files and tags the
+// directory with its ID
+func initXFSProject(quotaPath, path, projectName string, projectID uint32, projectsFile, projidFile string) error {
 	// 1. Add project to projects file
 	if err := AddProject(path, projectName, projectID, projectsFile, projidFile); err != nil {
 		return fmt.Errorf("failed to add project: %w", err)
@@ -59,27 +102,75 @@ func ApplyXFSQuota(quotaPath, path, projectName string, projectID uint32, sizeBy
 	if output, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("failed to initialize project: %w, output: %s", err, string(output))
 	}
+	return nil
+}
 
-	// 3. Set the quota limit
+// SetXFSProjectLimits sets the limits of an existing XFS project
+func SetXFSProjectLimits(quotaPath string, projectID uint32, limits Limits) error {
 	// Convert bytes to blocks (XFS uses 512-byte blocks for quota, but we'll use 1K blocks)
-	sizeKB := sizeBytes / 1024
-	if sizeKB == 0 {
-		sizeKB = 1
+	return xfsLimit(quotaPath, fmt.Sprintf("limit -p bhard=%dk bsoft=%dk ihard=%d isoft=%d %d",
+		kbLimit(limits.BlockHard), kbLimit(limits.BlockSoft), limits.InodeHard, limits.InodeSoft, projectID))
+}
+
+// SetXFSBlockHardLimit sets only the block hard limit of an XFS project
+func SetXFSBlockHardLimit(quotaPath string, projectID uint32, sizeBytes int64) error {
+	return xfsLimit(quotaPath, fmt.Sprintf("limit -p bhard=%dk %d", kbLimit(sizeBytes), projectID))
+}
+
+func xfsLimit(quotaPath, command string) error {
+	cmd := exec.Command("xfs_quota", "-x", "-c", command, quotaPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to set quota limit: %w, output: %s", err, string(output))
+	}
+	return nil
+}
+
+// GetXFSProjectQuotas reads usage and limits of all XFS projects on quotaPath
+func GetXFSProjectQuotas(quotaPath string) (map[uint32]*ProjectQuota, error) {
+	cmd := exec.Command("xfs_quota", "-x", "-c", "report -p -b -i -n -N", quotaPath)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("xfs_quota failed: %w, output: %s", err, string(output))
 	}
 
-	cmd = exec.Command("xfs_quota", "-x", "-c",
-		fmt.Sprintf("limit -p bhard=%dk %d", sizeKB, projectID),
+	result := make(map[uint32]*ProjectQuota)
+	for _, line := range strings.Split(string(output), "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		pq, err := parseXFSReportLine(line)
+		if err != nil {
+			slog.Debug("Skipping unparsable xfs_quota report line", "line", line, "error", err)
+			continue
+		}
+		result[pq.ProjectID] = pq
+	}
+
+	return result, nil
+}
+
+// RemoveXFSProjectQuota clears the limits of an XFS project and the project ID of its directory
+func RemoveXFSProjectQuota(quotaPath, path string, projectID uint32) error {
+	cmd := exec.Command("xfs_quota", "-x", "-c",
+		fmt.Sprintf("limit -p bhard=0 bsoft=0 ihard=0 isoft=0 %d", projectID),
 		quotaPath)
 	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to set quota limit: %w, output: %s", err, string(output))
+		return fmt.Errorf("failed to clear quota limit: %w, output: %s", err, string(output))
 	}
 
-	slog.Debug("XFS quota applied",
-		"path", path,
-		"projectName", projectName,
-		"projectID", projectID,
-		"sizeKB", sizeKB,
-	)
+	if path != "" {
+		return clearXFSProjectDir(quotaPath, path, projectID)
+	}
+	return nil
+}
 
+// clearXFSProjectDir detaches a directory tree from its project
+func clearXFSProjectDir(quotaPath, path string, projectID uint32) error {
+	cmd := exec.Command("xfs_quota", "-x", "-c",
+		fmt.Sprintf("project -C -p %s %d", path, projectID),
+		quotaPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to clear project: %w, output: %s", err, string(output))
+	}
 	return nil
 }
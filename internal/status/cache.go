@@ -0,0 +1,110 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package status
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dasomel/nfs-quota-agent/internal/quota"
+)
+
+// UsageMaxAge is how long the metrics endpoint and web UI reuse a scan
+const UsageMaxAge = 30 * time.Second
+
+// UsageWalkMaxAge is how long scans without walking carry forward the walked
+// size of directories missing from the quota report before walking again
+const UsageWalkMaxAge = 30 * time.Minute
+
+// UsageCache shares directory usage scans between the history collector,
+// the metrics endpoint and the web UI, so that the quota report is read
+// and directories are walked once per window instead of once per caller
+type UsageCache struct {
+	basePath string
+
+	mu     sync.Mutex // held while scanning, so concurrent callers share a scan
+	report *scan      // last scan, walked or not
+	walked *scan      // last scan that walked directories missing from the report
+}
+
+type scan struct {
+	usages []DirUsage
+	stats  ScanStats
+}
+
+// NewUsageCache returns a cache of the directory usages under basePath
+func NewUsageCache(basePath string) *UsageCache {
+	return &UsageCache{basePath: basePath}
+}
+
+// Get returns the directory usages, reusing a scan started less than maxAge
+// ago. With walk, directories missing from the quota report are sized by
+// walking them. Without it they keep the size of the last walk, and are
+// walked again once that is UsageWalkMaxAge old, so they are recorded in
+// every scan rather than only when someone else walked them; a walked scan
+// that is still fresh is preferred. The returned stats are those of the
+// scan used.
+func (c *UsageCache) Get(maxAge time.Duration, walk bool) ([]DirUsage, ScanStats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	fresh := func(s *scan, maxAge time.Duration) bool {
+		return s != nil && now.Sub(s.stats.Time) < maxAge
+	}
+	if fresh(c.walked, maxAge) {
+		return c.walked.copy()
+	}
+	if !walk && fresh(c.report, maxAge) {
+		return c.report.copy()
+	}
+
+	var sizes map[string]uint64
+	if !walk {
+		if fresh(c.walked, UsageWalkMaxAge) {
+			sizes = c.walked.sizes()
+		} else {
+			walk = true
+		}
+	}
+
+	fsType, _ := quota.DetectFSType(c.basePath)
+	usages, stats, err := scanDirUsages(c.basePath, fsType, walk, sizes)
+	if err != nil {
+		return nil, stats, err
+	}
+	s := &scan{usages: usages, stats: stats}
+	if walk {
+		c.walked = s
+	}
+	c.report = s
+	return s.copy()
+}
+
+// sizes maps each directory of the scan to its usage
+func (s *scan) sizes() map[string]uint64 {
+	sizes := make(map[string]uint64, len(s.usages))
+	for _, u := range s.usages {
+		sizes[u.Path] = u.Used
+	}
+	return sizes
+}
+
+// copy returns the scan's usages in a slice the caller may sort
+func (s *scan) copy() ([]DirUsage, ScanStats, error) {
+	return append([]DirUsage(nil), s.usages...), s.stats, nil
+}
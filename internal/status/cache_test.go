@@ -0,0 +1,74 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package status
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUsageCache(t *testing.T) {
+	base := t.TempDir()
+	if err := os.Mkdir(filepath.Join(base, "pvc-a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	c := NewUsageCache(base)
+
+	// Nothing was walked yet, so even a caller without walk gets a walk
+	usages, first, _ := c.Get(time.Minute, false)
+	if !first.Walk || len(usages) != 1 {
+		t.Fatalf("Expected a first walked scan of 1 dir, got %d %+v", len(usages), first)
+	}
+	if _, again, _ := c.Get(time.Minute, false); !again.Time.Equal(first.Time) {
+		t.Errorf("Expected the fresh scan to be reused")
+	}
+
+	// Callers may sort or modify what they get
+	usages[0].Used = 42
+	if again, _, _ := c.Get(time.Minute, true); again[0].Used == 42 {
+		t.Errorf("Expected the cached usages to be unaffected by callers")
+	}
+
+	// Once stale, scans without walk carry the walked size forward
+	if err := os.WriteFile(filepath.Join(base, "pvc-a", "data"), make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	carried, stats, _ := c.Get(0, false)
+	if stats.Walk || stats.Time.Equal(first.Time) || stats.Carried != 1 || stats.Skipped != 0 {
+		t.Fatalf("Expected a new scan carrying 1 dir forward, got %+v", stats)
+	}
+	if len(carried) != 1 || carried[0].Used != 0 || !carried[0].Carried {
+		t.Errorf("Expected the size of the last walk, got %+v", carried)
+	}
+
+	// A walking caller sizes the directory again
+	walked, stats, _ := c.Get(0, true)
+	if !stats.Walk || stats.Walked != 1 || len(walked) != 1 || walked[0].Used != 100 {
+		t.Fatalf("Expected a walked scan of 100 bytes, got %+v %+v", walked, stats)
+	}
+	if _, reused, _ := c.Get(time.Minute, false); !reused.Time.Equal(stats.Time) {
+		t.Errorf("Expected the walked scan to be reused without walking")
+	}
+
+	// And so does a caller without walk once the last walk is too old
+	c.walked.stats.Time = time.Now().Add(-UsageWalkMaxAge)
+	if _, stale, _ := c.Get(0, false); !stale.Walk || stale.Walked != 1 {
+		t.Errorf("Expected a walk once the last one is %v old, got %+v", UsageWalkMaxAge, stale)
+	}
+}
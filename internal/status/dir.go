@@ -20,12 +20,47 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/dasomel/nfs-quota-agent/internal/quota"
 )
 
+// ScanStats describes one directory usage scan
+type ScanStats struct {
+	Time       time.Time     `json:"time"`
+	Duration   time.Duration `json:"duration"`
+	Dirs       int           `json:"dirs"`       // directories returned
+	FromReport int           `json:"fromReport"` // usage taken from the quota report
+	Walked     int           `json:"walked"`     // usage computed by walking the directory
+	Carried    int           `json:"carried"`    // usage carried forward from an earlier walk
+	Skipped    int           `json:"skipped"`    // not in the report, not walked and never walked before
+	Walk       bool          `json:"walk"`
+
+	// ReportFailed is set when the quota report could not be read, so
+	// every directory had to be walked or skipped
+	ReportFailed bool `json:"reportFailed,omitempty"`
+}
+
 // GetDirUsages returns usage information for all directories with quotas
 func GetDirUsages(basePath, fsType string) ([]DirUsage, error) {
+	usages, _, err := ScanDirUsages(basePath, fsType, true)
+	return usages, err
+}
+
+// ScanDirUsages reads the quota report once and returns the usage of every
+// directory in it. Directories found under basePath but missing from the
+// report are walked to size them when walk is set, and left out otherwise,
+// so a scan without walk costs one quota report read and a listing of the
+// top two directory levels.
+func ScanDirUsages(basePath, fsType string, walk bool) ([]DirUsage, ScanStats, error) {
+	return scanDirUsages(basePath, fsType, walk, nil)
+}
+
+// scanDirUsages is ScanDirUsages where, without walk, directories missing
+// from the report take their size from walked (an earlier walk) instead of
+// being left out
+func scanDirUsages(basePath, fsType string, walk bool, walked map[string]uint64) ([]DirUsage, ScanStats, error) {
+	stats := ScanStats{Time: time.Now(), Walk: walk}
 	var usages []DirUsage
 
 	// Get quota report based on filesystem type
@@ -41,6 +76,7 @@ func GetDirUsages(basePath, fsType string) ([]DirUsage, error) {
 	}
 	if err != nil {
 		// Continue without quota info
+		stats.ReportFailed = true
 		quotaMap = make(map[string]uint64)
 		usageMap = make(map[string]uint64)
 	}
@@ -50,12 +86,15 @@ func GetDirUsages(basePath, fsType string) ([]DirUsage, error) {
 	for path := range quotaMap {
 		quotaDirs[path] = true
 	}
+	for path := range usageMap {
+		quotaDirs[path] = true
+	}
 
 	// Also scan directories up to 2 levels deep to find all potential PVC dirs
 	// This handles both flat (pvc-xxx) and nested (namespace/pvc-name) patterns
 	entries, err := os.ReadDir(basePath)
 	if err != nil {
-		return nil, err
+		return nil, stats, err
 	}
 
 	for _, entry := range entries {
@@ -98,17 +137,28 @@ func GetDirUsages(basePath, fsType string) ([]DirUsage, error) {
 			continue
 		}
 
-		// Get directory size
+		// Project-quota'd directories are never walked
 		var used uint64
+		carried := false
 		if u, ok := usageMap[dirPath]; ok {
 			used = u
-		} else {
+			stats.FromReport++
+		} else if walk {
 			used = GetDirSize(dirPath)
+			stats.Walked++
+		} else if u, ok := walked[dirPath]; ok {
+			used = u
+			carried = true
+			stats.Carried++
+		} else {
+			stats.Skipped++
+			continue
 		}
 
 		du := DirUsage{
-			Path: dirPath,
-			Used: used,
+			Path:    dirPath,
+			Used:    used,
+			Carried: carried,
 		}
 
 		// Get quota if available
@@ -122,7 +172,9 @@ func GetDirUsages(basePath, fsType string) ([]DirUsage, error) {
 		usages = append(usages, du)
 	}
 
-	return usages, nil
+	stats.Dirs = len(usages)
+	stats.Duration = time.Since(stats.Time)
+	return usages, stats, nil
 }
 
 // GetDirSize calculates directory size recursively
@@ -0,0 +1,64 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package status
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScanDirUsages(t *testing.T) {
+	base := t.TempDir()
+	for _, dir := range []string{"pvc-a", "team/pvc-b"} {
+		if err := os.MkdirAll(filepath.Join(base, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(base, dir, "data"), make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Without a quota report (unknown filesystem) every directory is
+	// walked, carried forward from an earlier walk, or skipped
+	tests := []struct {
+		walk                  bool
+		dirs, walked, skipped int
+		bytes                 uint64
+		before                map[string]uint64
+	}{
+		{true, 2, 2, 0, 200, nil},
+		{false, 0, 0, 2, 0, nil},
+		// Directories walked before keep that size
+		{false, 1, 0, 1, 50, map[string]uint64{filepath.Join(base, "pvc-a"): 50}},
+	}
+	for _, tt := range tests {
+		usages, stats, err := scanDirUsages(base, "", tt.walk, tt.before)
+		if err != nil {
+			t.Fatalf("ScanDirUsages(walk=%v) failed: %v", tt.walk, err)
+		}
+		var sum uint64
+		for _, u := range usages {
+			sum += u.Used
+		}
+		if len(usages) != tt.dirs || stats.Dirs != tt.dirs || stats.Walked != tt.walked ||
+			stats.Skipped != tt.skipped || sum != tt.bytes {
+			t.Errorf("walk=%v: expected %d dirs (%d walked, %d skipped, %d bytes), got %d %+v (%d bytes)",
+				tt.walk, tt.dirs, tt.walked, tt.skipped, tt.bytes, len(usages), stats, sum)
+		}
+	}
+}
@@ -32,4 +32,8 @@ type DirUsage struct {
 	UsedPct   float64
 	QuotaPct  float64 // percentage of quota used
 	ProjectID uint32
+
+	// Carried is set when Used is the size of an earlier walk carried
+	// forward, not a measurement taken by this scan
+	Carried bool
 }
@@ -0,0 +1,69 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dasomel/nfs-quota-agent/internal/archive"
+	"github.com/dasomel/nfs-quota-agent/internal/audit"
+)
+
+// ArchiveOptions configures archiving of trashed directories before purge
+type ArchiveOptions struct {
+	Dir    string         `json:"dir,omitempty"` // empty disables archiving
+	Format archive.Format `json:"format,omitempty"`
+}
+
+// Enabled reports whether trashed directories are archived before purge
+func (o ArchiveOptions) Enabled() bool { return o.Dir != "" }
+
+// PurgeEntry permanently deletes a trashed directory. If archiving is
+// enabled, the data is first streamed into a verified archive and the
+// entry is kept in the trash when archiving fails. A purge interrupted by
+// ctx is left for the next call to finish without archiving again. The
+// archive and the completed or failed purge are audited if logger is set.
+func (s *Store) PurgeEntry(ctx context.Context, e Entry, arch ArchiveOptions, logger *audit.Logger) (*archive.Result, error) {
+	if e.Purging == nil && arch.Enabled() {
+		res, err := archive.Create(e.TrashPath, arch.Dir, e.ID, arch.Format)
+		if logger != nil {
+			if err != nil {
+				logger.LogArchive(e.OriginalPath, e.ProjectName, e.ProjectID, "", 0, "", err)
+			} else {
+				logger.LogArchive(e.OriginalPath, e.ProjectName, e.ProjectID, res.Path, res.Size, res.Checksum, nil)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("not purging %s: %w", e.ID, err)
+		}
+		e.Archive = res
+	}
+
+	err := s.purge(ctx, &e)
+	if logger != nil && !Interrupted(err) {
+		logger.LogTrash(audit.ActionPurge, e.OriginalPath, e.ProjectName, e.ProjectID, e.TrashPath, err)
+	}
+	return e.Archive, err
+}
+
+// Interrupted reports whether a purge error means the purge was stopped
+// by its context and can be resumed
+func Interrupted(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
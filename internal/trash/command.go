@@ -0,0 +1,219 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trash
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dasomel/nfs-quota-agent/internal/audit"
+	"github.com/dasomel/nfs-quota-agent/internal/deleter"
+	"github.com/dasomel/nfs-quota-agent/internal/util"
+)
+
+// Options configures the trash command
+type Options struct {
+	BasePath     string
+	TrashDir     string
+	Retention    time.Duration
+	AuditLogPath string
+	Output       string // "table" or "json"
+	Yes          bool
+	Archive      ArchiveOptions
+	Limits       deleter.Limits
+}
+
+func (opts Options) store() *Store {
+	dir := opts.TrashDir
+	if dir == "" {
+		dir = DefaultDir(opts.BasePath)
+	}
+	store := NewStore(dir, opts.Retention)
+	store.SetDeleter(deleter.New(opts.Limits))
+	return store
+}
+
+// List prints the quarantined directories
+func List(opts Options) error {
+	entries, err := opts.store().List()
+	if err != nil {
+		return err
+	}
+	if entries == nil {
+		entries = []Entry{}
+	}
+
+	if opts.Output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("Trash is empty.")
+		return nil
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tORIGINAL PATH\tSIZE\tTRASHED\tPURGE")
+	for _, e := range entries {
+		purge := e.ExpiresAt.Format("2006-01-02 15:04")
+		switch {
+		case e.Purging != nil:
+			purge = "interrupted (" + util.FormatBytes(e.Purging.Bytes) + " deleted)"
+		case !now.Before(e.ExpiresAt):
+			purge = "expired"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			e.ID, e.OriginalPath, e.SizeStr, e.TrashedAt.Local().Format("2006-01-02 15:04"), purge)
+	}
+	return w.Flush()
+}
+
+// Restore moves a quarantined directory back to target, or to its
+// original path if target is empty
+func Restore(opts Options, id, target string) error {
+	store := opts.store()
+	trashed, err := store.Get(id)
+	if err != nil {
+		return err
+	}
+
+	entry, err := store.Restore(id, target)
+	if logger := audit.Open(opts.AuditLogPath); logger != nil {
+		path := trashed.OriginalPath
+		if entry != nil {
+			path = entry.OriginalPath
+		}
+		logger.LogTrash(audit.ActionRestore, path, trashed.ProjectName, trashed.ProjectID, trashed.TrashPath, err)
+		logger.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	if opts.Output == "json" {
+		return json.NewEncoder(os.Stdout).Encode(entry)
+	}
+	fmt.Printf("Restored %s to %s\n", id, entry.OriginalPath)
+	return nil
+}
+
+// Purge permanently deletes quarantined directories: the entry with the
+// given ID, every entry if all is set, or otherwise the expired ones and
+// those whose purge was interrupted. Cancelling ctx stops the purge after
+// the current file; running it again resumes.
+func Purge(ctx context.Context, opts Options, id string, all bool) error {
+	store := opts.store()
+
+	var targets []Entry
+	switch {
+	case id != "":
+		e, err := store.Get(id)
+		if err != nil {
+			return err
+		}
+		targets = []Entry{*e}
+	case all:
+		entries, err := store.List()
+		if err != nil {
+			return err
+		}
+		targets = entries
+	default:
+		expired, err := store.Expired(time.Now())
+		if err != nil {
+			return err
+		}
+		targets = expired
+	}
+
+	if len(targets) == 0 {
+		if opts.Output == "json" {
+			return json.NewEncoder(os.Stdout).Encode([]Entry{})
+		}
+		fmt.Println("Nothing to purge.")
+		return nil
+	}
+
+	// Purging before retention ends cannot be undone, so ask first
+	if (id != "" || all) && !opts.Yes {
+		if opts.Output == "json" {
+			return fmt.Errorf("refusing to purge before retention without --yes")
+		}
+		noun := "directories"
+		if len(targets) == 1 {
+			noun = "directory"
+		}
+		fmt.Printf("Permanently delete %d trashed %s? [y/N]: ", len(targets), noun)
+		reader := bufio.NewReader(os.Stdin)
+		answer, _ := reader.ReadString('\n')
+		answer = strings.TrimSpace(strings.ToLower(answer))
+		if answer != "y" && answer != "yes" {
+			fmt.Println("Purge cancelled.")
+			return nil
+		}
+	}
+
+	logger := audit.Open(opts.AuditLogPath)
+	if logger != nil {
+		defer logger.Close()
+	}
+
+	var purged []Entry
+	var failed int
+	for _, e := range targets {
+		res, err := store.PurgeEntry(ctx, e, opts.Archive, logger)
+		if Interrupted(err) {
+			fmt.Fprintf(os.Stderr, "  [INTERRUPTED] %s: run purge again to resume\n", e.ID)
+			return err
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "  [ERROR] %v\n", err)
+			failed++
+			continue
+		}
+		e.Archive = res
+		purged = append(purged, e)
+		if opts.Output != "json" {
+			if res != nil {
+				fmt.Printf("  [OK] Archived %s to %s (%s)\n", e.ID, res.Path, res.Checksum)
+			}
+			fmt.Printf("  [OK] Purged %s (%s, %s)\n", e.ID, e.OriginalPath, e.SizeStr)
+		}
+	}
+
+	if opts.Output == "json" {
+		if purged == nil {
+			purged = []Entry{}
+		}
+		if err := json.NewEncoder(os.Stdout).Encode(purged); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to purge %d of %d entries", failed, len(targets))
+	}
+	return nil
+}
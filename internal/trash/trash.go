@@ -0,0 +1,359 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trash
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dasomel/nfs-quota-agent/internal/archive"
+	"github.com/dasomel/nfs-quota-agent/internal/deleter"
+	"github.com/dasomel/nfs-quota-agent/internal/util"
+)
+
+const (
+	// DirName is the default trash directory name under the NFS base path.
+	// It starts with a dot so orphan detection and status skip it.
+	DirName = ".nfs-quota-trash"
+
+	// DefaultRetention is how long trashed directories are kept
+	DefaultRetention = 7 * 24 * time.Hour
+
+	metaFile  = "meta.json"
+	purgeFile = "purge.json"
+	dataDir   = "data"
+	dayDir    = "2006-01-02"
+	idTime    = "20060102T150405Z"
+)
+
+// Entry describes a directory held in the trash
+type Entry struct {
+	ID           string    `json:"id"`
+	OriginalPath string    `json:"originalPath"`
+	TrashPath    string    `json:"trashPath"`
+	TrashedAt    time.Time `json:"trashedAt"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	Size         uint64    `json:"size"`
+	SizeStr      string    `json:"sizeStr"`
+	ProjectID    uint32    `json:"projectId,omitempty"`
+	ProjectName  string    `json:"projectName,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+
+	// Archive is set on purged entries that were archived first
+	Archive *archive.Result `json:"archive,omitempty"`
+
+	// Purging is set while the entry is being deleted, and stays set if
+	// the purge was interrupted until it is resumed
+	Purging *deleter.Progress `json:"purging,omitempty"`
+}
+
+// purgeState is the on-disk checkpoint of an interrupted purge
+type purgeState struct {
+	Progress deleter.Progress `json:"progress"`
+	Archive  *archive.Result  `json:"archive,omitempty"`
+}
+
+// Store keeps quarantined directories under a dated trash directory:
+// <dir>/<YYYY-MM-DD>/<id>/{data,meta.json}
+type Store struct {
+	dir       string
+	retention time.Duration
+	deleter   *deleter.Deleter
+}
+
+// DefaultDir returns the default trash directory for an NFS base path
+func DefaultDir(basePath string) string {
+	return filepath.Join(basePath, DirName)
+}
+
+// NewStore creates a trash store that purges without throttling
+func NewStore(dir string, retention time.Duration) *Store {
+	return &Store{dir: dir, retention: retention, deleter: deleter.New(deleter.Limits{})}
+}
+
+// SetDeleter sets the deletion engine used to purge entries
+func (s *Store) SetDeleter(d *deleter.Deleter) { s.deleter = d }
+
+// Dir returns the trash directory
+func (s *Store) Dir() string { return s.dir }
+
+// Retention returns how long trashed directories are kept
+func (s *Store) Retention() time.Duration { return s.retention }
+
+// Move renames path into the trash. The trash must be on the same
+// filesystem as path; nothing is copied.
+func (s *Store) Move(path string, e Entry) (*Entry, error) {
+	path = filepath.Clean(path)
+	if rel, err := filepath.Rel(s.dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return nil, fmt.Errorf("%s is inside the trash directory", path)
+	}
+	if info, err := os.Stat(path); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", path)
+	}
+
+	now := time.Now().UTC()
+	day := filepath.Join(s.dir, now.Format(dayDir))
+	if err := os.MkdirAll(day, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create trash directory: %w", err)
+	}
+
+	base := now.Format(idTime) + "-" + filepath.Base(path)
+	id := base
+	entryDir := filepath.Join(day, id)
+	for i := 1; ; i++ {
+		err := os.Mkdir(entryDir, 0700)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create trash entry: %w", err)
+		}
+		id = fmt.Sprintf("%s-%d", base, i)
+		entryDir = filepath.Join(day, id)
+	}
+
+	e.ID = id
+	e.OriginalPath = path
+	e.TrashPath = filepath.Join(entryDir, dataDir)
+	e.TrashedAt = now
+	e.SizeStr = util.FormatBytes(int64(e.Size))
+
+	if err := writeMeta(entryDir, e); err != nil {
+		_ = os.RemoveAll(entryDir)
+		return nil, err
+	}
+
+	if err := os.Rename(path, e.TrashPath); err != nil {
+		_ = os.RemoveAll(entryDir)
+		if errors.Is(err, syscall.EXDEV) {
+			return nil, fmt.Errorf("trash directory %s is not on the same filesystem as %s", s.dir, path)
+		}
+		return nil, fmt.Errorf("failed to move %s to trash: %w", path, err)
+	}
+
+	e.ExpiresAt = e.TrashedAt.Add(s.retention)
+	return &e, nil
+}
+
+// List returns all trashed directories, newest first
+func (s *Store) List() ([]Entry, error) {
+	days, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read trash directory: %w", err)
+	}
+
+	var entries []Entry
+	for _, day := range days {
+		if !day.IsDir() {
+			continue
+		}
+		dayPath := filepath.Join(s.dir, day.Name())
+		items, err := os.ReadDir(dayPath)
+		if err != nil {
+			continue
+		}
+		for _, item := range items {
+			if !item.IsDir() {
+				continue
+			}
+			e, err := readMeta(filepath.Join(dayPath, item.Name()))
+			if err != nil {
+				continue
+			}
+			e.ExpiresAt = e.TrashedAt.Add(s.retention)
+			entries = append(entries, *e)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].TrashedAt.After(entries[j].TrashedAt)
+	})
+	return entries, nil
+}
+
+// Get returns a trashed directory by ID
+func (s *Store) Get(id string) (*Entry, error) {
+	entries, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.ID == id {
+			return &e, nil
+		}
+	}
+	return nil, fmt.Errorf("trash entry %q not found", id)
+}
+
+// Expired returns trashed directories whose retention has passed, with
+// interrupted purges first so that they are finished before new ones start
+func (s *Store) Expired(now time.Time) ([]Entry, error) {
+	entries, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var interrupted, expired []Entry
+	for _, e := range entries {
+		switch {
+		case e.Purging != nil:
+			interrupted = append(interrupted, e)
+		case !now.Before(e.ExpiresAt):
+			expired = append(expired, e)
+		}
+	}
+	return append(interrupted, expired...), nil
+}
+
+// Restore moves a trashed directory back to target, or to its original
+// path if target is empty. The target must not exist.
+func (s *Store) Restore(id, target string) (*Entry, error) {
+	e, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if e.Purging != nil {
+		return nil, fmt.Errorf("%s is partially purged and cannot be restored; purge it again to finish", id)
+	}
+
+	if target == "" {
+		target = e.OriginalPath
+	}
+	target = filepath.Clean(target)
+
+	if _, err := os.Stat(target); err == nil {
+		return nil, fmt.Errorf("restore target %s already exists", target)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create parent of %s: %w", target, err)
+	}
+	if err := os.Rename(e.TrashPath, target); err != nil {
+		return nil, fmt.Errorf("failed to restore %s: %w", target, err)
+	}
+
+	s.removeEntryDir(filepath.Dir(e.TrashPath))
+
+	e.OriginalPath = target
+	return e, nil
+}
+
+// Purge permanently deletes a trashed directory. The deletion is
+// checkpointed in the entry, so a purge interrupted by ctx or a restart
+// continues where it stopped when purged again.
+func (s *Store) Purge(ctx context.Context, id string) (*Entry, error) {
+	e, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return e, s.purge(ctx, e)
+}
+
+func (s *Store) purge(ctx context.Context, e *Entry) error {
+	entryDir := filepath.Dir(e.TrashPath)
+
+	state := purgeState{Archive: e.Archive}
+	if e.Purging != nil {
+		state.Progress = *e.Purging
+	} else {
+		state.Progress = deleter.Progress{Started: time.Now(), TotalBytes: int64(e.Size)}
+		// Mark the entry before deleting anything so a crash cannot leave
+		// a half-deleted entry that looks restorable
+		if err := writePurgeState(entryDir, state); err != nil {
+			return err
+		}
+	}
+
+	progress, err := s.deleter.RemoveAll(ctx, e.TrashPath, state.Progress, func(p deleter.Progress) {
+		state.Progress = p
+		_ = writePurgeState(entryDir, state)
+	})
+	e.Purging = &progress
+	if err != nil {
+		state.Progress = progress
+		_ = writePurgeState(entryDir, state)
+		return fmt.Errorf("failed to purge %s: %w", e.ID, err)
+	}
+
+	s.removeEntryDir(entryDir)
+	return nil
+}
+
+// removeEntryDir removes an entry directory and its day directory if empty
+func (s *Store) removeEntryDir(entryDir string) {
+	_ = os.RemoveAll(entryDir)
+	_ = os.Remove(filepath.Dir(entryDir))
+}
+
+func writeMeta(entryDir string, e Entry) error {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode trash metadata: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(entryDir, metaFile), data, 0600); err != nil {
+		return fmt.Errorf("failed to write trash metadata: %w", err)
+	}
+	return nil
+}
+
+func readMeta(entryDir string) (*Entry, error) {
+	data, err := os.ReadFile(filepath.Join(entryDir, metaFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var e Entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+
+	// Follow the entry if the trash directory was moved
+	e.TrashPath = filepath.Join(entryDir, dataDir)
+
+	if data, err := os.ReadFile(filepath.Join(entryDir, purgeFile)); err == nil {
+		var state purgeState
+		if err := json.Unmarshal(data, &state); err == nil {
+			e.Purging = &state.Progress
+			e.Archive = state.Archive
+		}
+	}
+	return &e, nil
+}
+
+// writePurgeState checkpoints a purge atomically
+func writePurgeState(entryDir string, state purgeState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode purge state: %w", err)
+	}
+	if err := util.WriteFileAtomic(filepath.Join(entryDir, purgeFile), data, 0600); err != nil {
+		return fmt.Errorf("failed to write purge state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trash
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dasomel/nfs-quota-agent/internal/archive"
+)
+
+func TestMoveRestore(t *testing.T) {
+	base := t.TempDir()
+	store := NewStore(DefaultDir(base), time.Hour)
+
+	orphan := filepath.Join(base, "pvc-abc")
+	if err := os.MkdirAll(orphan, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(orphan, "data.txt"), []byte("keep me"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := store.Move(orphan, Entry{Size: 7, ProjectID: 42, ProjectName: "pv_abc"})
+	if err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("original path still exists after Move()")
+	}
+	if _, err := os.Stat(filepath.Join(entry.TrashPath, "data.txt")); err != nil {
+		t.Errorf("trashed data missing: %v", err)
+	}
+	if !entry.ExpiresAt.Equal(entry.TrashedAt.Add(time.Hour)) {
+		t.Errorf("ExpiresAt = %v, want TrashedAt + retention", entry.ExpiresAt)
+	}
+
+	entries, err := store.List()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("List() = %v, %v; want 1 entry", entries, err)
+	}
+	if got := entries[0]; got.ID != entry.ID || got.OriginalPath != orphan || got.ProjectID != 42 {
+		t.Errorf("List()[0] = %+v, want entry for %s", got, orphan)
+	}
+
+	// Restoring over an existing directory must fail
+	if err := os.MkdirAll(orphan, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Restore(entry.ID, ""); err == nil {
+		t.Error("Restore() over existing directory succeeded")
+	}
+	if err := os.Remove(orphan); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Restore(entry.ID, ""); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(orphan, "data.txt"))
+	if err != nil || string(data) != "keep me" {
+		t.Errorf("restored data = %q, %v", data, err)
+	}
+	if entries, _ := store.List(); len(entries) != 0 {
+		t.Errorf("List() after Restore() = %d entries, want 0", len(entries))
+	}
+}
+
+func TestMoveRejectsTrashDir(t *testing.T) {
+	base := t.TempDir()
+	store := NewStore(DefaultDir(base), time.Hour)
+
+	inside := filepath.Join(store.Dir(), "x")
+	if err := os.MkdirAll(inside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Move(inside, Entry{}); err == nil {
+		t.Error("Move() of a path inside the trash succeeded")
+	}
+}
+
+func TestExpiredPurge(t *testing.T) {
+	base := t.TempDir()
+	store := NewStore(DefaultDir(base), time.Hour)
+
+	for _, name := range []string{"a", "b"} {
+		dir := filepath.Join(base, name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Move(dir, Entry{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if expired, _ := store.Expired(time.Now()); len(expired) != 0 {
+		t.Errorf("Expired(now) = %d entries, want 0", len(expired))
+	}
+
+	expired, err := store.Expired(time.Now().Add(2 * time.Hour))
+	if err != nil || len(expired) != 2 {
+		t.Fatalf("Expired(+2h) = %v, %v; want 2 entries", expired, err)
+	}
+
+	for _, e := range expired {
+		if _, err := store.Purge(context.Background(), e.ID); err != nil {
+			t.Errorf("Purge(%s) error = %v", e.ID, err)
+		}
+	}
+	if entries, _ := store.List(); len(entries) != 0 {
+		t.Errorf("List() after Purge() = %d entries, want 0", len(entries))
+	}
+	if days, _ := os.ReadDir(store.Dir()); len(days) != 0 {
+		t.Errorf("empty day directories left behind: %d", len(days))
+	}
+}
+
+func TestPurgeEntryArchive(t *testing.T) {
+	base := t.TempDir()
+	store := NewStore(DefaultDir(base), time.Hour)
+
+	dir := filepath.Join(base, "pvc-abc")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "data.txt"), []byte("keep me"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := store.Move(dir, Entry{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An unusable archive directory must keep the entry in the trash
+	blocker := filepath.Join(base, "not-a-dir")
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	bad := ArchiveOptions{Dir: filepath.Join(blocker, "archives"), Format: archive.FormatGzip}
+	if _, err := store.PurgeEntry(context.Background(), *entry, bad, nil); err == nil {
+		t.Fatal("PurgeEntry() with unusable archive dir succeeded")
+	}
+	if _, err := store.Get(entry.ID); err != nil {
+		t.Fatalf("entry purged despite failed archive: %v", err)
+	}
+
+	good := ArchiveOptions{Dir: filepath.Join(base, "archives"), Format: archive.FormatGzip}
+	res, err := store.PurgeEntry(context.Background(), *entry, good, nil)
+	if err != nil {
+		t.Fatalf("PurgeEntry() error = %v", err)
+	}
+	if res == nil || res.Bytes != 7 {
+		t.Fatalf("PurgeEntry() archive = %+v, want 7 bytes archived", res)
+	}
+	if _, err := os.Stat(res.Path); err != nil {
+		t.Errorf("archive missing: %v", err)
+	}
+	if _, err := store.Get(entry.ID); err == nil {
+		t.Error("entry still in trash after PurgeEntry()")
+	}
+}
+
+func TestPurgeResume(t *testing.T) {
+	base := t.TempDir()
+	store := NewStore(DefaultDir(base), 24*time.Hour)
+
+	dir := filepath.Join(base, "pvc-big")
+	for _, name := range []string{"a/1", "a/2", "b/3"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entry, err := store.Move(dir, Entry{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A purge stopped by its context stays in the trash, marked as purging
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := store.PurgeEntry(ctx, *entry, ArchiveOptions{}, nil); !Interrupted(err) {
+		t.Fatalf("PurgeEntry(cancelled) error = %v, want interrupted", err)
+	}
+	interrupted, err := store.Get(entry.ID)
+	if err != nil {
+		t.Fatalf("interrupted entry missing: %v", err)
+	}
+	if interrupted.Purging == nil {
+		t.Fatal("interrupted entry is not marked as purging")
+	}
+	if _, err := store.Restore(entry.ID, ""); err == nil {
+		t.Error("Restore() of a partially purged entry succeeded")
+	}
+
+	// Interrupted purges are due regardless of retention
+	expired, err := store.Expired(time.Now())
+	if err != nil || len(expired) != 1 {
+		t.Fatalf("Expired(now) = %v, %v; want the interrupted entry", expired, err)
+	}
+
+	if _, err := store.PurgeEntry(context.Background(), expired[0], ArchiveOptions{}, nil); err != nil {
+		t.Fatalf("resumed PurgeEntry() error = %v", err)
+	}
+	if _, err := store.Get(entry.ID); err == nil {
+		t.Error("entry still in trash after resumed purge")
+	}
+}
@@ -34,10 +34,13 @@ import (
 	"k8s.io/client-go/kubernetes"
 
 	"github.com/dasomel/nfs-quota-agent/internal/audit"
+	"github.com/dasomel/nfs-quota-agent/internal/chargeback"
+	"github.com/dasomel/nfs-quota-agent/internal/deleter"
 	"github.com/dasomel/nfs-quota-agent/internal/history"
 	"github.com/dasomel/nfs-quota-agent/internal/policy"
 	"github.com/dasomel/nfs-quota-agent/internal/quota"
 	"github.com/dasomel/nfs-quota-agent/internal/status"
+	"github.com/dasomel/nfs-quota-agent/internal/trash"
 	"github.com/dasomel/nfs-quota-agent/internal/util"
 )
 
@@ -51,28 +54,117 @@ type AgentInterface interface {
 	GetOrphans(ctx context.Context) []OrphanInfo
 	RemoveOrphan(orphan OrphanInfo) error
 	AuditLogger() *audit.Logger
+	FreezeStatus() FreezeInfo
+	Freeze(source, reason string)
+	Unfreeze(source string)
+	TrashEntries() ([]trash.Entry, error)
+	TrashRetention() time.Duration
+	TrashArchive() trash.ArchiveOptions
+	CleanupSafety() CleanupSafetyInfo
+	OrphanTracker() OrphanTrackerInfo
+	CleanupSchedule() CleanupScheduleInfo
+	RestoreTrash(id string) (*trash.Entry, error)
+	PurgeStatus() deleter.Status
+}
+
+// Freeze sources
+const (
+	FreezeSourceAPI       = "api"
+	FreezeSourceConfigMap = "configmap"
+	FreezeSourceSignal    = "signal"
+)
+
+// FreezeInfo describes the agent's maintenance freeze state. The agent stays
+// frozen until every source in Holds has released its freeze.
+type FreezeInfo struct {
+	Frozen       bool         `json:"frozen"`
+	Reason       string       `json:"reason,omitempty"`
+	Source       string       `json:"source,omitempty"`
+	Since        *time.Time   `json:"since,omitempty"`
+	Holds        []FreezeHold `json:"holds,omitempty"`
+	QueuedEvents int          `json:"queuedEvents"`
+}
+
+// FreezeHold is a freeze requested by one source
+type FreezeHold struct {
+	Source string    `json:"source"`
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since"`
 }
 
 // OrphanInfo represents an orphaned directory
 type OrphanInfo struct {
-	Path      string    `json:"path"`
-	DirName   string    `json:"dirName"`
-	Size      uint64    `json:"size"`
-	SizeStr   string    `json:"sizeStr"`
-	FirstSeen time.Time `json:"firstSeen"`
-	Age       string    `json:"age"`
-	CanDelete bool      `json:"canDelete"`
+	Path        string    `json:"path"`
+	DirName     string    `json:"dirName"`
+	Size        uint64    `json:"size"`
+	SizeStr     string    `json:"sizeStr"`
+	FirstSeen   time.Time `json:"firstSeen"`
+	Age         string    `json:"age"`
+	CanDelete   bool      `json:"canDelete"`
+	ProtectedBy string    `json:"protectedBy,omitempty"` // exclude rule or keep marker
+
+	Owner *OrphanOwner `json:"owner,omitempty"`
+}
+
+// OrphanOwner is the last known user of an orphaned directory, from the
+// audit log and usage history
+type OrphanOwner struct {
+	PVName       string     `json:"pvName,omitempty"`
+	Namespace    string     `json:"namespace,omitempty"`
+	PVCName      string     `json:"pvcName,omitempty"`
+	Since        *time.Time `json:"since,omitempty"`
+	DeletedAt    *time.Time `json:"deletedAt,omitempty"`
+	LastRecorded *time.Time `json:"lastRecorded,omitempty"` // last usage history sample
+	LastUsed     uint64     `json:"lastUsed,omitempty"`
+}
+
+// CleanupSafetyInfo describes the orphan cleanup safety rails
+type CleanupSafetyInfo struct {
+	Excludes     []string        `json:"excludes"`
+	KeepMarker   string          `json:"keepMarker"`
+	MaxCount     int             `json:"maxCount"`
+	MaxBytes     uint64          `json:"maxBytes"`
+	AbortPercent int             `json:"abortPercent"`
+	LastRun      *CleanupRunInfo `json:"lastRun,omitempty"`
+}
+
+// CleanupScheduleInfo describes when orphan cleanup runs
+type CleanupScheduleInfo struct {
+	Interval string     `json:"interval,omitempty"` // set when not scheduled
+	Schedule string     `json:"schedule,omitempty"`
+	TimeZone string     `json:"timeZone,omitempty"`
+	Window   string     `json:"window,omitempty"`
+	NextRun  *time.Time `json:"nextRun,omitempty"`
+}
+
+// OrphanTrackerInfo describes where orphan first-seen times are persisted
+type OrphanTrackerInfo struct {
+	File    string     `json:"file"`
+	Tracked int        `json:"tracked"`
+	Saved   *time.Time `json:"saved,omitempty"`
+	Error   string     `json:"error,omitempty"`
+}
+
+// CleanupRunInfo describes the outcome of an orphan cleanup run
+type CleanupRunInfo struct {
+	Time    time.Time `json:"time"`
+	Aborted bool      `json:"aborted"`
+	Reason  string    `json:"reason,omitempty"`
+	Moved   int       `json:"moved"`
+	Bytes   uint64    `json:"bytes"`
+	Skipped int       `json:"skipped"` // deletable orphans left for the next run
 }
 
 // PVInfo contains PV and PVC binding information
 type PVInfo struct {
-	PVName    string
-	PVCName   string
-	Namespace string
-	Phase     string
-	NfsPath   string
-	Capacity  string
-	IsBound   bool
+	PVName       string
+	PVCName      string
+	Namespace    string
+	StorageClass string
+	Phase        string
+	NfsPath      string
+	Capacity     string
+	IsBound      bool
 }
 
 // FileInfo represents a file or directory entry
@@ -92,6 +184,10 @@ type Options struct {
 	Client        kubernetes.Interface
 	Agent         AgentInterface
 	HistoryStore  *history.Store
+	Prices        chargeback.Prices // per GiB-month, for /api/chargeback
+
+	// Usages shares directory scans with the agent (nil = own cache)
+	Usages *status.UsageCache
 }
 
 // Server serves the web UI
@@ -103,6 +199,8 @@ type Server struct {
 	client        kubernetes.Interface
 	agent         AgentInterface
 	historyStore  *history.Store
+	prices        chargeback.Prices
+	usages        *status.UsageCache
 }
 
 // StartServer starts the web UI server with the given options
@@ -115,6 +213,11 @@ func StartServer(opts Options) error {
 		client:        opts.Client,
 		agent:         opts.Agent,
 		historyStore:  opts.HistoryStore,
+		prices:        opts.Prices,
+		usages:        opts.Usages,
+	}
+	if ui.usages == nil {
+		ui.usages = status.NewUsageCache(opts.BasePath)
 	}
 
 	mux := http.NewServeMux()
@@ -125,8 +228,15 @@ func StartServer(opts Options) error {
 	mux.HandleFunc("/api/config", ui.handleAPIConfig)
 	mux.HandleFunc("/api/orphans", ui.handleAPIOrphans)
 	mux.HandleFunc("/api/orphans/delete", ui.handleAPIOrphansDelete)
+	mux.HandleFunc("/api/freeze", ui.handleAPIFreeze)
+	mux.HandleFunc("/api/trash", ui.handleAPITrash)
+	mux.HandleFunc("/api/trash/restore", ui.handleAPITrashRestore)
 	mux.HandleFunc("/api/history", ui.handleAPIHistory)
+	mux.HandleFunc("/api/history/export", ui.handleAPIHistoryExport)
+	mux.HandleFunc("/api/history/namespaces", ui.handleAPIHistoryNamespaces)
 	mux.HandleFunc("/api/trends", ui.handleAPITrends)
+	mux.HandleFunc("/api/chargeback", ui.handleAPIChargeback)
+	mux.HandleFunc("/api/anomalies", ui.handleAPIAnomalies)
 	mux.HandleFunc("/api/policies", ui.handleAPIPolicies)
 	mux.HandleFunc("/api/violations", ui.handleAPIViolations)
 	mux.HandleFunc("/api/files", ui.handleAPIFiles)
@@ -150,7 +260,7 @@ func (ui *Server) handleAPIStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dirUsages, _ := status.GetDirUsages(ui.basePath, fsType)
+	dirUsages, _, _ := ui.usages.Get(status.UsageMaxAge, true)
 
 	var totalUsed, totalQuota uint64
 	var warningCount, exceededCount, okCount int
@@ -199,15 +309,22 @@ func (ui *Server) handleAPIStatus(w http.ResponseWriter, r *http.Request) {
 
 // getPVInfoMap returns a map of directory path to PV info
 func (ui *Server) getPVInfoMap(ctx context.Context) map[string]*PVInfo {
+	return PVInfoMap(ctx, ui.client, ui.basePath, ui.nfsServerPath)
+}
+
+// PVInfoMap lists the NFS PVs (native or CSI) and maps the local directory
+// of each, under basePath, to its PV info. It is empty without a client or
+// when the PVs cannot be listed.
+func PVInfoMap(ctx context.Context, client kubernetes.Interface, basePath, nfsServerPath string) map[string]*PVInfo {
 	pvMap := make(map[string]*PVInfo)
 
-	if ui.client == nil {
+	if client == nil {
 		return pvMap
 	}
 
-	pvList, err := ui.client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	pvList, err := client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
 	if err != nil {
-		slog.Warn("Failed to list PVs for UI", "error", err)
+		slog.Warn("Failed to list PVs", "error", err)
 		return pvMap
 	}
 
@@ -234,13 +351,14 @@ func (ui *Server) getPVInfoMap(ctx context.Context) map[string]*PVInfo {
 			continue
 		}
 
-		localPath := ui.nfsPathToLocal(nfsPath)
+		localPath := nfsPathToLocal(basePath, nfsServerPath, nfsPath)
 
 		info := &PVInfo{
-			PVName:  pv.Name,
-			NfsPath: nfsPath,
-			Phase:   string(pv.Status.Phase),
-			IsBound: pv.Status.Phase == v1.VolumeBound,
+			PVName:       pv.Name,
+			StorageClass: pv.Spec.StorageClassName,
+			NfsPath:      nfsPath,
+			Phase:        string(pv.Status.Phase),
+			IsBound:      pv.Status.Phase == v1.VolumeBound,
 		}
 
 		if capacity, ok := pv.Spec.Capacity[v1.ResourceStorage]; ok {
@@ -259,18 +377,17 @@ func (ui *Server) getPVInfoMap(ctx context.Context) map[string]*PVInfo {
 }
 
 // nfsPathToLocal converts NFS server path to local mount path
-func (ui *Server) nfsPathToLocal(nfsPath string) string {
-	if ui.nfsServerPath != "" && strings.HasPrefix(nfsPath, ui.nfsServerPath) {
-		return filepath.Join(ui.basePath, strings.TrimPrefix(nfsPath, ui.nfsServerPath))
+func nfsPathToLocal(basePath, nfsServerPath, nfsPath string) string {
+	if nfsServerPath != "" && strings.HasPrefix(nfsPath, nfsServerPath) {
+		return filepath.Join(basePath, strings.TrimPrefix(nfsPath, nfsServerPath))
 	}
-	return filepath.Join(ui.basePath, filepath.Base(nfsPath))
+	return filepath.Join(basePath, filepath.Base(nfsPath))
 }
 
 func (ui *Server) handleAPIQuotas(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	fsType, _ := quota.DetectFSType(ui.basePath)
-	dirUsages, err := status.GetDirUsages(ui.basePath, fsType)
+	dirUsages, _, err := ui.usages.Get(status.UsageMaxAge, true)
 	if err != nil {
 		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 		return
@@ -312,6 +429,7 @@ func (ui *Server) handleAPIQuotas(w http.ResponseWriter, r *http.Request) {
 
 		if pvInfo, ok := pvMap[du.Path]; ok {
 			entry["pvName"] = pvInfo.PVName
+			entry["storageClass"] = pvInfo.StorageClass
 			entry["pvPhase"] = pvInfo.Phase
 			entry["pvcName"] = pvInfo.PVCName
 			entry["namespace"] = pvInfo.Namespace
@@ -319,6 +437,7 @@ func (ui *Server) handleAPIQuotas(w http.ResponseWriter, r *http.Request) {
 			entry["pvStatus"] = "bound"
 		} else {
 			entry["pvName"] = ""
+			entry["storageClass"] = ""
 			entry["pvPhase"] = ""
 			entry["pvcName"] = ""
 			entry["namespace"] = ""
@@ -380,10 +499,54 @@ func (ui *Server) handleAPIConfig(w http.ResponseWriter, r *http.Request) {
 		"cleanupEnabled": ui.agent != nil && ui.agent.EnableAutoCleanup(),
 		"historyEnabled": ui.historyStore != nil,
 		"policyEnabled":  ui.agent != nil && ui.agent.EnablePolicy(),
+		"agentEnabled":   ui.agent != nil,
+		"frozen":         ui.agent != nil && ui.agent.FreezeStatus().Frozen,
+	}
+	if ui.agent != nil && ui.agent.EnableAutoCleanup() {
+		config["cleanup"] = ui.agent.CleanupSchedule()
 	}
 	_ = json.NewEncoder(w).Encode(config)
 }
 
+func (ui *Server) handleAPIFreeze(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if ui.agent == nil {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "agent not available"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(FreezeInfo{})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Frozen bool   `json:"frozen"`
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid request body"})
+			return
+		}
+		if req.Frozen {
+			ui.agent.Freeze(FreezeSourceAPI, req.Reason)
+		} else {
+			ui.agent.Unfreeze(FreezeSourceAPI)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(ui.agent.FreezeStatus())
+}
+
 func (ui *Server) handleAPIOrphans(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -410,6 +573,8 @@ func (ui *Server) handleAPIOrphans(w http.ResponseWriter, r *http.Request) {
 			"dryRun":      ui.agent.CleanupDryRun(),
 			"gracePeriod": ui.agent.OrphanGracePeriod().String(),
 			"interval":    ui.agent.CleanupInterval().String(),
+			"safety":      ui.agent.CleanupSafety(),
+			"tracker":     ui.agent.OrphanTracker(),
 		},
 	})
 }
@@ -441,6 +606,12 @@ func (ui *Server) handleAPIOrphansDelete(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if ui.agent.FreezeStatus().Frozen {
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "agent is frozen for maintenance"})
+		return
+	}
+
 	var req struct {
 		Path string `json:"path"`
 	}
@@ -473,17 +644,19 @@ func (ui *Server) handleAPIOrphansDelete(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if targetOrphan.ProtectedBy != "" {
+		w.WriteHeader(http.StatusForbidden)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "orphan is protected (" + targetOrphan.ProtectedBy + ")"})
+		return
+	}
+
 	if err := ui.agent.RemoveOrphan(*targetOrphan); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 		return
 	}
 
-	if logger := ui.agent.AuditLogger(); logger != nil {
-		logger.LogCleanup(targetOrphan.Path, targetOrphan.DirName, 0, nil)
-	}
-
-	slog.Info("Orphan deleted via UI", "path", req.Path, "size", targetOrphan.SizeStr)
+	slog.Info("Orphan moved to trash via UI", "path", req.Path, "size", targetOrphan.SizeStr)
 
 	_ = json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
@@ -491,6 +664,81 @@ func (ui *Server) handleAPIOrphansDelete(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+func (ui *Server) handleAPITrash(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if ui.agent == nil {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"entries": []trash.Entry{},
+			"count":   0,
+		})
+		return
+	}
+
+	entries, err := ui.agent.TrashEntries()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	if entries == nil {
+		entries = []trash.Entry{}
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries":   entries,
+		"count":     len(entries),
+		"retention": ui.agent.TrashRetention().String(),
+		"archive":   ui.agent.TrashArchive(),
+		"purge":     ui.agent.PurgeStatus(),
+	})
+}
+
+func (ui *Server) handleAPITrashRestore(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	if ui.agent == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "agent not available"})
+		return
+	}
+
+	if ui.agent.FreezeStatus().Frozen {
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "agent is frozen for maintenance"})
+		return
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "id is required"})
+		return
+	}
+
+	entry, err := ui.agent.RestoreTrash(req.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	slog.Info("Trashed directory restored via UI", "id", req.ID, "path", entry.OriginalPath)
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"restored": entry,
+	})
+}
+
 func (ui *Server) handleAPIHistory(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -504,31 +752,199 @@ func (ui *Server) handleAPIHistory(w http.ResponseWriter, r *http.Request) {
 
 	path := r.URL.Query().Get("path")
 	periodStr := r.URL.Query().Get("period")
+	sel := selectorFromQuery(r)
 
+	// Any step-like period works ("90d", "365d", ...); invalid ones fall
+	// back to 24h as before
 	period := 24 * time.Hour
-	switch periodStr {
-	case "7d":
-		period = 7 * 24 * time.Hour
-	case "30d":
-		period = 30 * 24 * time.Hour
-	case "24h", "":
-		period = 24 * time.Hour
+	if p, err := parseStep(periodStr); err == nil {
+		period = p
+	}
+
+	var step time.Duration
+	if stepStr := r.URL.Query().Get("step"); stepStr != "" {
+		var err error
+		if step, err = parseStep(stepStr); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("invalid step: %v", err)})
+			return
+		}
 	}
 
 	end := time.Now()
 	start := end.Add(-period)
 
-	h := ui.historyStore.Query(path, start, end)
+	// Without a path, a namespace or PVC selects the history of every
+	// directory it used; with a path, it keeps only that owner's snapshots
+	var (
+		h          []history.UsageHistory
+		resolution time.Duration
+	)
+	if path == "" && !sel.IsZero() {
+		h, resolution = ui.historyStore.QuerySelector(sel, start, end, step)
+	} else {
+		h, resolution = ui.historyStore.QueryStep(path, start, end, step)
+		if !sel.IsZero() {
+			selected := []history.UsageHistory{}
+			for _, p := range h {
+				if sel.Matches(p.Owner) {
+					selected = append(selected, p)
+				}
+			}
+			h = selected
+		}
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":   true,
+		"path":      path,
+		"namespace": sel.Namespace,
+		"pvc":       sel.PVCName,
+		"period":    periodStr,
+		"step":      resolution.String(),
+		"history":   h,
+		"stats":     ui.historyStore.GetHistoryStats(),
+	})
+}
+
+// selectorFromQuery reads the namespace and pvc history filters
+func selectorFromQuery(r *http.Request) history.Selector {
+	return history.Selector{
+		Namespace: r.URL.Query().Get("namespace"),
+		PVCName:   r.URL.Query().Get("pvc"),
+	}
+}
+
+// handleAPIHistoryNamespaces returns usage over time summed per namespace,
+// StorageClass or for the whole export
+func (ui *Server) handleAPIHistoryNamespaces(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if ui.historyStore == nil {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"enabled": false,
+			"groups":  []history.GroupUsage{},
+		})
+		return
+	}
+
+	q := r.URL.Query()
+	fail := func(msg string) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	}
+
+	by := q.Get("by")
+	if by == "" {
+		by = history.GroupByNamespace
+	}
+	period := 30 * 24 * time.Hour
+	if periodStr := q.Get("period"); periodStr != "" {
+		var err error
+		if period, err = parseStep(periodStr); err != nil {
+			fail(fmt.Sprintf("invalid period: %v", err))
+			return
+		}
+	}
+	var step time.Duration
+	if stepStr := q.Get("step"); stepStr != "" {
+		var err error
+		if step, err = parseStep(stepStr); err != nil {
+			fail(fmt.Sprintf("invalid step: %v", err))
+			return
+		}
+	}
+
+	end := time.Now()
+	groups, resolution, err := ui.historyStore.Aggregate(by, end.Add(-period), end, step)
+	if err != nil {
+		fail(err.Error())
+		return
+	}
 
 	_ = json.NewEncoder(w).Encode(map[string]interface{}{
 		"enabled": true,
-		"path":    path,
-		"period":  periodStr,
-		"history": h,
-		"stats":   ui.historyStore.GetHistoryStats(),
+		"by":      by,
+		"period":  period.String(),
+		"step":    resolution.String(),
+		"groups":  groups,
 	})
 }
 
+// handleAPIHistoryExport downloads usage history as CSV, JSON or Parquet
+func (ui *Server) handleAPIHistoryExport(w http.ResponseWriter, r *http.Request) {
+	fail := func(status int, msg string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	}
+
+	if ui.historyStore == nil {
+		fail(http.StatusNotFound, "history is not enabled")
+		return
+	}
+
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = history.FormatCSV
+	}
+	now := time.Now()
+	filter := history.ExportFilter{Path: q.Get("path"), Selector: selectorFromQuery(r)}
+	var err error
+	if filter.Since, err = history.ParseTime(q.Get("since"), now); err != nil {
+		fail(http.StatusBadRequest, fmt.Sprintf("invalid since: %v", err))
+		return
+	}
+	if filter.Until, err = history.ParseTime(q.Get("until"), now); err != nil {
+		fail(http.StatusBadRequest, fmt.Sprintf("invalid until: %v", err))
+		return
+	}
+
+	switch format {
+	case history.FormatCSV, history.FormatJSON, history.FormatParquet:
+	default:
+		fail(http.StatusBadRequest, fmt.Sprintf("unknown format %q (use csv, json or parquet)", format))
+		return
+	}
+
+	w.Header().Set("Content-Type", history.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="usage-history-%s.%s"`, now.Format("20060102-150405"), format))
+	writer, err := history.NewRecordWriter(w, format)
+	if err != nil {
+		slog.Warn("History export failed", "error", err)
+		return
+	}
+
+	if err := ui.historyStore.Export(filter, writer.Write); err != nil {
+		// Headers are sent; all we can do is cut the download short
+		slog.Warn("History export failed", "error", err)
+		return
+	}
+	if err := writer.Close(); err != nil {
+		slog.Warn("History export failed", "error", err)
+	}
+}
+
+// parseStep parses a history step such as "15m", "1h" or "7d"
+func parseStep(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid number of days %q", days)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("step must be positive")
+	}
+	return d, nil
+}
+
 func (ui *Server) handleAPITrends(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -542,15 +958,36 @@ func (ui *Server) handleAPITrends(w http.ResponseWriter, r *http.Request) {
 
 	path := r.URL.Query().Get("path")
 
+	var window time.Duration
+	if windowStr := r.URL.Query().Get("window"); windowStr != "" {
+		var err error
+		if window, err = parseStep(windowStr); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("invalid window: %v", err)})
+			return
+		}
+	}
+	method := r.URL.Query().Get("method")
+	if method != "" {
+		if err := history.ValidateMethod(method); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	sel := selectorFromQuery(r)
+
 	if path != "" {
 		trend := ui.historyStore.GetTrend(path)
-		if trend == nil {
+		if trend == nil || !sel.Matches(trend.Owner) {
 			_ = json.NewEncoder(w).Encode(map[string]interface{}{
 				"enabled": true,
 				"trend":   nil,
 			})
 			return
 		}
+		trend.Forecast = ui.historyStore.Forecast(path, window, method)
 		_ = json.NewEncoder(w).Encode(map[string]interface{}{
 			"enabled": true,
 			"trend":   trend,
@@ -558,14 +995,128 @@ func (ui *Server) handleAPITrends(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	trends := ui.historyStore.GetAllTrends()
-	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+	trends := ui.historyStore.GetTrends(sel)
+	forecasts := ui.historyStore.ForecastAll(window, method)
+	byPath := make(map[string]*history.Forecast, len(forecasts))
+	for i := range forecasts {
+		byPath[forecasts[i].Path] = &forecasts[i]
+	}
+	for i := range trends {
+		trends[i].Forecast = byPath[trends[i].Path]
+	}
+
+	resp := map[string]interface{}{
 		"enabled": true,
 		"trends":  trends,
 		"count":   len(trends),
+	}
+	if disk, err := status.GetDiskUsage(ui.basePath); err == nil {
+		resp["export"] = history.ForecastExport(forecasts, ui.basePath, disk, time.Now())
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// handleAPIAnomalies lists the usage anomalies detected in the last period
+// (default 7d) and every one still going on, newest first
+func (ui *Server) handleAPIAnomalies(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if ui.historyStore == nil || !ui.historyStore.AnomalyDetection().Enabled {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"enabled":   false,
+			"anomalies": []history.Anomaly{},
+		})
+		return
+	}
+
+	period := 7 * 24 * time.Hour
+	if periodStr := r.URL.Query().Get("period"); periodStr != "" {
+		var err error
+		if period, err = parseStep(periodStr); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("invalid period: %v", err)})
+			return
+		}
+	}
+	path := r.URL.Query().Get("path")
+
+	anomalies := []history.Anomaly{}
+	active := 0
+	for _, a := range ui.historyStore.Anomalies(time.Now().Add(-period)) {
+		if path != "" && a.Path != path {
+			continue
+		}
+		if a.Active() {
+			active++
+		}
+		anomalies = append(anomalies, a)
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":   true,
+		"anomalies": anomalies,
+		"count":     len(anomalies),
+		"active":    active,
+		"counts":    ui.historyStore.AnomalyCounts(),
 	})
 }
 
+// handleAPIChargeback returns the storage cost per namespace or namespace
+// label for a billing period as JSON, CSV or Markdown
+func (ui *Server) handleAPIChargeback(w http.ResponseWriter, r *http.Request) {
+	fail := func(status int, msg string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	}
+
+	if ui.historyStore == nil {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"enabled": false})
+		return
+	}
+
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = chargeback.FormatJSON
+	}
+	if err := chargeback.ValidateFormat(format); err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	opts := chargeback.Options{Prices: ui.prices, Label: q.Get("label")}
+	var err error
+	if opts.Start, opts.End, err = chargeback.ParsePeriod(q.Get("month"), q.Get("since"), q.Get("until"), time.Now()); err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+	if opts.Label != "" {
+		if ui.client == nil {
+			fail(http.StatusServiceUnavailable, "grouping by label needs a Kubernetes client")
+			return
+		}
+		if opts.Labels, err = chargeback.NamespaceLabels(r.Context(), ui.client, opts.Label); err != nil {
+			fail(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	report := chargeback.Generate(ui.historyStore, opts)
+	w.Header().Set("Content-Type", chargeback.ContentType(format))
+	if format != chargeback.FormatJSON {
+		ext := format
+		if format == chargeback.FormatMarkdown {
+			ext = "md"
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=chargeback-%s.%s", opts.Start.Format("2006-01-02"), ext))
+	}
+	if err := chargeback.Write(w, report, format); err != nil {
+		slog.Warn("Chargeback report failed", "error", err)
+	}
+}
+
 func (ui *Server) handleAPIPolicies(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -0,0 +1,227 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/dasomel/nfs-quota-agent/internal/agent"
+	"github.com/dasomel/nfs-quota-agent/internal/quota"
+	"github.com/dasomel/nfs-quota-agent/internal/util"
+)
+
+// Status is the enforcement status of a single PV
+type Status string
+
+const (
+	StatusOK           Status = "OK"
+	StatusMissing      Status = "MISSING"
+	StatusWrongLimit   Status = "WRONG_LIMIT"
+	StatusWrongProject Status = "WRONG_PROJECT"
+	StatusConflict     Status = "CONFLICT"
+	StatusDirMissing   Status = "DIR_MISSING"
+	StatusError        Status = "ERROR"
+)
+
+// Result is the verification result of a single PV
+type Result struct {
+	agent.PVTarget
+	Status          Status `json:"status"`
+	ActualProjectID uint32 `json:"actualProjectId,omitempty"`
+	ActualLimit     int64  `json:"actualLimitBytes,omitempty"`
+	Message         string `json:"message,omitempty"`
+}
+
+// Report contains the verification results of all PVs
+type Report struct {
+	Timestamp time.Time      `json:"timestamp"`
+	QuotaPath string         `json:"quotaPath"`
+	FSType    string         `json:"fsType"`
+	Results   []Result       `json:"results"`
+	Summary   map[Status]int `json:"summary"`
+	Problems  int            `json:"problems"`
+}
+
+// Options configures the verify command
+type Options struct {
+	NfsBasePath     string
+	NfsServerPath   string
+	ProvisionerName string
+	ProcessAllNFS   bool
+	Client          kubernetes.Interface
+
+	// AdoptExisting expects the project IDs that 'run --adopt-existing'
+	// keeps, read from the directories and the projects files
+	AdoptExisting bool
+	ProjectsFile  string
+	ProjidFile    string
+}
+
+// Run verifies that every PV the agent would process has an enforced
+// project quota matching its capacity
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	fsType, err := quota.DetectFSTypeWithFindmnt(opts.NfsBasePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect filesystem type: %w", err)
+	}
+	if fsType != quota.FSTypeXFS && fsType != quota.FSTypeExt4 {
+		return nil, fmt.Errorf("unsupported filesystem type: %s (only xfs and ext4 are supported)", fsType)
+	}
+
+	ag := agent.NewQuotaAgent(opts.Client, opts.NfsBasePath, opts.NfsServerPath, opts.ProvisionerName)
+	ag.SetProcessAllNFS(opts.ProcessAllNFS)
+
+	targets, err := ag.Targets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	quotas, err := quota.GetProjectQuotas(fsType, opts.NfsBasePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quota report: %w", err)
+	}
+
+	var entries []quota.ProjectEntry
+	if opts.AdoptExisting {
+		entries, err = quota.ReadProjectEntries(opts.ProjectsFile, opts.ProjidFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read project files: %w", err)
+		}
+	}
+
+	r := &Report{
+		Timestamp: time.Now(),
+		QuotaPath: opts.NfsBasePath,
+		FSType:    fsType,
+		Summary:   make(map[Status]int),
+	}
+
+	for _, t := range targets {
+		res := Result{PVTarget: t}
+
+		if info, err := os.Stat(t.LocalPath); err != nil || !info.IsDir() {
+			res.Status, res.Message = StatusDirMissing, "directory not found: "+t.LocalPath
+		} else if dirID, err := quota.GetDirProjectID(fsType, t.LocalPath); err != nil {
+			res.Status, res.Message = StatusError, err.Error()
+		} else {
+			res.ActualProjectID = dirID
+			var pq *quota.ProjectQuota
+			if dirID != 0 {
+				pq = quotas[dirID]
+			}
+			if pq != nil {
+				res.ActualLimit = pq.BlockHard
+			}
+			var conflicts []string
+			res.PVTarget, conflicts = expectedProject(t, dirID, entries, opts.AdoptExisting)
+			if len(conflicts) > 0 {
+				res.Status, res.Message = StatusConflict, strings.Join(conflicts, "; ")
+			} else {
+				res.Status, res.Message = classify(res.PVTarget, dirID, pq)
+			}
+		}
+
+		r.add(res)
+	}
+
+	return r, nil
+}
+
+// expectedProject returns the target with the project the agent would bind
+// its directory to. In adoption mode that is the ID kept by
+// agent.DecideAdoption; a blocking conflict is returned instead, since the
+// agent leaves such directories alone.
+func expectedProject(t agent.PVTarget, dirID uint32, entries []quota.ProjectEntry, adopt bool) (agent.PVTarget, []string) {
+	if !adopt {
+		return t, nil
+	}
+	ad := agent.DecideAdoption(t, dirID, entries)
+	if ad.Blocked {
+		return t, ad.Conflicts
+	}
+	t.ProjectID, t.ProjectName = ad.ProjectID, ad.ProjectName
+	return t, nil
+}
+
+// classify compares the project ID found on a PV directory and the limit of
+// that project with what the agent would apply
+func classify(t agent.PVTarget, dirProjectID uint32, pq *quota.ProjectQuota) (Status, string) {
+	if dirProjectID == 0 {
+		return StatusMissing, "directory has no project ID"
+	}
+	if dirProjectID != t.ProjectID {
+		return StatusWrongProject, fmt.Sprintf("directory has project %d, expected %d (%s)", dirProjectID, t.ProjectID, t.ProjectName)
+	}
+	if pq == nil || pq.BlockHard == 0 {
+		return StatusMissing, fmt.Sprintf("project %d has no hard limit", dirProjectID)
+	}
+
+	want := quota.BlockLimitBytes(t.CapacityBytes)
+	if pq.BlockHard != want {
+		return StatusWrongLimit, fmt.Sprintf("limit is %s, expected %s",
+			util.FormatBytes(pq.BlockHard), util.FormatBytes(want))
+	}
+	return StatusOK, ""
+}
+
+// add appends a result and updates the counters
+func (r *Report) add(res Result) {
+	r.Results = append(r.Results, res)
+	r.Summary[res.Status]++
+	if res.Status != StatusOK {
+		r.Problems++
+	}
+}
+
+// Print writes the report as a table or JSON
+func (r *Report) Print(format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	}
+
+	fmt.Printf("Quota path: %s (%s)\n\n", r.QuotaPath, r.FSType)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tPV\tPVC\tPATH\tCAPACITY\tLIMIT\tDETAIL")
+	for _, res := range r.Results {
+		pvc := "-"
+		if res.PVCName != "" {
+			pvc = res.Namespace + "/" + res.PVCName
+		}
+		limit := "-"
+		if res.ActualLimit > 0 {
+			limit = util.FormatBytes(res.ActualLimit)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			res.Status, res.PVName, pvc, res.LocalPath,
+			util.FormatBytes(res.CapacityBytes), limit, res.Message)
+	}
+	_ = w.Flush()
+
+	fmt.Printf("\nTotal: %d PVs, %d OK, %d problems\n", len(r.Results), r.Summary[StatusOK], r.Problems)
+	return nil
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verify
+
+import (
+	"testing"
+
+	"github.com/dasomel/nfs-quota-agent/internal/agent"
+	"github.com/dasomel/nfs-quota-agent/internal/quota"
+)
+
+func TestClassify(t *testing.T) {
+	target := agent.PVTarget{PVName: "pv-1", ProjectName: "pv_pv_1", ProjectID: 1001, CapacityBytes: 1 << 30}
+
+	tests := []struct {
+		name  string
+		dirID uint32
+		pq    *quota.ProjectQuota
+		want  Status
+	}{
+		{"ok", 1001, &quota.ProjectQuota{ProjectID: 1001, Limits: quota.Limits{BlockHard: 1 << 30}}, StatusOK},
+		{"no project on dir", 0, nil, StatusMissing},
+		{"no limit", 1001, nil, StatusMissing},
+		{"zero limit", 1001, &quota.ProjectQuota{ProjectID: 1001}, StatusMissing},
+		{"other project", 2002, &quota.ProjectQuota{ProjectID: 2002, Limits: quota.Limits{BlockHard: 1 << 30}}, StatusWrongProject},
+		{"wrong limit", 1001, &quota.ProjectQuota{ProjectID: 1001, Limits: quota.Limits{BlockHard: 2 << 30}}, StatusWrongLimit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, msg := classify(target, tt.dirID, tt.pq)
+			if got != tt.want {
+				t.Errorf("classify() = %s (%s), want %s", got, msg, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpectedProjectAdopted(t *testing.T) {
+	target := agent.PVTarget{PVName: "pv-1", LocalPath: "/export/a", ProjectName: "pv_pv_1", ProjectID: 1001, CapacityBytes: 1 << 30}
+	adopted := &quota.ProjectQuota{ProjectID: 42, Limits: quota.Limits{BlockHard: 1 << 30}}
+
+	tests := []struct {
+		name     string
+		entries  []quota.ProjectEntry
+		adopt    bool
+		want     Status
+		conflict bool
+	}{
+		{"adopted project", []quota.ProjectEntry{{ID: 42, Name: "team_a", Path: "/export/a"}}, true, StatusOK, false},
+		{"adopted, not recorded yet", nil, true, StatusOK, false},
+		{"without adoption", []quota.ProjectEntry{{ID: 42, Name: "team_a", Path: "/export/a"}}, false, StatusWrongProject, false},
+		{"shared project", []quota.ProjectEntry{{ID: 42, Path: "/export/a"}, {ID: 42, Path: "/export/b"}}, true, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected, conflicts := expectedProject(target, 42, tt.entries, tt.adopt)
+			if (len(conflicts) > 0) != tt.conflict {
+				t.Fatalf("expectedProject() conflicts = %v, want conflict %v", conflicts, tt.conflict)
+			}
+			if tt.conflict {
+				return
+			}
+			if got, msg := classify(expected, 42, adopted); got != tt.want {
+				t.Errorf("classify() = %s (%s), want %s", got, msg, tt.want)
+			}
+		})
+	}
+}